@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const peersBucket = "peers"
+const bansBucket = "bans"
+
+// Ограничения адресной книги
+const (
+	maxAddrBookSize    = 1000
+	maxAddrPerMessage  = 1000
+	maxConnectAttempts = 10 // После стольких неудачных попыток подряд адрес забывается
+	retryBaseDelay     = 10 * time.Second
+	retryMaxDelay      = time.Hour
+)
+
+// KnownAddress - запись адресной книги
+type KnownAddress struct {
+	Addr        string
+	LastSeen    time.Time // Последнее успешное рукопожатие
+	LastAttempt time.Time
+	Attempts    int // Неудачные попытки подряд
+}
+
+// retryDelay растет экспоненциально с каждой неудачной попыткой
+func (ka KnownAddress) retryDelay() time.Duration {
+	delay := retryBaseDelay
+	for i := 0; i < ka.Attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	return delay
+}
+
+// AddrBook - множество адресов пиров без повторов и забаненные хосты, хранится в базе нода
+type AddrBook struct {
+	db *bolt.DB
+}
+
+// NewAddrBook создает корзины адресной книги, если их еще нет
+func NewAddrBook(db *bolt.DB) *AddrBook {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(peersBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(bansBucket))
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return &AddrBook{db}
+}
+
+func (ka KnownAddress) serialize() []byte {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(ka)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+func deserializeKnownAddress(data []byte) (KnownAddress, error) {
+	var ka KnownAddress
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&ka)
+
+	return ka, err
+}
+
+// update применяет f к записи адреса, при create запись создается, если ее нет.
+// Если f возвращает false, запись удаляется
+func (ab *AddrBook) update(addr string, create bool, f func(ka *KnownAddress) bool) {
+	err := ab.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(peersBucket))
+
+		ka := KnownAddress{Addr: addr}
+		if data := b.Get([]byte(addr)); data != nil {
+			var err error
+			ka, err = deserializeKnownAddress(data)
+			if err != nil {
+				return err
+			}
+		} else if !create {
+			return nil
+		}
+
+		if !f(&ka) {
+			return b.Delete([]byte(addr))
+		}
+
+		return b.Put([]byte(addr), ka.serialize())
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// Add добавляет в книгу новые адреса и возвращает, сколько из них было новых.
+// Адреса сверх ограничения книги отбрасываются
+func (ab *AddrBook) Add(addrs []string) int {
+	added := 0
+
+	err := ab.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(peersBucket))
+		count := b.Stats().KeyN
+
+		for _, addr := range addrs {
+			if addr == "" || b.Get([]byte(addr)) != nil {
+				continue
+			}
+			if count >= maxAddrBookSize {
+				break
+			}
+
+			err := b.Put([]byte(addr), KnownAddress{Addr: addr}.serialize())
+			if err != nil {
+				return err
+			}
+			count++
+			added++
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return added
+}
+
+// Remove удаляет адрес из книги
+func (ab *AddrBook) Remove(addr string) {
+	ab.update(addr, false, func(ka *KnownAddress) bool {
+		return false
+	})
+}
+
+// Attempt отмечает начало попытки подключения к адресу из книги
+func (ab *AddrBook) Attempt(addr string) {
+	ab.update(addr, false, func(ka *KnownAddress) bool {
+		ka.LastAttempt = time.Now()
+		return true
+	})
+}
+
+// Failed считает неудачную попытку подключения, после maxConnectAttempts адрес забывается
+func (ab *AddrBook) Failed(addr string) {
+	ab.update(addr, false, func(ka *KnownAddress) bool {
+		ka.Attempts++
+		return ka.Attempts < maxConnectAttempts
+	})
+}
+
+// Good отмечает адрес рабочим после успешного рукопожатия, только так в книгу попадают адреса,
+// которые не добавлены через Add
+func (ab *AddrBook) Good(addr string) {
+	ab.update(addr, true, func(ka *KnownAddress) bool {
+		ka.LastSeen = time.Now()
+		ka.Attempts = 0
+		return true
+	})
+}
+
+// Ban не дает хосту подключаться и не дает подключаться к его адресам до указанного времени.
+// Заодно удаляются истекшие баны
+func (ab *AddrBook) Ban(host string, until time.Time) {
+	err := ab.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bansBucket))
+		now := time.Now()
+
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if !isBanned(tx, string(k), now) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			err = b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(until.Unix()))
+
+		return b.Put([]byte(host), data)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// isBanned проверяет бан хоста внутри транзакции
+func isBanned(tx *bolt.Tx, host string, now time.Time) bool {
+	data := tx.Bucket([]byte(bansBucket)).Get([]byte(host))
+	if len(data) != 8 {
+		return false
+	}
+
+	return now.Unix() < int64(binary.LittleEndian.Uint64(data))
+}
+
+// IsBanned проверяет, забанен ли хост
+func (ab *AddrBook) IsBanned(host string) bool {
+	banned := false
+
+	err := ab.db.View(func(tx *bolt.Tx) error {
+		banned = isBanned(tx, host, time.Now())
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return banned
+}
+
+// entries возвращает все записи, хосты которых не забанены
+func (ab *AddrBook) entries() []KnownAddress {
+	var entries []KnownAddress
+	now := time.Now()
+
+	err := ab.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(peersBucket)).ForEach(func(k, v []byte) error {
+			ka, err := deserializeKnownAddress(v)
+			if err == nil && !isBanned(tx, addrHost(ka.Addr), now) {
+				entries = append(entries, ka)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return entries
+}
+
+// Candidates возвращает до count адресов, к которым пора подключаться, кроме исключенных.
+// Первыми идут адреса, которые недавно работали
+func (ab *AddrBook) Candidates(count int, exclude map[string]bool) []string {
+	now := time.Now()
+
+	var ready []KnownAddress
+	for _, ka := range ab.entries() {
+		if exclude[ka.Addr] || now.Before(ka.LastAttempt.Add(ka.retryDelay())) {
+			continue
+		}
+		ready = append(ready, ka)
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		if ready[i].Attempts != ready[j].Attempts {
+			return ready[i].Attempts < ready[j].Attempts
+		}
+		return ready[i].LastSeen.After(ready[j].LastSeen)
+	})
+
+	var addrs []string
+	for i := 0; i < len(ready) && i < count; i++ {
+		addrs = append(addrs, ready[i].Addr)
+	}
+
+	return addrs
+}
+
+// GoodAddresses возвращает до count адресов с успешным рукопожатием, сначала самые свежие
+func (ab *AddrBook) GoodAddresses(count int) []string {
+	var good []KnownAddress
+	for _, ka := range ab.entries() {
+		if !ka.LastSeen.IsZero() {
+			good = append(good, ka)
+		}
+	}
+
+	sort.Slice(good, func(i, j int) bool {
+		return good[i].LastSeen.After(good[j].LastSeen)
+	})
+
+	var addrs []string
+	for i := 0; i < len(good) && i < count; i++ {
+		addrs = append(addrs, good[i].Addr)
+	}
+
+	return addrs
+}
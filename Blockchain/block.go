@@ -2,42 +2,73 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"log"
 	"time"
 )
 
 // Block - структура, представляющая отдельный блок в цепочке
 type Block struct {
+	Version       uint32         // Версия определяет кодирование заголовка и транзакций для хэшей
 	Timestamp     int64          // Время создания блока
 	Transactions  []*Transaction // Полезная информация
 	PrevBlockHash []byte         // Хэш предыдущего блока
 	Hash          []byte         // Хэш текущего блока
 	Nonce         int            // Номер успешной попытки?
 	Height        int            // Вес
+	MerkleRoot    []byte         // Корень дерева Меркла транзакций блока
+	Bits          uint32         // Цель доказательства работы в компактном виде
 }
 
-// NewBlock Функция, которая возращает новый блок
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
+// NewBlock Функция, которая возращает новый блок, bits - цель в компактном виде
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block {
+	block, _, err := NewBlockContext(context.Background(), transactions, prevBlockHash, height, bits, miningThreads)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return block
+}
+
+// NewBlockContext майнит новый блок на threads горутинах, пока не найдет nonce или не будет отменен ctx
+func NewBlockContext(ctx context.Context, transactions []*Transaction, prevBlockHash []byte, height int, bits uint32, threads int) (*Block, MiningStats, error) {
 	// Создаем непосредственно объект блока
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
+	block := &Block{
+		Version:       blockVersion,
+		Timestamp:     time.Now().Unix(),
+		Transactions:  transactions,
+		PrevBlockHash: prevBlockHash,
+		Hash:          []byte{},
+		Height:        height,
+		Bits:          bits,
+	}
+	block.MerkleRoot = block.HashTransactions()
 
 	// Создаем объект для вычисления доказательства работы
 	pow := NewProofOfWork(block)
 	// Выполняем подсчет доказательства работы и хэша
-	nonce, hash := pow.Run()
+	nonce, hash, stats, err := pow.RunContext(ctx, threads)
+	if err != nil {
+		return nil, stats, err
+	}
 
 	// Сохраняем их в блоке
-	block.Hash = hash[:] // TODO: Зачем [:]??
+	block.Hash = hash
 	block.Nonce = nonce
 
-	return block
+	return block, stats, nil
 }
 
 // NewGenesisBlock С помощью этой функции можно создавать базовый блок, без предыдущих
 func NewGenesisBlock(coinbase *Transaction) *Block {
 	// Создание базового блока без предварительного хэша
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, BigToCompact(activeNetwork.GenesisTarget))
+}
+
+// hasMerkleRoot checks whether the header commits to the transactions by itself.
+// It is required since version 1, older blocks stored before the field compute the root from the transactions
+func (b *Block) hasMerkleRoot() bool {
+	return b.Version != blockVersionLegacy || len(b.MerkleRoot) > 0
 }
 
 // HashTransactions Возвращает хэш от вычисленных транзакций данного блока
@@ -45,41 +76,78 @@ func (b *Block) HashTransactions() []byte {
 	var transactions [][]byte
 
 	for _, tx := range b.Transactions {
-		transactions = append(transactions, tx.Serialize())
+		transactions = append(transactions, tx.merkleData())
 	}
 	mTree := NewMerkleTree(transactions)
 
 	return mTree.RootNode.Data
 }
 
-// Serialize Выполняем сериализацию блока в набор байтов
+// MerkleProof доказывает, что транзакция входит в блок: проверяется по MerkleRoot и tx.merkleData()
+func (b *Block) MerkleProof(txID []byte) (*MerkleProof, error) {
+	var transactions [][]byte
+	index := -1
+
+	for i, tx := range b.Transactions {
+		transactions = append(transactions, tx.merkleData())
+		if bytes.Equal(tx.ID, txID) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, ErrNoSuchLeaf
+	}
+
+	return NewMerkleTree(transactions).Proof(index)
+}
+
+// Serialize Выполняем сериализацию блока в каноническое представление
 func (b *Block) Serialize() []byte {
-	// Создаем буффер
-	var result bytes.Buffer
-	// Создаем кодировщик с результатами в этом буффере
-	encoder := gob.NewEncoder(&result)
+	w := &wireWriter{}
+	b.encode(w)
+
+	return w.buf.Bytes()
+}
+
+// SerializeHeader returns the header of the block with its hash, without transactions
+func (b *Block) SerializeHeader() []byte {
+	w := &wireWriter{}
+	b.encodeHeader(w, b.Nonce)
+	w.putBytes(b.Hash)
 
-	// Кодируем наш блок в массив байтов данных
-	err := encoder.Encode(b)
+	return w.buf.Bytes()
+}
+
+// DecodeHeader decodes a header received from a peer, the result is a block without transactions
+func DecodeHeader(d []byte) (*Block, error) {
+	var header Block
+
+	err := decodeCanonical(d, header.decodeHeader)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return result.Bytes()
+	return &header, nil
 }
 
 // DeserializeBlock deserializes a block
 func DeserializeBlock(d []byte) *Block {
-	// Создаем переменную, в которую будем засовывать блок
+	block, err := DecodeBlock(d)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return block
+}
+
+// DecodeBlock decodes a block received from outside, where broken data is not a reason to panic
+func DecodeBlock(d []byte) (*Block, error) {
 	var block Block
 
-	// Создаем декодер на основе ридера данных
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	// Декодируем
-	err := decoder.Decode(&block)
+	err := decodeCanonical(d, block.decode)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return &block
+	return &block, nil
 }
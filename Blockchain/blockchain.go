@@ -2,215 +2,535 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"math/big"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
 
 const dbFile = "blockchain_%s.db"
 const blocksBucket = "blocks"
-const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+const chainWorkBucket = "chainwork"
+const maxOrphanBlocks = 100
+
+// Через сколько шаблон блока считается устаревшим: его время и набор транзакций пора обновить
+var templateLifetime = time.Minute
+
+// Сколько ждать, пока базу отпустит другой процесс, например запущенный нод
+const dbOpenTimeout = time.Second
+
+// Причины остановки майнинга
+var (
+	ErrTipChanged    = errors.New("tip has changed")
+	ErrStaleTemplate = errors.New("block template is stale")
+)
+
+// ErrNoBlockchain is returned when the database of the node doesn't exist
+var ErrNoBlockchain = errors.New("no existing blockchain found, create one first")
 
 // Blockchain реализует цепочку, которая взаимодействует с базой данных
 type Blockchain struct {
 	tip []byte
 	db  *bolt.DB
+
+	orphans     map[string][]*Block // Блоки без известного родителя, ключ - хэш родителя
+	orphansLock sync.Mutex
+
+	tipChanged chan struct{} // Закрывается при смене вершины, чтобы прервать майнинг на старой
+	tipLock    sync.Mutex    // Защищает tip и tipChanged, блоки сохраняются из горутин разных пиров
+
+	log *Logger
 }
 
-// CreateBlockchain создает новую базу данных
-func CreateBlockchain(address, nodeID string) *Blockchain {
+// CreateBlockchain создает новую базу данных, txIndex включает индексы транзакций и адресов.
+// Генезис сохраняется вместе с chainstate в одной транзакции базы
+func CreateBlockchain(address, nodeID string, txIndex bool) (*Blockchain, error) {
 	// Вычисляем имя базы данных
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := dataPath(dbFile, nodeID)
 	// Проверяем, что такого файла не существует еще
 	if dbExists(dbFile) {
-		fmt.Println("Blockchain already exists.")
-		os.Exit(1)
+		return nil, fmt.Errorf("%w: %s", ErrDBExists, dbFile)
 	}
 
-	// TODO: ???
-	var tip []byte
-
-	// TODO: ???
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
+	// Монетка за генезис уходит на адрес создателя, данные coinbase у каждой сети свои
+	cbtx := NewCoinbaseTX(address, activeNetwork.GenesisData, 0, 0)
 	// Создаем базовый блок без предыдущего хэша
 	genesis := NewGenesisBlock(cbtx)
 
 	// Открываем файл базы на чтение-запись
 	db, err := bolt.Open(dbFile, 0600, nil)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
+	bc := &Blockchain{tip: genesis.Hash, db: db, orphans: make(map[string][]*Block), log: nodeLogger(nodeID)}
+
 	// Выполняем обновление базы данных, функция вызывается когда доходит очередь
 	err = db.Update(func(tx *bolt.Tx) error {
 		// Создаем корзину с именем blocks
 		b, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
 		// Кладем в корзину хэш в качестве ключа и наш базовый блокчейн
 		err = b.Put(genesis.Hash, genesis.Serialize())
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
 		// Кладем в корзину хэш в качестве последнего
 		err = b.Put([]byte("l"), genesis.Hash)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		// Сохраняем ключ
-		tip = genesis.Hash
+		err = writeDBVersion(tx)
+		if err != nil {
+			return err
+		}
 
-		return nil
+		err = writeNetworkParams(tx)
+		if err != nil {
+			return err
+		}
+
+		// Наличие корзин индексов включает их ведение
+		if txIndex {
+			for _, name := range []string{txIndexBucket, addrIndexBucket} {
+				_, err = tx.CreateBucket([]byte(name))
+				if err != nil {
+					return err
+				}
+			}
+
+			err = indexBlock(tx, genesis)
+			if err != nil {
+				return err
+			}
+		}
+
+		return UTXOSet{bc}.connectBlock(tx, genesis)
 	})
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		os.Remove(dbFile)
+		return nil, err
 	}
 
-	bc := Blockchain{tip, db}
-
-	return &bc
+	return bc, nil
 }
 
-// NewBlockchain создает новый блокчейн с имеющейся базой данных
-func NewBlockchain(nodeID string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+// NewBlockchain создает новый блокчейн с имеющейся базой данных.
+// Если chainstate не соответствует вершине цепочки, он восстанавливается до открытия
+func NewBlockchain(nodeID string) (*Blockchain, error) {
+	dbFile := dataPath(dbFile, nodeID)
 	if dbExists(dbFile) == false {
-		fmt.Println("No existing blockchain found. Create one first.")
-		os.Exit(1)
+		return nil, ErrNoBlockchain
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: dbOpenTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is locked by another process, stop the node first", dbFile)
+	}
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	bc := &Blockchain{db: db, orphans: make(map[string][]*Block), log: nodeLogger(nodeID)}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		// Блоки старого формата не читаются, базу надо сначала сконвертировать
+		if readDBVersion(tx) < dbVersion {
+			return ErrDBOutdated
+		}
+		if name := readNetworkName(tx); name != activeNetwork.Name {
+			return fmt.Errorf("%w: %s is created in %s", ErrWrongNetwork, dbFile, name)
+		}
+		// Сложность блоков считается по времени между блоками, с которым создана цепочка
+		targetBlockInterval = readTargetInterval(tx)
+
 		// Получаем корзину
 		b := tx.Bucket([]byte(blocksBucket))
-		// Получаем из корзины последний хэш
-		tip = b.Get([]byte("l"))
+		// Получаем из корзины последний хэш, данные bolt действительны только внутри транзакции
+		bc.tip = append([]byte{}, b.Get([]byte("l"))...)
 
 		return nil
 	})
+	if err == nil {
+		err = bc.checkChainstate()
+	}
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	bc := Blockchain{tip, db}
+	return bc, nil
+}
+
+// checkChainstate сверяет chainstate с вершиной цепочки. Сейчас они меняются в одной транзакции базы,
+// но старые версии обновляли их по отдельности и могли оставить после сбоя расхождение.
+// Сначала chainstate доводится до вершины обычной реорганизацией, если не вышло - строится заново
+func (bc *Blockchain) checkChainstate() error {
+	var stateTip []byte
+	hasState, pruned := false, false
 
-	return &bc
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		stateTip = append([]byte{}, readChainstateTip(tx)...)
+		hasState = tx.Bucket([]byte(utxoBucket)) != nil
+		pruned = readPruneHeight(tx) > 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hasState && bytes.Equal(stateTip, bc.tip) {
+		return nil
+	}
+
+	// Обрезку включили уже после того, как обновления стали атомарными, а построить chainstate заново
+	// у такой базы нечем, поэтому ему можно доверять и просто запомнить вершину
+	if hasState && len(stateTip) == 0 && pruned {
+		return bc.db.Update(func(tx *bolt.Tx) error {
+			return writeChainstateTip(tx, bc.tip)
+		})
+	}
+
+	if hasState && len(stateTip) > 0 {
+		err = bc.db.Update(func(tx *bolt.Tx) error {
+			tipData := tx.Bucket([]byte(blocksBucket)).Get(bc.tip)
+			if tipData == nil {
+				return fmt.Errorf("block %x is not found", bc.tip)
+			}
+			return bc.reorganize(tx, stateTip, DeserializeBlock(tipData))
+		})
+		if err == nil {
+			bc.log.Info("Chainstate is moved to the tip", "from", stateTip)
+			return nil
+		}
+		bc.log.Warn("Failed to move chainstate to the tip", "err", err)
+	}
+
+	bc.log.Warn("Chainstate doesn't match the tip, rebuilding it")
+
+	err = UTXOSet{bc}.Reindex()
+	if err != nil {
+		return err
+	}
+
+	hasIndex, err := bc.HasTxIndex()
+	if err != nil || !hasIndex {
+		return err
+	}
+
+	// Индексы транзакций обновлялись вместе с chainstate, так что разошлись и они
+	_, err = bc.ReindexTransactions()
+	return err
 }
 
-// AddBlock сохраняет блок в нашу цепочку
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.db.Update(func(tx *bolt.Tx) error {
-		// Получаем корзину
+// AddBlock проверяет и сохраняет блок в нашу цепочку.
+// Блоки с известным родителем сохраняются всегда, даже если они лежат на боковой ветке.
+// Если совокупная работа новой ветки больше, чем у текущей, то выполняется реорганизация цепочки.
+// Блоки без родителя ждут его в памяти и добавляются, как только родитель появится.
+// Нарушение правил возвращается как RuleError
+func (bc *Blockchain) AddBlock(block *Block) error {
+	stored, err := bc.storeBlock(block)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return nil
+	}
+
+	queue := bc.takeOrphans(block.Hash)
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		stored, err := bc.storeBlock(current)
+		if err != nil {
+			bc.log.Warn("Block is rejected", "block", current.Hash, "err", err)
+			continue
+		}
+
+		// Теперь можно подцепить блоки, которые ждали именно этот
+		if stored {
+			queue = append(queue, bc.takeOrphans(current.Hash)...)
+		}
+	}
+
+	return nil
+}
+
+// storeBlock сохраняет блок и при необходимости переключает основную цепочку на его ветку.
+// Возвращает false, если блок уже был в базе или у него нет родителя
+func (bc *Blockchain) storeBlock(block *Block) (bool, error) {
+	stored := false
+	var newTip []byte
+
+	// Проверки, не требующие базы, делаем до того, как блок попадет куда-либо
+	err := CheckBlock(block)
+	if err != nil {
+		return false, err
+	}
+
+	err = bc.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
-		// Получаем блок в базе
-		blockInDb := b.Get(block.Hash)
+		// Такой блок у нас уже есть
+		if b.Get(block.Hash) != nil {
+			return nil
+		}
 
-		// Проверяем, что такого нету в базе
-		if blockInDb != nil {
+		// Родителя нет - откладываем блок до его появления
+		parentData := b.Get(block.PrevBlockHash)
+		if parentData == nil {
+			bc.addOrphan(block)
 			return nil
 		}
+		parent := DeserializeBlock(parentData)
+
+		err := checkBlockContext(block, parent, dbBlockLookup(tx))
+		if err != nil {
+			return err
+		}
 
-		// Можем теперь смело создавать блок
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		err = b.Put(block.Hash, block.Serialize())
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
+		stored = true
 
-		// Получаем предудущий хэш
+		// Сравниваем совокупную работу новой ветки и текущей основной цепочки
+		blockWork, err := chainWork(tx, block.Hash)
+		if err != nil {
+			return err
+		}
 		lastHash := b.Get([]byte("l"))
-		// Получаем данные для предыдущего блока
-		lastBlockData := b.Get(lastHash)
-		// Восстанавливаем предыдущий блок
-		lastBlock := DeserializeBlock(lastBlockData)
-
-		// Вес нового блока должен быть больше веса последнего
-		if block.Height > lastBlock.Height {
-			// Сохраняем теперь в качестве последнего - хэш текущего
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			// Сохраняем хэш в блокчейне
-			bc.tip = block.Hash
+		tipWork, err := chainWork(tx, lastHash)
+		if err != nil {
+			return err
 		}
 
-		return nil
+		if blockWork.Cmp(tipWork) <= 0 {
+			bc.log.Info("Block is stored on a side branch", "block", block.Hash, "height", block.Height)
+			return nil
+		}
+
+		err = bc.reorganize(tx, lastHash, block)
+		if err != nil {
+			return err
+		}
+
+		newTip = block.Hash
+
+		err = b.Put([]byte("l"), block.Hash)
+		if err != nil || pruneKeep == 0 {
+			return err
+		}
+
+		// Вместе с новой вершиной обрезаем блоки, ставшие слишком старыми
+		_, err = pruneBlocks(tx, pruneKeep)
+		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return false, err
+	}
+
+	// Обновляем tip только после успешной записи транзакции в базу
+	if newTip != nil {
+		bc.setTip(newTip)
 	}
+
+	return stored, nil
 }
 
-// FindTransaction finds a transaction by its ID
-func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
+// reorganize переключает chainstate с ветки oldTipHash на ветку, которая заканчивается newTip.
+// Блоки старой ветки до точки разветвления отключаются, блоки новой ветки подключаются по порядку
+func (bc *Blockchain) reorganize(tx *bolt.Tx, oldTipHash []byte, newTip *Block) error {
+	b := tx.Bucket([]byte(blocksBucket))
+	UTXOSet := UTXOSet{bc}
 
-	for {
-		block, hasNext := bci.Next()
+	parentOf := func(block *Block) (*Block, error) {
+		data := b.Get(block.PrevBlockHash)
+		if data == nil {
+			return nil, fmt.Errorf("block %x is not found", block.PrevBlockHash)
+		}
+		return DeserializeBlock(data), nil
+	}
 
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
+	var detach, attach []*Block
+	var err error
+	oldBlock := DeserializeBlock(b.Get(oldTipHash))
+	newBlock := newTip
+
+	// Выравниваем высоты обеих веток, затем идем назад до общего предка
+	for oldBlock.Height > newBlock.Height {
+		detach = append(detach, oldBlock)
+		if oldBlock, err = parentOf(oldBlock); err != nil {
+			return err
+		}
+	}
+	for newBlock.Height > oldBlock.Height {
+		attach = append([]*Block{newBlock}, attach...)
+		if newBlock, err = parentOf(newBlock); err != nil {
+			return err
+		}
+	}
+	for bytes.Compare(oldBlock.Hash, newBlock.Hash) != 0 {
+		detach = append(detach, oldBlock)
+		attach = append([]*Block{newBlock}, attach...)
+		if oldBlock, err = parentOf(oldBlock); err != nil {
+			return err
 		}
+		if newBlock, err = parentOf(newBlock); err != nil {
+			return err
+		}
+	}
 
-		if hasNext == false {
+	for _, block := range detach {
+		// Без транзакций и undo-данных выходы блока не восстановить
+		if block.isPruned() {
+			return fmt.Errorf("%w: block %x can't be disconnected", ErrPruned, block.Hash)
+		}
+
+		err = UTXOSet.disconnectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+		err = unindexBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+	for _, block := range attach {
+		err = UTXOSet.connectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+		err = indexBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(detach) > 0 {
+		bc.log.Info("Chain reorganization", "height", oldBlock.Height, "disconnected", len(detach), "connected", len(attach))
+	}
+
+	return nil
+}
+
+// chainWork возвращает совокупную работу цепочки от генезиса до блока с хэшем hash.
+// Значения кэшируются в корзине chainwork, отсутствующие досчитываются по цепочке родителей
+func chainWork(tx *bolt.Tx, hash []byte) (*big.Int, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+	w, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return nil, err
+	}
+
+	total := big.NewInt(0)
+	var pending []*Block
+
+	for len(hash) > 0 {
+		if value := w.Get(hash); value != nil {
+			total.SetBytes(value)
 			break
 		}
+
+		blockData := b.Get(hash)
+		if blockData == nil {
+			return nil, fmt.Errorf("block %x is not found", hash)
+		}
+		block := DeserializeBlock(blockData)
+		pending = append(pending, block)
+
+		hash = block.PrevBlockHash
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	for i := len(pending) - 1; i >= 0; i-- {
+		total.Add(total, NewProofOfWork(pending[i]).Work())
+
+		err = w.Put(pending[i].Hash, total.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return total, nil
 }
 
-// FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
-func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
-	UTXO := make(map[string]TXOutputs)
-	spentTXOs := make(map[string][]int)
+// addOrphan запоминает блок, родитель которого еще не получен
+func (bc *Blockchain) addOrphan(block *Block) {
+	bc.orphansLock.Lock()
+	defer bc.orphansLock.Unlock()
+
+	count := 0
+	for _, blocks := range bc.orphans {
+		count += len(blocks)
+	}
+	// Не даем пулу расти бесконечно, просто сбрасываем его
+	if count >= maxOrphanBlocks {
+		bc.orphans = make(map[string][]*Block)
+	}
+
+	prevHash := hex.EncodeToString(block.PrevBlockHash)
+	for _, orphan := range bc.orphans[prevHash] {
+		if bytes.Compare(orphan.Hash, block.Hash) == 0 {
+			return
+		}
+	}
+	bc.orphans[prevHash] = append(bc.orphans[prevHash], block)
+
+	bc.log.Info("Block is an orphan, waiting for its parent", "block", block.Hash, "parent", block.PrevBlockHash)
+}
+
+// takeOrphans возвращает и удаляет из пула блоки, ожидавшие родителя parentHash
+func (bc *Blockchain) takeOrphans(parentHash []byte) []*Block {
+	bc.orphansLock.Lock()
+	defer bc.orphansLock.Unlock()
+
+	key := hex.EncodeToString(parentHash)
+	blocks := bc.orphans[key]
+	delete(bc.orphans, key)
+
+	return blocks
+}
+
+// FindTransaction finds a transaction by its ID, the transaction index is used when it is enabled
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	tx, found, indexed, err := bc.findIndexedTransaction(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if indexed {
+		if !found {
+			return Transaction{}, errors.New("Transaction is not found")
+		}
+		return tx, nil
+	}
+
 	bci := bc.Iterator()
 
 	for {
 		block, hasNext := bci.Next()
+		if block == nil {
+			break
+		}
 
 		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				// Was the output spent?
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
-			}
-
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Vin {
-					inTxID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-				}
+			if bytes.Compare(tx.ID, ID) == 0 {
+				return *tx, nil
 			}
 		}
 
@@ -218,19 +538,24 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 			break
 		}
 	}
+	if err := bci.Err(); err != nil {
+		return Transaction{}, err
+	}
 
-	return UTXO
+	return Transaction{}, errors.New("Transaction is not found")
 }
 
 // Iterator returns a BlockchainIterat
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.db}
+	bc.tipLock.Lock()
+	bci := &BlockchainIterator{currentHash: bc.tip, db: bc.db}
+	bc.tipLock.Unlock()
 
 	return bci
 }
 
 // GetBestHeight returns the height of the latest block
-func (bc *Blockchain) GetBestHeight() int {
+func (bc *Blockchain) GetBestHeight() (int, error) {
 	var lastBlock Block
 
 	err := bc.db.View(func(tx *bolt.Tx) error {
@@ -241,11 +566,8 @@ func (bc *Blockchain) GetBestHeight() int {
 
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
-	}
 
-	return lastBlock.Height
+	return lastBlock.Height, err
 }
 
 // GetBlock finds a block by its hash and returns it
@@ -272,108 +594,322 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
-// GetBlockHashes returns a list of hashes of all the blocks in the chain
-func (bc *Blockchain) GetBlockHashes() [][]byte {
-	var blocks [][]byte
+// BlockLocator returns hashes of main chain blocks from the tip to genesis: the last ten in a row,
+// then with doubling steps. A peer finds the last block we have in common by it
+func (bc *Blockchain) BlockLocator() ([][]byte, error) {
+	var locator [][]byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		lookup := dbBlockLookup(tx)
+		locator = blockLocator(lookup(tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))), lookup)
+		return nil
+	})
+
+	return locator, err
+}
+
+// blockLocator собирает локатор, спускаясь от tip по родителям. Генезис добавляется всегда
+func blockLocator(tip *Block, lookup blockLookup) [][]byte {
+	var locator [][]byte
+	step := 1
+
+	for block := tip; block != nil; {
+		locator = append(locator, block.Hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+
+		// Идем назад на step блоков, но не дальше генезиса
+		prev := block
+		for i := 0; i < step && len(prev.PrevBlockHash) > 0; i++ {
+			parent := lookup(prev.PrevBlockHash)
+			if parent == nil {
+				break
+			}
+			prev = parent
+		}
+		if prev == block {
+			break
+		}
+		block = prev
+	}
+
+	return locator
+}
+
+// HeadersAfter returns up to max headers of main chain blocks following the first locator hash
+// found in the main chain. When none is found they follow genesis, which all nodes share,
+// an empty locator gets genesis too
+func (bc *Blockchain) HeadersAfter(locator [][]byte, max int) ([]*Block, error) {
+	known := make(map[string]bool)
+	for _, hash := range locator {
+		known[hex.EncodeToString(hash)] = true
+	}
+
+	var headers []*Block
 	bci := bc.Iterator()
 
 	for {
 		block, hasNext := bci.Next()
+		if block == nil || known[hex.EncodeToString(block.Hash)] {
+			break
+		}
+		// Генезис нужен только тому, у кого нет ни одного блока, и кто прислал пустой локатор
+		if hasNext == false && len(locator) > 0 {
+			break
+		}
 
-		blocks = append(blocks, block.Hash)
+		header := *block
+		header.Transactions = nil
+		headers = append(headers, &header)
 
 		if hasNext == false {
 			break
 		}
 	}
+	if err := bci.Err(); err != nil {
+		return nil, err
+	}
 
-	return blocks
+	// Собирали от вершины, а отдаем от старых к новым
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	if len(headers) > max {
+		headers = headers[:max]
+	}
+
+	return headers, nil
+}
+
+// ChainWork returns the total work of the chain ending with the stored block
+func (bc *Blockchain) ChainWork(hash []byte) (*big.Int, error) {
+	var work *big.Int
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		work, err = chainWork(tx, hash)
+		return err
+	})
+
+	return work, err
+}
+
+// TipHash returns the hash of the last main chain block
+func (bc *Blockchain) TipHash() ([]byte, error) {
+	var tip []byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		tip = append([]byte{}, tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))...)
+		return nil
+	})
+
+	return tip, err
+}
+
+// GetBlockHashByHeight returns the hash of the main chain block at the height
+func (bc *Blockchain) GetBlockHashByHeight(height int) ([]byte, error) {
+	bci := bc.Iterator()
+
+	for {
+		block, hasNext := bci.Next()
+		if block == nil || block.Height < height {
+			break
+		}
+
+		if block.Height == height {
+			return block.Hash, nil
+		}
+
+		if hasNext == false {
+			break
+		}
+	}
+	if err := bci.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("Block is not found")
+}
+
+// tipNotify returns a channel which is closed when the tip changes
+func (bc *Blockchain) tipNotify() <-chan struct{} {
+	bc.tipLock.Lock()
+	defer bc.tipLock.Unlock()
+
+	if bc.tipChanged == nil {
+		bc.tipChanged = make(chan struct{})
+	}
+
+	return bc.tipChanged
+}
+
+// setTip запоминает новую вершину и будит всех, кто ждет ее смены, следующие подписчики получат новый канал
+func (bc *Blockchain) setTip(tip []byte) {
+	bc.tipLock.Lock()
+	defer bc.tipLock.Unlock()
+
+	bc.tip = tip
+	if bc.tipChanged != nil {
+		close(bc.tipChanged)
+		bc.tipChanged = nil
+	}
+}
+
+// MineBlock mines a new block with the provided transactions, interrupted attempts are repeated on a new template
+func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
+	var total MiningStats
+	for {
+		block, stats, err := bc.MineBlockContext(context.Background(), transactions, miningThreads)
+		total = total.Add(stats)
+		if errors.Is(err, ErrTipChanged) || errors.Is(err, ErrStaleTemplate) {
+			continue
+		}
+		if err == nil {
+			bc.log.Info("New block is mined", "block", block.Hash, "height", block.Height,
+				"threads", miningThreads, "hashes", total.Hashes, "duration", total.Duration.Round(time.Millisecond), "hashrate", total)
+		}
+
+		return block, err
+	}
 }
 
-// MineBlock mines a new block with the provided transactions
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
+// MineBlockContext mines a new block with the provided transactions on top of the current tip.
+// Mining stops with ErrTipChanged when another block becomes the tip and with ErrStaleTemplate
+// after templateLifetime, the caller is expected to build a new template then
+func (bc *Blockchain) MineBlockContext(ctx context.Context, transactions []*Transaction, threads int) (*Block, MiningStats, error) {
 	var lastHash []byte
 	var lastHeight int
+	var bits uint32
 
 	for _, tx := range transactions {
-		// TODO: ignore transaction if it's not valid
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
+		valid, err := bc.VerifyTransaction(tx)
+		if err != nil {
+			return nil, MiningStats{}, err
+		}
+		if !valid {
+			return nil, MiningStats{}, fmt.Errorf("invalid transaction %x", tx.ID)
 		}
 	}
 
+	// Подписываемся до чтения вершины, иначе можно пропустить ее смену
+	tipChanged := bc.tipNotify()
+
 	err := bc.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
 
 		blockData := b.Get(lastHash)
 		block := DeserializeBlock(blockData)
 
 		lastHeight = block.Height
+		bits = nextWorkRequired(block, dbBlockLookup(tx))
 
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, MiningStats{}, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	miningCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
+	stale := time.AfterFunc(templateLifetime, func() { cancel(ErrStaleTemplate) })
+	defer stale.Stop()
 
-		err = b.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel(ErrTipChanged)
+		case <-miningCtx.Done():
 		}
+	}()
 
-		bc.tip = newBlock.Hash
+	newBlock, stats, err := NewBlockContext(miningCtx, transactions, lastHash, lastHeight+1, bits, threads)
+	if err != nil && miningCtx.Err() != nil {
+		return nil, stats, context.Cause(miningCtx)
+	}
+	if err != nil {
+		return nil, stats, err
+	}
 
-		return nil
-	})
+	// Сохраняем блок тем же путем, что и полученные от других нодов, вместе с обновлением chainstate
+	_, err = bc.storeBlock(newBlock)
 	if err != nil {
-		log.Panic(err)
+		return nil, stats, err
 	}
 
-	return newBlock
+	return newBlock, stats, nil
 }
 
 // SignTransaction signs inputs of a Transaction
-func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	tx.Sign(privKey, prevTXs)
+
+	return nil
+}
+
+// prevTransactions находит транзакции, выходы которых тратит tx. Транзакции обрезанных блоков
+// восстанавливаются из chainstate: у них есть только тратящиеся выходы, чего хватает для подписи и проверки
+func (bc *Blockchain) prevTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
+	UTXOSet := UTXOSet{bc}
 
 	for _, vin := range tx.Vin {
+		key := hex.EncodeToString(vin.Txid)
+
 		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
+		if err == nil {
+			prevTXs[key] = prevTX
+			continue
+		}
+
+		out, found, utxoErr := UTXOSet.FindOutput(vin.Txid, vin.Vout)
+		if utxoErr != nil {
+			return nil, utxoErr
 		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		if !found || vin.Vout < 0 {
+			return nil, err
+		}
+		prevTX = prevTXs[key]
+		prevTX.ID = vin.Txid
+		for len(prevTX.Vout) <= vin.Vout {
+			prevTX.Vout = append(prevTX.Vout, TXOutput{})
+		}
+		prevTX.Vout[vin.Vout] = out
+		prevTXs[key] = prevTX
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return prevTXs, nil
 }
 
-// VerifyTransaction verifies transaction input signatures
-func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+// VerifyTransaction verifies transaction input signatures. Spending a coinbase output which won't be mature
+// in the next block is an error
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) (bool, error) {
 	if tx.IsCoinbase() {
-		return true
+		return true, nil
 	}
 
-	prevTXs := make(map[string]Transaction)
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return false, err
+	}
+	err = (UTXOSet{bc}).checkMaturity(tx, bestHeight+1)
+	if err != nil {
+		return false, err
+	}
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return false, err
 	}
 
-	return tx.Verify(prevTXs)
+	return tx.Verify(prevTXs), nil
 }
 
 func dbExists(dbFile string) bool {
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/boltdb/bolt"
 )
@@ -10,11 +10,13 @@ import (
 type BlockchainIterator struct {
 	currentHash []byte   // Хэш текущий
 	db          *bolt.DB // База данных
+	err         error    // Ошибка, на которой обход остановился
 }
 
-// Next возвращает следующий блок, начиная с tip, возвращает блок и наличие следующего блока
+// Next возвращает следующий блок, начиная с tip, возвращает блок и наличие следующего блока.
+// При ошибке чтения базы возвращается nil, саму ошибку отдает Err
 func (i *BlockchainIterator) Next() (*Block, bool) {
-	if len(i.currentHash) == 0 {
+	if len(i.currentHash) == 0 || i.err != nil {
 		return nil, false
 	}
 
@@ -26,14 +28,17 @@ func (i *BlockchainIterator) Next() (*Block, bool) {
 		b := tx.Bucket([]byte(blocksBucket))
 		// Получаем данные блока для текущего хэша
 		encodedBlock := b.Get(i.currentHash)
+		if encodedBlock == nil {
+			return fmt.Errorf("block %x is not found", i.currentHash)
+		}
 		// Создаем непосредственно блок из данных
 		block = DeserializeBlock(encodedBlock)
 
 		return nil
 	})
-
 	if err != nil {
-		log.Panic(err)
+		i.err = err
+		return nil, false
 	}
 
 	// Для следующей итерации сохраняем хэш предыдущего блока, по сути - обратный обход очереди получается
@@ -41,3 +46,8 @@ func (i *BlockchainIterator) Next() (*Block, bool) {
 
 	return block, (len(i.currentHash) != 0)
 }
+
+// Err returns the error which stopped the iteration
+func (i *BlockchainIterator) Err() error {
+	return i.err
+}
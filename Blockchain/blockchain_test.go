@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// spendCoinbasesAtOnce позволяет до конца теста тратить награды за блок сразу, созревание проверяет
+// TestCoinbaseMaturityAndHalving. Параметры сетей при этом не меняются
+func spendCoinbasesAtOnce(t *testing.T) {
+	oldMaturity := coinbaseMaturity
+	coinbaseMaturity = 0
+	t.Cleanup(func() { coinbaseMaturity = oldMaturity })
+}
+
+// reopenBlockchain закрывает базу после change и открывает ее заново, как после перезапуска
+func reopenBlockchain(t *testing.T, bc *Blockchain, change func(tx *bolt.Tx) error) *Blockchain {
+	assert.NoError(t, bc.db.Update(change))
+	bc.db.Close()
+
+	bc, err := NewBlockchain("r")
+	assert.NoError(t, err)
+	t.Cleanup(func() { bc.db.Close() })
+
+	return bc
+}
+
+func TestNewBlockchainRepairsChainstate(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	_, err := NewBlockchain("r")
+	assert.ErrorIs(t, err, ErrNoBlockchain)
+
+	owner := NewWallet()
+	address := string(owner.GetAddress())
+	bc, err := CreateBlockchain(address, "r", true)
+	assert.NoError(t, err)
+	_, err = CreateBlockchain(address, "r", true)
+	assert.ErrorIs(t, err, ErrDBExists)
+
+	testMine(t, bc, NewCoinbaseTX(address, "", testHeight(t, bc)+1, 0))
+	payment := testPayment(t, owner, string(NewWallet().GetAddress()), 5, 1, bc)
+	tip := testMine(t, bc, NewCoinbaseTX(address, "", testHeight(t, bc)+1, 1), payment)
+	count := testCountUTXO(t, bc)
+
+	// Вершина записана, а chainstate остался на предыдущем блоке - так падали старые версии
+	bc = reopenBlockchain(t, bc, func(tx *bolt.Tx) error {
+		err := UTXOSet{bc}.disconnectBlock(tx, tip)
+		if err != nil {
+			return err
+		}
+		return unindexBlock(tx, tip)
+	})
+	assert.Equal(t, count, testCountUTXO(t, bc))
+	txBlock, found, err := bc.FindTransactionBlock(payment.ID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, tip.Hash, txBlock)
+
+	// Базы, созданные до появления отметки, без chainstate вовсе строят его заново
+	bc = reopenBlockchain(t, bc, func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(metaBucket)).Delete([]byte(chainstateTipKey))
+	})
+	assert.Equal(t, count, testCountUTXO(t, bc))
+
+	assert.NoError(t, bc.db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, tip.Hash, readChainstateTip(tx))
+		return nil
+	}))
+}
+
+func TestCoinbaseMaturityAndHalving(t *testing.T) {
+	oldMaturity, oldHalving := coinbaseMaturity, halvingInterval
+	coinbaseMaturity, halvingInterval = 3, 4
+	defer func() { coinbaseMaturity, halvingInterval = oldMaturity, oldHalving }()
+
+	owner := NewWallet()
+	address, other := string(owner.GetAddress()), string(NewWallet().GetAddress())
+	a, b := syncedChains(t, address)
+
+	// Награда генезиса тратится не раньше блока на высоте 3
+	testMine(t, a, NewCoinbaseTX(other, "", 1, 0))
+	_, err := NewUTXOTransaction(owner, other, 5, 1, &UTXOSet{a})
+	assert.ErrorIs(t, err, ErrNotEnoughFunds)
+	testMine(t, a, NewCoinbaseTX(other, "", 2, 0))
+	payment := testPayment(t, owner, other, 5, 1, a)
+
+	// На цепочке без этих блоков та же трата преждевременна
+	assert.ErrorIs(t, NewMempool(b).Add(*payment), ErrImmatureCoinbase)
+	_, err = b.VerifyTransaction(payment)
+	assert.ErrorIs(t, err, ErrImmatureCoinbase)
+	early := NewBlock([]*Transaction{NewCoinbaseTX(other, "", 1, 1), payment}, testTip(t, b), 1, BigToCompact(initialTarget))
+	assert.ErrorIs(t, b.AddBlock(early), ErrImmatureCoinbase)
+
+	// Награда уменьшается вдвое каждые 4 блока, больше положенного блок заплатить не может
+	block := testMine(t, a, NewCoinbaseTX(other, "", 3, 1), payment)
+	assert.Equal(t, subsidy+1, block.Transactions[0].Vout[0].Value)
+	block = testMine(t, a, NewCoinbaseTX(other, "", 4, 0))
+	assert.Equal(t, subsidy/2, block.Transactions[0].Vout[0].Value)
+	_, err = a.MineBlock([]*Transaction{NewCoinbaseTX(other, "", 4, 1)})
+	assert.ErrorIs(t, err, ErrBadCoinbaseValue)
+
+	// Выпуск заканчивается после 4 уменьшений: (10 + 5 + 2 + 1) * 4
+	assert.Equal(t, 0, blockSubsidy(16))
+	assert.Equal(t, 72, maxSupply())
+	inflated := NewBlock([]*Transaction{NewCoinbaseTX(other, "", 5, 100)}, block.Hash, 5, block.Bits)
+	assert.ErrorIs(t, CheckBlock(inflated), ErrMaxSupply)
+}
+
+// testChainstate возвращает содержимое chainstate и undo-данных для сравнения
+func testChainstate(t *testing.T, bc *Blockchain) map[string]string {
+	return testBuckets(t, bc, utxoBucket, undoBucket)
+}
+
+// testBuckets возвращает содержимое корзин базы в hex
+func testBuckets(t *testing.T, bc *Blockchain, names ...string) map[string]string {
+	state := make(map[string]string)
+	assert.NoError(t, bc.db.View(func(tx *bolt.Tx) error {
+		for _, name := range names {
+			err := tx.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+				state[name+":"+hex.EncodeToString(k)] = hex.EncodeToString(v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	return state
+}
+
+func TestReorganizeMatchesReindex(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address, other := string(owner.GetAddress()), string(NewWallet().GetAddress())
+	a, b := syncedChains(t, address)
+
+	// Обе ветки тратят награду генезиса, ветка b длиннее
+	payment := testPayment(t, owner, other, 5, 1, a)
+	testMine(t, a, NewCoinbaseTX(address, "", 1, 1), payment)
+	conflict := testPayment(t, owner, other, 3, 2, b)
+	testMine(t, b, NewCoinbaseTX(other, "", 1, 2), conflict)
+	testMine(t, b, NewCoinbaseTX(other, "", 2, 0))
+
+	for height := 1; height <= 2; height++ {
+		hash, err := b.GetBlockHashByHeight(height)
+		assert.NoError(t, err)
+		block, err := b.GetBlock(hash)
+		assert.NoError(t, err)
+		assert.NoError(t, a.AddBlock(&block))
+	}
+	assert.Equal(t, testTip(t, b), testTip(t, a))
+
+	// Выходы отключенной ветки ушли, потраченные ей выходы вернулись и потрачены веткой b
+	_, found, err := UTXOSet{a}.FindOutput(payment.ID, 0)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = UTXOSet{a}.FindOutput(conflict.ID, 0)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// После реорганизации chainstate такой же, как у цепочки, построенной заново
+	state := testChainstate(t, a)
+	assert.Equal(t, testChainstate(t, b), state)
+	assert.NoError(t, UTXOSet{a}.Reindex())
+	assert.Equal(t, state, testChainstate(t, a))
+}
@@ -4,102 +4,349 @@ import (
 	"flag"
 	"fmt"
 	"log"
-
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // CLI responsible for processing command line arguments
 type CLI struct{}
 
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address <ADDRESS> (Create a blockchain and send genesis block reward to ADDRESS)")
-	fmt.Println("  createwallet (Generates a new key-pair and saves it into the wallet file)")
+	fmt.Println("Usage: [-conf <FILE>] [-datadir <DIR>] [-loglevel <debug|info|warn|error>] [-network <mainnet|testnet|regtest>] <COMMAND>")
+	fmt.Println("  -conf reads settings from FILE, <DIR>/node.conf by default. Its lines are key=value with keys network, datadir, nodeid, listen, seed, miner, rpc, rpcuser, rpcpassword, explorer, metrics and loglevel, flags override them")
+	fmt.Println("  -datadir keeps databases and wallets in DIR, testnet and regtest use its subdirectories")
+	fmt.Println("  -loglevel drops log records of the node below the level, info by default")
+	fmt.Println("  -network selects the network, regtest mines blocks instantly")
+	fmt.Println("  NODE_ID env. var names the files of the node, the default port of the network is used when it is not set")
+	fmt.Println("Commands:")
+	fmt.Println("  broadcasttx -hex <HEX> -node <HOST:PORT> (Sends the signed transaction to the node, the first seed node by default)")
+	fmt.Println("  createblockchain -address <ADDRESS> -target-interval <SECONDS> -txindex (Create a blockchain and send genesis block reward to ADDRESS. -target-interval sets the desired time between blocks of a local test network, it is stored in the database, -txindex=false disables transaction and address indexes)")
+	fmt.Println("  createmultisig -required <M> -pubkeys <KEY1,KEY2,...> (Creates an address spendable with M signatures of the hex public keys and saves its script into the wallet)")
+	fmt.Println("  createpsbt -from <FROM> -to <TO> -amount <AMOUNT> -fee <FEE> -locktime <LOCKTIME> -file <FILE> (Saves an unsigned transaction to FILE for signpsbt, FROM can be a multisig or time-locked address)")
+	fmt.Println("  createrawtx -from <FROM> -to <TO> -amount <AMOUNT> -fee <FEE> -locktime <LOCKTIME> (Prints the hex of an unsigned transaction with the outputs it spends, no private keys are needed)")
+	fmt.Println("  createtimelock -address <ADDRESS> -locktime <LOCKTIME> (Creates an address spendable by ADDRESS after block height LOCKTIME, or unix time when it is 500000000 or more)")
+	fmt.Println("  createwallet (Derives a new address from the wallet seed and saves it into the wallet file. The seed is generated with the first address)")
+	fmt.Println("  decoderawtx -hex <HEX> (Prints the transaction, for an unsigned one also the spent outputs and the fee)")
+	fmt.Println("  encryptwallet (Encrypts private keys and the seed in the wallet file with a passphrase typed twice in the terminal or taken from WALLET_PASSPHRASE env. var)")
+	fmt.Println("  exportseed (Prints the mnemonic of the wallet seed)")
+	fmt.Println("  exportsnapshot -file <FILE> (Saves the main chain headers and the UTXO set to FILE with a checksum)")
+	fmt.Println("  finalizepsbt -file <FILE> -miner <ADDRESS> (Builds the signed transaction from FILE and sends it, or mines it on the same node paying the reward to ADDRESS)")
 	fmt.Println("  getbalance -address <ADDRESS> (Get balance of ADDRESS)")
+	fmt.Println("  getpubkey -address <ADDRESS> (Prints the public key of the wallet ADDRESS for createmultisig)")
+	fmt.Println("  importsnapshot -file <FILE> (Creates the blockchain of the node from a snapshot, old blocks keep only headers as if they were pruned)")
 	fmt.Println("  listaddresses (Lists all addresses from the wallet file)")
+	fmt.Println("  listtransactions -address <ADDRESS> -count <N> (Lists the last N transactions of the wallet or of its ADDRESS with confirmations, pending ones are sent by this wallet and not mined yet, 0 lists all)")
+	fmt.Println("  migratedb (Converts the blockchain database of an older version to the current encoding, the old file is kept with .bak suffix)")
 	fmt.Println("  printchain (Print all the blocks of the blockchain)")
+	fmt.Println("  rescan (Rebuilds the wallet transaction history from the blockchain, run it after restorewallet)")
+	fmt.Println("  restorewallet -mnemonic <MNEMONIC> -count <N> -encrypt (Creates the wallet file from the seed with N first addresses, -encrypt encrypts it like encryptwallet)")
 	fmt.Println("  reindexutxo (Rebuilds the UTXO set)")
-	fmt.Println("  send -from <FROM> -to <TO> -amount <AMOUNT> -mine (Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.)")
-	fmt.Println("  startnode -miner <ADDRESS> (Start a node with ID specified in NODE_ID env. var. -miner enables mining)")
+	fmt.Println("  reindextxindex (Builds or rebuilds transaction and address indexes)")
+	fmt.Println("  send -from <FROM> -to <TO> -amount <AMOUNT> -fee <FEE> -mine -threads <N> (Send AMOUNT of coins from FROM address to TO paying FEE to the miner. Mine on the same node with N goroutines, when -mine is set.)")
+	fmt.Println("  signpsbt -file <FILE> -sighash <ALL|NONE|SINGLE[|ANYONECANPAY]> (Adds signatures of the wallet keys to the transaction in FILE, -sighash selects what they cover)")
+	fmt.Println("  signrawtx -hex <HEX> -sighash <ALL|NONE|SINGLE[|ANYONECANPAY]> (Signs the transaction with the wallet keys without the blockchain and the network, prints the hex of the signed transaction when it is complete)")
+	fmt.Println("  startnode -explorer <HOST:PORT> -listen <HOST:PORT> -metrics <HOST:PORT> -miner <ADDRESS> -prune <N> -rpc <HOST:PORT> -rpcuser <USER> -rpcpassword <PASSWORD> -seeds <HOST:PORT,...> -spv -threads <N> -wire-encoding <binary|gob> (Start a node. -explorer serves HTML pages and JSON under /api/ with blocks, transactions, addresses, the mempool and peers, -listen sets the address for incoming connections, localhost:NODE_ID by default, -metrics serves chain, mempool, peer, mining and database metrics for Prometheus, -miner enables mining on -threads goroutines, -prune keeps transactions of the last N blocks only, -rpc enables JSON-RPC protected with -rpcuser and -rpcpassword when they are set, -seeds replaces the seed nodes of the network, -spv runs a light client keeping only headers and checking wallet transactions by Merkle proofs, -wire-encoding selects the encoding of sent messages)")
+	fmt.Println("  Commands using private keys or the seed of an encrypted wallet ask for the passphrase in the terminal or take it from WALLET_PASSPHRASE env. var, it is never saved")
 }
 
 // Проверяем, что параметры были введены, иначе выводим сообщение по использованию
-func (cli *CLI) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CLI) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		os.Exit(1)
 	}
 }
 
-// Run parses command line arguments and processes commands
-func (cli *CLI) Run() {
-	cli.validateArgs()
+// loadConfig собирает настройки из файла конфигурации, общих параметров и NODE_ID и применяет их к процессу
+func (cli *CLI) loadConfig(confFile, dataDirFlag, logLevelFlag, networkFlag string) Config {
+	config := DefaultConfig()
+	if dataDirFlag != "" {
+		config.DataDir = dataDirFlag
+	}
+
+	// Файл по умолчанию может отсутствовать, а явно указанный обязан быть
+	file := confFile
+	if file == "" {
+		file = filepath.Join(config.DataDir, configFile)
+	}
+	err := LoadConfig(file, &config)
+	if err != nil && (confFile != "" || !os.IsNotExist(err)) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if dataDirFlag != "" {
+		config.DataDir = dataDirFlag
+	}
+	if logLevelFlag != "" {
+		config.LogLevel = logLevelFlag
+	}
+	if networkFlag != "" {
+		config.Network = networkFlag
+	}
+	if nodeID := os.Getenv("NODE_ID"); nodeID != "" {
+		config.NodeID = nodeID
+	}
+
+	err = config.Apply()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return config
+}
 
-	// Получаем NODE_ID
-	nodeID := os.Getenv("NODE_ID")
-	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!")
+// openBlockchain открывает базу нода для команды, без нее команде делать нечего
+func openBlockchain(nodeID string) *Blockchain {
+	bc, err := NewBlockchain(nodeID)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	return bc
+}
+
+// Run parses command line arguments and processes commands
+func (cli *CLI) Run() {
+	// Общие параметры идут до команды
+	globalFlags := flag.NewFlagSet("global", flag.ExitOnError)
+	globalFlags.Usage = cli.printUsage
+	confFile := globalFlags.String("conf", "", "Config file, node.conf in the data directory by default")
+	dataDirFlag := globalFlags.String("datadir", "", "Directory of databases and wallets")
+	logLevelFlag := globalFlags.String("loglevel", "", "Log level: debug, info, warn or error")
+	networkFlag := globalFlags.String("network", "", "Network: mainnet, testnet or regtest")
+	err := globalFlags.Parse(os.Args[1:])
+	if err != nil {
+		log.Panic(err)
+	}
+	args := globalFlags.Args()
+	cli.validateArgs(args)
+
+	config := cli.loadConfig(*confFile, *dataDirFlag, *logLevelFlag, *networkFlag)
+	nodeID := config.NodeID
+
 	// Получаем значения команд, которые надо выполнить
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	exportSeedCmd := flag.NewFlagSet("exportseed", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxIndexCmd := flag.NewFlagSet("reindextxindex", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createTimeLockCmd := flag.NewFlagSet("createtimelock", flag.ExitOnError)
+	createPSBTCmd := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	signPSBTCmd := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	finalizePSBTCmd := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	migrateDBCmd := flag.NewFlagSet("migratedb", flag.ExitOnError)
+	exportSnapshotCmd := flag.NewFlagSet("exportsnapshot", flag.ExitOnError)
+	importSnapshotCmd := flag.NewFlagSet("importsnapshot", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	decodeRawTxCmd := flag.NewFlagSet("decoderawtx", flag.ExitOnError)
+	broadcastTxCmd := flag.NewFlagSet("broadcasttx", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	rescanCmd := flag.NewFlagSet("rescan", flag.ExitOnError)
 
 	// Получаем значения параметров, которые после черточки
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainTxIndex := createBlockchainCmd.Bool("txindex", true, "Maintain transaction and address indexes")
+	createBlockchainTargetInterval := createBlockchainCmd.Int64("target-interval", targetBlockInterval, "Desired time between blocks in seconds")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic of the wallet seed")
+	restoreWalletCount := restoreWalletCmd.Int("count", 1, "Number of addresses to derive")
+	restoreWalletEncrypt := restoreWalletCmd.Bool("encrypt", false, "Encrypt the restored wallet")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
-	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeMiner := startNodeCmd.String("miner", config.Miner, "Enable mining mode and send reward to ADDRESS")
+	startNodeExplorer := startNodeCmd.String("explorer", config.Explorer, "Serve the block explorer on HOST:PORT")
+	startNodeListen := startNodeCmd.String("listen", config.Listen, "Address for incoming connections")
+	startNodeMetrics := startNodeCmd.String("metrics", config.Metrics, "Serve Prometheus metrics on HOST:PORT/metrics")
+	startNodeSeeds := startNodeCmd.String("seeds", strings.Join(config.Seeds, ","), "Comma separated seed nodes")
+	sendThreads := sendCmd.Int("threads", miningThreads, "Number of mining goroutines")
+	startNodeRPC := startNodeCmd.String("rpc", config.RPC, "Serve JSON-RPC on HOST:PORT")
+	startNodeRPCUser := startNodeCmd.String("rpcuser", config.RPCUser, "User required by JSON-RPC")
+	startNodeRPCPassword := startNodeCmd.String("rpcpassword", config.RPCPassword, "Password required by JSON-RPC")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Keep transactions of the last N blocks only, 0 keeps all blocks")
+	startNodeSPV := startNodeCmd.Bool("spv", false, "Run a light client")
+	startNodeThreads := startNodeCmd.Int("threads", miningThreads, "Number of mining goroutines")
+	startNodeWireEncoding := startNodeCmd.String("wire-encoding", "binary", "Encoding of sent messages: binary or gob")
+	getPubKeyAddress := getPubKeyCmd.String("address", "", "Wallet address")
+	createMultisigRequired := createMultisigCmd.Int("required", 1, "Number of required signatures")
+	createMultisigPubKeys := createMultisigCmd.String("pubkeys", "", "Comma separated hex public keys")
+	createTimeLockAddress := createTimeLockCmd.String("address", "", "Address which can spend after the lock time")
+	createTimeLockLockTime := createTimeLockCmd.Int64("locktime", 0, "Block height or unix time")
+	createPSBTFrom := createPSBTCmd.String("from", "", "Source address")
+	createPSBTTo := createPSBTCmd.String("to", "", "Destination address")
+	createPSBTAmount := createPSBTCmd.Int("amount", 0, "Amount to send")
+	createPSBTFee := createPSBTCmd.Int("fee", 0, "Fee paid to the miner")
+	createPSBTLockTime := createPSBTCmd.Int64("locktime", 0, "Transaction lock time, taken from a time-locked source address by default")
+	createPSBTFile := createPSBTCmd.String("file", "", "File to save the transaction to")
+	signPSBTFile := signPSBTCmd.String("file", "", "File with the transaction")
+	signPSBTSigHash := signPSBTCmd.String("sighash", "ALL", "Signature hash type: ALL, NONE or SINGLE, optionally with |ANYONECANPAY")
+	finalizePSBTFile := finalizePSBTCmd.String("file", "", "File with the transaction")
+	finalizePSBTMiner := finalizePSBTCmd.String("miner", "", "Mine on the same node and send the reward to ADDRESS")
+	exportSnapshotFile := exportSnapshotCmd.String("file", "", "File to save the snapshot to")
+	importSnapshotFile := importSnapshotCmd.String("file", "", "Snapshot file")
+	createRawTxFrom := createRawTxCmd.String("from", "", "Source address")
+	createRawTxTo := createRawTxCmd.String("to", "", "Destination address")
+	createRawTxAmount := createRawTxCmd.Int("amount", 0, "Amount to send")
+	createRawTxFee := createRawTxCmd.Int("fee", 0, "Fee paid to the miner")
+	createRawTxLockTime := createRawTxCmd.Int64("locktime", 0, "Transaction lock time, taken from a time-locked source address by default")
+	signRawTxHex := signRawTxCmd.String("hex", "", "Hex of the unsigned transaction")
+	signRawTxSigHash := signRawTxCmd.String("sighash", "ALL", "Signature hash type: ALL, NONE or SINGLE, optionally with |ANYONECANPAY")
+	decodeRawTxHex := decodeRawTxCmd.String("hex", "", "Hex of the transaction")
+	broadcastTxHex := broadcastTxCmd.String("hex", "", "Hex of the signed transaction")
+	broadcastTxNode := broadcastTxCmd.String("node", "", "Node to send the transaction to")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "Wallet address, all addresses by default")
+	listTransactionsCount := listTransactionsCmd.Int("count", 10, "Number of the last transactions, 0 lists all")
 
 	// Производим валидацию значений
-	switch os.Args[1] {
+	switch args[0] {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportseed":
+		err := exportSeedCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "reindexutxo":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindextxindex":
+		err := reindexTxIndexCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "startnode":
-		err := startNodeCmd.Parse(os.Args[2:])
+		err := startNodeCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createtimelock":
+		err := createTimeLockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createpsbt":
+		err := createPSBTCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signpsbt":
+		err := signPSBTCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "finalizepsbt":
+		err := finalizePSBTCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "migratedb":
+		err := migrateDBCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportsnapshot":
+		err := exportSnapshotCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importsnapshot":
+		err := importSnapshotCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createrawtx":
+		err := createRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signrawtx":
+		err := signRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "decoderawtx":
+		err := decodeRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "broadcasttx":
+		err := broadcastTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "rescan":
+		err := rescanCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
@@ -119,11 +366,13 @@ func (cli *CLI) Run() {
 
 	// Команда создания блокчейна
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainAddress == "" || *createBlockchainTargetInterval <= 0 {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		targetBlockInterval = *createBlockchainTargetInterval
+
+		cli.createBlockchain(*createBlockchainAddress, nodeID, *createBlockchainTxIndex)
 	}
 
 	// Команда создания кошелька
@@ -131,6 +380,25 @@ func (cli *CLI) Run() {
 		cli.createWallet(nodeID)
 	}
 
+	// Шифрование кошелька
+	if encryptWalletCmd.Parsed() {
+		cli.encryptWallet(nodeID)
+	}
+
+	// Вывод сида кошелька
+	if exportSeedCmd.Parsed() {
+		cli.exportSeed(nodeID)
+	}
+
+	// Восстановление кошелька из сида
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" || *restoreWalletCount <= 0 {
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(*restoreWalletMnemonic, *restoreWalletCount, *restoreWalletEncrypt, nodeID)
+	}
+
 	// Список адресов
 	if listAddressesCmd.Parsed() {
 		cli.listAddresses(nodeID)
@@ -146,23 +414,179 @@ func (cli *CLI) Run() {
 		cli.reindexUTXO(nodeID)
 	}
 
+	// Перестраиваем индексы транзакций и адресов
+	if reindexTxIndexCmd.Parsed() {
+		cli.reindexTxIndex(nodeID)
+	}
+
 	// Отправка от одного пользователя другому
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || *sendThreads < 1 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
+		miningThreads = *sendThreads
+
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, nodeID, *sendMine)
+	}
+
+	// Публичный ключ адреса для создания multisig
+	if getPubKeyCmd.Parsed() {
+		if *getPubKeyAddress == "" {
+			getPubKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getPubKey(*getPubKeyAddress, nodeID)
+	}
+
+	// Адрес, требующий несколько подписей
+	if createMultisigCmd.Parsed() {
+		if *createMultisigPubKeys == "" {
+			createMultisigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisig(*createMultisigRequired, *createMultisigPubKeys, nodeID)
+	}
+
+	// Адрес с блокировкой по времени
+	if createTimeLockCmd.Parsed() {
+		if *createTimeLockAddress == "" || *createTimeLockLockTime <= 0 {
+			createTimeLockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createTimeLock(*createTimeLockAddress, *createTimeLockLockTime, nodeID)
+	}
+
+	// Частично подписанные транзакции
+	if createPSBTCmd.Parsed() {
+		if *createPSBTFrom == "" || *createPSBTTo == "" || *createPSBTAmount <= 0 || *createPSBTFee < 0 ||
+			*createPSBTLockTime < 0 || *createPSBTFile == "" {
+			createPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createPSBT(*createPSBTFrom, *createPSBTTo, *createPSBTAmount, *createPSBTFee, *createPSBTLockTime, *createPSBTFile, nodeID)
+	}
+
+	if signPSBTCmd.Parsed() {
+		hashType, err := ParseSigHashType(*signPSBTSigHash)
+		if *signPSBTFile == "" || err != nil {
+			signPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signPSBT(*signPSBTFile, hashType, nodeID)
+	}
+
+	if finalizePSBTCmd.Parsed() {
+		if *finalizePSBTFile == "" {
+			finalizePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizePSBT(*finalizePSBTFile, *finalizePSBTMiner, nodeID)
+	}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+	if migrateDBCmd.Parsed() {
+		cli.migrateDB(nodeID)
+	}
+
+	if exportSnapshotCmd.Parsed() {
+		if *exportSnapshotFile == "" {
+			exportSnapshotCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportSnapshot(*exportSnapshotFile, nodeID)
+	}
+
+	if importSnapshotCmd.Parsed() {
+		if *importSnapshotFile == "" {
+			importSnapshotCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importSnapshot(*importSnapshotFile, nodeID)
+	}
+
+	// Неподписанная транзакция в hex для подписи на другой машине
+	if createRawTxCmd.Parsed() {
+		if *createRawTxFrom == "" || *createRawTxTo == "" || *createRawTxAmount <= 0 || *createRawTxFee < 0 || *createRawTxLockTime < 0 {
+			createRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createRawTx(*createRawTxFrom, *createRawTxTo, *createRawTxAmount, *createRawTxFee, *createRawTxLockTime, nodeID)
+	}
+
+	if signRawTxCmd.Parsed() {
+		hashType, err := ParseSigHashType(*signRawTxSigHash)
+		if *signRawTxHex == "" || err != nil {
+			signRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signRawTx(*signRawTxHex, hashType, nodeID)
+	}
+
+	if decodeRawTxCmd.Parsed() {
+		if *decodeRawTxHex == "" {
+			decodeRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.decodeRawTx(*decodeRawTxHex)
+	}
+
+	if broadcastTxCmd.Parsed() {
+		if *broadcastTxHex == "" {
+			broadcastTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.broadcastTx(*broadcastTxHex, *broadcastTxNode)
+	}
+
+	// История транзакций кошелька
+	if listTransactionsCmd.Parsed() {
+		if *listTransactionsCount < 0 {
+			listTransactionsCmd.Usage()
+			os.Exit(1)
+		}
+		cli.listTransactions(*listTransactionsAddress, *listTransactionsCount, nodeID)
+	}
+
+	if rescanCmd.Parsed() {
+		cli.rescan(nodeID)
 	}
 
 	// Запускаем нод
 	if startNodeCmd.Parsed() {
-		nodeID := os.Getenv("NODE_ID")
-		if nodeID == "" {
+		if *startNodeThreads < 1 || (*startNodeSPV && (*startNodeMiner != "" || *startNodeRPC != "" || *startNodeExplorer != "")) ||
+			(*startNodePrune != 0 && *startNodePrune < minPruneKeep) || (*startNodeRPCUser != "" && *startNodeRPCPassword == "") {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		pruneKeep = *startNodePrune
+		miningThreads = *startNodeThreads
+
+		encoding, err := ParseEncoding(*startNodeWireEncoding)
+		if err != nil {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		wireEncoding = encoding
+
+		config.Listen = *startNodeListen
+		config.Seeds = nil
+		for _, seed := range strings.Split(*startNodeSeeds, ",") {
+			if seed = strings.TrimSpace(seed); seed != "" {
+				config.Seeds = append(config.Seeds, seed)
+			}
+		}
+		seedNodes = config.Seeds
+		config.Miner = *startNodeMiner
+		config.RPC = *startNodeRPC
+		config.Metrics = *startNodeMetrics
+		config.RPCUser = *startNodeRPCUser
+		config.RPCPassword = *startNodeRPCPassword
+		config.Explorer = *startNodeExplorer
+
+		if *startNodeSPV {
+			cli.startLightClient(nodeID)
+		} else {
+			cli.startNode(config)
+		}
 	}
 }
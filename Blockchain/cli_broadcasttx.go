@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Транзакция отправляется ноду как есть, проверит ее уже он
+func (cli *CLI) broadcastTx(rawTx, nodeAddress string) {
+	transaction, psbt, err := DecodeRawTransaction(rawTx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if psbt != nil {
+		transaction, err = psbt.Finalize()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if nodeAddress == "" {
+		nodeAddress = seedNodes[0]
+	}
+	err = sendOneShot(nodeAddress, newMessage("tx", &tx{"", transaction.Serialize()}))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Transaction %x is sent to %s\n", transaction.ID, nodeAddress)
+}
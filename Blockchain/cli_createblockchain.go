@@ -3,18 +3,21 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 )
 
-func (cli *CLI) createBlockchain(address, nodeID string) {
+func (cli *CLI) createBlockchain(address, nodeID string, txIndex bool) {
 	// Для первого создания базы нам не нужна валидация
 	if !ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	bc := CreateBlockchain(address, nodeID)
+	// Chainstate строится вместе с генезисом
+	bc, err := CreateBlockchain(address, nodeID, txIndex)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	defer bc.db.Close()
 
-	UTXOSet := UTXOSet{bc}
-	UTXOSet.Reindex()
-
 	fmt.Println("Done!")
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func (cli *CLI) createMultisig(required int, pubKeys, nodeID string) {
+	var keys [][]byte
+	for _, pubKey := range strings.Split(pubKeys, ",") {
+		key, err := hex.DecodeString(strings.TrimSpace(pubKey))
+		if err != nil {
+			log.Panic("ERROR: Public key is not valid hex: ", pubKey)
+		}
+		if _, ok := parsePublicKey(key); !ok {
+			log.Panic("ERROR: Public key is not valid: ", pubKey)
+		}
+		keys = append(keys, key)
+	}
+
+	script, err := MultisigScript(required, keys)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Скрипт нужен для траты выходов адреса, поэтому сохраняем его в кошелек
+	wallets, err := NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	address := wallets.AddScript(script)
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Multisig address: %s\n", address)
+	fmt.Printf("Redeem script: %x\n", script)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func (cli *CLI) createPSBT(from, to string, amount, fee int, lockTime int64, file, nodeID string) {
+	psbt := newPSBT(from, to, amount, fee, lockTime, nodeID)
+
+	err := ioutil.WriteFile(file, psbt.Serialize(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Unsigned transaction %x is saved to %s\n", psbt.Tx.ID, file)
+}
+
+// newPSBT собирает неподписанную транзакцию с выходами, которые она тратит. Ключи для этого не нужны,
+// из кошелька, если он есть, берутся только скрипты адресов
+func newPSBT(from, to string, amount, fee int, lockTime int64, nodeID string) *PartiallySignedTx {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+
+	bc := openBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+
+	// Выходы адреса с блокировкой по времени тратятся транзакцией с тем же временем
+	if script, err := wallets.GetScript(from); err == nil && lockTime == 0 {
+		lockTime, _, _ = extractTimeLock(script)
+	}
+
+	tx, err := NewUnsignedTransaction(from, to, amount, fee, lockTime, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	psbt, err := NewPartiallySignedTx(tx, &UTXOSet, wallets)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return psbt
+}
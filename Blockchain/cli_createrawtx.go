@@ -0,0 +1,11 @@
+package main
+
+import "fmt"
+
+// Транзакция собирается на машине с блокчейном, кошелек с ключами для этого не нужен
+func (cli *CLI) createRawTx(from, to string, amount, fee int, lockTime int64, nodeID string) {
+	psbt := newPSBT(from, to, amount, fee, lockTime, nodeID)
+
+	fmt.Printf("Unsigned transaction %x, fee %d:\n", psbt.Tx.ID, psbt.Fee())
+	fmt.Println(EncodeRawTransaction(psbt))
+}
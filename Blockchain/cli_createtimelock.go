@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) createTimeLock(address string, lockTime int64, nodeID string) {
+	if !ValidateAddress(address) || IsScriptAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	script := TimeLockScript(lockTime, PubKeyHashFromAddress(address))
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	lockedAddress := wallets.AddScript(script)
+	wallets.SaveToFile(nodeID)
+
+	if lockTime < lockTimeThreshold {
+		fmt.Printf("Outputs of the address can be spent by %s from block %d\n", address, lockTime+1)
+	} else {
+		fmt.Printf("Outputs of the address can be spent by %s after unix time %d\n", address, lockTime)
+	}
+	fmt.Printf("Time-locked address: %s\n", lockedAddress)
+	fmt.Printf("Redeem script: %x\n", script)
+}
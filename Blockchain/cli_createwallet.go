@@ -1,11 +1,28 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+	"os"
+)
 
 func (cli *CLI) createWallet(nodeID string) {
-	wallets, _ := NewWallets(nodeID)
-	address := wallets.CreateWallet()
+	wallets, err := UnlockWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	hadSeed := wallets.HasSeed()
+
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		log.Panic(err)
+	}
 	wallets.SaveToFile(nodeID)
 
+	// Сид создается вместе с первым адресом, его надо записать для восстановления кошелька
+	if !hadSeed {
+		mnemonic, _ := wallets.Mnemonic()
+		fmt.Printf("Your wallet seed, write it down to restore the wallet: %s\n", mnemonic)
+	}
 	fmt.Printf("Your new address: %s\n", address)
 }
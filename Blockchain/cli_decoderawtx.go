@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func (cli *CLI) decodeRawTx(rawTx string) {
+	tx, psbt, err := DecodeRawTransaction(rawTx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if tx != nil {
+		fmt.Println(tx)
+		return
+	}
+
+	// У неподписанной транзакции известны траты входов, по ним видна комиссия
+	fmt.Println(psbt.Tx)
+	for i, input := range psbt.Inputs {
+		fmt.Printf("     Input %d spends %d of %s, signatures: %d\n", i, input.PrevOut.Value, input.PrevOut.Address(), len(input.Signatures))
+	}
+	fmt.Printf("     Fee: %d\n", psbt.Fee())
+}
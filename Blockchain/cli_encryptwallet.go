@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) encryptWallet(nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = wallets.Encrypt(passphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Println("Wallet encrypted. The passphrase can't be recovered, keep it along with the seed")
+}
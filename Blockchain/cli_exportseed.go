@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) exportSeed(nodeID string) {
+	wallets, err := UnlockWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	mnemonic, err := wallets.Mnemonic()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(mnemonic)
+}
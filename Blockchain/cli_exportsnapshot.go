@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) exportSnapshot(file, nodeID string) {
+	bc := openBlockchain(nodeID)
+	defer bc.db.Close()
+
+	height, count, err := bc.ExportSnapshot(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Saved headers up to height %d and %d UTXO set entries to %s\n", height, count, file)
+}
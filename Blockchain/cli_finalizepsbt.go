@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) finalizePSBT(file, minerAddress, nodeID string) {
+	psbt := readPSBT(file)
+
+	tx, err := psbt.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Без адреса майнера транзакция отправляется в сеть, как в send
+	if minerAddress == "" {
+		sendTx(seedNodes[0], tx)
+		fmt.Printf("Transaction %x is sent\n", tx.ID)
+		return
+	}
+
+	if !ValidateAddress(minerAddress) {
+		log.Panic("ERROR: Miner address is not valid")
+	}
+
+	bc := openBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	txFee, err := UTXOSet.TransactionFee(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	cbTx := NewCoinbaseTX(minerAddress, "", bestHeight+1, txFee)
+	_, err = bc.MineBlock([]*Transaction{cbTx, tx})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Transaction %x is mined\n", tx.ID)
+}
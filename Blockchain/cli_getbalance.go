@@ -12,7 +12,7 @@ func (cli *CLI) getBalance(address, nodeID string) {
 	}
 
 	// Создаем новую цепучку с существующей базой для текущего нода
-	bc := NewBlockchain(nodeID)
+	bc := openBlockchain(nodeID)
 	// Создаем непотраченых выходов для цепочки
 	UTXOSet := UTXOSet{bc}
 
@@ -24,7 +24,10 @@ func (cli *CLI) getBalance(address, nodeID string) {
 	// В качестве публичного ключа будем использовать кодированый от адрес
 	pubKeyHash := Base58Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4] // Сокращаем его
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)          // Выполняем поиск непотраченых выходов
+	UTXOs, err := UTXOSet.FindUTXO(pubKeyHash)     // Выполняем поиск непотраченых выходов
+	if err != nil {
+		log.Panic(err)
+	}
 
 	// Суммируем баланс
 	for _, out := range UTXOs {
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) getPubKey(address, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Публичные ключи доступны и в заблокированном кошельке
+	wallet := wallets.Wallets[address]
+	if wallet == nil {
+		log.Panic(ErrUnknownAddress)
+	}
+
+	fmt.Printf("%x\n", wallet.PublicKey)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func (cli *CLI) importSnapshot(file, nodeID string) {
+	height, err := ImportSnapshot(nodeID, file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Blockchain is imported up to height %d, the node can be started\n", height)
+}
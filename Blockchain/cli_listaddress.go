@@ -15,4 +15,8 @@ func (cli *CLI) listAddresses(nodeID string) {
 	for _, address := range addresses {
 		fmt.Println(address)
 	}
+	for _, address := range wallets.GetScriptAddresses() {
+		script, _ := wallets.GetScript(address)
+		fmt.Printf("%s (script: %s)\n", address, DisassembleScript(script))
+	}
 }
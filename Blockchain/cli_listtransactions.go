@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func (cli *CLI) listTransactions(address string, count int, nodeID string) {
+	if address != "" && !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	bc := openBlockchain(nodeID)
+	defer bc.db.Close()
+	history := openWalletHistory(nodeID)
+	defer history.Close()
+
+	// История догоняет блокчейн при каждом просмотре
+	_, err := history.Sync(bc)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+	txs, err := history.Transactions(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if count > 0 && len(txs) > count {
+		txs = txs[len(txs)-count:]
+	}
+	for _, wtx := range txs {
+		line := fmt.Sprintf("%x %-8s %+d confirmations: %d", wtx.ID, wtx.Category(), wtx.Amount(), wtx.Confirmations(bestHeight))
+		if wtx.Category() == "send" {
+			line += fmt.Sprintf(" fee: %d change: %d", wtx.Fee, wtx.Change)
+		}
+		fmt.Printf("%s addresses: %s\n", line, strings.Join(wtx.Addresses, ","))
+	}
+}
+
+// openWalletHistory открывает историю для адресов кошелька нода
+func openWalletHistory(nodeID string) *WalletHistory {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history, err := OpenWalletHistory(nodeID, wallets)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return history
+}
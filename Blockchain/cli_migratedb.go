@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) migrateDB(nodeID string) {
+	converted, err := MigrateDatabase(nodeID)
+	if errors.Is(err, ErrDBUpToDate) {
+		fmt.Println("Blockchain database is up to date")
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Converted %d blocks, the old database is saved to %s.bak\n", converted, dataPath(dbFile, nodeID))
+}
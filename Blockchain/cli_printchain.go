@@ -2,12 +2,13 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"strconv"
 )
 
 func (cli *CLI) printChain(nodeID string) {
 	// Создаем цепочку с имеющейся базой данных
-	bc := NewBlockchain(nodeID)
+	bc := openBlockchain(nodeID)
 	defer bc.db.Close()
 
 	// Создаем итератор по цепочке
@@ -16,17 +17,29 @@ func (cli *CLI) printChain(nodeID string) {
 	for {
 		// Получаем новый блок
 		block, hasNext := bci.Next()
+		if block == nil {
+			break
+		}
 
 		// Выводим информацию по блоку
 		fmt.Printf("============ Block %x ============\n", block.Hash)
+		fmt.Printf("Version: %d\n", block.Version)
 		fmt.Printf("Height: %d\n", block.Height)
 		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-
-		// Доказательство валидности блока
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("Bits: %08x\n", block.Bits)
+
+		// Доказательство валидности блока. Хэш старого блока без корня Меркла считается по транзакциям
+		if block.isPruned() && !block.hasMerkleRoot() {
+			fmt.Printf("PoW: unknown\n\n")
+		} else {
+			pow := NewProofOfWork(block)
+			fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+		}
 
 		// Выводим транзакции блока
+		if block.isPruned() {
+			fmt.Println("Transactions are pruned")
+		}
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
@@ -37,4 +50,7 @@ func (cli *CLI) printChain(nodeID string) {
 			break
 		}
 	}
+	if err := bci.Err(); err != nil {
+		log.Panic(err)
+	}
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) reindexTxIndex(nodeID string) {
+	bc := openBlockchain(nodeID)
+	defer bc.db.Close()
+
+	// Индексы строятся по транзакциям всех блоков, а у обрезанных их уже нет
+	if bc.PruneHeight() > 0 {
+		fmt.Printf("Can't reindex: %s below height %d\n", ErrPruned, bc.PruneHeight())
+		os.Exit(1)
+	}
+
+	count, err := bc.ReindexTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Done! There are %d transactions in the transaction index.\n", count)
+}
@@ -1,12 +1,30 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+	"os"
+)
 
 func (cli *CLI) reindexUTXO(nodeID string) {
-	bc := NewBlockchain(nodeID)
+	bc := openBlockchain(nodeID)
 	UTXOSet := UTXOSet{bc}
-	UTXOSet.Reindex()
+	defer bc.db.Close()
 
-	count := UTXOSet.CountTransactions()
+	// Chainstate строится по транзакциям всех блоков, а у обрезанных их уже нет
+	if bc.PruneHeight() > 0 {
+		fmt.Printf("Can't reindex: %s below height %d\n", ErrPruned, bc.PruneHeight())
+		os.Exit(1)
+	}
+
+	err := UTXOSet.Reindex()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Нужен после restorewallet и импорта ключей: старые платежи на их адреса в историю иначе не попадут
+func (cli *CLI) rescan(nodeID string) {
+	bc := openBlockchain(nodeID)
+	defer bc.db.Close()
+	history := openWalletHistory(nodeID)
+	defer history.Close()
+
+	scanned, err := history.Rescan(bc)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	txs, err := history.Transactions("")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Scanned %d blocks, found %d wallet transactions\n", scanned, len(txs))
+}
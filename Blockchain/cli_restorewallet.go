@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) restoreWallet(mnemonic string, count int, encrypt bool, nodeID string) {
+	wallets, err := RestoreWallets(nodeID, mnemonic, count)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if encrypt {
+		passphrase, err := readNewPassphrase()
+		if err != nil {
+			log.Panic(err)
+		}
+		err = wallets.Encrypt(passphrase)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	wallets.SaveToFile(nodeID)
+
+	for _, address := range wallets.GetAddresses() {
+		fmt.Println(address)
+	}
+}
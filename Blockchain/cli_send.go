@@ -5,7 +5,7 @@ import (
 	"log"
 )
 
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
+func (cli *CLI) send(from, to string, amount, fee int, nodeID string, mineNow bool) {
 	// Проверяем валиднось адресов отправителя и получателя
 	if !ValidateAddress(from) {
 		log.Panic("ERROR: Sender address is not valid")
@@ -15,31 +15,65 @@ func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
 	}
 
 	// Создаем новый блок для текущего ID нода
-	bc := NewBlockchain(nodeID)
+	bc := openBlockchain(nodeID)
 	UTXOSet := UTXOSet{bc}
 
 	// При завершении работы закрываем базу
 	defer bc.db.Close()
 
 	// Создаем кошелек для текущего нода
-	wallets, err := NewWallets(nodeID)
+	wallets, err := UnlockWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, err := wallets.GetWallet(from)
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(from)
 
 	// Инициируем транзакцию
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	tx, err := NewUTXOTransaction(&wallet, to, amount, fee, &UTXOSet)
+	if err != nil {
+		log.Panic("ERROR: ", err)
+	}
 
 	// Если надо майнить - стартуем вычисления, иначе откладываем
 	if mineNow {
-		cbTx := NewCoinbaseTX(from, "")
+		// Комиссия может оказаться больше заданной, если сдача была слишком мелкой
+		txFee, err := UTXOSet.TransactionFee(tx)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		bestHeight, err := bc.GetBestHeight()
+		if err != nil {
+			log.Panic(err)
+		}
+
+		cbTx := NewCoinbaseTX(from, "", bestHeight+1, txFee)
 		txs := []*Transaction{cbTx, tx}
 
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
+		// MineBlock сам обновляет chainstate
+		_, err = bc.MineBlock(txs)
+		if err != nil {
+			log.Panic(err)
+		}
 	} else {
-		sendTx(knownNodes[0], tx)
+		sendTx(seedNodes[0], tx)
+
+		// До попадания в блок транзакция видна в истории кошелька как ожидающая
+		history, err := OpenWalletHistory(nodeID, wallets)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer history.Close()
+		_, err = history.Sync(bc)
+		if err == nil {
+			err = history.AddPending(tx)
+		}
+		if err != nil {
+			fmt.Printf("Transaction is not recorded in the wallet history: %s\n", err)
+		}
 	}
 
 	fmt.Println("Success!")
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+func (cli *CLI) signPSBT(file string, hashType byte, nodeID string) {
+	psbt := readPSBT(file)
+
+	wallets, err := UnlockWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signed, err := psbt.Sign(wallets, hashType)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(file, psbt.Serialize(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Added %d signatures to %s\n", signed, file)
+}
+
+func readPSBT(file string) *PartiallySignedTx {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	psbt, err := DeserializePartiallySignedTx(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return psbt
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Подпись не трогает ни блокчейн, ни сеть, поэтому работает на холодной машине с одним файлом кошелька
+func (cli *CLI) signRawTx(rawTx string, hashType byte, nodeID string) {
+	_, psbt, err := DecodeRawTransaction(rawTx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if psbt == nil {
+		fmt.Println("Transaction is already signed")
+		os.Exit(1)
+	}
+
+	wallets, err := UnlockWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signed, err := psbt.Sign(wallets, hashType)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Когда подписей хватает, сразу собираем транзакцию для broadcasttx
+	tx, err := psbt.Finalize()
+	if errors.Is(err, ErrNotEnoughSignatures) {
+		fmt.Printf("Added %d signatures, more are needed:\n", signed)
+		fmt.Println(EncodeRawTransaction(psbt))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Added %d signatures, transaction %x is complete:\n", signed, tx.ID)
+	fmt.Printf("%x\n", tx.Serialize())
+}
@@ -3,19 +3,31 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 )
 
 // Запуск нода
-func (cli *CLI) startNode(nodeID, minerAddress string) {
-	fmt.Printf("Starting node %s\n", nodeID)
-	if len(minerAddress) > 0 {
-		if ValidateAddress(minerAddress) {
-			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
+func (cli *CLI) startNode(config Config) {
+	fmt.Printf("Starting node %s in %s\n", config.NodeID, activeNetwork.Name)
+	if len(config.Miner) > 0 {
+		if ValidateAddress(config.Miner) {
+			fmt.Println("Mining is on. Address to receive rewards: ", config.Miner)
 		} else {
 			log.Panic("Wrong miner address!")
 		}
 	}
 
-	// Запускаем сервер, который прослушивает подключающиеся соединения
-	StartServer(nodeID, minerAddress)
+	// Запускаем сервер, который прослушивает подключающиеся соединения, до сигнала остановки
+	err := StartServer(config)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Запуск легкого клиента, который хранит только заголовки
+func (cli *CLI) startLightClient(nodeID string) {
+	fmt.Printf("Starting light client %s\n", nodeID)
+
+	StartLightClient(nodeID)
 }
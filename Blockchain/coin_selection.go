@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Максимальное количество входов, которое кошелек кладет в одну транзакцию
+const maxTxInputs = 50
+
+// SpendableOutput - непотраченный выход, на который может сослаться новый вход
+type SpendableOutput struct {
+	Txid  []byte
+	Vout  int
+	Value int
+}
+
+// isDust сообщает, что выход сдачи не стоит создавать:
+// его трата с той же комиссией съест всю сумму
+func isDust(value, fee int) bool {
+	return value > 0 && value <= fee
+}
+
+// SelectCoins выбирает выходы на сумму target (сумма перевода плюс комиссия).
+// Предпочитается один выход, покрывающий target без сдачи-пыли,
+// иначе берутся самые крупные выходы, чтобы входов было меньше.
+// Возвращает выбранные выходы и их сумму, при нехватке средств сумма меньше target
+func SelectCoins(candidates []SpendableOutput, target, fee int) ([]SpendableOutput, int) {
+	sorted := make([]SpendableOutput, len(candidates))
+	copy(sorted, candidates)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Value != sorted[j].Value {
+			return sorted[i].Value < sorted[j].Value
+		}
+		if c := bytes.Compare(sorted[i].Txid, sorted[j].Txid); c != 0 {
+			return c < 0
+		}
+		return sorted[i].Vout < sorted[j].Vout
+	})
+
+	// Самый маленький выход, которому не нужна сдача или сдача с которого стоит того
+	for _, out := range sorted {
+		change := out.Value - target
+		if change == 0 || (change > 0 && !isDust(change, fee)) {
+			return []SpendableOutput{out}, out.Value
+		}
+	}
+
+	// Сначала крупные, выходы-пыль идут в ход, только когда ничего другого не осталось
+	var selected []SpendableOutput
+	accumulated := 0
+	for i := len(sorted) - 1; i >= 0 && accumulated < target; i-- {
+		if len(selected) == maxTxInputs {
+			break
+		}
+
+		selected = append(selected, sorted[i])
+		accumulated += sorted[i].Value
+	}
+
+	return selected, accumulated
+}
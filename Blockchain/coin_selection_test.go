@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectCoinsSingleOutput(t *testing.T) {
+	candidates := []SpendableOutput{
+		{[]byte("tx1"), 0, 3},
+		{[]byte("tx2"), 0, 10},
+		{[]byte("tx3"), 1, 6},
+		{[]byte("tx4"), 0, 30},
+	}
+
+	selected, sum := SelectCoins(candidates, 6, 1)
+	assert.Equal(t, []SpendableOutput{{[]byte("tx3"), 1, 6}}, selected, "Exact match is used")
+	assert.Equal(t, 6, sum)
+
+	// 6 would leave change of 1, which is dust for the fee of 1
+	selected, sum = SelectCoins(candidates, 5, 1)
+	assert.Equal(t, []SpendableOutput{{[]byte("tx2"), 0, 10}}, selected, "Output leaving dust change is skipped")
+	assert.Equal(t, 10, sum)
+}
+
+func TestSelectCoinsLargestFirst(t *testing.T) {
+	candidates := []SpendableOutput{
+		{[]byte("tx1"), 0, 1},
+		{[]byte("tx2"), 0, 10},
+		{[]byte("tx3"), 0, 10},
+		{[]byte("tx4"), 0, 5},
+	}
+
+	selected, sum := SelectCoins(candidates, 21, 0)
+	assert.Len(t, selected, 3, "Dust is used only when needed")
+	assert.Equal(t, 25, sum)
+
+	_, sum = SelectCoins(candidates, 100, 0)
+	assert.Equal(t, 26, sum, "Not enough funds")
+}
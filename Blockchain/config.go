@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Файл конфигурации ищется в каталоге данных, если не указан параметром -conf
+const configFile = "node.conf"
+
+// Каталог с базами и кошельками выбранной сети
+var dataDir = "."
+
+// dataPath возвращает путь к файлу нода в каталоге данных
+func dataPath(pattern, nodeID string) string {
+	return filepath.Join(dataDir, fmt.Sprintf(pattern, nodeID))
+}
+
+// Config holds settings of the node. Flags override values of the config file
+type Config struct {
+	Network     string
+	DataDir     string
+	NodeID      string   // Часть имен файлов нода, по умолчанию порт сети
+	Listen      string   // Адрес для входящих соединений, по умолчанию localhost:NodeID
+	Seeds       []string // По умолчанию ноды сети
+	Miner       string
+	RPC         string
+	RPCUser     string // Без логина JSON-RPC доступен без авторизации
+	RPCPassword string
+	Explorer    string
+	Metrics     string // Адрес, на котором /metrics отдает метрики в формате Prometheus
+	LogLevel    string
+}
+
+// DefaultConfig returns settings of a mainnet node keeping its files in the working directory
+func DefaultConfig() Config {
+	return Config{Network: "mainnet", DataDir: ".", LogLevel: "info"}
+}
+
+// LoadConfig reads key=value lines of the file into the config. Empty lines and lines starting with # are skipped,
+// seed can be set several times
+func LoadConfig(file string, config *Config) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s:%d: expected key=value", file, line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		switch key {
+		case "network":
+			config.Network = value
+		case "datadir":
+			config.DataDir = value
+		case "nodeid":
+			config.NodeID = value
+		case "listen":
+			config.Listen = value
+		case "seed":
+			config.Seeds = append(config.Seeds, value)
+		case "miner":
+			config.Miner = value
+		case "rpc":
+			config.RPC = value
+		case "rpcuser":
+			config.RPCUser = value
+		case "rpcpassword":
+			config.RPCPassword = value
+		case "explorer":
+			config.Explorer = value
+		case "metrics":
+			config.Metrics = value
+		case "loglevel":
+			config.LogLevel = value
+		default:
+			return fmt.Errorf("%s:%d: unknown setting %q", file, line, key)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Apply switches the process to the network and the log level of the config and creates the data directory
+// of the network. Node ID and seeds which are not set are taken from the network
+func (c *Config) Apply() error {
+	level, err := ParseLogLevel(c.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	err = UseNetwork(c.Network)
+	if err != nil {
+		return err
+	}
+	logLevel = level
+
+	dataDir = filepath.Join(c.DataDir, activeNetwork.DataSubdir)
+	err = os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	if c.NodeID == "" {
+		c.NodeID = strconv.Itoa(activeNetwork.DefaultPort)
+	}
+	if len(c.Seeds) == 0 {
+		c.Seeds = activeNetwork.Seeds
+	}
+	seedNodes = c.Seeds
+
+	return nil
+}
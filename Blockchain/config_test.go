@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useTestNetwork переключает процесс на сеть с каталогом данных во временном каталоге до конца теста
+func useTestNetwork(t *testing.T, network string) Config {
+	t.Cleanup(func() {
+		UseNetwork("mainnet")
+		dataDir = "."
+	})
+
+	config := DefaultConfig()
+	config.Network = network
+	config.DataDir = t.TempDir()
+	assert.NoError(t, config.Apply())
+
+	return config
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), configFile)
+	content := "# regtest node\nnetwork = regtest\nseed=localhost:1\nseed=localhost:2\n\nrpc=localhost:8332\nrpcuser=user\n"
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+	config := DefaultConfig()
+	assert.NoError(t, LoadConfig(file, &config))
+	assert.Equal(t, "regtest", config.Network)
+	assert.Equal(t, []string{"localhost:1", "localhost:2"}, config.Seeds)
+	assert.Equal(t, "localhost:8332", config.RPC)
+	assert.Equal(t, "user", config.RPCUser)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("network=regtest\nport=1\n"), 0600))
+	assert.EqualError(t, LoadConfig(file, &config), file+`:2: unknown setting "port"`)
+
+	config.Network = "simnet"
+	assert.Error(t, config.Apply())
+}
+
+func TestRegtestNetwork(t *testing.T) {
+	mainnetAddress := string(NewWallet().GetAddress())
+
+	config := useTestNetwork(t, "regtest")
+	assert.Equal(t, "23000", config.NodeID)
+	assert.Equal(t, activeNetwork.Halving, halvingInterval, "The halving interval is a parameter of the network")
+	assert.Equal(t, filepath.Join(config.DataDir, "regtest", "blockchain_23000.db"), dataPath(dbFile, config.NodeID))
+	assert.False(t, ValidateAddress(mainnetAddress), "addresses of other networks are rejected")
+
+	address := string(NewWallet().GetAddress())
+	assert.True(t, ValidateAddress(address))
+	bc, err := CreateBlockchain(address, config.NodeID, false)
+	assert.NoError(t, err)
+
+	// Сложность не пересчитывается, блоки майнятся с первых попыток
+	var block *Block
+	for i := 0; i < retargetInterval+1; i++ {
+		block = testMine(t, bc, NewCoinbaseTX(address, "", i+1, 0))
+	}
+	assert.Equal(t, BigToCompact(activeNetwork.GenesisTarget), block.Bits)
+	bc.db.Close()
+
+	// База regtest не открывается в другой сети
+	assert.NoError(t, UseNetwork("testnet"))
+	_, err = NewBlockchain(config.NodeID)
+	assert.ErrorIs(t, err, ErrWrongNetwork)
+}
+
+func TestTargetIntervalIsStoredWithChain(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	defer UseNetwork("mainnet")
+
+	// Цепочка локальной сети создается с другим временем между блоками
+	targetBlockInterval = 60
+	address := string(NewWallet().GetAddress())
+	bc, err := CreateBlockchain(address, "t", false)
+	assert.NoError(t, err)
+	bc.db.Close()
+
+	// После перезапуска без параметра сложность считается по времени из базы
+	assert.NoError(t, UseNetwork("mainnet"))
+	assert.Equal(t, activeNetwork.TargetInterval, targetBlockInterval)
+	bc, err = NewBlockchain("t")
+	assert.NoError(t, err)
+	defer bc.db.Close()
+	assert.Equal(t, int64(60), targetBlockInterval)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math/big"
+)
+
+// Через какое количество блоков пересчитывается сложность
+const retargetInterval = 10
+
+// Желаемое время между блоками в секундах. Берется из сети или из параметра -target-interval при создании цепочки,
+// затем из базы, в которую оно записывается
+var targetBlockInterval = networks["mainnet"].TargetInterval
+
+// Максимальная цель, то есть минимальная сложность, ниже которой опуститься нельзя. Задается сетью
+var powLimit = networks["mainnet"].PowLimit
+
+// Цель для блоков, сохраненных до появления поля Bits
+var initialTarget = new(big.Int).Lsh(big.NewInt(1), 256-targetBits)
+
+// CompactToBig восстанавливает цель из компактного представления Bits.
+// Формат как в биткоине: старший байт - длина числа в байтах, остальные три - мантисса со знаковым битом
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if isNegative {
+		target = target.Neg(target)
+	}
+
+	return target
+}
+
+// BigToCompact упаковывает цель в компактное представление Bits
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(target.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		shifted := new(big.Int).Abs(target)
+		shifted.Rsh(shifted, 8*(exponent-3))
+		mantissa = uint32(shifted.Bits()[0])
+	}
+
+	// Старший бит мантиссы занят под знак, поэтому сдвигаем ее на байт
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+// blockTarget возвращает цель, которой должен удовлетворять хэш блока
+func blockTarget(block *Block) *big.Int {
+	if block.Bits == 0 {
+		return new(big.Int).Set(initialTarget)
+	}
+
+	return CompactToBig(block.Bits)
+}
+
+// nextWorkRequired вычисляет Bits для блока, следующего за parent.
+// Каждые retargetInterval блоков цель умножается на отношение фактического времени их создания к желаемому,
+// отношение ограничивается в пределах от 1/4 до 4, как в биткоине. В сетях без пересчета цель не меняется
+func nextWorkRequired(parent *Block, lookup blockLookup) uint32 {
+	parentTarget := blockTarget(parent)
+
+	height := parent.Height + 1
+	if !activeNetwork.Retarget || height%retargetInterval != 0 {
+		return BigToCompact(parentTarget)
+	}
+
+	// Ищем первый блок интервала
+	first := parent
+	for i := 0; i < retargetInterval-1; i++ {
+		prev := lookup(first.PrevBlockHash)
+		if prev == nil {
+			break
+		}
+		first = prev
+	}
+
+	expectedTimespan := targetBlockInterval * retargetInterval
+	actualTimespan := parent.Timestamp - first.Timestamp
+	if actualTimespan < expectedTimespan/4 {
+		actualTimespan = expectedTimespan / 4
+	}
+	if actualTimespan > expectedTimespan*4 {
+		actualTimespan = expectedTimespan * 4
+	}
+
+	newTarget := new(big.Int).Mul(parentTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(expectedTimespan))
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget.Set(powLimit)
+	}
+
+	return BigToCompact(newTarget)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Сколько последних блоков показывается на главной странице
+const explorerLatestBlocks = 20
+
+// errExplorerNotFound отдается как 404
+var errExplorerNotFound = errors.New("not found")
+
+// explorerPage builds the data of a page from the rest of the path, the same data is rendered as HTML or JSON
+type explorerPage struct {
+	template string
+	data     func(e *Explorer, arg string) (interface{}, error)
+}
+
+var explorerPages = map[string]explorerPage{
+	"":        {"index", explorerIndex},
+	"block":   {"block", explorerBlock},
+	"tx":      {"tx", explorerTransaction},
+	"address": {"address", explorerAddress},
+	"mempool": {"mempool", explorerMempool},
+	"peers":   {"peers", explorerPeers},
+}
+
+// Explorer serves the chain, the mempool and connected peers as HTML pages and as JSON under /api/
+type Explorer struct {
+	bc      *Blockchain
+	mempool *Mempool
+	peers   *PeerManager // nil, пока нод не подключился к сети
+	pages   *template.Template
+}
+
+// BlockSummaryView is a line of the latest blocks list
+type BlockSummaryView struct {
+	Hash         string `json:"hash"`
+	Height       int    `json:"height"`
+	Time         int64  `json:"time"`
+	Transactions int    `json:"txcount"`
+}
+
+// ExplorerIndexView is the main page of the explorer
+type ExplorerIndexView struct {
+	Height  int                `json:"height"`
+	Blocks  []BlockSummaryView `json:"blocks"`
+	Mempool MempoolView        `json:"mempool"`
+	Peers   int                `json:"peers"`
+}
+
+// ExplorerBlockView is a block with its transactions, a pruned block has only the header
+type ExplorerBlockView struct {
+	Block        BlockView        `json:"block"`
+	Pruned       bool             `json:"pruned"`
+	Transactions []ExplorerTxView `json:"transactions"`
+}
+
+// ResolvedInputView is an input with the address and the value of the output it spends
+type ResolvedInputView struct {
+	Txid    string `json:"txid,omitempty"`
+	Vout    int    `json:"vout"`
+	Address string `json:"address,omitempty"`
+	Value   int    `json:"value"`
+	Known   bool   `json:"known"` // Потраченный выход найден, у обрезанных блоков его может не быть
+}
+
+// ExplorerTxView is a transaction with resolved inputs
+type ExplorerTxView struct {
+	Transaction TransactionView     `json:"transaction"`
+	Pending     bool                `json:"pending"`
+	Inputs      []ResolvedInputView `json:"inputs"`
+	Fee         int                 `json:"fee"` // -1, если не все входы найдены
+}
+
+// ExplorerAddressView is the balance of an address from the UTXO set
+type ExplorerAddressView struct {
+	Address      string        `json:"address"`
+	Balance      int           `json:"balance"`
+	Unspent      []UnspentView `json:"unspent"`
+	Transactions []string      `json:"transactions,omitempty"` // Только при включенном индексе адресов
+	Indexed      bool          `json:"indexed"`
+}
+
+// MempoolView is the mempool summary with pending transactions
+type MempoolView struct {
+	Count        int               `json:"size"`
+	Size         int               `json:"bytes"`
+	Fees         int               `json:"fees"`
+	Transactions []TransactionView `json:"transactions,omitempty"`
+}
+
+// PeerView is a connected peer
+type PeerView struct {
+	Address    string `json:"address"`
+	Inbound    bool   `json:"inbound"`
+	Version    int    `json:"version"`
+	BestHeight int    `json:"bestheight"`
+	PingMs     int64  `json:"pingms"`
+}
+
+// StartExplorer serves the explorer on the address in the background, the returned server is stopped with Shutdown
+func StartExplorer(address string, bc *Blockchain, mempool *Mempool, peers *PeerManager) (*http.Server, error) {
+	server, err := serveHTTP(address, NewExplorer(bc, mempool, peers))
+	if err != nil {
+		return nil, err
+	}
+
+	bc.log.Info("Explorer is listening", "address", "http://"+address)
+
+	return server, nil
+}
+
+// NewExplorer creates the explorer, peers may be nil
+func NewExplorer(bc *Blockchain, mempool *Mempool, peers *PeerManager) *Explorer {
+	pages := template.Must(template.New("").Funcs(template.FuncMap{
+		"time": func(timestamp int64) string {
+			return time.Unix(timestamp, 0).UTC().Format("2006-01-02 15:04:05")
+		},
+	}).Parse(explorerTemplates))
+
+	return &Explorer{bc, mempool, peers, pages}
+}
+
+// ServeHTTP отдает страницу /<page>/<arg> в HTML или /api/<page>/<arg> в JSON
+func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET requests are served", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	api := path == "api" || strings.HasPrefix(path, "api/")
+	if api {
+		path = strings.TrimPrefix(strings.TrimPrefix(path, "api"), "/")
+	}
+
+	name, arg := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		name, arg = path[:i], path[i+1:]
+	}
+	// Главная страница в JSON - это список последних блоков
+	if api && name == "blocks" {
+		name = ""
+	}
+
+	page, ok := explorerPages[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := page.data(e, arg)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errExplorerNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if api {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(data)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = e.pages.ExecuteTemplate(w, page.template, data)
+	}
+	if err != nil {
+		e.bc.log.Warn("Failed to write explorer page", "err", err)
+	}
+}
+
+func explorerIndex(e *Explorer, arg string) (interface{}, error) {
+	height, err := e.bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	view := ExplorerIndexView{Height: height, Blocks: []BlockSummaryView{}}
+
+	it := e.bc.Iterator()
+	for len(view.Blocks) < explorerLatestBlocks {
+		block, more := it.Next()
+		if block == nil {
+			break
+		}
+		view.Blocks = append(view.Blocks, BlockSummaryView{
+			Hash:         hex.EncodeToString(block.Hash),
+			Height:       block.Height,
+			Time:         block.Timestamp,
+			Transactions: len(block.Transactions),
+		})
+		if !more {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	info := e.mempool.Info()
+	view.Mempool = MempoolView{Count: info.Count, Size: info.Size, Fees: info.Fees}
+	if e.peers != nil {
+		view.Peers = len(e.peers.Peers())
+	}
+
+	return view, nil
+}
+
+// explorerBlock принимает хэш блока или высоту в основной цепочке
+func explorerBlock(e *Explorer, arg string) (interface{}, error) {
+	hash, err := hex.DecodeString(arg)
+	if height, convErr := strconv.Atoi(arg); convErr == nil && len(arg) < 64 {
+		hash, err = e.bc.GetBlockHashByHeight(height)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: block %s", errExplorerNotFound, arg)
+	}
+
+	block, err := e.bc.GetBlock(hash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: block %s", errExplorerNotFound, arg)
+	}
+
+	view := ExplorerBlockView{Block: NewBlockView(&block), Pruned: block.isPruned(), Transactions: []ExplorerTxView{}}
+	for _, tx := range block.Transactions {
+		txView := e.transactionView(tx)
+		txView.Transaction.BlockHash = view.Block.Hash
+		view.Transactions = append(view.Transactions, txView)
+	}
+
+	return view, nil
+}
+
+func explorerTransaction(e *Explorer, arg string) (interface{}, error) {
+	txID, err := hex.DecodeString(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: transaction %s", errExplorerNotFound, arg)
+	}
+
+	if tx, ok := e.mempool.Get(txID); ok {
+		view := e.transactionView(&tx)
+		view.Pending = true
+		return view, nil
+	}
+
+	tx, err := e.bc.FindTransaction(txID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: transaction %s", errExplorerNotFound, arg)
+	}
+
+	view := e.transactionView(&tx)
+	blockHash, found, err := e.bc.FindTransactionBlock(txID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		view.Transaction.BlockHash = hex.EncodeToString(blockHash)
+	}
+
+	return view, nil
+}
+
+func explorerAddress(e *Explorer, arg string) (interface{}, error) {
+	if !ValidateAddress(arg) {
+		return nil, fmt.Errorf("%w: address %s is not valid", errExplorerNotFound, arg)
+	}
+	pubKeyHash := PubKeyHashFromAddress(arg)
+
+	indexed, err := e.bc.HasTxIndex()
+	if err != nil {
+		return nil, err
+	}
+	unspent, err := (UTXOSet{e.bc}).FindSpendableOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	view := ExplorerAddressView{Address: arg, Unspent: []UnspentView{}, Indexed: indexed}
+	for _, out := range unspent {
+		view.Balance += out.Value
+		view.Unspent = append(view.Unspent, UnspentView{hex.EncodeToString(out.Txid), out.Vout, out.Value})
+	}
+
+	if view.Indexed {
+		txIDs, err := e.bc.FindAddressTransactions(pubKeyHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, txID := range txIDs {
+			view.Transactions = append(view.Transactions, hex.EncodeToString(txID))
+		}
+	}
+
+	return view, nil
+}
+
+func explorerMempool(e *Explorer, arg string) (interface{}, error) {
+	info := e.mempool.Info()
+	view := MempoolView{Count: info.Count, Size: info.Size, Fees: info.Fees, Transactions: []TransactionView{}}
+
+	for _, tx := range e.mempool.Transactions() {
+		view.Transactions = append(view.Transactions, NewTransactionView(tx))
+	}
+
+	return view, nil
+}
+
+func explorerPeers(e *Explorer, arg string) (interface{}, error) {
+	views := []PeerView{}
+	if e.peers == nil {
+		return views, nil
+	}
+
+	for _, p := range e.peers.Peers() {
+		view := PeerView{Address: p.Addr(), Inbound: p.inbound, PingMs: p.PingTime().Milliseconds()}
+		if p.version != nil {
+			view.Version = p.version.Version
+			view.BestHeight = p.version.BestHeight
+		}
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+// transactionView находит выходы, которые тратит транзакция, сначала в мемпуле, затем в цепочке
+func (e *Explorer) transactionView(tx *Transaction) ExplorerTxView {
+	view := ExplorerTxView{Transaction: NewTransactionView(tx), Inputs: []ResolvedInputView{}}
+	if tx.IsCoinbase() {
+		return view
+	}
+
+	in, known := 0, true
+	for _, vin := range tx.Vin {
+		input := ResolvedInputView{Txid: hex.EncodeToString(vin.Txid), Vout: vin.Vout}
+
+		prevTx, ok := e.mempool.Get(vin.Txid)
+		if !ok {
+			found, err := e.bc.FindTransaction(vin.Txid)
+			prevTx, ok = found, err == nil
+		}
+		if ok && vin.Vout >= 0 && vin.Vout < len(prevTx.Vout) {
+			out := prevTx.Vout[vin.Vout]
+			input.Address, input.Value, input.Known = out.Address(), out.Value, true
+		} else if out, found, err := (UTXOSet{e.bc}).FindOutput(vin.Txid, vin.Vout); err == nil && found {
+			// Транзакция в обрезанном блоке, но ее выход еще не потрачен
+			input.Address, input.Value, input.Known = out.Address(), out.Value, true
+		}
+
+		in += input.Value
+		known = known && input.Known
+		view.Inputs = append(view.Inputs, input)
+	}
+
+	view.Fee = -1
+	if known {
+		view.Fee = in
+		for _, out := range tx.Vout {
+			view.Fee -= out.Value
+		}
+	}
+
+	return view
+}
@@ -0,0 +1,98 @@
+package main
+
+// Шаблоны страниц обозревателя, у каждой страницы есть JSON-вариант под /api/
+const explorerTemplates = `
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Blockchain explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.hash { font-family: monospace; }
+</style>
+</head>
+<body>
+<p><a href="/">Blocks</a> | <a href="/mempool">Mempool</a> | <a href="/peers">Peers</a></p>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header"}}
+<h1>Height {{.Height}}</h1>
+<p>Mempool: {{.Mempool.Count}} transactions, {{.Mempool.Size}} bytes, {{.Mempool.Fees}} in fees. Peers: {{.Peers}}</p>
+<table>
+<tr><th>Height</th><th>Hash</th><th>Time</th><th>Transactions</th></tr>
+{{range .Blocks}}<tr><td>{{.Height}}</td><td class="hash"><a href="/block/{{.Hash}}">{{.Hash}}</a></td><td>{{time .Time}}</td><td>{{.Transactions}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "inputs"}}<table>
+<tr><th>Spends</th><th>Address</th><th>Value</th></tr>
+{{range .}}<tr><td class="hash"><a href="/tx/{{.Txid}}">{{.Txid}}</a>:{{.Vout}}</td><td>{{if .Known}}<a href="/address/{{.Address}}">{{.Address}}</a>{{else}}unknown{{end}}</td><td>{{if .Known}}{{.Value}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+
+{{define "outputs"}}<table>
+<tr><th>#</th><th>Address</th><th>Value</th><th>Script</th></tr>
+{{range .}}<tr><td>{{.N}}</td><td>{{if .Address}}<a href="/address/{{.Address}}">{{.Address}}</a>{{end}}</td><td>{{.Value}}</td><td class="hash">{{.Script}}</td></tr>
+{{end}}</table>{{end}}
+
+{{define "transaction"}}<h3 class="hash"><a href="/tx/{{.Transaction.Txid}}">{{.Transaction.Txid}}</a></h3>
+{{if .Transaction.Coinbase}}<p>Coinbase</p>{{else}}{{template "inputs" .Inputs}}<p>Fee: {{if ge .Fee 0}}{{.Fee}}{{else}}unknown{{end}}</p>{{end}}
+{{template "outputs" .Transaction.Vout}}{{end}}
+
+{{define "block"}}{{template "header"}}
+<h1>Block {{.Block.Height}}</h1>
+<table>
+<tr><td>Hash</td><td class="hash">{{.Block.Hash}}</td></tr>
+<tr><td>Previous</td><td class="hash"><a href="/block/{{.Block.PreviousHash}}">{{.Block.PreviousHash}}</a></td></tr>
+<tr><td>Merkle root</td><td class="hash">{{.Block.MerkleRoot}}</td></tr>
+<tr><td>Time</td><td>{{time .Block.Time}}</td></tr>
+<tr><td>Version</td><td>{{.Block.Version}}</td></tr>
+<tr><td>Bits</td><td>{{.Block.Bits}}</td></tr>
+<tr><td>Nonce</td><td>{{.Block.Nonce}}</td></tr>
+</table>
+{{if .Pruned}}<p>Transactions of the block are pruned.</p>{{end}}
+{{range .Transactions}}{{template "transaction" .}}{{end}}
+{{template "footer"}}{{end}}
+
+{{define "tx"}}{{template "header"}}
+<h1>Transaction</h1>
+{{if .Pending}}<p>Pending in the mempool</p>{{else if .Transaction.BlockHash}}<p>Block <a class="hash" href="/block/{{.Transaction.BlockHash}}">{{.Transaction.BlockHash}}</a></p>{{end}}
+{{template "transaction" .}}
+{{template "footer"}}{{end}}
+
+{{define "address"}}{{template "header"}}
+<h1 class="hash">{{.Address}}</h1>
+<p>Balance: {{.Balance}}</p>
+<table>
+<tr><th>Unspent output</th><th>Value</th></tr>
+{{range .Unspent}}<tr><td class="hash"><a href="/tx/{{.Txid}}">{{.Txid}}</a>:{{.Vout}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{if .Indexed}}<h2>Transactions</h2>
+<ul>
+{{range .Transactions}}<li class="hash"><a href="/tx/{{.}}">{{.}}</a></li>
+{{end}}</ul>{{else}}<p>Address index is disabled, run reindextxindex to list transactions.</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "mempool"}}{{template "header"}}
+<h1>Mempool</h1>
+<p>{{.Count}} transactions, {{.Size}} bytes, {{.Fees}} in fees</p>
+<table>
+<tr><th>Transaction</th><th>Inputs</th><th>Outputs</th></tr>
+{{range .Transactions}}<tr><td class="hash"><a href="/tx/{{.Txid}}">{{.Txid}}</a></td><td>{{len .Vin}}</td><td>{{len .Vout}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "peers"}}{{template "header"}}
+<h1>Peers</h1>
+<table>
+<tr><th>Address</th><th>Direction</th><th>Version</th><th>Best height</th><th>Ping, ms</th></tr>
+{{range .}}<tr><td>{{.Address}}</td><td>{{if .Inbound}}inbound{{else}}outbound{{end}}</td><td>{{.Version}}</td><td>{{.BestHeight}}</td><td>{{.PingMs}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+`
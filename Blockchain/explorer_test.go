@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func explorerGet(t *testing.T, server *httptest.Server, path string, result interface{}) int {
+	resp, err := http.Get(server.URL + path)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	if result != nil && resp.StatusCode == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(body, result))
+	}
+
+	return resp.StatusCode
+}
+
+func TestExplorerResolvesInputs(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner, other := NewWallet(), NewWallet()
+	address, otherAddress := string(owner.GetAddress()), string(other.GetAddress())
+	bc, _ := syncedChains(t, address)
+
+	payment := testPayment(t, owner, otherAddress, 7, 1, bc)
+	block := testMine(t, bc, NewCoinbaseTX(otherAddress, "", testHeight(t, bc)+1, 1), payment)
+
+	server := httptest.NewServer(NewExplorer(bc, NewMempool(bc), nil))
+	defer server.Close()
+
+	var index ExplorerIndexView
+	assert.Equal(t, http.StatusOK, explorerGet(t, server, "/api/blocks", &index))
+	assert.Equal(t, 1, index.Height)
+	assert.Equal(t, hex.EncodeToString(block.Hash), index.Blocks[0].Hash)
+	assert.Len(t, index.Blocks, 2)
+
+	var tx ExplorerTxView
+	assert.Equal(t, http.StatusOK, explorerGet(t, server, "/api/tx/"+hex.EncodeToString(payment.ID), &tx))
+	assert.Equal(t, 1, tx.Fee)
+	assert.Equal(t, address, tx.Inputs[0].Address)
+	assert.True(t, tx.Inputs[0].Known)
+
+	// Блок доступен и по хэшу, и по высоте
+	var byHeight ExplorerBlockView
+	assert.Equal(t, http.StatusOK, explorerGet(t, server, "/api/block/1", &byHeight))
+	assert.Equal(t, hex.EncodeToString(block.Hash), byHeight.Block.Hash)
+	assert.Len(t, byHeight.Transactions, 2)
+
+	var balance ExplorerAddressView
+	assert.Equal(t, http.StatusOK, explorerGet(t, server, "/api/address/"+otherAddress, &balance))
+	assert.Equal(t, 7+subsidy+1, balance.Balance)
+
+	assert.Equal(t, http.StatusNotFound, explorerGet(t, server, "/api/tx/00", nil))
+	assert.Equal(t, http.StatusNotFound, explorerGet(t, server, "/api/address/nope", nil))
+
+	for _, page := range []string{"/", "/block/" + hex.EncodeToString(block.Hash), "/tx/" + hex.EncodeToString(payment.ID), "/address/" + address, "/mempool", "/peers"} {
+		assert.Equal(t, http.StatusOK, explorerGet(t, server, page, nil), page)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+)
+
+// Ключи кошелька выводятся из сида по SLIP-0010 для кривой NIST P-256,
+// используется только усиленная (hardened) деривация
+const hdSeedKey = "Nist256p1 seed"
+const hdHardened = uint32(0x80000000)
+
+// Путь до адресов кошелька: m/44'/1'/0'/0'/i'
+var hdAccountPath = []uint32{44 | hdHardened, 1 | hdHardened, 0 | hdHardened, 0 | hdHardened}
+
+// hdKey - расширенный приватный ключ: сам ключ и цепной код для вывода дочерних ключей
+type hdKey struct {
+	key       *big.Int
+	chainCode []byte
+}
+
+// hdMasterKey вычисляет мастер-ключ из сида
+func hdMasterKey(seed []byte) hdKey {
+	n := elliptic.P256().Params().N
+
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(hdSeedKey))
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		// Невалидный ключ - хэшируем результат еще раз
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return hdKey{key, sum[32:]}
+		}
+		data = sum
+	}
+}
+
+// child выводит усиленный дочерний ключ с номером index
+func (k hdKey) child(index uint32) hdKey {
+	n := elliptic.P256().Params().N
+	index |= hdHardened
+
+	data := make([]byte, 0, 37)
+	data = append(data, 0x00)
+	data = append(data, k.key.FillBytes(make([]byte, 32))...)
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		childKey := new(big.Int).Add(tweak, k.key)
+		childKey.Mod(childKey, n)
+		if tweak.Cmp(n) < 0 && childKey.Sign() != 0 {
+			return hdKey{childKey, sum[32:]}
+		}
+
+		// По SLIP-0010 при невалидном результате повторяем с правой половиной хэша
+		data = data[:0]
+		data = append(data, 0x01)
+		data = append(data, sum[32:]...)
+		data = binary.BigEndian.AppendUint32(data, index)
+	}
+}
+
+// deriveWalletKey выводит ключ адреса с номером index из сида
+func deriveWalletKey(seed []byte, index uint32) ecdsa.PrivateKey {
+	key := hdMasterKey(seed)
+	for _, i := range hdAccountPath {
+		key = key.child(i)
+	}
+	key = key.child(index)
+
+	return privateKeyFromD(key.key.Bytes())
+}
+
+// privateKeyFromD восстанавливает ключевую пару P-256 по приватному числу
+func privateKeyFromD(d []byte) ecdsa.PrivateKey {
+	curve := elliptic.P256()
+
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(private.D.FillBytes(make([]byte, 32)))
+
+	return private
+}
+
+// publicKeyBytes возвращает публичный ключ в формате кошелька: X и Y по 32 байта
+func publicKeyBytes(private ecdsa.PrivateKey) []byte {
+	pubKey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(pubKey[:32])
+	private.PublicKey.Y.FillBytes(pubKey[32:])
+
+	return pubKey
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test vector 1 for nist256p1 from SLIP-0010
+func TestHDKeyDerivation(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	master := hdMasterKey(seed)
+	assert.Equal(t, "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2", hex.EncodeToString(master.key.Bytes()))
+	assert.Equal(t, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", hex.EncodeToString(master.chainCode))
+
+	child := master.child(0)
+	assert.Equal(t, "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c", hex.EncodeToString(child.key.Bytes()))
+	assert.Equal(t, "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", hex.EncodeToString(child.chainCode))
+}
+
+func TestDeriveWalletKey(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	first := deriveWalletKey(seed, 0)
+	assert.Equal(t, first, deriveWalletKey(seed, 0), "Derivation is deterministic")
+	assert.NotEqual(t, first.D, deriveWalletKey(seed, 1).D)
+	assert.Len(t, publicKeyBytes(first), 64)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel orders log records by importance, records below the process level are dropped
+type LogLevel int
+
+// Уровни логов
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+// ParseLogLevel returns the level by its name
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range logLevelNames {
+		if name == levelName {
+			return LogLevel(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %v", name, logLevelNames)
+}
+
+// Уровень логов процесса задается параметром -loglevel, вывод общий для всех нодов процесса
+var (
+	logLevel            = LevelInfo
+	logOutput io.Writer = os.Stdout
+	logLock   sync.Mutex
+)
+
+// Logger writes records in logfmt: time, level, message and key=value fields.
+// The nil Logger writes records without fields
+type Logger struct {
+	fields []interface{}
+}
+
+// nodeLogger возвращает логгер с ID нода в каждой записи
+func nodeLogger(nodeID string) *Logger {
+	return (*Logger)(nil).With("node", nodeID)
+}
+
+// With returns a logger adding key-value pairs to every record
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	var fields []interface{}
+	if l != nil {
+		fields = append(fields, l.fields...)
+	}
+
+	return &Logger{fields: append(fields, keyvals...)}
+}
+
+// Debug writes a record about routine events, like every received message
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.write(LevelDebug, msg, keyvals)
+}
+
+// Info writes a record about the state of the node
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.write(LevelInfo, msg, keyvals)
+}
+
+// Warn writes a record about bad data from outside or a recoverable failure
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.write(LevelWarn, msg, keyvals)
+}
+
+// Error writes a record about a failure of the node itself
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.write(LevelError, msg, keyvals)
+}
+
+func (l *Logger) write(level LogLevel, msg string, keyvals []interface{}) {
+	if level < logLevel {
+		return
+	}
+
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "time=%s level=%s", time.Now().Format(time.RFC3339), level)
+	if l != nil {
+		writeLogFields(&line, l.fields)
+	}
+	fmt.Fprintf(&line, " msg=%s", formatLogValue(msg))
+	writeLogFields(&line, keyvals)
+	line.WriteByte('\n')
+
+	logLock.Lock()
+	defer logLock.Unlock()
+	logOutput.Write(line.Bytes())
+}
+
+func writeLogFields(line *bytes.Buffer, keyvals []interface{}) {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fmt.Fprintf(line, " %v=%s", keyvals[i], formatLogValue(keyvals[i+1]))
+	}
+}
+
+// formatLogValue выводит хэши в hex, а строки с пробелами и кавычками берет в кавычки
+func formatLogValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case []byte:
+		s = hex.EncodeToString(v)
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return s
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLog перенаправляет логи процесса в буфер до конца теста
+func captureLog(t *testing.T, level LogLevel) *bytes.Buffer {
+	var buf bytes.Buffer
+	oldOutput, oldLevel := logOutput, logLevel
+	logOutput, logLevel = &buf, level
+	t.Cleanup(func() { logOutput, logLevel = oldOutput, oldLevel })
+
+	return &buf
+}
+
+func TestLoggerFormat(t *testing.T) {
+	buf := captureLog(t, LevelInfo)
+
+	logger := nodeLogger("3000").With("peer", "localhost:3001")
+	logger.Warn("Block is rejected", "block", []byte{0xab, 0xcd}, "err", errors.New("bad proof of work"))
+	logger.Debug("Received a message", "command", "inv")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 1, "debug records are dropped at the info level")
+	assert.Regexp(t, `^time=\S+ level=warn node=3000 peer=localhost:3001 msg="Block is rejected" block=abcd err="bad proof of work"$`, lines[0])
+
+	level, err := ParseLogLevel("debug")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelDebug, level)
+	_, err = ParseLogLevel("verbose")
+	assert.Error(t, err)
+}
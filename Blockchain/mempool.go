@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ограничения мемпула
+const (
+	maxTxSize       = 100000  // Размер одной транзакции в байтах
+	maxBlockTxsSize = 1 << 19 // Размер транзакций, которые кладутся в шаблон блока
+	mempoolExpiry   = 24 * time.Hour
+)
+
+// Общий размер ожидающих транзакций в байтах, тесты его уменьшают
+var maxMempoolSize = 1 << 20
+
+// Ошибки мемпула
+var (
+	ErrTxInMempool     = errors.New("transaction is already in the mempool")
+	ErrMempoolConflict = errors.New("transaction spends an output spent by another pending transaction")
+	ErrMempoolFull     = errors.New("mempool is full and the transaction fee is too low")
+	ErrTxTooLarge      = errors.New("transaction is too large")
+	ErrCoinbaseInPool  = errors.New("coinbase transaction can't be relayed")
+	ErrLegacyInput     = errors.New("inputs without unlocking scripts are not relayed")
+)
+
+// mempoolEntry - ожидающая транзакция с данными, по которым определяется ее приоритет
+type mempoolEntry struct {
+	tx    *Transaction
+	fee   int
+	size  int
+	added time.Time
+}
+
+// feeRate возвращает комиссию за килобайт, это и есть приоритет транзакции
+func (e *mempoolEntry) feeRate() int {
+	return e.fee * 1000 / e.size
+}
+
+// Mempool хранит проверенные транзакции, ожидающие майнинга. Безопасен для использования из нескольких горутин
+type Mempool struct {
+	bc    *Blockchain
+	lock  sync.RWMutex
+	txs   map[string]*mempoolEntry
+	spent map[string]string // Выход -> ID ожидающей транзакции, которая его тратит
+	size  int
+}
+
+// MempoolInfo описывает состояние мемпула
+type MempoolInfo struct {
+	Count int
+	Size  int
+	Fees  int
+}
+
+// NewMempool создает пустой мемпул, проверяющий транзакции по блокчейну
+func NewMempool(bc *Blockchain) *Mempool {
+	return &Mempool{
+		bc:    bc,
+		txs:   make(map[string]*mempoolEntry),
+		spent: make(map[string]string),
+	}
+}
+
+func outpointKey(txID []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txID, vout)
+}
+
+// Add проверяет транзакцию и кладет ее в мемпул.
+// Входы должны быть в UTXO set или быть выходами других ожидающих транзакций
+// и не должны тратиться ни одной ожидающей транзакцией
+func (m *Mempool) Add(tx Transaction) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	txID := hex.EncodeToString(tx.ID)
+	if len(tx.ID) == 0 || tx.IsCoinbase() {
+		return ErrCoinbaseInPool
+	}
+	if m.txs[txID] != nil {
+		return ErrTxInMempool
+	}
+
+	size := len(tx.Serialize())
+	if size > maxTxSize {
+		return ErrTxTooLarge
+	}
+
+	// Подписи входов старого формата не зависят от транзакции, такие входы принимаются только в старых блоках
+	for _, vin := range tx.Vin {
+		if vin.isLegacyInput() {
+			return ErrLegacyInput
+		}
+	}
+
+	// Новые блоки содержат только транзакции текущей версии
+	if tx.Version != txVersion {
+		return ruleError(ErrBadVersion, "transaction %s version %d", txID, tx.Version)
+	}
+	err := checkTransactionEncoding(&tx)
+	if err != nil {
+		return err
+	}
+
+	// Транзакция должна подходить для следующего блока
+	bestHeight, err := m.bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	if !tx.IsFinal(bestHeight+1, time.Now().Unix()) {
+		return ruleError(ErrNonFinalTx, "transaction %s lock time %d", txID, tx.LockTime)
+	}
+
+	UTXOSet := UTXOSet{m.bc}
+	prevOuts := make([]TXOutput, len(tx.Vin))
+	seen := make(map[string]bool)
+
+	for i, vin := range tx.Vin {
+		key := outpointKey(vin.Txid, vin.Vout)
+		if seen[key] {
+			return ruleError(ErrDoubleSpend, "output %s in transaction %s", key, txID)
+		}
+		seen[key] = true
+
+		if spender, ok := m.spent[key]; ok {
+			return fmt.Errorf("%w: output %s is spent by %s", ErrMempoolConflict, key, spender)
+		}
+
+		out, found := m.pendingOutput(vin.Txid, vin.Vout)
+		if !found {
+			out, found, err = UTXOSet.FindOutput(vin.Txid, vin.Vout)
+			if err != nil {
+				return err
+			}
+		}
+		if !found {
+			return ruleError(ErrMissingInput, "input %s of transaction %s", key, txID)
+		}
+		prevOuts[i] = out
+	}
+
+	// Выходы транзакций мемпула не бывают coinbase, а остальные входы проверяются для следующего блока
+	err = UTXOSet.checkMaturity(&tx, bestHeight+1)
+	if err != nil {
+		return err
+	}
+
+	fee, err := checkTransactionInputs(&tx, prevOuts)
+	if err != nil {
+		return err
+	}
+
+	entry := &mempoolEntry{&tx, fee, size, time.Now()}
+	m.txs[txID] = entry
+	m.size += size
+	for _, vin := range tx.Vin {
+		m.spent[outpointKey(vin.Txid, vin.Vout)] = txID
+	}
+
+	// Сверх лимита первыми уходят самые дешевые транзакции, возможно, и новая
+	m.evict()
+	if m.txs[txID] == nil {
+		return ErrMempoolFull
+	}
+
+	return nil
+}
+
+// pendingOutput возвращает выход ожидающей транзакции
+func (m *Mempool) pendingOutput(txID []byte, vout int) (TXOutput, bool) {
+	entry := m.txs[hex.EncodeToString(txID)]
+	if entry == nil || vout < 0 || vout >= len(entry.tx.Vout) {
+		return TXOutput{}, false
+	}
+
+	return entry.tx.Vout[vout], true
+}
+
+// evict удаляет транзакции с самой низкой комиссией за килобайт, пока мемпул не уложится в лимит размера
+func (m *Mempool) evict() {
+	if m.size <= maxMempoolSize {
+		return
+	}
+
+	entries := m.sortedEntries()
+	for i := len(entries) - 1; i >= 0 && m.size > maxMempoolSize; i-- {
+		m.remove(hex.EncodeToString(entries[i].tx.ID), true)
+	}
+}
+
+// remove удаляет транзакцию, с withDescendants удаляются и ее потомки
+func (m *Mempool) remove(txID string, withDescendants bool) {
+	entry := m.txs[txID]
+	if entry == nil {
+		return
+	}
+
+	delete(m.txs, txID)
+	m.size -= entry.size
+	for _, vin := range entry.tx.Vin {
+		delete(m.spent, outpointKey(vin.Txid, vin.Vout))
+	}
+
+	if !withDescendants {
+		return
+	}
+	for vout := range entry.tx.Vout {
+		if spender, ok := m.spent[outpointKey(entry.tx.ID, vout)]; ok {
+			m.remove(spender, true)
+		}
+	}
+}
+
+// sortedEntries возвращает ожидающие транзакции от высшего приоритета к низшему
+func (m *Mempool) sortedEntries() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(m.txs))
+	for _, entry := range m.txs {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].feeRate() != entries[j].feeRate() {
+			return entries[i].feeRate() > entries[j].feeRate()
+		}
+		return entries[i].added.Before(entries[j].added)
+	})
+
+	return entries
+}
+
+// RemoveBlock выбрасывает транзакции, вошедшие в блок, и конфликтующие с ними
+func (m *Mempool) RemoveBlock(block *Block) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, tx := range block.Transactions {
+		// Для потомков транзакция из блока остается действительной, их можно замайнить позже
+		m.remove(hex.EncodeToString(tx.ID), false)
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			if spender, ok := m.spent[outpointKey(vin.Txid, vin.Vout)]; ok {
+				m.remove(spender, true)
+			}
+		}
+	}
+}
+
+// Prune выбрасывает устаревшие транзакции и те, чьих входов больше нет, например после реорганизации,
+// после которой транзакции с блокировкой по времени тоже могут снова стать незавершенными
+func (m *Mempool) Prune() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	UTXOSet := UTXOSet{m.bc}
+	expireBefore := time.Now().Add(-mempoolExpiry)
+	bestHeight, err := m.bc.GetBestHeight()
+	if err != nil {
+		m.bc.log.Error("Failed to prune the mempool", "err", err)
+		return
+	}
+	nextHeight := bestHeight + 1
+
+	for txID, entry := range m.txs {
+		if entry.added.Before(expireBefore) || !entry.tx.IsFinal(nextHeight, time.Now().Unix()) {
+			m.remove(txID, true)
+			continue
+		}
+
+		for _, vin := range entry.tx.Vin {
+			if _, found := m.pendingOutput(vin.Txid, vin.Vout); found {
+				continue
+			}
+			// Ошибка чтения базы - не повод выбрасывать транзакцию
+			if _, found, err := UTXOSet.FindOutput(vin.Txid, vin.Vout); err == nil && !found {
+				m.remove(txID, true)
+				break
+			}
+		}
+	}
+}
+
+// Has проверяет, ожидает ли транзакция в мемпуле
+func (m *Mempool) Has(txID []byte) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.txs[hex.EncodeToString(txID)] != nil
+}
+
+// Get возвращает ожидающую транзакцию по ее ID
+func (m *Mempool) Get(txID []byte) (Transaction, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	entry := m.txs[hex.EncodeToString(txID)]
+	if entry == nil {
+		return Transaction{}, false
+	}
+
+	return *entry.tx, true
+}
+
+// Transactions возвращает все ожидающие транзакции от высшего приоритета к низшему
+func (m *Mempool) Transactions() []*Transaction {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var txs []*Transaction
+	for _, entry := range m.sortedEntries() {
+		txs = append(txs, entry.tx)
+	}
+
+	return txs
+}
+
+// IsSpent проверяет, тратит ли выход какая-нибудь ожидающая транзакция
+func (m *Mempool) IsSpent(txID []byte, vout int) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	_, ok := m.spent[outpointKey(txID, vout)]
+	return ok
+}
+
+// Count возвращает количество ожидающих транзакций
+func (m *Mempool) Count() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.txs)
+}
+
+// Info возвращает количество, общий размер и общую комиссию ожидающих транзакций
+func (m *Mempool) Info() MempoolInfo {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	info := MempoolInfo{Count: len(m.txs), Size: m.size}
+	for _, entry := range m.txs {
+		info.Fees += entry.fee
+	}
+
+	return info
+}
+
+// BlockTemplate отбирает транзакции для нового блока по комиссии за килобайт и возвращает их вместе с общей комиссией.
+// Берутся только транзакции, тратящие подтвержденные выходы, их ожидающие потомки ждут следующего блока
+func (m *Mempool) BlockTemplate() ([]*Transaction, int) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var txs []*Transaction
+	fees := 0
+	size := 0
+
+Entries:
+	for _, entry := range m.sortedEntries() {
+		if size+entry.size > maxBlockTxsSize {
+			continue
+		}
+
+		for _, vin := range entry.tx.Vin {
+			if _, pending := m.pendingOutput(vin.Txid, vin.Vout); pending {
+				continue Entries
+			}
+		}
+
+		txs = append(txs, entry.tx)
+		fees += entry.fee
+		size += entry.size
+	}
+
+	return txs, fees
+}
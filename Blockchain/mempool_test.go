@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSignedSpend создает подписанную транзакцию кошелька, тратящую выход vout транзакции parent.
+// Комиссия - все, что не вошло в value
+func testSignedSpend(wallet *Wallet, parent *Transaction, vout, value int, to string) *Transaction {
+	tx := testSpend(parent.ID, vout, value, to)
+	tx.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(parent.ID): *parent})
+
+	return tx
+}
+
+// testMempoolChain создает цепочку с тремя подтвержденными наградами владельца
+func testMempoolChain(t *testing.T) (*Wallet, *Blockchain, []*Transaction) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address := string(owner.GetAddress())
+	bc, _ := syncedChains(t, address)
+
+	genesis, err := bc.GetBlock(testTip(t, bc))
+	assert.NoError(t, err)
+	coinbases := []*Transaction{genesis.Transactions[0]}
+	for height := 1; height <= 2; height++ {
+		block := testMine(t, bc, NewCoinbaseTX(address, "", height, 0))
+		coinbases = append(coinbases, block.Transactions[0])
+	}
+
+	return owner, bc, coinbases
+}
+
+func TestMempoolConflictsAndTemplate(t *testing.T) {
+	owner, bc, coinbases := testMempoolChain(t)
+	address := string(owner.GetAddress())
+	m := NewMempool(bc)
+
+	cheap := testSignedSpend(owner, coinbases[0], 0, 9, address)
+	assert.NoError(t, m.Add(*cheap))
+	assert.ErrorIs(t, m.Add(*cheap), ErrTxInMempool)
+
+	// Другая трата того же выхода конфликтует с ожидающей транзакцией
+	conflict := testSignedSpend(owner, coinbases[0], 0, 8, address)
+	assert.ErrorIs(t, m.Add(*conflict), ErrMempoolConflict)
+
+	// Выход ожидающей транзакции можно тратить, но в шаблон потомок попадает только после родителя
+	child := testSignedSpend(owner, cheap, 0, 8, address)
+	assert.NoError(t, m.Add(*child))
+	assert.True(t, m.IsSpent(cheap.ID, 0))
+
+	expensive := testSignedSpend(owner, coinbases[1], 0, 5, address)
+	assert.NoError(t, m.Add(*expensive))
+	middle := testSignedSpend(owner, coinbases[2], 0, 7, address)
+	assert.NoError(t, m.Add(*middle))
+
+	txs, fees := m.BlockTemplate()
+	assert.Equal(t, []*Transaction{expensive, middle, cheap}, txs)
+	assert.Equal(t, 5+3+1, fees)
+	assert.Equal(t, MempoolInfo{Count: 4, Size: m.Info().Size, Fees: 5 + 3 + 1 + 1}, m.Info())
+}
+
+func TestMempoolRemovesDescendants(t *testing.T) {
+	owner, bc, coinbases := testMempoolChain(t)
+	address := string(owner.GetAddress())
+	m := NewMempool(bc)
+
+	parent := testSignedSpend(owner, coinbases[0], 0, 9, address)
+	child := testSignedSpend(owner, parent, 0, 8, address)
+	other := testSignedSpend(owner, coinbases[1], 0, 9, address)
+	for _, tx := range []*Transaction{parent, child, other} {
+		assert.NoError(t, m.Add(*tx))
+	}
+
+	// Потомки транзакции из блока остаются, их можно замайнить следом
+	block := testMine(t, bc, NewCoinbaseTX(address, "", 3, 1), parent)
+	m.RemoveBlock(block)
+	assert.False(t, m.Has(parent.ID))
+	assert.True(t, m.Has(child.ID))
+
+	// Блок с конфликтующей тратой выбрасывает транзакцию вместе с потомками
+	grandchild := testSignedSpend(owner, other, 0, 8, address)
+	assert.NoError(t, m.Add(*grandchild))
+	conflict := testSignedSpend(owner, coinbases[1], 0, 5, address)
+	block = testMine(t, bc, NewCoinbaseTX(address, "", 4, 5), conflict)
+	m.RemoveBlock(block)
+	assert.False(t, m.Has(other.ID))
+	assert.False(t, m.Has(grandchild.ID))
+	assert.False(t, m.IsSpent(other.ID, 0))
+	assert.Equal(t, 1, m.Count())
+}
+
+func TestMempoolExpiryAndEviction(t *testing.T) {
+	owner, bc, coinbases := testMempoolChain(t)
+	address := string(owner.GetAddress())
+	m := NewMempool(bc)
+
+	old := testSignedSpend(owner, coinbases[0], 0, 9, address)
+	child := testSignedSpend(owner, old, 0, 8, address)
+	fresh := testSignedSpend(owner, coinbases[1], 0, 9, address)
+	for _, tx := range []*Transaction{old, child, fresh} {
+		assert.NoError(t, m.Add(*tx))
+	}
+
+	// Устаревшая транзакция уходит вместе с потомком
+	m.txs[hex.EncodeToString(old.ID)].added = time.Now().Add(-mempoolExpiry - time.Minute)
+	m.Prune()
+	assert.False(t, m.Has(old.ID))
+	assert.False(t, m.Has(child.ID))
+	assert.True(t, m.Has(fresh.ID))
+
+	// Сверх лимита размера вытесняется транзакция с меньшей комиссией за килобайт, даже если она новая
+	oldLimit := maxMempoolSize
+	maxMempoolSize = m.Info().Size * 3 / 2
+	defer func() { maxMempoolSize = oldLimit }()
+
+	better := testSignedSpend(owner, coinbases[2], 0, 5, address)
+	assert.NoError(t, m.Add(*better))
+	assert.False(t, m.Has(fresh.ID))
+	assert.True(t, m.Has(better.ID))
+
+	worse := testSignedSpend(owner, coinbases[0], 0, 9, address)
+	assert.ErrorIs(t, m.Add(*worse), ErrMempoolFull)
+	assert.Equal(t, 1, m.Count())
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
 )
 
 // MerkleTree represent a Merkle tree
 type MerkleTree struct {
 	RootNode *MerkleNode
+
+	leaves int
+	levels [][]*MerkleNode // Levels from the leaves to the root, used to build proofs
 }
 
 // MerkleNode represent a Merkle tree node
@@ -16,33 +21,77 @@ type MerkleNode struct {
 	Data  []byte
 }
 
-// NewMerkleTree creates a new Merkle tree from a sequence of data
-func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
+// MerkleProof proves that a leaf is in the tree: Hashes are the siblings on the path from the leaf to the root,
+// bits of Index tell on which side each of them is
+type MerkleProof struct {
+	Index  int
+	Hashes [][]byte
+}
 
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
+// ErrNoSuchLeaf is returned when a proof is requested for a leaf the tree doesn't have
+var ErrNoSuchLeaf = errors.New("no such leaf in the Merkle tree")
 
+// NewMerkleTree creates a new Merkle tree from a sequence of data.
+// A level with an odd number of nodes gets its last node duplicated, a single leaf is paired with itself
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var nodes []*MerkleNode
 	for _, datum := range data {
-		node := NewMerkleNode(nil, nil, datum)
-		nodes = append(nodes, *node)
+		nodes = append(nodes, NewMerkleNode(nil, nil, datum))
 	}
 
-	for i := 0; i < len(data)/2; i++ {
-		var newLevel []MerkleNode
+	tree := MerkleTree{leaves: len(data)}
+	for len(nodes) > 1 || len(tree.levels) == 0 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+		tree.levels = append(tree.levels, nodes)
 
+		var newLevel []*MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
-			newLevel = append(newLevel, *node)
+			newLevel = append(newLevel, NewMerkleNode(nodes[j], nodes[j+1], nil))
 		}
 
 		nodes = newLevel
 	}
+	tree.RootNode = nodes[0]
+
+	return &tree
+}
+
+// Proof returns the proof of the leaf with the index
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, ErrNoSuchLeaf
+	}
+
+	proof := MerkleProof{Index: index}
+	for _, level := range t.levels {
+		proof.Hashes = append(proof.Hashes, level[index^1].Data)
+		index /= 2
+	}
+
+	return &proof, nil
+}
 
-	mTree := MerkleTree{&nodes[0]}
+// VerifyProof checks that the data is a leaf of the tree with the root
+func VerifyProof(root, data []byte, proof *MerkleProof) bool {
+	// Индекс не может выходить за пределы дерева такой высоты
+	if proof.Index < 0 || len(proof.Hashes) >= 32 || proof.Index>>uint(len(proof.Hashes)) != 0 {
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+	index := proof.Index
+	for _, sibling := range proof.Hashes {
+		if index%2 == 0 {
+			hash = sha256.Sum256(append(hash[:], sibling...))
+		} else {
+			hash = sha256.Sum256(append(append([]byte{}, sibling...), hash[:]...))
+		}
+		index /= 2
+	}
 
-	return &mTree
+	return bytes.Equal(hash[:], root)
 }
 
 // NewMerkleNode creates a new Merkle tree node
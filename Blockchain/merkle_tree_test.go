@@ -73,3 +73,44 @@ func TestNewMerkleTree(t *testing.T) {
 
 	assert.Equal(t, rootHash, fmt.Sprintf("%x", mTree.RootNode.Data), "Merkle tree root hash is correct")
 }
+
+func TestMerkleTreeOddLevels(t *testing.T) {
+	var data [][]byte
+	for i := 0; i < 9; i++ {
+		data = append(data, []byte(fmt.Sprintf("node%d", i)))
+	}
+
+	// Один лист, как и раньше, хэшируется в паре с самим собой
+	leaf := NewMerkleNode(nil, nil, data[0])
+	assert.Equal(t, NewMerkleNode(leaf, leaf, nil).Data, NewMerkleTree(data[:1]).RootNode.Data)
+
+	// У пяти листьев нечетны два уровня, последний узел каждого из них дублируется
+	var leaves []*MerkleNode
+	for _, datum := range data[:5] {
+		leaves = append(leaves, NewMerkleNode(nil, nil, datum))
+	}
+	n01 := NewMerkleNode(leaves[0], leaves[1], nil)
+	n23 := NewMerkleNode(leaves[2], leaves[3], nil)
+	n44 := NewMerkleNode(leaves[4], leaves[4], nil)
+	left := NewMerkleNode(n01, n23, nil)
+	right := NewMerkleNode(n44, n44, nil)
+	assert.Equal(t, NewMerkleNode(left, right, nil).Data, NewMerkleTree(data[:5]).RootNode.Data)
+
+	for count := 1; count <= len(data); count++ {
+		tree := NewMerkleTree(data[:count])
+		root := tree.RootNode.Data
+
+		for i := 0; i < count; i++ {
+			proof, err := tree.Proof(i)
+			assert.NoError(t, err)
+			assert.True(t, VerifyProof(root, data[i], proof), "Proof of leaf %d of %d", i, count)
+			assert.False(t, VerifyProof(root, []byte("other"), proof))
+
+			proof.Hashes[len(proof.Hashes)-1] = root
+			assert.False(t, VerifyProof(root, data[i], proof))
+		}
+
+		_, err := tree.Proof(count)
+		assert.ErrorIs(t, err, ErrNoSuchLeaf)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+// Сообщения протокола. Каждое умеет кодироваться детерминированным бинарным кодированием,
+// поля пишутся в порядке объявления
+
+type addr struct {
+	AddrList []string
+}
+
+func (m *addr) encodeBinary(w *wireWriter) {
+	w.putStringList(m.AddrList)
+}
+
+func (m *addr) decodeBinary(r *wireReader) {
+	m.AddrList = r.stringList()
+}
+
+type block struct {
+	AddrFrom string
+	Block    []byte
+}
+
+func (m *block) encodeBinary(w *wireWriter) {
+	w.putString(m.AddrFrom)
+	w.putBytes(m.Block)
+}
+
+func (m *block) decodeBinary(r *wireReader) {
+	m.AddrFrom = r.string()
+	m.Block = r.bytes()
+}
+
+// getheaders запрашивает заголовки основной цепочки после последнего общего блока.
+// Locator - хэши нашей цепочки от вершины к генезису, см. Blockchain.BlockLocator
+type getheaders struct {
+	Locator [][]byte
+}
+
+func (m *getheaders) encodeBinary(w *wireWriter) {
+	w.putBytesList(m.Locator)
+}
+
+func (m *getheaders) decodeBinary(r *wireReader) {
+	m.Locator = r.bytesList()
+}
+
+// headers отвечает на getheaders, каждый элемент закодирован Block.SerializeHeader
+type headers struct {
+	Headers [][]byte
+}
+
+func (m *headers) encodeBinary(w *wireWriter) {
+	w.putBytesList(m.Headers)
+}
+
+func (m *headers) decodeBinary(r *wireReader) {
+	m.Headers = r.bytesList()
+}
+
+// getmerkleproof запрашивает доказательства включения транзакций в блоки основной цепочки:
+// перечисленных и затрагивающих адреса с хэшами ключей PubKeyHashes. Ответ - merkleproof на каждую транзакцию
+type getmerkleproof struct {
+	TxIDs        [][]byte
+	PubKeyHashes [][]byte
+}
+
+func (m *getmerkleproof) encodeBinary(w *wireWriter) {
+	w.putBytesList(m.TxIDs)
+	w.putBytesList(m.PubKeyHashes)
+}
+
+func (m *getmerkleproof) decodeBinary(r *wireReader) {
+	m.TxIDs = r.bytesList()
+	m.PubKeyHashes = r.bytesList()
+}
+
+// merkleproof содержит транзакцию и путь от нее до корня Меркла блока BlockHash
+type merkleproof struct {
+	BlockHash   []byte
+	Transaction []byte
+	Index       int
+	Hashes      [][]byte
+}
+
+func (m *merkleproof) encodeBinary(w *wireWriter) {
+	w.putBytes(m.BlockHash)
+	w.putBytes(m.Transaction)
+	w.putInt64(int64(m.Index))
+	w.putBytesList(m.Hashes)
+}
+
+func (m *merkleproof) decodeBinary(r *wireReader) {
+	m.BlockHash = r.bytes()
+	m.Transaction = r.bytes()
+	m.Index = int(r.int64())
+	m.Hashes = r.bytesList()
+}
+
+type getdata struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
+func (m *getdata) encodeBinary(w *wireWriter) {
+	w.putString(m.AddrFrom)
+	w.putString(m.Type)
+	w.putBytes(m.ID)
+}
+
+func (m *getdata) decodeBinary(r *wireReader) {
+	m.AddrFrom = r.string()
+	m.Type = r.string()
+	m.ID = r.bytes()
+}
+
+type inv struct {
+	AddrFrom string
+	Type     string
+	Items    [][]byte
+}
+
+func (m *inv) encodeBinary(w *wireWriter) {
+	w.putString(m.AddrFrom)
+	w.putString(m.Type)
+	w.putBytesList(m.Items)
+}
+
+func (m *inv) decodeBinary(r *wireReader) {
+	m.AddrFrom = r.string()
+	m.Type = r.string()
+	m.Items = r.bytesList()
+}
+
+type tx struct {
+	AddFrom     string
+	Transaction []byte
+}
+
+func (m *tx) encodeBinary(w *wireWriter) {
+	w.putString(m.AddFrom)
+	w.putBytes(m.Transaction)
+}
+
+func (m *tx) decodeBinary(r *wireReader) {
+	m.AddFrom = r.string()
+	m.Transaction = r.bytes()
+}
+
+type verzion struct {
+	Version    int
+	BestHeight int
+	AddrFrom   string
+	Nonce      uint64 // Случайное число нода, по нему обнаруживается подключение к самому себе
+}
+
+func (m *verzion) encodeBinary(w *wireWriter) {
+	w.putInt64(int64(m.Version))
+	w.putInt64(int64(m.BestHeight))
+	w.putString(m.AddrFrom)
+	w.putUint64(m.Nonce)
+}
+
+func (m *verzion) decodeBinary(r *wireReader) {
+	m.Version = int(r.int64())
+	m.BestHeight = int(r.int64())
+	m.AddrFrom = r.string()
+	m.Nonce = r.uint64()
+}
+
+// verack подтверждает получение версии и не несет данных
+type verack struct{}
+
+func (m *verack) encodeBinary(w *wireWriter) {}
+
+func (m *verack) decodeBinary(r *wireReader) {}
+
+// ping используется и для pong, ответ повторяет nonce запроса
+type ping struct {
+	Nonce uint64
+}
+
+func (m *ping) encodeBinary(w *wireWriter) {
+	w.putUint64(m.Nonce)
+}
+
+func (m *ping) decodeBinary(r *wireReader) {
+	m.Nonce = r.uint64()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing metric safe for concurrent use
+type Counter struct {
+	value uint64
+}
+
+// Inc увеличивает счетчик на единицу
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// NodeMetrics counts messages of peers. Gauges like the chain height are read from the node on every scrape
+type NodeMetrics struct {
+	BlocksReceived Counter
+	BlocksRejected Counter // Битые, невалидные и не подключившиеся при синхронизации блоки
+	TxsReceived    Counter
+	TxsRejected    Counter
+}
+
+// metricsWriter пишет метрики в текстовом формате Prometheus
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricsWriter) metric(name, kind, help string, value interface{}) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+func (w *metricsWriter) gauge(name, help string, value interface{}) {
+	w.metric(name, "gauge", help, value)
+}
+
+func (w *metricsWriter) counter(name, help string, value interface{}) {
+	w.metric(name, "counter", help, value)
+}
+
+// MetricsHandler serves metrics of the node in the Prometheus text format
+type MetricsHandler struct {
+	node *Node
+}
+
+func (h MetricsHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	n := h.node
+	var w metricsWriter
+
+	height, err := n.bc.GetBestHeight()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.gauge("blockchain_height", "Height of the best chain.", height)
+
+	mempool := n.mempool.Info()
+	w.gauge("blockchain_mempool_transactions", "Transactions in the mempool.", mempool.Count)
+	w.gauge("blockchain_mempool_bytes", "Size of the mempool transactions.", mempool.Size)
+	w.gauge("blockchain_peers", "Peers which finished the handshake.", len(n.peers.Peers()))
+
+	w.counter("blockchain_blocks_received_total", "Blocks received from peers.", n.metrics.BlocksReceived.Value())
+	w.counter("blockchain_blocks_rejected_total", "Blocks from peers which were not added.", n.metrics.BlocksRejected.Value())
+	w.counter("blockchain_transactions_received_total", "Transactions received from peers.", n.metrics.TxsReceived.Value())
+	w.counter("blockchain_transactions_rejected_total", "Transactions from peers which were not accepted.", n.metrics.TxsRejected.Value())
+
+	if n.miner != nil {
+		mining := n.miner.Info()
+		w.gauge("blockchain_miner_hashrate", "Hashes per second of the miner since the start.", mining.HashRate)
+		w.counter("blockchain_miner_hashes_total", "Hashes computed by the miner.", mining.Hashes)
+		w.counter("blockchain_miner_blocks_total", "Blocks mined by the node.", mining.Blocks)
+	}
+
+	// Статистика bolt накапливается с открытия базы
+	stats := n.bc.db.Stats()
+	w.counter("bolt_transactions_total", "Started read transactions.", stats.TxN)
+	w.gauge("bolt_open_transactions", "Currently open read transactions.", stats.OpenTxN)
+	w.counter("bolt_write_total", "Writes to the database file.", stats.TxStats.Write)
+	w.counter("bolt_write_seconds_total", "Time spent writing to the database file.", stats.TxStats.WriteTime.Seconds())
+	w.counter("bolt_spill_seconds_total", "Time spent spilling pages.", stats.TxStats.SpillTime.Seconds())
+	w.counter("bolt_rebalance_seconds_total", "Time spent rebalancing pages.", stats.TxStats.RebalanceTime.Seconds())
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rw.Write(w.buf.Bytes())
+}
+
+// StartMetricsServer serves metrics of the node on address/metrics in the background
+func StartMetricsServer(address string, node *Node) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler{node})
+
+	server, err := serveHTTP(address, mux)
+	if err != nil {
+		return nil, err
+	}
+
+	node.log.Info("Metrics are served", "address", "http://"+address+"/metrics")
+
+	return server, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeMetrics(t *testing.T) {
+	captureLog(t, LevelError)
+	owner := NewWallet()
+	address := string(owner.GetAddress())
+	transport, ids := testNetwork(t, 2, address)
+
+	a := startTestNode(t, transport, ids[0])
+	b := startTestNode(t, transport, ids[1], ids[0])
+	waitFor(t, func() bool { return len(b.peers.Peers()) == 1 }, "b is connected to a")
+
+	block := mineTestBlock(t, a, address)
+	waitFor(t, func() bool { return string(testTip(t, b.bc)) == string(block.Hash) }, "block reaches b")
+
+	server := httptest.NewServer(MetricsHandler{b})
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "# TYPE blockchain_height gauge\nblockchain_height 1\n")
+	assert.Contains(t, string(body), "\nblockchain_peers 1\n")
+	assert.Contains(t, string(body), "\nblockchain_blocks_received_total 1\n")
+	assert.Contains(t, string(body), "\nblockchain_blocks_rejected_total 0\n")
+	assert.Contains(t, string(body), "# TYPE bolt_write_seconds_total counter\n")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+const metaBucket = "meta"
+const dbVersionKey = "version"
+
+// Версия формата базы данных. 0 - блоки в gob, 1 - в каноническом кодировании
+const dbVersion = 1
+
+// Ошибки конвертации базы данных
+var (
+	ErrDBOutdated  = errors.New("blockchain database has an older format, run migratedb")
+	ErrDBUpToDate  = errors.New("blockchain database is up to date")
+	ErrBackupFound = errors.New("backup of the database already exists")
+)
+
+// readDBVersion возвращает версию формата базы, у баз без корзины meta она 0
+func readDBVersion(tx *bolt.Tx) int {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0
+	}
+
+	data := b.Get([]byte(dbVersionKey))
+	if len(data) != 4 {
+		return 0
+	}
+
+	return int(binary.LittleEndian.Uint32(data))
+}
+
+// writeDBVersion записывает текущую версию формата базы
+func writeDBVersion(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	var data [4]byte
+	binary.LittleEndian.PutUint32(data[:], dbVersion)
+
+	return b.Put([]byte(dbVersionKey), data[:])
+}
+
+// MigrateDatabase converts blocks stored in gob by older versions to the canonical encoding
+// and returns the number of converted blocks. The blocks keep version 0, so their hashes don't change.
+// A copy of the database is saved with .bak suffix before the conversion
+func MigrateDatabase(nodeID string) (int, error) {
+	dbFile := dataPath(dbFile, nodeID)
+	if !dbExists(dbFile) {
+		return 0, fmt.Errorf("%s doesn't exist", dbFile)
+	}
+
+	db, err := bolt.Open(dbFile, 0600, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	backupFile := dbFile + ".bak"
+
+	err = db.View(func(tx *bolt.Tx) error {
+		if readDBVersion(tx) >= dbVersion {
+			return ErrDBUpToDate
+		}
+		if dbExists(backupFile) {
+			return fmt.Errorf("%w: %s", ErrBackupFound, backupFile)
+		}
+
+		return tx.CopyFile(backupFile, 0600)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	converted := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		// Во время обхода корзину менять нельзя, поэтому сначала собираем ключи
+		var hashes [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if !bytes.Equal(k, []byte("l")) {
+				hashes = append(hashes, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			block, err := deserializeGobBlock(b.Get(hash))
+			if err != nil {
+				return fmt.Errorf("block %x: %w", hash, err)
+			}
+
+			// Хэши старых блоков и корни Меркла должны сходиться и после перекодирования
+			if !bytes.Equal(block.Hash, hash) {
+				return fmt.Errorf("block %x: stored with hash %x", hash, block.Hash)
+			}
+			err = CheckBlock(block)
+			if err != nil {
+				return fmt.Errorf("block %x: %w", hash, err)
+			}
+
+			err = b.Put(hash, block.Serialize())
+			if err != nil {
+				return err
+			}
+			converted++
+		}
+
+		return writeDBVersion(tx)
+	})
+	if err != nil {
+		// База не изменилась, копия не нужна
+		os.Remove(backupFile)
+		return 0, err
+	}
+
+	return converted, nil
+}
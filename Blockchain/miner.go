@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Miner mines blocks from the mempool on its own goroutine, so handling of messages doesn't wait for mining
+type Miner struct {
+	node    *Node
+	address string
+	threads int
+	wake    chan struct{}
+
+	lock   sync.Mutex
+	mining bool
+	blocks int
+	stats  MiningStats // Вся работа с момента запуска, включая прерванные попытки
+}
+
+// MiningInfo describes the state of the miner
+type MiningInfo struct {
+	Address  string  `json:"address"`
+	Threads  int     `json:"threads"`
+	Mining   bool    `json:"mining"`
+	Blocks   int     `json:"blocks"`
+	Hashes   uint64  `json:"hashes"`
+	HashRate float64 `json:"hashrate"`
+}
+
+// NewMiner создает майнера нода, награда за блоки отправляется на address
+func NewMiner(n *Node, address string, threads int) *Miner {
+	return &Miner{
+		node:    n,
+		address: address,
+		threads: threads,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Wake просит майнера собрать блок из мемпула, повторные просьбы во время майнинга склеиваются в одну
+func (m *Miner) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run mines blocks while the mempool has transactions, it is woken up by Wake and returns when the node stops
+func (m *Miner) Run() {
+	for {
+		select {
+		case <-m.wake:
+			m.mineTransactions()
+		case <-m.node.ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Miner) mineTransactions() {
+	m.setMining(true)
+	defer m.setMining(false)
+
+	for m.node.mempool.Count() > 0 && m.node.ctx.Err() == nil {
+		// Транзакции выбираются по размеру комиссии, майнер забирает их все
+		txs, fees := m.node.mempool.BlockTemplate()
+
+		if len(txs) == 0 {
+			m.node.log.Warn("All transactions are invalid, waiting for new ones")
+			return
+		}
+
+		// Награда зависит от высоты блока, который ляжет на текущую вершину
+		bestHeight, err := m.node.bc.GetBestHeight()
+		if err != nil {
+			m.node.log.Error("Failed to read the best height", "err", err)
+			return
+		}
+
+		cbTx := NewCoinbaseTX(m.address, "", bestHeight+1, fees)
+		txs = append(txs, cbTx)
+
+		m.node.log.Debug("Mining a new block", "height", bestHeight+1, "transactions", len(txs), "threads", m.threads)
+		newBlock, stats, err := m.node.bc.MineBlockContext(m.node.ctx, txs, m.threads)
+		m.addStats(stats, err == nil)
+
+		// Шаблон собирается заново: на новой вершине часть транзакций уже может быть в блоке
+		if errors.Is(err, ErrTipChanged) || errors.Is(err, ErrStaleTemplate) {
+			m.node.log.Info("Mining is interrupted", "err", err, "hashes", stats.Hashes)
+			continue
+		}
+		if err != nil {
+			m.node.log.Error("Failed to mine a block", "err", err)
+			return
+		}
+
+		m.node.log.Info("New block is mined", "block", newBlock.Hash, "height", newBlock.Height,
+			"threads", m.threads, "hashes", stats.Hashes, "duration", stats.Duration.Round(time.Millisecond), "hashrate", stats)
+
+		m.node.announceBlock(newBlock)
+	}
+}
+
+func (m *Miner) setMining(mining bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.mining = mining
+}
+
+func (m *Miner) addStats(stats MiningStats, mined bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.stats = m.stats.Add(stats)
+	if mined {
+		m.blocks++
+	}
+}
+
+// Info returns the state of the miner and its average hashrate
+func (m *Miner) Info() MiningInfo {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return MiningInfo{
+		Address:  m.address,
+		Threads:  m.threads,
+		Mining:   m.mining,
+		Blocks:   m.blocks,
+		Hashes:   m.stats.Hashes,
+		HashRate: m.stats.HashRate(),
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProofOfWorkWorkers(t *testing.T) {
+	coinbase := NewCoinbaseTX(string(NewWallet().GetAddress()), "", 0, 0)
+	block := &Block{
+		Version:       blockVersion,
+		Timestamp:     time.Now().Unix(),
+		Transactions:  []*Transaction{coinbase},
+		PrevBlockHash: []byte{},
+		Bits:          BigToCompact(initialTarget),
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	nonce, hash, stats, err := NewProofOfWork(block).RunContext(context.Background(), 4)
+	assert.NoError(t, err)
+	assert.NotZero(t, stats.Hashes)
+
+	block.Nonce, block.Hash = nonce, hash
+	assert.True(t, NewProofOfWork(block).Validate())
+
+	// С такой целью блок не найти, перебор останавливает только контекст
+	block.Bits = BigToCompact(big.NewInt(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, stats, err = NewProofOfWork(block).RunContext(ctx, 4)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotZero(t, stats.Hashes)
+}
+
+func TestMiningStopsOnNewTip(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc, _ := syncedChains(t, address)
+	height := testHeight(t, bc)
+
+	tipChanged := bc.tipNotify()
+	block := testMine(t, bc, NewCoinbaseTX(address, "", height+1, 0))
+
+	select {
+	case <-tipChanged:
+	default:
+		t.Fatal("tip change is not notified")
+	}
+
+	// Прерванный майнинг ничего не записывает
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := bc.MineBlockContext(ctx, []*Transaction{NewCoinbaseTX(address, "", height+2, 1)}, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, height+1, testHeight(t, bc))
+	assert.Equal(t, block.Hash, testTip(t, bc))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// Под этим ключом в метаданных базы записано имя сети, базы без него созданы в основной сети
+const networkKey = "network"
+
+// Под этим ключом записано желаемое время между блоками, с которым создана цепочка. От него зависит Bits каждого блока,
+// поэтому после создания базы оно не меняется, в базах без него действует значение сети
+const targetIntervalKey = "targetinterval"
+
+// ErrWrongNetwork is returned when the database was created in another network
+var ErrWrongNetwork = errors.New("blockchain database belongs to another network")
+
+// Network describes parameters which separate networks: nodes of different networks drop messages of each other,
+// don't accept addresses of each other and start their chains from different genesis blocks
+type Network struct {
+	Name           string
+	Magic          uint32   // Начало каждого сообщения
+	AddressVersion byte     // Версия адресов с хэшем ключа
+	ScriptVersion  byte     // Версия адресов с хэшем скрипта (P2SH)
+	GenesisData    string   // Данные coinbase генезиса
+	GenesisTarget  *big.Int // Цель генезиса, с нее начинается пересчет сложности
+	PowLimit       *big.Int // Максимальная цель, то есть минимальная сложность
+	Retarget       bool     // Без пересчета сложность всей цепочки равна сложности генезиса
+	TargetInterval int64    // Желаемое время между блоками в секундах, по нему пересчитывается сложность
+	MinerBatch     int      // Сколько транзакций майнер ждет в мемпуле, прежде чем майнить блок
+	Halving        int      // Через сколько блоков награда за блок уменьшается вдвое
+	Maturity       int      // Через сколько блоков можно тратить награду за блок
+	DefaultPort    int      // Порт и ID нода по умолчанию
+	Seeds          []string // Ноды, к которым подключаемся при старте
+	DataSubdir     string   // Подкаталог каталога данных, чтобы файлы сетей не смешивались
+}
+
+var networks = map[string]*Network{
+	"mainnet": {
+		Name:           "mainnet",
+		Magic:          0xd9b4bef9,
+		AddressVersion: 0x00,
+		ScriptVersion:  0x05,
+		GenesisData:    "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+		GenesisTarget:  new(big.Int).Lsh(big.NewInt(1), 256-targetBits),
+		PowLimit:       new(big.Int).Lsh(big.NewInt(1), 256-8),
+		Retarget:       true,
+		TargetInterval: 10,
+		MinerBatch:     2,
+		Halving:        210000,
+		Maturity:       100,
+		DefaultPort:    3000,
+		Seeds:          []string{"localhost:3000"},
+	},
+	"testnet": {
+		Name:           "testnet",
+		Magic:          0x0709110b,
+		AddressVersion: 0x6f,
+		ScriptVersion:  0xc4,
+		GenesisData:    "Testnet genesis, coins of this network have no value",
+		GenesisTarget:  new(big.Int).Lsh(big.NewInt(1), 256-12),
+		PowLimit:       new(big.Int).Lsh(big.NewInt(1), 256-8),
+		Retarget:       true,
+		TargetInterval: 10,
+		MinerBatch:     2,
+		Halving:        210000,
+		Maturity:       100,
+		DefaultPort:    13000,
+		Seeds:          []string{"localhost:13000"},
+		DataSubdir:     "testnet",
+	},
+	// В regtest почти любой хэш подходит, блок майнится на первой же транзакции, а награды быстро созревают и уменьшаются
+	"regtest": {
+		Name:           "regtest",
+		Magic:          0xdab5bffa,
+		AddressVersion: 0x6f,
+		ScriptVersion:  0xc4,
+		GenesisData:    "Regtest genesis",
+		GenesisTarget:  new(big.Int).Lsh(big.NewInt(1), 255),
+		PowLimit:       new(big.Int).Lsh(big.NewInt(1), 255),
+		Retarget:       false,
+		TargetInterval: 10,
+		MinerBatch:     1,
+		Halving:        150,
+		Maturity:       10,
+		DefaultPort:    23000,
+		Seeds:          []string{"localhost:23000"},
+		DataSubdir:     "regtest",
+	},
+}
+
+// Сеть, в которой работает процесс, выбирается параметром -network
+var activeNetwork = networks["mainnet"]
+
+// networkNames возвращает имена известных сетей для сообщений об ошибках
+func networkNames() []string {
+	var names []string
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// UseNetwork switches the process to the network with the given name
+func UseNetwork(name string) error {
+	network, ok := networks[name]
+	if !ok {
+		return fmt.Errorf("unknown network %q, expected one of %v", name, networkNames())
+	}
+
+	activeNetwork = network
+	networkMagic = network.Magic
+	version = network.AddressVersion
+	scriptVersion = network.ScriptVersion
+	powLimit = network.PowLimit
+	targetBlockInterval = network.TargetInterval
+	seedNodes = network.Seeds
+	halvingInterval = network.Halving
+	coinbaseMaturity = network.Maturity
+
+	return nil
+}
+
+// readNetworkName возвращает имя сети, в которой создана база
+func readNetworkName(tx *bolt.Tx) string {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return "mainnet"
+	}
+
+	name := b.Get([]byte(networkKey))
+	if name == nil {
+		return "mainnet"
+	}
+
+	return string(name)
+}
+
+// writeNetworkParams записывает имя сети и параметры консенсуса, с которыми создается база
+func writeNetworkParams(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	err = b.Put([]byte(networkKey), []byte(activeNetwork.Name))
+	if err != nil {
+		return err
+	}
+
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], uint64(targetBlockInterval))
+
+	return b.Put([]byte(targetIntervalKey), data[:])
+}
+
+// readTargetInterval возвращает время между блоками, с которым создана база
+func readTargetInterval(tx *bolt.Tx) int64 {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return activeNetwork.TargetInterval
+	}
+
+	data := b.Get([]byte(targetIntervalKey))
+	if len(data) != 8 {
+		return activeNetwork.TargetInterval
+	}
+
+	return int64(binary.LittleEndian.Uint64(data))
+}
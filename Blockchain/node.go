@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// NodeConfig describes a node, only ID is required
+type NodeConfig struct {
+	ID           string    // Определяет файл базы данных
+	Address      string    // Адрес для входящих соединений, по умолчанию localhost:ID
+	Seeds        []string  // Адреса, с которых начинается адресная книга
+	MinerAddress string    // Включает майнинг с наградой на этот адрес
+	Transport    Transport // По умолчанию TCP
+}
+
+// Node is a full node: the blockchain with its mempool, connections to other nodes, the sync and the miner.
+// All its state is here, so several nodes can run in one process, each with its own database and transport
+type Node struct {
+	address   string
+	seeds     []string
+	transport Transport
+
+	bc      *Blockchain
+	mempool *Mempool
+	peers   *PeerManager
+	sync    *SyncManager
+	miner   *Miner // nil, если майнинг выключен
+
+	log     *Logger
+	metrics NodeMetrics
+
+	listener net.Listener
+	ctx      context.Context // Отменяется при остановке нода
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	stopped  chan struct{} // Закрывается, когда база закрыта
+}
+
+// NewNode opens the blockchain of the node, the node doesn't connect to anybody until Start
+func NewNode(config NodeConfig) (*Node, error) {
+	bc, err := NewBlockchain(config.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	n := newNode(bc, config.Address, config.Transport)
+	if n.address == "" {
+		n.address = fmt.Sprintf("localhost:%s", config.ID)
+	}
+	n.seeds = config.Seeds
+
+	if config.MinerAddress != "" {
+		n.miner = NewMiner(n, config.MinerAddress, miningThreads)
+	}
+
+	return n, nil
+}
+
+// newNode собирает нод вокруг открытого блокчейна
+func newNode(bc *Blockchain, address string, transport Transport) *Node {
+	if transport == nil {
+		transport = TCPTransport{}
+	}
+
+	n := &Node{address: address, transport: transport, bc: bc, mempool: NewMempool(bc), stopped: make(chan struct{})}
+	n.log = bc.log
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.peers = NewPeerManager(n)
+	n.sync = NewSyncManager(n)
+
+	return n
+}
+
+// Start listens for connections and starts connecting to the seeds.
+// A node which failed to start has to be stopped to close its database
+func (n *Node) Start() error {
+	listener, err := n.transport.Listen(n.address)
+	if err != nil {
+		return err
+	}
+	n.listener = listener
+
+	// Режим обрезки включается и для уже накопленных блоков, дальше блоки обрезаются при смене вершины
+	if pruneKeep > 0 {
+		pruned, err := n.bc.Prune(pruneKeep)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		n.log.Info("Pruning is on", "pruned", pruned, "keep", pruneKeep)
+	}
+
+	// Мемпул периодически чистится от устаревших транзакций
+	n.every(mempoolPruneInterval, n.mempool.Prune)
+
+	// Блоки загружаются сначала заголовками, затем параллельно с нескольких нодов
+	n.every(syncCheckInterval, n.sync.checkTimeouts)
+
+	// Майнер работает в своей горутине и прерывается, когда вершину меняет чужой блок
+	if n.miner != nil {
+		n.spawn(n.miner.Run)
+	}
+
+	// Менеджер сам подключается к нодам из адресной книги, начиная с сидов
+	n.peers.Start(n.seeds)
+
+	n.spawn(n.acceptConnections)
+
+	return nil
+}
+
+// acceptConnections принимает входящие соединения, пока слушатель не закрыт при остановке
+func (n *Node) acceptConnections() {
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			if n.ctx.Err() == nil {
+				n.log.Error("Failed to accept a connection", "err", err)
+				n.Stop()
+			}
+			return
+		}
+		n.spawn(func() { n.peers.HandleInbound(conn) })
+	}
+}
+
+// Stop disconnects peers, waits for running handlers and closes the database
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		n.cancel()
+		if n.listener != nil {
+			n.listener.Close()
+		}
+		n.peers.DisconnectAll()
+
+		// Остановку может вызвать и горутина самого нода, тогда ждать ее нельзя
+		go func() {
+			n.wg.Wait()
+			n.bc.db.Close()
+			close(n.stopped)
+		}()
+	})
+}
+
+// Done is closed when the node starts stopping, by Stop or because its listener failed
+func (n *Node) Done() <-chan struct{} {
+	return n.ctx.Done()
+}
+
+// Wait blocks until the node is stopped and its database is closed
+func (n *Node) Wait() {
+	<-n.stopped
+}
+
+// spawn запускает горутину нода, Stop дожидается их всех
+func (n *Node) spawn(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+// every вызывает f с интервалом до остановки нода
+func (n *Node) every(interval time.Duration, f func()) {
+	n.spawn(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-n.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// broadcast отправляет запрос всем подключенным нодам, кроме except
+func (n *Node) broadcast(data []byte, except string) {
+	n.peers.Broadcast(data, except)
+}
+
+// announceBlock убирает транзакции нового блока из мемпула и рассылает его подключенным нодам
+func (n *Node) announceBlock(block *Block) {
+	n.mempool.RemoveBlock(block)
+	n.relayBlock(block.Hash, "")
+}
+
+// relayBlock сообщает о блоке всем подключенным нодам, кроме except, от которого блок получен
+func (n *Node) relayBlock(hash []byte, except string) {
+	n.broadcast(newMessage("inv", &inv{n.address, "block", [][]byte{hash}}), except)
+}
+
+// Connect dials the address right away without waiting for the address book
+func (n *Node) Connect(address string) {
+	n.peers.Connect(address)
+}
+
+// SubmitTransaction puts a local transaction into the mempool and announces it
+func (n *Node) SubmitTransaction(tx Transaction) error {
+	return n.processTransaction(tx, n.address)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNetwork создает во временном каталоге базы count нодов с общим генезисом, награда за который у address
+func testNetwork(t *testing.T, count int, address string) (*MemoryTransport, []string) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	bc, err := CreateBlockchain(address, "n0", false)
+	assert.NoError(t, err)
+	bc.db.Close()
+
+	data, err := ioutil.ReadFile("blockchain_n0.db")
+	assert.NoError(t, err)
+
+	ids := []string{"n0"}
+	for i := 1; i < count; i++ {
+		id := fmt.Sprintf("n%d", i)
+		assert.NoError(t, ioutil.WriteFile(fmt.Sprintf(dbFile, id), data, 0600))
+		ids = append(ids, id)
+	}
+
+	return NewMemoryTransport(), ids
+}
+
+// startTestNode запускает нод в памяти процесса, его адрес и хост совпадают с ID
+func startTestNode(t *testing.T, transport *MemoryTransport, id string, seeds ...string) *Node {
+	n, err := NewNode(NodeConfig{ID: id, Address: id, Seeds: seeds, Transport: transport.Host(id)})
+	assert.NoError(t, err)
+	assert.NoError(t, n.Start())
+	t.Cleanup(func() {
+		n.Stop()
+		n.Wait()
+	})
+
+	return n
+}
+
+// mineTestBlock майнит пустой блок на ноде и рассылает его, как это делает майнер
+func mineTestBlock(t *testing.T, n *Node, address string, txs ...*Transaction) *Block {
+	block := testMine(t, n.bc, append([]*Transaction{NewCoinbaseTX(address, "", testHeight(t, n.bc)+1, 0)}, txs...)...)
+	n.announceBlock(block)
+
+	return block
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	assert.Eventually(t, condition, 10*time.Second, 10*time.Millisecond, message)
+}
+
+func balance(t *testing.T, n *Node, address string) int {
+	outputs, err := (UTXOSet{n.bc}).FindUTXO(PubKeyHashFromAddress(address))
+	assert.NoError(t, err)
+
+	total := 0
+	for _, out := range outputs {
+		total += out.Value
+	}
+
+	return total
+}
+
+func TestNodesPropagateBlocksAndTransactions(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address := string(owner.GetAddress())
+	transport, ids := testNetwork(t, 3, address)
+
+	// Цепочка a - b - c, о блоках и транзакциях c узнает только через b
+	a := startTestNode(t, transport, ids[0])
+	b := startTestNode(t, transport, ids[1], ids[0])
+	c := startTestNode(t, transport, ids[2], ids[1])
+	waitFor(t, func() bool { return len(b.peers.Peers()) >= 2 }, "b is connected to a and c")
+
+	block := mineTestBlock(t, a, address)
+	waitFor(t, func() bool { return string(testTip(t, c.bc)) == string(block.Hash) }, "block reaches c")
+
+	other := string(NewWallet().GetAddress())
+	payment := testPayment(t, owner, other, 3, 1, c.bc)
+	assert.NoError(t, c.SubmitTransaction(*payment))
+	waitFor(t, func() bool { return a.mempool.Has(payment.ID) }, "transaction reaches a")
+
+	block = mineTestBlock(t, a, address, payment)
+	waitFor(t, func() bool { return testHeight(t, c.bc) == 2 && c.mempool.Count() == 0 }, "mined transaction leaves mempools")
+	assert.Equal(t, 3, balance(t, c, other))
+	assert.Equal(t, block.Hash, testTip(t, b.bc))
+}
+
+func TestNodesSwitchToHeavierFork(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	transport, ids := testNetwork(t, 2, address)
+
+	a := startTestNode(t, transport, ids[0])
+	b := startTestNode(t, transport, ids[1])
+
+	// Пока ноды не связаны, каждый майнит свою ветку
+	mineTestBlock(t, a, address)
+	tip := mineTestBlock(t, a, address)
+	forked := mineTestBlock(t, b, string(NewWallet().GetAddress()))
+
+	b.Connect(a.address)
+	waitFor(t, func() bool { return string(testTip(t, b.bc)) == string(tip.Hash) }, "b switches to the longer branch")
+
+	// Блок b остался на боковой ветке, его награда в chainstate не попала
+	_, err := b.bc.GetBlock(forked.Hash)
+	assert.NoError(t, err)
+	hash, err := b.bc.GetBlockHashByHeight(1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, forked.Hash, hash)
+	assert.Equal(t, testCountUTXO(t, a.bc), testCountUTXO(t, b.bc))
+}
+
+func TestNodesResolveDoubleSpend(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address := string(owner.GetAddress())
+	transport, ids := testNetwork(t, 2, address)
+
+	a := startTestNode(t, transport, ids[0])
+	b := startTestNode(t, transport, ids[1])
+
+	// Один и тот же выход генезиса тратится по-разному на двух несвязанных нодах
+	first, second := string(NewWallet().GetAddress()), string(NewWallet().GetAddress())
+	spend := testPayment(t, owner, first, 4, 1, a.bc)
+	doubleSpend := testPayment(t, owner, second, 4, 1, b.bc)
+	assert.NoError(t, a.SubmitTransaction(*spend))
+	assert.NoError(t, b.SubmitTransaction(*doubleSpend))
+
+	b.Connect(a.address)
+	waitFor(t, func() bool { return len(a.peers.Peers()) == 1 }, "nodes are connected")
+
+	// Каждый нод держится за первую полученную транзакцию
+	assert.ErrorIs(t, a.SubmitTransaction(*doubleSpend), ErrMempoolConflict)
+	assert.False(t, b.mempool.Has(spend.ID))
+
+	mineTestBlock(t, a, address, spend)
+	waitFor(t, func() bool { return testHeight(t, b.bc) == 1 }, "block reaches b")
+
+	assert.False(t, b.mempool.Has(doubleSpend.ID), "conflicting transaction is dropped")
+	assert.Equal(t, 4, balance(t, b, first))
+	assert.Equal(t, 0, balance(t, b, second))
+	assert.Error(t, b.SubmitTransaction(*doubleSpend))
+}
+
+func TestNodeRestartCatchesUp(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	transport, ids := testNetwork(t, 2, address)
+
+	a := startTestNode(t, transport, ids[0])
+	b := startTestNode(t, transport, ids[1], ids[0])
+
+	mineTestBlock(t, a, address)
+	waitFor(t, func() bool { return testHeight(t, b.bc) == 1 }, "b is synced")
+
+	b.Stop()
+	b.Wait()
+	waitFor(t, func() bool { return len(a.peers.Peers()) == 0 }, "a notices the stopped peer")
+
+	mineTestBlock(t, a, address)
+	tip := mineTestBlock(t, a, address)
+
+	// После перезапуска база на месте, недостающие блоки догружаются при подключении
+	b = startTestNode(t, transport, ids[1], ids[0])
+	assert.GreaterOrEqual(t, testHeight(t, b.bc), 1)
+	waitFor(t, func() bool { return string(testTip(t, b.bc)) == string(tip.Hash) }, "b catches up after restart")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Тайминги соединения с пиром
+const (
+	handshakeTimeout = 10 * time.Second
+	pingInterval     = 30 * time.Second
+	peerTimeout      = 90 * time.Second // Если так долго ничего не приходит, соединение рвется
+	writeTimeout     = 10 * time.Second
+	peerSendQueue    = 100
+)
+
+// Peer - долгоживущее соединение с другим нодом. Сообщения пира обрабатываются по одному
+// в его горутине чтения, отправка идет через очередь, которую обслуживает отдельная горутина
+type Peer struct {
+	conn    net.Conn
+	inbound bool
+
+	// Для исходящих - адрес, к которому подключились, для входящих - удаленный адрес соединения.
+	// Адресу, который пир сам сообщает в version, не доверяем
+	addr string
+	host string // Удаленный хост соединения, по нему считаются штрафы и баны
+
+	version    *verzion
+	verackDone bool
+
+	send      chan []byte
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	pingLock  sync.Mutex
+	pingNonce uint64
+	pingSent  time.Time
+	pingTime  time.Duration
+}
+
+func newPeer(conn net.Conn, addr string, inbound bool) *Peer {
+	return &Peer{
+		conn:    conn,
+		inbound: inbound,
+		addr:    addr,
+		host:    remoteHost(conn),
+		send:    make(chan []byte, peerSendQueue),
+		quit:    make(chan struct{}),
+	}
+}
+
+func (p *Peer) String() string {
+	direction := "outbound"
+	if p.inbound {
+		direction = "inbound"
+	}
+
+	return fmt.Sprintf("%s (%s)", p.Addr(), direction)
+}
+
+// Addr возвращает адрес пира
+func (p *Peer) Addr() string {
+	return p.addr
+}
+
+// addrHost возвращает хост адреса, адрес без порта возвращается целиком
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// remoteHost возвращает хост другого конца соединения
+func remoteHost(conn net.Conn) string {
+	return addrHost(conn.RemoteAddr().String())
+}
+
+// handshakeDone проверяет, получены ли и version, и verack
+func (p *Peer) handshakeDone() bool {
+	return p.version != nil && p.verackDone
+}
+
+// Send ставит рамку сообщения в очередь, пир, не успевающий разбирать очередь, отключается
+func (p *Peer) Send(frame []byte) {
+	select {
+	case p.send <- frame:
+	case <-p.quit:
+	default:
+		(*Logger)(nil).Warn("Send queue is full, disconnecting", "peer", p)
+		p.Disconnect()
+	}
+}
+
+// Disconnect закрывает соединение, после этого горутина чтения удаляет пира
+func (p *Peer) Disconnect() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+// Closed проверяет, отключен ли пир
+func (p *Peer) Closed() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeLoop отправляет сообщения из очереди и пингует пира
+func (p *Peer) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		var frame []byte
+
+		select {
+		case frame = <-p.send:
+		case <-ticker.C:
+			frame = p.newPing()
+		case <-p.quit:
+			return
+		}
+
+		p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err := writeMessage(p.conn, frame)
+		if err != nil {
+			p.Disconnect()
+			return
+		}
+	}
+}
+
+// newPing создает сообщение ping и запоминает его nonce, чтобы замерить время ответа
+func (p *Peer) newPing() []byte {
+	p.pingLock.Lock()
+	defer p.pingLock.Unlock()
+
+	p.pingNonce = randomNonce()
+	p.pingSent = time.Now()
+
+	return newMessage("ping", &ping{p.pingNonce})
+}
+
+// handlePong принимает ответ на последний ping
+func (p *Peer) handlePong(nonce uint64) {
+	p.pingLock.Lock()
+	defer p.pingLock.Unlock()
+
+	if nonce == p.pingNonce && !p.pingSent.IsZero() {
+		p.pingTime = time.Since(p.pingSent)
+	}
+}
+
+// PingTime возвращает последнее замеренное время ответа
+func (p *Peer) PingTime() time.Duration {
+	p.pingLock.Lock()
+	defer p.pingLock.Unlock()
+
+	return p.pingTime
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Ограничения на число пиров
+const (
+	maxOutboundPeers = 8
+	maxInboundPeers  = 32
+	connectInterval  = 5 * time.Second
+	dialTimeout      = 5 * time.Second
+)
+
+// Хосты пиров, набравших banThreshold штрафных очков, банятся на banDuration
+const banThreshold = 100
+const banDuration = 24 * time.Hour
+
+var errBannedHost = errors.New("host is banned")
+
+// PeerManager держит соединения с другими нодами: принимает входящих пиров, подключается к адресам
+// из адресной книги, чтобы поддерживать исходящие соединения, и банит нарушителей
+type PeerManager struct {
+	node  *Node
+	book  *AddrBook
+	nonce uint64 // Отправляется в version, чтобы распознать подключение к самому себе
+
+	lock      sync.Mutex
+	peers     map[*Peer]bool
+	ready     map[*Peer]bool  // Пиры, завершившие рукопожатие
+	dialing   map[string]bool // Устанавливаемые исходящие соединения
+	banScores map[string]int  // Штрафные очки по хостам пиров
+}
+
+// NewPeerManager создает менеджер пиров нода, адресная книга хранится в базе блокчейна
+func NewPeerManager(n *Node) *PeerManager {
+	return &PeerManager{
+		node:      n,
+		book:      NewAddrBook(n.bc.db),
+		nonce:     randomNonce(),
+		peers:     make(map[*Peer]bool),
+		ready:     make(map[*Peer]bool),
+		dialing:   make(map[string]bool),
+		banScores: make(map[string]int),
+	}
+}
+
+// Start добавляет адреса сидов в адресную книгу и поддерживает исходящие соединения
+func (pm *PeerManager) Start(seeds []string) {
+	var addrs []string
+	for _, seed := range seeds {
+		if seed != pm.node.address {
+			addrs = append(addrs, seed)
+		}
+	}
+	pm.book.Add(addrs)
+
+	pm.node.spawn(func() {
+		for {
+			pm.connectPeers()
+
+			select {
+			case <-time.After(connectInterval):
+			case <-pm.node.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// HandleInbound начинает обслуживать принятое соединение. Соединения с забаненных хостов
+// и сверх лимита входящих закрываются до рукопожатия
+func (pm *PeerManager) HandleInbound(conn net.Conn) {
+	if pm.IsBanned(remoteHost(conn)) {
+		pm.node.log.Debug("Banned host is rejected", "peer", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	pm.lock.Lock()
+	inbound := 0
+	for p := range pm.peers {
+		if p.inbound {
+			inbound++
+		}
+	}
+	// Остановленный нод новых соединений не принимает
+	if inbound >= maxInboundPeers || pm.node.ctx.Err() != nil {
+		pm.lock.Unlock()
+		conn.Close()
+		return
+	}
+
+	p := newPeer(conn, conn.RemoteAddr().String(), true)
+	pm.peers[p] = true
+	pm.lock.Unlock()
+
+	pm.runPeer(p)
+}
+
+// connectPeers подключается к адресам из адресной книги, пока не наберется лимит исходящих
+func (pm *PeerManager) connectPeers() {
+	pm.lock.Lock()
+	exclude := map[string]bool{pm.node.address: true}
+	outbound := len(pm.dialing)
+	for addr := range pm.dialing {
+		exclude[addr] = true
+	}
+	for p := range pm.peers {
+		exclude[p.Addr()] = true
+		if !p.inbound {
+			outbound++
+		}
+	}
+	pm.lock.Unlock()
+
+	if outbound >= maxOutboundPeers {
+		return
+	}
+
+	for _, addr := range pm.book.Candidates(maxOutboundPeers-outbound, exclude) {
+		pm.lock.Lock()
+		pm.dialing[addr] = true
+		pm.lock.Unlock()
+
+		addr := addr
+		pm.node.spawn(func() { pm.connect(addr) })
+	}
+}
+
+// Connect добавляет адрес в адресную книгу и подключается к нему, если еще не подключен
+func (pm *PeerManager) Connect(addr string) {
+	pm.book.Add([]string{addr})
+	pm.dial(addr, false)
+}
+
+// dial подключается к адресу, если еще не подключен. С limited подключение не превышает лимит исходящих
+func (pm *PeerManager) dial(addr string, limited bool) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if pm.dialing[addr] || addr == pm.node.address {
+		return
+	}
+	outbound := len(pm.dialing)
+	for p := range pm.peers {
+		if p.Addr() == addr {
+			return
+		}
+		if !p.inbound {
+			outbound++
+		}
+	}
+	if limited && outbound >= maxOutboundPeers {
+		return
+	}
+	pm.dialing[addr] = true
+
+	pm.node.spawn(func() { pm.connect(addr) })
+}
+
+// connect подключается к адресу и обслуживает соединение
+func (pm *PeerManager) connect(addr string) {
+	pm.book.Attempt(addr)
+
+	conn, err := pm.node.transport.Dial(addr, dialTimeout)
+	if err == nil && pm.IsBanned(remoteHost(conn)) {
+		conn.Close()
+		err = errBannedHost
+	}
+
+	pm.lock.Lock()
+	delete(pm.dialing, addr)
+	if err == nil && pm.node.ctx.Err() != nil {
+		// Нод остановился, пока мы подключались
+		pm.lock.Unlock()
+		conn.Close()
+		return
+	}
+	if err != nil {
+		pm.lock.Unlock()
+		pm.node.log.Debug("Node is not available", "peer", addr, "err", err)
+		pm.book.Failed(addr)
+		return
+	}
+	p := newPeer(conn, addr, false)
+	pm.peers[p] = true
+	pm.lock.Unlock()
+
+	pm.runPeer(p)
+}
+
+// runPeer читает и обрабатывает сообщения пира, пока соединение не закроется
+func (pm *PeerManager) runPeer(p *Peer) {
+	defer pm.removePeer(p)
+
+	go p.writeLoop()
+	if !p.inbound {
+		pm.node.sendVersion(p)
+	}
+
+	for {
+		timeout := handshakeTimeout
+		if p.handshakeDone() {
+			timeout = peerTimeout
+		}
+		p.conn.SetReadDeadline(time.Now().Add(timeout))
+
+		msg, err := readMessage(p.conn)
+		if err == ErrBadChecksum || err == ErrBadEncoding {
+			// Рамка прочитана целиком, поток не сбился, и соединение можно не рвать
+			pm.Misbehaving(p, banThreshold/5, err.Error())
+			continue
+		}
+		if err != nil {
+			if err == ErrBadMagic || err == ErrBadCommand || err == ErrMessageTooLarge {
+				pm.Misbehaving(p, banThreshold, err.Error())
+			}
+			return
+		}
+
+		if !p.handshakeDone() && msg.Command != "version" && msg.Command != "verack" {
+			pm.node.log.Warn("Message before the handshake", "peer", p, "command", msg.Command)
+			return
+		}
+
+		pm.node.handleMessage(p, msg)
+	}
+}
+
+// removePeer забывает закрытого пира, неудачное исходящее рукопожатие считается неудачной попыткой
+func (pm *PeerManager) removePeer(p *Peer) {
+	p.Disconnect()
+
+	pm.lock.Lock()
+	delete(pm.peers, p)
+	wasReady := pm.ready[p]
+	delete(pm.ready, p)
+	pm.lock.Unlock()
+
+	if !p.inbound && !wasReady {
+		pm.book.Failed(p.Addr())
+	}
+	if wasReady {
+		pm.node.log.Info("Peer disconnected", "peer", p)
+	}
+}
+
+// acceptVersion проверяет версию пира. Возвращает false, если пира нужно отключить.
+// Адрес, объявленный входящим пиром, проверяется подключением к нему, в адресную книгу
+// он попадает только после успешного рукопожатия по этому адресу
+func (pm *PeerManager) acceptVersion(p *Peer, version verzion) bool {
+	if version.Nonce == pm.nonce {
+		// Подключились сами к себе, такой адрес больше не нужен
+		pm.book.Remove(p.Addr())
+		return false
+	}
+	if version.Version < minPeerVersion {
+		pm.node.log.Warn("Peer has an obsolete version", "peer", p, "version", version.Version)
+		return false
+	}
+	if !pm.keepConnection(p, version) {
+		return false
+	}
+
+	if p.inbound && version.AddrFrom != "" {
+		pm.dial(version.AddrFrom, true)
+	}
+
+	return true
+}
+
+// keepConnection запоминает версию пира, если с его нодом еще нет соединения. Нод узнается по nonce
+// из version вместе с хостом, так что чужой nonce не дает закрыть соединение с другого хоста
+func (pm *PeerManager) keepConnection(p *Peer, version verzion) bool {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	for other := range pm.peers {
+		if other == p || other.version == nil || other.version.Nonce != version.Nonce || other.host != p.host {
+			continue
+		}
+
+		// Ноды могли подключиться друг к другу одновременно, например при проверке адреса. Обе стороны
+		// оставляют соединение, открытое нодом с меньшим nonce, иначе оба соединения закрылись бы
+		if other.inbound == p.inbound || pm.initiatorNonce(p, version) > pm.initiatorNonce(other, *other.version) {
+			pm.node.log.Debug("Already connected", "peer", p)
+			return false
+		}
+		other.Disconnect()
+	}
+	p.version = &version
+
+	return true
+}
+
+// initiatorNonce возвращает nonce нода, открывшего соединение
+func (pm *PeerManager) initiatorNonce(p *Peer, version verzion) uint64 {
+	if p.inbound {
+		return version.Nonce
+	}
+
+	return pm.nonce
+}
+
+// handshakeDone делает пира доступным для отправки
+func (pm *PeerManager) handshakeDone(p *Peer) {
+	pm.lock.Lock()
+	pm.ready[p] = true
+	pm.lock.Unlock()
+
+	if !p.inbound {
+		pm.book.Good(p.Addr())
+	}
+	pm.node.log.Info("Connected to peer", "peer", p, "inbound", p.inbound)
+}
+
+// Peer возвращает подключенного пира по адресу
+func (pm *PeerManager) Peer(addr string) *Peer {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	for p := range pm.ready {
+		if p.Addr() == addr {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Peers возвращает всех подключенных пиров
+func (pm *PeerManager) Peers() []*Peer {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	var peers []*Peer
+	for p := range pm.ready {
+		peers = append(peers, p)
+	}
+
+	return peers
+}
+
+// Broadcast отправляет сообщение всем подключенным пирам, кроме пира с адресом except
+func (pm *PeerManager) Broadcast(frame []byte, except string) {
+	for _, p := range pm.Peers() {
+		if p.Addr() != except {
+			p.Send(frame)
+		}
+	}
+}
+
+// GoodAddresses возвращает адреса для рассылки другим пирам, включая собственный
+func (pm *PeerManager) GoodAddresses() []string {
+	return append([]string{pm.node.address}, pm.book.GoodAddresses(maxAddrPerMessage-1)...)
+}
+
+// AddAddresses добавляет в адресную книгу адреса, объявленные пирами
+func (pm *PeerManager) AddAddresses(addrs []string) int {
+	var filtered []string
+	for _, addr := range addrs {
+		if addr != pm.node.address {
+			filtered = append(filtered, addr)
+		}
+	}
+
+	return pm.book.Add(filtered)
+}
+
+// Misbehaving добавляет хосту пира штрафные очки, при banThreshold хост банится и отключается.
+// Очки считаются по хосту соединения, а не по адресу из version, который пир может подменить
+func (pm *PeerManager) Misbehaving(p *Peer, score int, reason string) {
+	pm.lock.Lock()
+	pm.banScores[p.host] += score
+	total := pm.banScores[p.host]
+	pm.lock.Unlock()
+
+	pm.node.log.Warn("Peer misbehaved", "peer", p, "reason", reason, "score", total)
+
+	if total >= banThreshold {
+		pm.Ban(p.host)
+	}
+}
+
+// Ban отключает пиров с этого хоста и не дает им подключаться в течение banDuration
+func (pm *PeerManager) Ban(host string) {
+	pm.book.Ban(host, time.Now().Add(banDuration))
+	pm.node.log.Warn("Host is banned", "host", host)
+
+	pm.lock.Lock()
+	delete(pm.banScores, host)
+	var banned []*Peer
+	for p := range pm.peers {
+		if p.host == host {
+			banned = append(banned, p)
+		}
+	}
+	pm.lock.Unlock()
+
+	for _, p := range banned {
+		p.Disconnect()
+	}
+}
+
+// DisconnectAll закрывает соединения со всеми пирами, включая тех, что в процессе рукопожатия
+func (pm *PeerManager) DisconnectAll() {
+	pm.lock.Lock()
+	var all []*Peer
+	for p := range pm.peers {
+		all = append(all, p)
+	}
+	pm.lock.Unlock()
+
+	for _, p := range all {
+		p.Disconnect()
+	}
+}
+
+// IsBanned проверяет, забанен ли хост
+func (pm *PeerManager) IsBanned(host string) bool {
+	return pm.book.IsBanned(host)
+}
+
+// sendOneShot доставляет сообщение без запущенного нода, как это делают команды CLI:
+// подключается, проходит рукопожатие, отправляет сообщение и отключается
+func sendOneShot(addr string, frame []byte) error {
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	err = writeMessage(conn, newVersionMessage("", 0, randomNonce()))
+	if err != nil {
+		return err
+	}
+
+	gotVersion, gotVerack := false, false
+	for !gotVersion || !gotVerack {
+		reply, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		switch reply.Command {
+		case "version":
+			gotVersion = true
+			err = writeMessage(conn, newMessage("verack", &verack{}))
+			if err != nil {
+				return err
+			}
+		case "verack":
+			gotVerack = true
+		}
+	}
+
+	return writeMessage(conn, frame)
+}
+
+func randomNonce() uint64 {
+	var nonce [8]byte
+	_, err := rand.Read(nonce[:])
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return binary.BigEndian.Uint64(nonce[:])
+}
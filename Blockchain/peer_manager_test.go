@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{0, retryBaseDelay},
+		{1, 2 * retryBaseDelay},
+		{3, 8 * retryBaseDelay},
+		{maxConnectAttempts * 2, retryMaxDelay},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.delay, KnownAddress{Attempts: test.attempts}.retryDelay(), "attempts %d", test.attempts)
+	}
+}
+
+func TestAddrBook(t *testing.T) {
+	bc, _ := syncedChains(t, string(NewWallet().GetAddress()))
+	book := NewAddrBook(bc.db)
+
+	// Повторы и пустые адреса не добавляются
+	assert.Equal(t, 2, book.Add([]string{"localhost:1", "localhost:2", "localhost:1", ""}))
+	assert.Equal(t, 1, book.Add([]string{"localhost:2", "localhost:3"}))
+	assert.ElementsMatch(t, []string{"localhost:1", "localhost:2", "localhost:3"}, book.Candidates(10, nil))
+	assert.Equal(t, []string{"localhost:3"}, book.Candidates(10, map[string]bool{"localhost:1": true, "localhost:2": true}))
+
+	// После попытки адрес ждет retryDelay, и ожидание растет с каждой неудачей
+	book.Attempt("localhost:1")
+	book.Failed("localhost:1")
+	assert.NotContains(t, book.Candidates(10, nil), "localhost:1")
+	book.update("localhost:1", false, func(ka *KnownAddress) bool {
+		ka.LastAttempt = time.Now().Add(-2*retryBaseDelay - time.Second)
+		return true
+	})
+	assert.Equal(t, "localhost:1", book.Candidates(10, nil)[2], "Failed addresses go last")
+	book.Failed("localhost:1")
+	assert.NotContains(t, book.Candidates(10, nil), "localhost:1")
+
+	// Успешное рукопожатие сбрасывает счетчик, такие адреса идут первыми
+	book.Good("localhost:1")
+	book.Good("localhost:3")
+	assert.Equal(t, []string{"localhost:3", "localhost:1"}, book.GoodAddresses(10))
+	assert.Equal(t, "localhost:3", book.Candidates(10, nil)[0])
+
+	// После maxConnectAttempts неудач подряд адрес забывается
+	for i := 0; i < maxConnectAttempts; i++ {
+		book.Failed("localhost:2")
+	}
+	assert.Equal(t, 1, book.Add([]string{"localhost:2"}), "A forgotten address can be added again")
+
+	// Адреса забаненного хоста не выдаются, пока бан не кончится
+	assert.Equal(t, 2, book.Add([]string{"banned:1", "banned:2"}))
+	book.Ban("banned", time.Now().Add(time.Hour))
+	assert.True(t, book.IsBanned("banned"))
+	assert.False(t, book.IsBanned("localhost"))
+	assert.NotContains(t, book.Candidates(10, nil), "banned:1")
+	assert.NotContains(t, book.Candidates(10, nil), "banned:2")
+	assert.Contains(t, book.Candidates(10, nil), "localhost:1")
+
+	book.Ban("banned", time.Now().Add(-time.Second))
+	assert.False(t, book.IsBanned("banned"))
+	assert.Contains(t, book.Candidates(10, nil), "banned:1")
+}
+
+func TestAddrBookLimit(t *testing.T) {
+	bc, _ := syncedChains(t, string(NewWallet().GetAddress()))
+	book := NewAddrBook(bc.db)
+
+	var addrs []string
+	for i := 0; i < maxAddrBookSize+10; i++ {
+		addrs = append(addrs, "localhost:"+strconv.Itoa(i))
+	}
+	assert.Equal(t, maxAddrBookSize, book.Add(addrs))
+	assert.Equal(t, 0, book.Add([]string{"localhost:new"}))
+}
+
+func TestMisbehavingBansPeer(t *testing.T) {
+	bc, _ := syncedChains(t, string(NewWallet().GetAddress()))
+	node := newNode(bc, "localhost:2", nil)
+	hostPeer := func(host string) *Peer {
+		p := testPeer(node, 0)
+		p.host = host
+		return p
+	}
+	p, sameHost, other := hostPeer("10.0.0.1"), hostPeer("10.0.0.1"), hostPeer("10.0.0.2")
+
+	// Очки копятся по хосту, бан наступает ровно на пороге
+	node.peers.Misbehaving(p, banThreshold/2, "test")
+	node.peers.Misbehaving(sameHost, banThreshold/2-1, "test")
+	assert.False(t, node.peers.IsBanned("10.0.0.1"))
+	assert.False(t, p.Closed())
+
+	node.peers.Misbehaving(p, 1, "test")
+	assert.True(t, node.peers.IsBanned("10.0.0.1"))
+	assert.True(t, p.Closed())
+	assert.True(t, sameHost.Closed())
+	assert.False(t, other.Closed(), "Only peers from the banned host are disconnected")
+	assert.False(t, node.peers.IsBanned("10.0.0.2"))
+	assert.NotContains(t, node.peers.banScores, "10.0.0.1")
+}
+
+// testHandshake проходит рукопожатие с нодом от имени пира, объявляющего addrFrom
+func testHandshake(t *testing.T, conn net.Conn, addrFrom string) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	assert.NoError(t, writeMessage(conn, newVersionMessage(addrFrom, 0, randomNonce())))
+	gotVersion, gotVerack := false, false
+	for !gotVersion || !gotVerack {
+		msg, err := readMessage(conn)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		switch msg.Command {
+		case "version":
+			gotVersion = true
+			assert.NoError(t, writeMessage(conn, newMessage("verack", &verack{})))
+		case "verack":
+			gotVerack = true
+		}
+	}
+}
+
+// testPeerHosts возвращает хосты подключенных пиров нода
+func testPeerHosts(n *Node) []string {
+	var hosts []string
+	for _, p := range n.peers.Peers() {
+		hosts = append(hosts, p.host)
+	}
+
+	return hosts
+}
+
+func TestSpoofedAddrFromIsNotTrusted(t *testing.T) {
+	transport, ids := testNetwork(t, 3, string(NewWallet().GetAddress()))
+	v := startTestNode(t, transport, ids[0])
+	h := startTestNode(t, transport, ids[1], v.address)
+	w := startTestNode(t, transport, ids[2])
+	waitFor(t, func() bool { return len(v.peers.Peers()) == 1 }, "h is connected to v")
+
+	// Пир с хоста evil выдает себя за h: соединение h остается, бан достается только evil
+	spoofer, err := transport.Host("evil").Dial(v.address, dialTimeout)
+	assert.NoError(t, err)
+	defer spoofer.Close()
+	testHandshake(t, spoofer, h.address)
+
+	// Объявленный адрес попадает в книгу только после рукопожатия по нему
+	announcer, err := transport.Host("evil").Dial(v.address, dialTimeout)
+	assert.NoError(t, err)
+	defer announcer.Close()
+	assert.NotContains(t, v.peers.book.GoodAddresses(10), w.address)
+	testHandshake(t, announcer, w.address)
+	waitFor(t, func() bool { return len(v.peers.Peers()) == 4 }, "v verifies the address of w by connecting to it")
+	assert.Contains(t, v.peers.book.GoodAddresses(10), w.address)
+	assert.ElementsMatch(t, []string{h.address, w.address, "evil", "evil"}, testPeerHosts(v))
+
+	// Мусор вместо сообщения банит хост соединения
+	_, err = spoofer.Write(make([]byte, messageHeaderSize))
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return v.peers.IsBanned("evil") }, "evil is banned")
+	waitFor(t, func() bool { return len(v.peers.Peers()) == 2 }, "both connections from evil are closed")
+	assert.ElementsMatch(t, []string{h.address, w.address}, testPeerHosts(v))
+	assert.False(t, v.peers.IsBanned(h.address))
+	assert.False(t, h.peers.IsBanned(v.address))
+
+	// С забаненного хоста соединение закрывается до рукопожатия
+	again, err := transport.Host("evil").Dial(v.address, dialTimeout)
+	assert.NoError(t, err)
+	defer again.Close()
+	again.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = readMessage(again)
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -2,16 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	maxNonce = math.MaxInt64
 )
 
+// Число горутин майнинга, задается параметром -threads
+var miningThreads = runtime.NumCPU()
+
+// Через сколько попыток горутина майнинга проверяет отмену и находку соседей
+const miningCheckInterval = 1 << 12
+
+// ErrNonceExhausted is returned when no nonce satisfies the target
+var ErrNonceExhausted = errors.New("nonce space is exhausted")
+
+// Сложность генезиса в количестве нулевых старших бит хэша
 const targetBits = 16
 
 // ProofOfWork представляет собой систему расчета доказательства работы
@@ -20,29 +37,46 @@ type ProofOfWork struct {
 	target *big.Int
 }
 
-// NewProofOfWork создает и возвращает новое доказательство работ
+// NewProofOfWork создает и возвращает новое доказательство работ, цель берется из поля Bits блока
 func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	// Выполняем смещение 1цы на 256-16=240 бит
-	target.Lsh(target, uint(256-targetBits))
+	target := blockTarget(b)
 
 	pow := &ProofOfWork{b, target}
 
 	return pow
 }
 
+// prepareData returns the hashed header. Blocks of version 1 hash the canonical header
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	if pow.block.Version != blockVersionLegacy {
+		w := &wireWriter{}
+		pow.block.encodeHeader(w, nonce)
+
+		return w.buf.Bytes()
+	}
+
 	// Подготовка данных для расчета хэша, здесь мы сложим все данные блока
 	// такие как:
 	// хэш предыдущего блока
 	// текущий хэш транзакций
 	// время, количество бит и nonce
+	// Блоки, сохраненные до появления поля MerkleRoot, считают корень по транзакциям
+	merkleRoot := pow.block.MerkleRoot
+	if len(merkleRoot) == 0 {
+		merkleRoot = pow.block.HashTransactions()
+	}
+	// Аналогично для блоков без поля Bits
+	bits := int64(pow.block.Bits)
+	if bits == 0 {
+		bits = targetBits
+	}
+
 	data := bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
+			merkleRoot,
 			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
+			IntToHex(bits),
 			IntToHex(int64(nonce)),
 		},
 		[]byte{},
@@ -51,33 +85,82 @@ func (pow *ProofOfWork) prepareData(nonce int) []byte {
 	return data
 }
 
-// Run выполняет непосредственно расчет
+// Run выполняет расчет на miningThreads горутинах и не может быть прерван
 func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
-
-	fmt.Printf("Mining a new block")
-	// Проверяем, что номер попытки меньше максимального
-	for nonce < maxNonce {
-		// Создаем данные для расчета
-		data := pow.prepareData(nonce)
-
-		// Считаем от них хэш
-		hash = sha256.Sum256(data)
-		//fmt.Printf("\r%x", hash) // Вывод пока что отключим
-		hashInt.SetBytes(hash[:])
-
-		// Сравниваем, если хэш меньше целевого числа - ура, нашли - иначе пробуем с числом на 1цу больше
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
+	nonce, hash, _, err := pow.RunContext(context.Background(), miningThreads)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return nonce, hash
+}
+
+// RunContext ищет nonce на threads горутинах, каждая перебирает свой непрерывный диапазон.
+// Перебор останавливается при отмене ctx, тогда возвращается ошибка контекста и статистика уже сделанной работы.
+// Сам RunContext ничего не выводит, статистику в лог пишут вызывающие
+func (pow *ProofOfWork) RunContext(ctx context.Context, threads int) (int, []byte, MiningStats, error) {
+	if threads < 1 {
+		threads = 1
+	}
+
+	var hashes uint64
+	var found int32
+	var once sync.Once
+	var wg sync.WaitGroup
+	resultNonce, resultHash := 0, []byte(nil)
+
+	start := time.Now()
+
+	chunk := maxNonce / threads
+	for i := 0; i < threads; i++ {
+		from, to := i*chunk, (i+1)*chunk
+		if i == threads-1 {
+			to = maxNonce
 		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var hashInt big.Int
+			counted := 0
+			for nonce := from; nonce < to; nonce++ {
+				// Флаг и контекст проверяем пачками, чтобы не тормозить перебор
+				if (nonce-from)%miningCheckInterval == 0 {
+					atomic.AddUint64(&hashes, uint64(nonce-from-counted))
+					counted = nonce - from
+					if atomic.LoadInt32(&found) != 0 || ctx.Err() != nil {
+						return
+					}
+				}
+
+				hash := sha256.Sum256(pow.prepareData(nonce))
+				hashInt.SetBytes(hash[:])
+
+				if hashInt.Cmp(pow.target) == -1 {
+					atomic.AddUint64(&hashes, uint64(nonce-from-counted+1))
+					once.Do(func() {
+						atomic.StoreInt32(&found, 1)
+						resultNonce, resultHash = nonce, hash[:]
+					})
+					return
+				}
+			}
+			atomic.AddUint64(&hashes, uint64(to-from-counted))
+		}()
 	}
-	fmt.Print("\n\n")
+	wg.Wait()
+
+	stats := MiningStats{Hashes: atomic.LoadUint64(&hashes), Duration: time.Since(start)}
 
-	return nonce, hash[:]
+	if resultHash != nil {
+		return resultNonce, resultHash, stats, nil
+	}
+	if ctx.Err() != nil {
+		return 0, nil, stats, ctx.Err()
+	}
+
+	return 0, nil, stats, ErrNonceExhausted
 }
 
 // Validate проверяет доказательство работы
@@ -89,8 +172,49 @@ func (pow *ProofOfWork) Validate() bool {
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
-	// Сравниваем, значение должно быть меньше целевого
-	isValid := hashInt.Cmp(pow.target) == -1
+	// Сравниваем, значение должно быть меньше целевого, а сам хэш - совпадать с записанным в блоке
+	isValid := hashInt.Cmp(pow.target) == -1 && bytes.Compare(hash[:], pow.block.Hash) == 0
 
 	return isValid
 }
+
+// MiningStats описывает работу, проделанную при поиске nonce
+type MiningStats struct {
+	Hashes   uint64
+	Duration time.Duration
+}
+
+// Add складывает статистику нескольких попыток майнинга
+func (s MiningStats) Add(other MiningStats) MiningStats {
+	return MiningStats{s.Hashes + other.Hashes, s.Duration + other.Duration}
+}
+
+// HashRate returns hashes per second
+func (s MiningStats) HashRate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+
+	return float64(s.Hashes) / s.Duration.Seconds()
+}
+
+func (s MiningStats) String() string {
+	rate := s.HashRate()
+	units := []string{"H/s", "kH/s", "MH/s", "GH/s"}
+
+	i := 0
+	for rate >= 1000 && i < len(units)-1 {
+		rate /= 1000
+		i++
+	}
+
+	return fmt.Sprintf("%.2f %s", rate, units[i])
+}
+
+// Work возвращает ожидаемое количество хэшей, необходимое для поиска блока с текущей целью: 2^256 / (target + 1)
+func (pow *ProofOfWork) Work() *big.Int {
+	denominator := new(big.Int).Add(pow.target, big.NewInt(1))
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	return numerator.Div(numerator, denominator)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// Высота, ниже которой у блоков основной цепочки остались только заголовки
+const pruneHeightKey = "pruneheight"
+
+// Меньше блоков хранить нельзя, иначе не переживем обычную реорганизацию
+const minPruneKeep = 10
+
+// Сколько последних блоков хранится целиком, задается параметром -prune. 0 - блоки не удаляются
+var pruneKeep = 0
+
+// ErrPruned is returned when transactions of a pruned block are needed
+var ErrPruned = errors.New("block data is pruned")
+
+// isPruned checks whether only the header of the stored block is left, valid blocks always have a coinbase
+func (b *Block) isPruned() bool {
+	return len(b.Transactions) == 0
+}
+
+// readPruneHeight возвращает высоту, ниже которой блоки обрезаны, 0 - обрезки не было
+func readPruneHeight(tx *bolt.Tx) int {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0
+	}
+
+	data := b.Get([]byte(pruneHeightKey))
+	if len(data) != 4 {
+		return 0
+	}
+
+	return int(binary.LittleEndian.Uint32(data))
+}
+
+func writePruneHeight(tx *bolt.Tx, height int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	var data [4]byte
+	binary.LittleEndian.PutUint32(data[:], uint32(height))
+
+	return b.Put([]byte(pruneHeightKey), data[:])
+}
+
+// pruneBlocks оставляет только заголовки у блоков основной цепочки старше keep последних и удаляет их undo-данные.
+// Такие блоки уже нельзя отключить при реорганизации или отдать другим нодам. Возвращает число обрезанных блоков
+func pruneBlocks(tx *bolt.Tx, keep int) (int, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+	undo := tx.Bucket([]byte(undoBucket))
+
+	tip := DeserializeBlock(b.Get(b.Get([]byte("l"))))
+	target := tip.Height - keep + 1
+	pruned := readPruneHeight(tx)
+	if target <= pruned {
+		return 0, nil
+	}
+
+	count := 0
+	for block := tip; block.Height >= pruned; {
+		if block.Height < target && !block.isPruned() {
+			header := *block
+			header.Transactions = nil
+
+			err := b.Put(block.Hash, header.Serialize())
+			if err != nil {
+				return 0, err
+			}
+			if undo != nil {
+				err = undo.Delete(block.Hash)
+				if err != nil {
+					return 0, err
+				}
+			}
+			count++
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+		block = DeserializeBlock(b.Get(block.PrevBlockHash))
+	}
+
+	return count, writePruneHeight(tx, target)
+}
+
+// Prune keeps transactions of the last keep main chain blocks only, the UTXO set stays complete
+func (bc *Blockchain) Prune(keep int) (int, error) {
+	if keep < minPruneKeep {
+		return 0, fmt.Errorf("at least %d blocks have to be kept", minPruneKeep)
+	}
+
+	count := 0
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		count, err = pruneBlocks(tx, keep)
+		return err
+	})
+
+	return count, err
+}
+
+// PruneHeight returns the height below which main chain blocks have only headers
+func (bc *Blockchain) PruneHeight() int {
+	height := 0
+
+	bc.db.View(func(tx *bolt.Tx) error {
+		height = readPruneHeight(tx)
+		return nil
+	})
+
+	return height
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneKeepsChainstate(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address := string(owner.GetAddress())
+	bc, _ := syncedChains(t, address)
+	genesisHash := testTip(t, bc)
+	for i := 0; i < 14; i++ {
+		bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", i+1, 0)})
+	}
+
+	_, err := bc.Prune(minPruneKeep - 1)
+	assert.Error(t, err)
+
+	pruned, err := bc.Prune(minPruneKeep)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, pruned)
+	assert.Equal(t, 5, bc.PruneHeight())
+
+	genesis, err := bc.GetBlock(genesisHash)
+	assert.NoError(t, err)
+	assert.True(t, genesis.isPruned())
+	assert.Len(t, testLocator(t, bc), 12, "Headers of pruned blocks are kept")
+
+	// Выход из обрезанного генезиса тратится по chainstate
+	pruneKeep = minPruneKeep
+	defer func() { pruneKeep = 0 }()
+	spend := testPayment(t, owner, string(NewWallet().GetAddress()), 150, 0, bc)
+	block := testMine(t, bc, NewCoinbaseTX(address, "", testHeight(t, bc)+1, 0), spend)
+	assert.Equal(t, 15, block.Height)
+	assert.Equal(t, 6, bc.PruneHeight(), "Blocks are pruned as the tip moves")
+}
+
+func TestSnapshotExportImport(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc, _ := syncedChains(t, address)
+	for i := 0; i < 3; i++ {
+		bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", i+1, 0)})
+	}
+
+	height, count, err := bc.ExportSnapshot("chain.snap")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, height)
+	assert.Equal(t, 4, count)
+
+	imported, err := ImportSnapshot("c", "chain.snap")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, imported)
+	_, err = ImportSnapshot("c", "chain.snap")
+	assert.ErrorIs(t, err, ErrDBExists)
+
+	c, err := NewBlockchain("c")
+	assert.NoError(t, err)
+	defer c.db.Close()
+	assert.Equal(t, testTip(t, bc), testTip(t, c))
+	assert.Equal(t, count, testCountUTXO(t, c))
+	assert.Equal(t, 4, c.PruneHeight())
+
+	// С восстановленного состояния цепочка продолжается как обычно
+	c.MineBlock([]*Transaction{NewCoinbaseTX(address, "", testHeight(t, c)+1, 0)})
+	assert.Equal(t, 4, testHeight(t, c))
+
+	data, err := ioutil.ReadFile("chain.snap")
+	assert.NoError(t, err)
+	data[10] ^= 1
+	assert.NoError(t, ioutil.WriteFile("broken.snap", data, 0644))
+	_, err = ImportSnapshot("d", "broken.snap")
+	assert.ErrorIs(t, err, ErrBadSnapshot)
+}
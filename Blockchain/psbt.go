@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// PSBT errors
+var (
+	ErrNotEnoughSignatures = errors.New("not enough signatures")
+	ErrUnsupportedScript   = errors.New("script is not supported by the wallet")
+)
+
+// PartiallySignedTx is a transaction passed between owners of the keys it needs:
+// everyone adds signatures of own keys, and the unlocking scripts are built when enough are collected
+type PartiallySignedTx struct {
+	Tx     Transaction
+	Inputs []PSBTInput // Parallel to Tx.Vin
+}
+
+// PSBTInput keeps what is needed to sign and unlock an input
+type PSBTInput struct {
+	PrevOut      TXOutput
+	RedeemScript []byte            // Set for P2SH outputs
+	Signatures   map[string][]byte // Hex encoded public key -> signature
+}
+
+// NewPartiallySignedTx prepares the unsigned transaction for signing.
+// Redeem scripts of P2SH outputs are taken from the wallet
+func NewPartiallySignedTx(tx *Transaction, UTXOSet *UTXOSet, wallets *Wallets) (*PartiallySignedTx, error) {
+	psbt := &PartiallySignedTx{Tx: *tx}
+
+	for _, vin := range tx.Vin {
+		out, found, err := UTXOSet.FindOutput(vin.Txid, vin.Vout)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %x:%d", ErrMissingInput, vin.Txid, vin.Vout)
+		}
+
+		input := PSBTInput{PrevOut: out, Signatures: make(map[string][]byte)}
+		if _, ok := extractScriptHash(out.LockingScript()); ok {
+			script, err := wallets.GetScript(out.Address())
+			if err != nil {
+				return nil, fmt.Errorf("redeem script of %s: %w", out.Address(), err)
+			}
+			input.RedeemScript = script
+		}
+		psbt.Inputs = append(psbt.Inputs, input)
+	}
+
+	return psbt, nil
+}
+
+// subscript returns the script signatures of the input commit to
+func (p *PartiallySignedTx) subscript(inputIndex int) []byte {
+	input := p.Inputs[inputIndex]
+	if len(input.RedeemScript) > 0 {
+		return input.RedeemScript
+	}
+
+	return input.PrevOut.LockingScript()
+}
+
+// Sign adds signatures of the wallet keys the inputs need and returns how many were added.
+// hashType chooses the parts of the transaction the signatures cover
+func (p *PartiallySignedTx) Sign(wallets *Wallets, hashType byte) (int, error) {
+	signed := 0
+
+	for i := range p.Inputs {
+		input := &p.Inputs[i]
+		subscript := p.subscript(i)
+
+		for _, pubKeyHash := range scriptKeyHashes(subscript) {
+			wallet, err := wallets.GetWalletByPubKeyHash(pubKeyHash)
+			if err == ErrUnknownAddress {
+				continue
+			}
+			if err != nil {
+				return signed, err
+			}
+
+			key := hex.EncodeToString(wallet.PublicKey)
+			if _, ok := input.Signatures[key]; ok {
+				continue
+			}
+			signature, err := p.Tx.SignInput(i, subscript, wallet.PrivateKey, hashType)
+			if err != nil {
+				return signed, fmt.Errorf("input %d: %w", i, err)
+			}
+			input.Signatures[key] = signature
+			signed++
+		}
+	}
+
+	return signed, nil
+}
+
+// Finalize builds unlocking scripts from the collected signatures and verifies the result
+func (p *PartiallySignedTx) Finalize() (*Transaction, error) {
+	tx := p.Tx
+	tx.Vin = append([]TXInput{}, p.Tx.Vin...)
+	prevOuts := make([]TXOutput, len(p.Inputs))
+
+	for i, input := range p.Inputs {
+		subscript := p.subscript(i)
+		b := &ScriptBuilder{}
+
+		if m, keys, ok := extractMultisig(subscript); ok {
+			// Подписи должны идти в порядке ключей скрипта
+			count := 0
+			for _, key := range keys {
+				signature, ok := input.Signatures[hex.EncodeToString(key)]
+				if ok && count < m {
+					b.AddData(signature)
+					count++
+				}
+			}
+			if count < m {
+				return nil, fmt.Errorf("%w: input %d has %d of %d", ErrNotEnoughSignatures, i, count, m)
+			}
+		} else if hashes := scriptKeyHashes(subscript); len(hashes) == 1 {
+			pubKey, signature := input.signatureOf(hashes[0])
+			if signature == nil {
+				return nil, fmt.Errorf("%w: input %d is not signed", ErrNotEnoughSignatures, i)
+			}
+			b.AddData(signature).AddData(pubKey)
+		} else {
+			return nil, fmt.Errorf("%w: input %d", ErrUnsupportedScript, i)
+		}
+
+		if len(input.RedeemScript) > 0 {
+			b.AddData(input.RedeemScript)
+		}
+		tx.Vin[i].ScriptSig = b.Script()
+		prevOuts[i] = input.PrevOut
+	}
+	// ID транзакций версии 0 зависит от скриптов входов
+	tx.ID = tx.Hash()
+
+	err := tx.VerifyScripts(prevOuts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// signatureOf returns the signature made by the key with the hash
+func (in PSBTInput) signatureOf(pubKeyHash []byte) ([]byte, []byte) {
+	for key, signature := range in.Signatures {
+		pubKey, err := hex.DecodeString(key)
+		if err == nil && bytes.Equal(HashPubKey(pubKey), pubKeyHash) {
+			return pubKey, signature
+		}
+	}
+
+	return nil, nil
+}
+
+// scriptKeyHashes returns hashes of the keys that can sign for a standard script
+func scriptKeyHashes(script []byte) [][]byte {
+	if pubKeyHash, ok := extractPubKeyHash(script); ok {
+		return [][]byte{pubKeyHash}
+	}
+	if _, pubKeyHash, ok := extractTimeLock(script); ok {
+		return [][]byte{pubKeyHash}
+	}
+
+	var hashes [][]byte
+	if _, keys, ok := extractMultisig(script); ok {
+		for _, key := range keys {
+			hashes = append(hashes, HashPubKey(key))
+		}
+	}
+
+	return hashes
+}
+
+// Serialize serializes the partially signed transaction
+func (p *PartiallySignedTx) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	err := gob.NewEncoder(&encoded).Encode(p)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// DeserializePartiallySignedTx deserializes a partially signed transaction
+func DeserializePartiallySignedTx(data []byte) (*PartiallySignedTx, error) {
+	var psbt PartiallySignedTx
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&psbt)
+	if err != nil {
+		return nil, err
+	}
+	if len(psbt.Inputs) != len(psbt.Tx.Vin) {
+		return nil, errors.New("partially signed transaction has inputs without data")
+	}
+	for i := range psbt.Inputs {
+		if psbt.Inputs[i].Signatures == nil {
+			psbt.Inputs[i].Signatures = make(map[string][]byte)
+		}
+	}
+
+	return &psbt, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrBadRawTransaction is returned when the hex is neither a signed nor a partially signed transaction
+var ErrBadRawTransaction = errors.New("raw transaction is broken")
+
+// Неподписанная транзакция передается в hex вместе с выходами, которые она тратит,
+// чтобы холодная машина с одним только кошельком могла ее проверить и подписать.
+// Собранная подписанная транзакция передается в hex в обычной сериализации
+
+// EncodeRawTransaction returns the hex of the partially signed transaction
+func EncodeRawTransaction(psbt *PartiallySignedTx) string {
+	return hex.EncodeToString(psbt.Serialize())
+}
+
+// DecodeRawTransaction decodes the hex of a signed transaction or of a partially signed one,
+// only one of them is returned
+func DecodeRawTransaction(rawTx string) (*Transaction, *PartiallySignedTx, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(rawTx))
+	if err != nil {
+		return nil, nil, ErrBadRawTransaction
+	}
+
+	// Каноническая сериализация обязана занять все данные, поэтому с gob ее не спутать
+	tx, err := DecodeTransaction(data)
+	if err == nil {
+		return &tx, nil, nil
+	}
+
+	psbt, err := DeserializePartiallySignedTx(data)
+	if err != nil {
+		return nil, nil, ErrBadRawTransaction
+	}
+
+	return nil, psbt, nil
+}
+
+// Fee returns what the inputs bring over the outputs, the signer should check it before signing
+func (p *PartiallySignedTx) Fee() int {
+	fee := 0
+	for _, input := range p.Inputs {
+		fee += input.PrevOut.Value
+	}
+	for _, out := range p.Tx.Vout {
+		fee -= out.Value
+	}
+
+	return fee
+}
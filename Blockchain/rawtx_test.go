@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawTransactionOfflineSigning(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	// Ключи есть только у холодного кошелька, на машине с блокчейном известен лишь адрес
+	cold, _ := NewWallets("cold")
+	owner, err := cold.CreateWallet()
+	assert.NoError(t, err)
+	bc, err := CreateBlockchain(owner, "hot", false)
+	assert.NoError(t, err)
+	defer bc.db.Close()
+
+	other := string(NewWallet().GetAddress())
+	unsigned, err := NewUnsignedTransaction(owner, other, 4, 1, 0, &UTXOSet{bc})
+	assert.NoError(t, err)
+	psbt, err := NewPartiallySignedTx(unsigned, &UTXOSet{bc}, nil)
+	assert.NoError(t, err)
+
+	tx, decoded, err := DecodeRawTransaction(EncodeRawTransaction(psbt))
+	assert.NoError(t, err)
+	assert.Nil(t, tx)
+	assert.Equal(t, 1, decoded.Fee())
+
+	signed, err := decoded.Sign(cold, sigHashAll)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, signed)
+	final, err := decoded.Finalize()
+	assert.NoError(t, err)
+
+	tx, decoded, err = DecodeRawTransaction(hex.EncodeToString(final.Serialize()))
+	assert.NoError(t, err)
+	assert.Nil(t, decoded)
+	assert.Equal(t, final.ID, tx.ID)
+
+	testMine(t, bc, NewCoinbaseTX(owner, "", testHeight(t, bc)+1, 1), tx)
+	outputs, err := (UTXOSet{bc}).FindUTXO(PubKeyHashFromAddress(other))
+	assert.NoError(t, err)
+	assert.Len(t, outputs, 1)
+
+	_, _, err = DecodeRawTransaction("not hex")
+	assert.ErrorIs(t, err, ErrBadRawTransaction)
+	_, _, err = DecodeRawTransaction("00")
+	assert.ErrorIs(t, err, ErrBadRawTransaction)
+}
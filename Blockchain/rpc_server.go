@@ -0,0 +1,525 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// JSON-RPC error codes, the application ones follow bitcoind
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcNotFound       = -5
+	rpcVerifyRejected = -26
+)
+
+// Максимальный размер тела запроса: сырая транзакция в hex занимает вдвое больше maxTxSize
+const maxRPCRequestSize = 4 * maxTxSize
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result"`
+	Error   *rpcError       `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcHandler handles a single method, params are already split from the request
+type rpcHandler func(s *RPCServer, params []json.RawMessage) (interface{}, error)
+
+var rpcHandlers = map[string]rpcHandler{
+	"getblockcount":      rpcGetBlockCount,
+	"getblock":           rpcGetBlock,
+	"getblockhash":       rpcGetBlockHash,
+	"gettransaction":     rpcGetTransaction,
+	"getaddresstxids":    rpcGetAddressTxIDs,
+	"getbalance":         rpcGetBalance,
+	"listunspent":        rpcListUnspent,
+	"sendrawtransaction": rpcSendRawTransaction,
+	"getmempoolinfo":     rpcGetMempoolInfo,
+	"getmininginfo":      rpcGetMiningInfo,
+}
+
+// RPCServer serves JSON-RPC requests over HTTP using the node's blockchain and mempool
+type RPCServer struct {
+	node *Node
+}
+
+// BlockView is the JSON representation of a block
+type BlockView struct {
+	Hash         string   `json:"hash"`
+	Version      uint32   `json:"version"`
+	Height       int      `json:"height"`
+	PreviousHash string   `json:"previousblockhash"`
+	MerkleRoot   string   `json:"merkleroot"`
+	Time         int64    `json:"time"`
+	Bits         string   `json:"bits"`
+	Nonce        int      `json:"nonce"`
+	Transactions []string `json:"tx"`
+}
+
+// TxInputView is the JSON representation of a transaction input
+type TxInputView struct {
+	Txid      string `json:"txid,omitempty"`
+	Vout      int    `json:"vout"`
+	Signature string `json:"signature,omitempty"`
+	PubKey    string `json:"pubkey,omitempty"`
+	ScriptSig string `json:"scriptsig,omitempty"`
+	Coinbase  string `json:"coinbase,omitempty"`
+}
+
+// TxOutputView is the JSON representation of a transaction output
+type TxOutputView struct {
+	N          int    `json:"n"`
+	Value      int    `json:"value"`
+	PubKeyHash string `json:"pubkeyhash"`
+	Address    string `json:"address"`
+	Script     string `json:"script"`
+}
+
+// TransactionView is the JSON representation of a transaction
+type TransactionView struct {
+	Txid      string         `json:"txid"`
+	Version   uint32         `json:"version"`
+	BlockHash string         `json:"blockhash,omitempty"`
+	Coinbase  bool           `json:"coinbase"`
+	LockTime  int64          `json:"locktime"`
+	Vin       []TxInputView  `json:"vin"`
+	Vout      []TxOutputView `json:"vout"`
+	Hex       string         `json:"hex"`
+}
+
+// UnspentView is the JSON representation of an unspent output
+type UnspentView struct {
+	Txid  string `json:"txid"`
+	Vout  int    `json:"vout"`
+	Value int    `json:"value"`
+}
+
+// NewBlockView converts a block to its JSON representation
+func NewBlockView(block *Block) BlockView {
+	view := BlockView{
+		Hash:         hex.EncodeToString(block.Hash),
+		Version:      block.Version,
+		Height:       block.Height,
+		PreviousHash: hex.EncodeToString(block.PrevBlockHash),
+		MerkleRoot:   hex.EncodeToString(block.MerkleRoot),
+		Time:         block.Timestamp,
+		Bits:         fmt.Sprintf("%08x", block.Bits),
+		Nonce:        block.Nonce,
+		Transactions: []string{},
+	}
+
+	for _, tx := range block.Transactions {
+		view.Transactions = append(view.Transactions, hex.EncodeToString(tx.ID))
+	}
+
+	return view
+}
+
+// NewTransactionView converts a transaction to its JSON representation
+func NewTransactionView(tx *Transaction) TransactionView {
+	view := TransactionView{
+		Txid:     hex.EncodeToString(tx.ID),
+		Version:  tx.Version,
+		Coinbase: tx.IsCoinbase(),
+		LockTime: tx.LockTime,
+		Vin:      []TxInputView{},
+		Vout:     []TxOutputView{},
+		Hex:      hex.EncodeToString(tx.Serialize()),
+	}
+
+	for _, vin := range tx.Vin {
+		if tx.IsCoinbase() {
+			view.Vin = append(view.Vin, TxInputView{Vout: vin.Vout, Coinbase: hex.EncodeToString(vin.PubKey)})
+			continue
+		}
+
+		view.Vin = append(view.Vin, TxInputView{
+			Txid:      hex.EncodeToString(vin.Txid),
+			Vout:      vin.Vout,
+			Signature: hex.EncodeToString(vin.Signature),
+			PubKey:    hex.EncodeToString(vin.PubKey),
+			ScriptSig: DisassembleScript(vin.ScriptSig),
+		})
+	}
+
+	for i, out := range tx.Vout {
+		view.Vout = append(view.Vout, TxOutputView{
+			N:          i,
+			Value:      out.Value,
+			PubKeyHash: hex.EncodeToString(out.PubKeyHash),
+			Address:    out.Address(),
+			Script:     DisassembleScript(out.LockingScript()),
+		})
+	}
+
+	return view
+}
+
+// StartRPCServer serves JSON-RPC on the address in the background, the returned server is stopped with Shutdown.
+// When user is set, requests must carry it and the password with HTTP basic authentication
+func StartRPCServer(address, user, password string, node *Node) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/", rpcAuth(user, password, &RPCServer{node}))
+
+	server, err := serveHTTP(address, mux)
+	if err != nil {
+		return nil, err
+	}
+
+	node.log.Info("JSON-RPC is listening", "address", address)
+
+	return server, nil
+}
+
+// rpcAuth пропускает к handler только запросы с заданными логином и паролем, пустой логин отключает проверку
+func rpcAuth(user, password string, handler http.Handler) http.Handler {
+	if user == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestUser, requestPassword, ok := r.BasicAuth()
+		// Сравнение за постоянное время, чтобы пароль нельзя было подобрать по времени ответа
+		if !ok || subtle.ConstantTimeCompare([]byte(requestUser), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(requestPassword), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// serveHTTP начинает слушать адрес сразу, чтобы занятый порт был ошибкой запуска, а запросы обслуживает в фоне
+func serveHTTP(address string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen(protocol, address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			(*Logger)(nil).Error("HTTP server is stopped", "address", address, "err", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// ServeHTTP handles a JSON-RPC request sent with POST
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request rpcRequest
+	response := rpcResponse{JSONRPC: "2.0"}
+
+	// Тело читается не дальше лимита, иначе один запрос может занять сколько угодно памяти
+	r.Body = http.MaxBytesReader(w, r.Body, maxRPCRequestSize)
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		response.Error = &rpcError{rpcParseError, err.Error()}
+	} else {
+		response.ID = request.ID
+		response.Result, response.Error = s.call(request)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		s.node.log.Warn("Failed to write RPC response", "err", err)
+	}
+}
+
+// call dispatches the request to its handler and converts errors to JSON-RPC ones
+func (s *RPCServer) call(request rpcRequest) (interface{}, *rpcError) {
+	if request.Method == "" {
+		return nil, &rpcError{rpcInvalidRequest, "method is not set"}
+	}
+
+	handler, ok := rpcHandlers[request.Method]
+	if !ok {
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method %s is not found", request.Method)}
+	}
+
+	result, err := handler(s, request.Params)
+	if err != nil {
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			return nil, rpcErr
+		}
+
+		return nil, &rpcError{rpcInternalError, err.Error()}
+	}
+
+	return result, nil
+}
+
+// Params parsing helpers
+
+func rpcParamsCount(params []json.RawMessage, count int) error {
+	if len(params) != count {
+		return &rpcError{rpcInvalidParams, fmt.Sprintf("expected %d params, got %d", count, len(params))}
+	}
+
+	return nil
+}
+
+func rpcStringParam(params []json.RawMessage, i int) (string, error) {
+	var value string
+	if err := json.Unmarshal(params[i], &value); err != nil {
+		return "", &rpcError{rpcInvalidParams, fmt.Sprintf("param %d must be a string", i)}
+	}
+
+	return value, nil
+}
+
+func rpcIntParam(params []json.RawMessage, i int) (int, error) {
+	var value int
+	if err := json.Unmarshal(params[i], &value); err != nil {
+		return 0, &rpcError{rpcInvalidParams, fmt.Sprintf("param %d must be an integer", i)}
+	}
+
+	return value, nil
+}
+
+func rpcHexParam(params []json.RawMessage, i int) ([]byte, error) {
+	value, err := rpcStringParam(params, i)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("param %d must be hex encoded", i)}
+	}
+
+	return data, nil
+}
+
+func rpcAddressParam(params []json.RawMessage, i int) ([]byte, error) {
+	address, err := rpcStringParam(params, i)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ValidateAddress(address) {
+		return nil, &rpcError{rpcNotFound, fmt.Sprintf("address %s is not valid", address)}
+	}
+
+	return PubKeyHashFromAddress(address), nil
+}
+
+// Method handlers
+
+func rpcGetBlockCount(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	return s.node.bc.GetBestHeight()
+}
+
+func rpcGetBlock(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := rpcParamsCount(params, 1); err != nil {
+		return nil, err
+	}
+	hash, err := rpcHexParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.node.bc.GetBlock(hash)
+	if err != nil {
+		return nil, &rpcError{rpcNotFound, err.Error()}
+	}
+
+	return NewBlockView(&block), nil
+}
+
+func rpcGetBlockHash(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := rpcParamsCount(params, 1); err != nil {
+		return nil, err
+	}
+	height, err := rpcIntParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := s.node.bc.GetBlockHashByHeight(height)
+	if err != nil {
+		return nil, &rpcError{rpcNotFound, err.Error()}
+	}
+
+	return hex.EncodeToString(hash), nil
+}
+
+func rpcGetTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := rpcParamsCount(params, 1); err != nil {
+		return nil, err
+	}
+	txID, err := rpcHexParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx, ok := s.node.mempool.Get(txID); ok {
+		return NewTransactionView(&tx), nil
+	}
+
+	tx, err := s.node.bc.FindTransaction(txID)
+	if err != nil {
+		return nil, &rpcError{rpcNotFound, err.Error()}
+	}
+
+	view := NewTransactionView(&tx)
+	blockHash, found, err := s.node.bc.FindTransactionBlock(txID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		view.BlockHash = hex.EncodeToString(blockHash)
+	}
+
+	return view, nil
+}
+
+func rpcGetAddressTxIDs(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := rpcParamsCount(params, 1); err != nil {
+		return nil, err
+	}
+	pubKeyHash, err := rpcAddressParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	indexed, err := s.node.bc.HasTxIndex()
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		return nil, &rpcError{rpcInternalError, "address index is disabled, run reindextxindex"}
+	}
+
+	found, err := s.node.bc.FindAddressTransactions(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	txIDs := []string{}
+	for _, txID := range found {
+		txIDs = append(txIDs, hex.EncodeToString(txID))
+	}
+
+	return txIDs, nil
+}
+
+func rpcGetBalance(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := rpcParamsCount(params, 1); err != nil {
+		return nil, err
+	}
+	pubKeyHash, err := rpcAddressParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := (UTXOSet{s.node.bc}).FindUTXO(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := 0
+	for _, out := range outputs {
+		balance += out.Value
+	}
+
+	return balance, nil
+}
+
+func rpcListUnspent(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := rpcParamsCount(params, 1); err != nil {
+		return nil, err
+	}
+	pubKeyHash, err := rpcAddressParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := (UTXOSet{s.node.bc}).FindSpendableOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	unspent := []UnspentView{}
+	for _, out := range outputs {
+		unspent = append(unspent, UnspentView{hex.EncodeToString(out.Txid), out.Vout, out.Value})
+	}
+
+	return unspent, nil
+}
+
+func rpcSendRawTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := rpcParamsCount(params, 1); err != nil {
+		return nil, err
+	}
+	data, err := rpcHexParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := DecodeTransaction(data)
+	if err != nil {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("transaction can't be decoded: %s", err)}
+	}
+
+	err = s.node.SubmitTransaction(tx)
+	if err != nil {
+		return nil, &rpcError{rpcVerifyRejected, err.Error()}
+	}
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+func rpcGetMempoolInfo(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	info := s.node.mempool.Info()
+
+	return map[string]int{
+		"size":  info.Count,
+		"bytes": info.Size,
+		"fees":  info.Fees,
+	}, nil
+}
+
+func rpcGetMiningInfo(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if s.node.miner == nil {
+		return nil, &rpcError{rpcNotFound, "mining is off"}
+	}
+
+	return s.node.miner.Info(), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRPCResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// rpcPost отправляет тело запроса на сервер и возвращает HTTP статус и разобранный ответ
+func rpcPost(t *testing.T, server *httptest.Server, user, password, body string) (int, testRPCResponse) {
+	request, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+	assert.NoError(t, err)
+	if user != "" {
+		request.SetBasicAuth(user, password)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	var response testRPCResponse
+	if resp.StatusCode == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	}
+
+	return resp.StatusCode, response
+}
+
+func TestRPCServer(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address, other := string(owner.GetAddress()), string(NewWallet().GetAddress())
+	bc, _ := syncedChains(t, address)
+	node := newNode(bc, "rpc", NewMemoryTransport())
+
+	server := httptest.NewServer(rpcAuth("user", "secret", &RPCServer{node}))
+	defer server.Close()
+
+	// Без логина и пароля или с чужим паролем запрос не доходит до методов
+	status, _ := rpcPost(t, server, "", "", `{"method":"getblockcount","id":1}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = rpcPost(t, server, "user", "wrong", `{"method":"getblockcount","id":1}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, response := rpcPost(t, server, "user", "secret", `{"jsonrpc":"2.0","method":"getblockcount","id":7}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, response.Error)
+	assert.JSONEq(t, `0`, string(response.Result))
+	assert.JSONEq(t, `7`, string(response.ID))
+
+	// Запросы принимаются только методом POST
+	request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	request.SetBasicAuth("user", "secret")
+	resp, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"broken json", `{"method":`, rpcParseError},
+		{"no method", `{"id":1}`, rpcInvalidRequest},
+		{"unknown method", `{"method":"stop","id":1}`, rpcMethodNotFound},
+		{"bad params", `{"method":"getblockhash","params":["one"],"id":1}`, rpcInvalidParams},
+		{"unknown block", `{"method":"getblockhash","params":[5],"id":1}`, rpcNotFound},
+		{"undecodable transaction", `{"method":"sendrawtransaction","params":["00"],"id":1}`, rpcInvalidParams},
+		{"body over the limit", `{"method":"sendrawtransaction","params":["` + strings.Repeat("00", maxRPCRequestSize) + `"],"id":1}`, rpcParseError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, response := rpcPost(t, server, "user", "secret", test.body)
+			assert.Equal(t, http.StatusOK, status)
+			if assert.NotNil(t, response.Error) {
+				assert.Equal(t, test.code, response.Error.Code)
+			}
+		})
+	}
+
+	// Транзакция попадает в мемпул, повторная отправка отклоняется
+	payment := testPayment(t, owner, other, 5, 1, bc)
+	body := `{"method":"sendrawtransaction","params":["` + hex.EncodeToString(payment.Serialize()) + `"],"id":2}`
+	status, response = rpcPost(t, server, "user", "secret", body)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, response.Error)
+	assert.JSONEq(t, `"`+hex.EncodeToString(payment.ID)+`"`, string(response.Result))
+	assert.True(t, node.mempool.Has(payment.ID))
+
+	_, response = rpcPost(t, server, "user", "secret", body)
+	if assert.NotNil(t, response.Error) {
+		assert.Equal(t, rpcVerifyRejected, response.Error.Code)
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Opcodes of the script language, a subset of Bitcoin script with the same codes
+const (
+	op0                   = 0x00
+	opPushData1           = 0x4c
+	opPushData2           = 0x4d
+	op1                   = 0x51
+	op16                  = 0x60
+	opVerify              = 0x69
+	opReturn              = 0x6a
+	opDrop                = 0x75
+	opDup                 = 0x76
+	opEqual               = 0x87
+	opEqualVerify         = 0x88
+	opHash160             = 0xa9
+	opCheckSig            = 0xac
+	opCheckSigVerify      = 0xad
+	opCheckMultiSig       = 0xae
+	opCheckMultiSigVerify = 0xaf
+	opCheckLockTimeVerify = 0xb1
+)
+
+var opcodeNames = map[byte]string{
+	opVerify:              "OP_VERIFY",
+	opReturn:              "OP_RETURN",
+	opDrop:                "OP_DROP",
+	opDup:                 "OP_DUP",
+	opEqual:               "OP_EQUAL",
+	opEqualVerify:         "OP_EQUALVERIFY",
+	opHash160:             "OP_HASH160",
+	opCheckSig:            "OP_CHECKSIG",
+	opCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	opCheckMultiSig:       "OP_CHECKMULTISIG",
+	opCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	opCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+// Script limits
+const (
+	maxScriptSize        = 10000
+	maxScriptElementSize = 520
+	maxStackSize         = 1000
+	maxMultisigKeys      = 20
+	maxScriptNumLen      = 5
+	lockTimeThreshold    = 500000000 // Lock time below it is a block height, above it is unix time
+)
+
+// Script errors
+var (
+	ErrScriptMalformed = errors.New("malformed script")
+	ErrScriptFailed    = errors.New("script failed")
+	ErrScriptLockTime  = errors.New("output is time-locked")
+)
+
+// scriptOp is a parsed opcode with the data it pushes
+type scriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+// parseScript splits the script into opcodes
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: script is too large", ErrScriptMalformed)
+	}
+
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		size := 0
+		switch {
+		case opcode > op0 && opcode < opPushData1:
+			size = int(opcode)
+		case opcode == opPushData1:
+			if i+1 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push", ErrScriptMalformed)
+			}
+			size = int(script[i])
+			i++
+		case opcode == opPushData2:
+			if i+2 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push", ErrScriptMalformed)
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+
+		if i+size > len(script) {
+			return nil, fmt.Errorf("%w: truncated push", ErrScriptMalformed)
+		}
+
+		op := scriptOp{opcode: opcode}
+		if isPushOp(opcode) {
+			op.data = script[i : i+size]
+		}
+		ops = append(ops, op)
+		i += size
+	}
+
+	return ops, nil
+}
+
+func isPushOp(opcode byte) bool {
+	return opcode <= opPushData2
+}
+
+func isSmallIntOp(opcode byte) bool {
+	return opcode == op0 || (opcode >= op1 && opcode <= op16)
+}
+
+func smallIntValue(opcode byte) int {
+	if opcode == op0 {
+		return 0
+	}
+
+	return int(opcode-op1) + 1
+}
+
+// ScriptBuilder assembles scripts choosing the shortest push opcodes
+type ScriptBuilder struct {
+	script []byte
+}
+
+// AddOp appends an opcode
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// AddData appends a push of the data
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch {
+	case len(data) == 0:
+		b.script = append(b.script, op0)
+	case len(data) < opPushData1:
+		b.script = append(b.script, byte(len(data)))
+	case len(data) <= 0xff:
+		b.script = append(b.script, opPushData1, byte(len(data)))
+	default:
+		b.script = append(b.script, opPushData2, byte(len(data)), byte(len(data)>>8))
+	}
+	b.script = append(b.script, data...)
+
+	return b
+}
+
+// AddInt appends a push of the number, small ones use OP_0..OP_16
+func (b *ScriptBuilder) AddInt(n int64) *ScriptBuilder {
+	if n == 0 {
+		return b.AddOp(op0)
+	}
+	if n >= 1 && n <= 16 {
+		return b.AddOp(byte(op1 + n - 1))
+	}
+
+	return b.AddData(encodeScriptNum(n))
+}
+
+// Script returns the assembled script
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// encodeScriptNum encodes a number as little-endian sign-magnitude, as Bitcoin does
+func encodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+
+	var result []byte
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	// Старший бит занят знаком, если он уже используется - добавляем байт
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+func decodeScriptNum(data []byte) (int64, error) {
+	if len(data) > maxScriptNumLen {
+		return 0, fmt.Errorf("%w: number is too long", ErrScriptFailed)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var result int64
+	for i, b := range data {
+		result |= int64(b) << uint(8*i)
+	}
+
+	if data[len(data)-1]&0x80 != 0 {
+		result &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		return -result, nil
+	}
+
+	return result, nil
+}
+
+// castToBool treats empty data and zeros, including negative zero, as false
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+// DisassembleScript returns a human-readable form of the script
+func DisassembleScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[error: %s]", err)
+	}
+
+	var parts []string
+	for _, op := range ops {
+		switch {
+		case isSmallIntOp(op.opcode):
+			parts = append(parts, fmt.Sprintf("%d", smallIntValue(op.opcode)))
+		case isPushOp(op.opcode):
+			parts = append(parts, fmt.Sprintf("%x", op.data))
+		case opcodeNames[op.opcode] != "":
+			parts = append(parts, opcodeNames[op.opcode])
+		default:
+			parts = append(parts, fmt.Sprintf("OP_UNKNOWN%d", op.opcode))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// sigChecker checks signatures of one transaction input
+type sigChecker interface {
+	checkSig(signature, pubKey, subscript []byte) bool
+	lockTime() int64
+}
+
+// scriptEngine executes scripts on a shared stack
+type scriptEngine struct {
+	checker   sigChecker
+	subscript []byte // Script which signatures commit to, the locking or the redeem one
+	stack     [][]byte
+}
+
+func (e *scriptEngine) push(data []byte) error {
+	if len(e.stack) >= maxStackSize {
+		return fmt.Errorf("%w: stack overflow", ErrScriptFailed)
+	}
+	e.stack = append(e.stack, data)
+
+	return nil
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+	}
+	data := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+
+	return data, nil
+}
+
+func (e *scriptEngine) popInt() (int64, error) {
+	data, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeScriptNum(data)
+}
+
+func (e *scriptEngine) pushBool(v bool) error {
+	if v {
+		return e.push([]byte{1})
+	}
+
+	return e.push(nil)
+}
+
+// success checks the result of the executed scripts: the top of the stack has to be true
+func (e *scriptEngine) success() bool {
+	return len(e.stack) > 0 && castToBool(e.stack[len(e.stack)-1])
+}
+
+// execute runs the script on the current stack
+func (e *scriptEngine) execute(script []byte) error {
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if len(op.data) > maxScriptElementSize {
+			return fmt.Errorf("%w: pushed element is too large", ErrScriptFailed)
+		}
+
+		err = e.step(op)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *scriptEngine) step(op scriptOp) error {
+	switch {
+	case op.opcode == op0:
+		return e.push(nil)
+	case isSmallIntOp(op.opcode):
+		return e.push(encodeScriptNum(int64(smallIntValue(op.opcode))))
+	case isPushOp(op.opcode):
+		return e.push(op.data)
+	}
+
+	switch op.opcode {
+	case opVerify:
+		data, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !castToBool(data) {
+			return fmt.Errorf("%w: OP_VERIFY", ErrScriptFailed)
+		}
+		return nil
+
+	case opReturn:
+		return fmt.Errorf("%w: OP_RETURN", ErrScriptFailed)
+
+	case opDrop:
+		_, err := e.pop()
+		return err
+
+	case opDup:
+		if len(e.stack) == 0 {
+			return fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+		}
+		return e.push(e.stack[len(e.stack)-1])
+
+	case opEqual, opEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.result(op.opcode == opEqualVerify, bytes.Equal(a, b), "OP_EQUALVERIFY")
+
+	case opHash160:
+		data, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.push(HashPubKey(data))
+
+	case opCheckSig, opCheckSigVerify:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+		valid := e.checker.checkSig(signature, pubKey, e.subscript)
+		return e.result(op.opcode == opCheckSigVerify, valid, "OP_CHECKSIGVERIFY")
+
+	case opCheckMultiSig, opCheckMultiSigVerify:
+		valid, err := e.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		return e.result(op.opcode == opCheckMultiSigVerify, valid, "OP_CHECKMULTISIGVERIFY")
+
+	case opCheckLockTimeVerify:
+		return e.checkLockTime()
+	}
+
+	return fmt.Errorf("%w: unknown opcode %#x", ErrScriptFailed, op.opcode)
+}
+
+// result pushes the result of a check or, for the VERIFY variant, fails when it is false
+func (e *scriptEngine) result(verify, ok bool, name string) error {
+	if !verify {
+		return e.pushBool(ok)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrScriptFailed, name)
+	}
+
+	return nil
+}
+
+// checkMultiSig takes <sig 1> ... <sig m> <m> <key 1> ... <key n> <n> from the stack.
+// Signatures have to follow in the order of their keys. Unlike Bitcoin there is no extra dummy element
+func (e *scriptEngine) checkMultiSig() (bool, error) {
+	n, err := e.popInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > maxMultisigKeys {
+		return false, fmt.Errorf("%w: wrong number of keys %d", ErrScriptFailed, n)
+	}
+
+	keys := make([][]byte, n)
+	for i := int(n) - 1; i >= 0; i-- {
+		if keys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := e.popInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, fmt.Errorf("%w: wrong number of signatures %d", ErrScriptFailed, m)
+	}
+
+	signatures := make([][]byte, m)
+	for i := int(m) - 1; i >= 0; i-- {
+		if signatures[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	key := 0
+	for _, signature := range signatures {
+		for key < len(keys) && !e.checker.checkSig(signature, keys[key], e.subscript) {
+			key++
+		}
+		if key == len(keys) {
+			return false, nil
+		}
+		key++
+	}
+
+	return true, nil
+}
+
+// checkLockTime leaves the lock time on the stack and fails unless the transaction lock time reached it
+func (e *scriptEngine) checkLockTime() error {
+	if len(e.stack) == 0 {
+		return fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+	}
+	lockTime, err := decodeScriptNum(e.stack[len(e.stack)-1])
+	if err != nil {
+		return err
+	}
+
+	txLockTime := e.checker.lockTime()
+	if lockTime < 0 {
+		return fmt.Errorf("%w: negative lock time", ErrScriptFailed)
+	}
+	if (lockTime < lockTimeThreshold) != (txLockTime < lockTimeThreshold) {
+		return fmt.Errorf("%w: lock time types differ", ErrScriptLockTime)
+	}
+	if lockTime > txLockTime {
+		return fmt.Errorf("%w: until %d, transaction lock time is %d", ErrScriptLockTime, lockTime, txLockTime)
+	}
+
+	return nil
+}
+
+// Standard scripts
+
+// P2PKHScript locks an output to the owner of the key with the hash:
+// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+func P2PKHScript(pubKeyHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(opDup).AddOp(opHash160).AddData(pubKeyHash).AddOp(opEqualVerify).AddOp(opCheckSig)
+
+	return b.Script()
+}
+
+// P2SHScript locks an output to the redeem script with the hash: OP_HASH160 <hash> OP_EQUAL
+func P2SHScript(scriptHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(opHash160).AddData(scriptHash).AddOp(opEqual)
+
+	return b.Script()
+}
+
+// MultisigScript requires m signatures of the keys: <m> <key 1> ... <key n> <n> OP_CHECKMULTISIG
+func MultisigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxMultisigKeys {
+		return nil, fmt.Errorf("multisig needs from 1 to %d keys", maxMultisigKeys)
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("multisig can't require %d of %d signatures", m, len(pubKeys))
+	}
+
+	b := &ScriptBuilder{}
+	b.AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	b.AddInt(int64(len(pubKeys))).AddOp(opCheckMultiSig)
+
+	if len(b.Script()) > maxScriptElementSize {
+		return nil, errors.New("multisig script is too large to be a redeem script")
+	}
+
+	return b.Script(), nil
+}
+
+// TimeLockScript locks an output to the key until the block height or unix time:
+// <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+func TimeLockScript(lockTime int64, pubKeyHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddInt(lockTime).AddOp(opCheckLockTimeVerify).AddOp(opDrop)
+	b.script = append(b.script, P2PKHScript(pubKeyHash)...)
+
+	return b.Script()
+}
+
+// extractPubKeyHash returns the key hash of a P2PKH script
+func extractPubKeyHash(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 5 {
+		return nil, false
+	}
+	if ops[0].opcode != opDup || ops[1].opcode != opHash160 || len(ops[2].data) != 20 ||
+		ops[3].opcode != opEqualVerify || ops[4].opcode != opCheckSig {
+		return nil, false
+	}
+
+	return ops[2].data, true
+}
+
+// extractScriptHash returns the redeem script hash of a P2SH script
+func extractScriptHash(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 3 {
+		return nil, false
+	}
+	if ops[0].opcode != opHash160 || len(ops[1].data) != 20 || ops[2].opcode != opEqual {
+		return nil, false
+	}
+
+	return ops[1].data, true
+}
+
+// extractMultisig returns the number of required signatures and the keys of a multisig script
+func extractMultisig(script []byte) (int, [][]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 {
+		return 0, nil, false
+	}
+
+	last := len(ops) - 1
+	if ops[last].opcode != opCheckMultiSig || !isSmallIntOp(ops[0].opcode) || !isSmallIntOp(ops[last-1].opcode) {
+		return 0, nil, false
+	}
+
+	m := smallIntValue(ops[0].opcode)
+	n := smallIntValue(ops[last-1].opcode)
+	if n != last-2 || m < 1 || m > n {
+		return 0, nil, false
+	}
+
+	var keys [][]byte
+	for _, op := range ops[1 : last-1] {
+		if !isPushOp(op.opcode) || len(op.data) == 0 {
+			return 0, nil, false
+		}
+		keys = append(keys, op.data)
+	}
+
+	return m, keys, true
+}
+
+// extractTimeLock returns the lock time and the key hash of a time lock script
+func extractTimeLock(script []byte) (int64, []byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 8 || len(script) < 25 || ops[1].opcode != opCheckLockTimeVerify || ops[2].opcode != opDrop {
+		return 0, nil, false
+	}
+
+	var lockTime int64
+	if isSmallIntOp(ops[0].opcode) {
+		lockTime = int64(smallIntValue(ops[0].opcode))
+	} else if isPushOp(ops[0].opcode) {
+		if lockTime, err = decodeScriptNum(ops[0].data); err != nil {
+			return 0, nil, false
+		}
+	} else {
+		return 0, nil, false
+	}
+
+	pubKeyHash, ok := extractPubKeyHash(script[len(script)-25:])
+	if !ok {
+		return 0, nil, false
+	}
+
+	return lockTime, pubKeyHash, true
+}
+
+// isPushOnly checks that the script only pushes data
+func isPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+
+	for _, op := range ops {
+		if !isPushOp(op.opcode) && !isSmallIntOp(op.opcode) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scriptPushes returns the data pushed by a push only script
+func scriptPushes(script []byte) [][]byte {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil
+	}
+
+	var pushes [][]byte
+	for _, op := range ops {
+		if isPushOp(op.opcode) {
+			pushes = append(pushes, op.data)
+		}
+	}
+
+	return pushes
+}
+
+// parsePublicKey converts the wallet public key format, X and Y of the same length, to a key
+func parsePublicKey(pubKey []byte) (*ecdsa.PublicKey, bool) {
+	if len(pubKey) == 0 || len(pubKey)%2 != 0 {
+		return nil, false
+	}
+
+	curve := elliptic.P256()
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, false
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, true
+}
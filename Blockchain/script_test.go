@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spendingTx creates a transaction spending the single output
+func spendingTx(prevOut TXOutput) *Transaction {
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: []byte("previous transaction"), Vout: 0}},
+		Vout:    []TXOutput{*NewTXOutput(prevOut.Value, string(NewWallet().GetAddress()))},
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestScriptNum(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 127, 128, -128, 255, 256, 500000000, -2147483647} {
+		decoded, err := decodeScriptNum(encodeScriptNum(n))
+		assert.NoError(t, err)
+		assert.Equal(t, n, decoded)
+	}
+
+	assert.Equal(t, []byte{0x80, 0x00}, encodeScriptNum(128), "Sign bit needs an extra byte")
+	assert.Equal(t, []byte{0x81}, encodeScriptNum(-1))
+	assert.False(t, castToBool([]byte{0x00, 0x80}), "Negative zero is false")
+}
+
+func TestScriptAddress(t *testing.T) {
+	script := TimeLockScript(100, HashPubKey(NewWallet().PublicKey))
+	address := string(AddressFromScriptHash(HashPubKey(script)))
+
+	assert.True(t, ValidateAddress(address))
+	assert.True(t, IsScriptAddress(address))
+	assert.Equal(t, "3", address[:1])
+
+	out := NewTXOutput(1, address)
+	assert.Equal(t, P2SHScript(HashPubKey(script)), out.Script)
+	assert.Equal(t, address, out.Address())
+
+	lockTime, _, ok := extractTimeLock(script)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), lockTime)
+}
+
+func TestP2PKH(t *testing.T) {
+	owner := NewWallet()
+	prevOut := *NewTXOutput(10, string(owner.GetAddress()))
+	assert.Nil(t, prevOut.Script, "Key outputs keep the implicit script")
+
+	tx := spendingTx(prevOut)
+	prevTx := Transaction{ID: tx.Vin[0].Txid, Vout: []TXOutput{prevOut}}
+	prevTXs := map[string]Transaction{hex.EncodeToString(prevTx.ID): prevTx}
+
+	tx.Sign(NewWallet().PrivateKey, prevTXs)
+	assert.Empty(t, tx.Vin[0].ScriptSig, "Inputs of other keys are not signed")
+	assert.Error(t, tx.VerifyInput(0, prevOut))
+
+	tx.Sign(owner.PrivateKey, prevTXs)
+	assert.NoError(t, tx.VerifyInput(0, prevOut))
+
+	tx.Vout[0].Value++
+	assert.True(t, errors.Is(tx.VerifyInput(0, prevOut), ErrScriptFailed), "Signature covers outputs")
+}
+
+func TestLegacyInput(t *testing.T) {
+	owner := NewWallet()
+	prevOut := *NewTXOutput(10, string(owner.GetAddress()))
+	tx := spendingTx(prevOut)
+
+	// Так подписывались входы до появления скриптов, строка одинакова для всех транзакций
+	r, s, err := ecdsa.Sign(rand.Reader, &owner.PrivateKey, []byte(fmt.Sprintf("%x\n", *tx)))
+	assert.NoError(t, err)
+	tx.Vin[0].Signature = append(r.Bytes(), s.Bytes()...)
+	tx.Vin[0].PubKey = owner.PublicKey
+
+	assert.NoError(t, tx.VerifyInput(0, prevOut))
+
+	tx.Vin[0].PubKey = NewWallet().PublicKey
+	assert.Error(t, tx.VerifyInput(0, prevOut))
+}
+
+func TestMultisig(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	keys := [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey}
+
+	redeemScript, err := MultisigScript(2, keys)
+	assert.NoError(t, err)
+	m, extracted, ok := extractMultisig(redeemScript)
+	assert.True(t, ok)
+	assert.Equal(t, 2, m)
+	assert.Equal(t, keys, extracted)
+
+	prevOut := *NewTXOutput(10, string(AddressFromScriptHash(HashPubKey(redeemScript))))
+	tx := spendingTx(prevOut)
+
+	unlock := func(signers ...int) error {
+		b := &ScriptBuilder{}
+		for _, i := range signers {
+			signature, err := tx.SignInput(0, redeemScript, wallets[i].PrivateKey, sigHashAll)
+			assert.NoError(t, err)
+			b.AddData(signature)
+		}
+		tx.Vin[0].ScriptSig = b.AddData(redeemScript).Script()
+
+		return tx.VerifyInput(0, prevOut)
+	}
+
+	assert.NoError(t, unlock(0, 2))
+	assert.NoError(t, unlock(1, 2))
+	assert.Error(t, unlock(2, 0), "Signatures have to follow the order of keys")
+	assert.Error(t, unlock(1), "One signature is not enough")
+	assert.Error(t, unlock(1, 1), "The same key can't sign twice")
+}
+
+func TestTimeLock(t *testing.T) {
+	owner := NewWallet()
+	redeemScript := TimeLockScript(50, HashPubKey(owner.PublicKey))
+	prevOut := *NewTXOutput(10, string(AddressFromScriptHash(HashPubKey(redeemScript))))
+
+	unlock := func(lockTime int64) error {
+		tx := spendingTx(prevOut)
+		tx.LockTime = lockTime
+		signature, err := tx.SignInput(0, redeemScript, owner.PrivateKey, sigHashAll)
+		assert.NoError(t, err)
+		tx.Vin[0].ScriptSig = (&ScriptBuilder{}).AddData(signature).AddData(owner.PublicKey).AddData(redeemScript).Script()
+
+		return tx.VerifyInput(0, prevOut)
+	}
+
+	assert.True(t, errors.Is(unlock(0), ErrScriptLockTime))
+	assert.True(t, errors.Is(unlock(49), ErrScriptLockTime))
+	assert.True(t, errors.Is(unlock(lockTimeThreshold+50), ErrScriptLockTime), "Time can't satisfy a height lock")
+	assert.NoError(t, unlock(50))
+
+	tx := Transaction{LockTime: 50}
+	assert.False(t, tx.IsFinal(50, 0))
+	assert.True(t, tx.IsFinal(51, 0))
+}
+
+func TestUnlockingScriptPushOnly(t *testing.T) {
+	owner := NewWallet()
+	prevOut := *NewTXOutput(10, string(owner.GetAddress()))
+	tx := spendingTx(prevOut)
+
+	// OP_1 OP_DUP оставил бы на стеке true без подписи
+	tx.Vin[0].ScriptSig = (&ScriptBuilder{}).AddInt(1).AddOp(opDup).Script()
+	assert.True(t, errors.Is(tx.VerifyInput(0, prevOut), ErrScriptFailed))
+}
+
+func TestSigHashTypes(t *testing.T) {
+	owner := NewWallet()
+	prevOut := *NewTXOutput(10, string(owner.GetAddress()))
+	script := prevOut.LockingScript()
+
+	newTx := func() *Transaction {
+		tx := spendingTx(prevOut)
+		tx.Vin = append(tx.Vin, TXInput{Txid: []byte("another transaction"), Vout: 1})
+		tx.Vout = append(tx.Vout, *NewTXOutput(5, string(NewWallet().GetAddress())))
+		return tx
+	}
+	// verify подписывает первый вход, меняет транзакцию и проверяет подпись
+	verify := func(hashType byte, change func(tx *Transaction)) error {
+		tx := newTx()
+		signature, err := tx.SignInput(0, script, owner.PrivateKey, hashType)
+		assert.NoError(t, err)
+		tx.Vin[0].ScriptSig = (&ScriptBuilder{}).AddData(signature).AddData(owner.PublicKey).Script()
+
+		change(tx)
+		return tx.VerifyInput(0, prevOut)
+	}
+	changeOutput := func(i int) func(tx *Transaction) {
+		return func(tx *Transaction) { tx.Vout[i].Value++ }
+	}
+	changeInput := func(tx *Transaction) { tx.Vin[1].Vout++ }
+	addInput := func(tx *Transaction) { tx.Vin = append(tx.Vin, TXInput{Txid: []byte("third"), Vout: 0}) }
+
+	assert.NoError(t, verify(sigHashAll, func(tx *Transaction) {}))
+	assert.Error(t, verify(sigHashAll, changeOutput(1)))
+	assert.Error(t, verify(sigHashAll, changeInput))
+
+	assert.NoError(t, verify(sigHashNone, changeOutput(0)), "NONE doesn't cover outputs")
+	assert.Error(t, verify(sigHashNone, changeInput))
+
+	assert.NoError(t, verify(sigHashSingle, changeOutput(1)), "SINGLE covers only the output of the input")
+	assert.Error(t, verify(sigHashSingle, changeOutput(0)))
+
+	assert.Error(t, verify(sigHashAll, addInput))
+	assert.NoError(t, verify(sigHashAll|sigHashAnyoneCanPay, addInput))
+	assert.NoError(t, verify(sigHashAll|sigHashAnyoneCanPay, changeInput))
+
+	tx := newTx()
+	tx.Vout = tx.Vout[:1]
+	_, err := tx.SignInput(1, script, owner.PrivateKey, sigHashSingle)
+	assert.True(t, errors.Is(err, ErrSigHashType), "Input without an output can't sign SINGLE")
+
+	tx.Version = txVersionLegacy
+	_, err = tx.SignInput(0, script, owner.PrivateKey, sigHashNone)
+	assert.True(t, errors.Is(err, ErrSigHashType))
+
+	hashType, err := ParseSigHashType("single|anyonecanpay")
+	assert.NoError(t, err)
+	assert.Equal(t, sigHashSingle|sigHashAnyoneCanPay, hashType)
+	_, err = ParseSigHashType("ANYONECANPAY")
+	assert.Error(t, err)
+}
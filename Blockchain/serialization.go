@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+)
+
+// Transaction and block versions. Version 0 is what was stored before the canonical encoding:
+// such transactions and blocks are still hashed over gob, so their hashes and signatures stay valid
+const (
+	txVersionLegacy    = 0
+	txVersion          = 1
+	blockVersionLegacy = 0
+	blockVersion       = 1
+)
+
+// Canonical encoding. Numbers are little-endian, byte strings and lists are prefixed with their length as uvarint:
+//
+//	transaction := version (4) | id | inputs | outputs | lock time (8)
+//	input       := txid | vout (8) | script sig | signature | pubkey
+//	output      := value (8) | pubkey hash | script
+//	header      := version (4) | previous hash | merkle root | timestamp (8) | bits (4) | nonce (8) | height (8)
+//	block       := header | hash | transactions
+//
+// Headers are sent during sync with the hash: header | hash, blocks stored before MerkleRoot can't compute it.
+//
+// Transaction ID is double SHA-256 of the encoding without the id and script sigs, so it is known before signing.
+// Merkle tree leaves are the encodings without the id, they include script sigs
+
+// encode writes the canonical encoding of the transaction
+func (tx *Transaction) encode(w *wireWriter, withID, withScriptSigs bool) {
+	w.putUint32(tx.Version)
+	if withID {
+		w.putBytes(tx.ID)
+	}
+
+	w.putCount(len(tx.Vin))
+	for _, vin := range tx.Vin {
+		w.putBytes(vin.Txid)
+		w.putInt64(int64(vin.Vout))
+		if withScriptSigs {
+			w.putBytes(vin.ScriptSig)
+		}
+		w.putBytes(vin.Signature)
+		w.putBytes(vin.PubKey)
+	}
+
+	w.putCount(len(tx.Vout))
+	for _, vout := range tx.Vout {
+		w.putInt64(int64(vout.Value))
+		w.putBytes(vout.PubKeyHash)
+		w.putBytes(vout.Script)
+	}
+
+	w.putInt64(tx.LockTime)
+}
+
+func (tx *Transaction) decode(r *wireReader) {
+	tx.Version = r.uint32()
+	tx.ID = nilIfEmpty(r.bytes())
+
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		var vin TXInput
+		vin.Txid = r.bytes()
+		vin.Vout = int(r.int64())
+		vin.ScriptSig = nilIfEmpty(r.bytes())
+		vin.Signature = nilIfEmpty(r.bytes())
+		vin.PubKey = nilIfEmpty(r.bytes())
+		tx.Vin = append(tx.Vin, vin)
+	}
+
+	n = r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		var vout TXOutput
+		vout.Value = int(r.int64())
+		vout.PubKeyHash = nilIfEmpty(r.bytes())
+		vout.Script = nilIfEmpty(r.bytes())
+		tx.Vout = append(tx.Vout, vout)
+	}
+
+	tx.LockTime = r.int64()
+}
+
+// encodeHeader writes the block header with the nonce, the block hash is computed from it
+func (b *Block) encodeHeader(w *wireWriter, nonce int) {
+	w.putUint32(b.Version)
+	w.putBytes(b.PrevBlockHash)
+	w.putBytes(b.MerkleRoot)
+	w.putInt64(b.Timestamp)
+	w.putUint32(b.Bits)
+	w.putInt64(int64(nonce))
+	w.putInt64(int64(b.Height))
+}
+
+func (b *Block) encode(w *wireWriter) {
+	b.encodeHeader(w, b.Nonce)
+	w.putBytes(b.Hash)
+
+	w.putCount(len(b.Transactions))
+	for _, tx := range b.Transactions {
+		tx.encode(w, true, true)
+	}
+}
+
+func (b *Block) decodeHeader(r *wireReader) {
+	b.Version = r.uint32()
+	b.PrevBlockHash = r.bytes()
+	b.MerkleRoot = nilIfEmpty(r.bytes())
+	b.Timestamp = r.int64()
+	b.Bits = r.uint32()
+	b.Nonce = int(r.int64())
+	b.Height = int(r.int64())
+	b.Hash = r.bytes()
+}
+
+func (b *Block) decode(r *wireReader) {
+	b.decodeHeader(r)
+
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		tx := &Transaction{}
+		tx.decode(r)
+		b.Transactions = append(b.Transactions, tx)
+	}
+}
+
+// decodeCanonical decodes the whole data, trailing bytes are an error
+func decodeCanonical(data []byte, decode func(r *wireReader)) error {
+	r := &wireReader{data: data}
+	decode(r)
+	if r.err == nil && r.pos != len(r.data) {
+		r.err = ErrMalformedData
+	}
+
+	return r.err
+}
+
+// nilIfEmpty keeps decoded empty fields nil, as they are in created transactions
+func nilIfEmpty(data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return data
+}
+
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
+
+// gob нумерует типы в порядке их первого кодирования в процессе, и номера попадают в закодированные данные.
+// Хэши транзакций версии 0 считаются от этих данных, поэтому их раскладки кодируются первыми при старте,
+// в том же порядке, что и раньше, чтобы номера совпадали во всех процессах
+func init() {
+	tx := Transaction{}
+	gobEncode(tx.legacyLayout())
+	gobEncode(tx.scriptLayout())
+}
+
+// legacyGob returns the gob encoding of a version 0 transaction, its hashes are computed from it.
+// Transactions without scripts use the layout from before scripts, the others the one from before versions
+func (tx *Transaction) legacyGob() []byte {
+	if tx.isLegacy() {
+		return gobEncode(tx.legacyLayout())
+	}
+
+	return gobEncode(tx.scriptLayout())
+}
+
+// isLegacy checks whether the transaction has nothing that appeared with scripts
+func (tx *Transaction) isLegacy() bool {
+	if tx.LockTime != 0 {
+		return false
+	}
+	for _, vin := range tx.Vin {
+		if len(vin.ScriptSig) > 0 {
+			return false
+		}
+	}
+	for _, vout := range tx.Vout {
+		if len(vout.Script) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// legacyLayout copies the transaction into structures with the fields it had before scripts.
+// gob writes type names into the data, so the types are declared here under the old names
+func (tx *Transaction) legacyLayout() interface{} {
+	type TXInput struct {
+		Txid      []byte
+		Vout      int
+		Signature []byte
+		PubKey    []byte
+	}
+	type TXOutput struct {
+		Value      int
+		PubKeyHash []byte
+	}
+	type Transaction struct {
+		ID   []byte
+		Vin  []TXInput
+		Vout []TXOutput
+	}
+
+	txCopy := Transaction{ID: tx.ID}
+	for _, vin := range tx.Vin {
+		txCopy.Vin = append(txCopy.Vin, TXInput{vin.Txid, vin.Vout, vin.Signature, vin.PubKey})
+	}
+	for _, vout := range tx.Vout {
+		txCopy.Vout = append(txCopy.Vout, TXOutput{vout.Value, vout.PubKeyHash})
+	}
+
+	return txCopy
+}
+
+// scriptLayout copies the transaction into structures with the fields it had before versions
+func (tx *Transaction) scriptLayout() interface{} {
+	type TXInput struct {
+		Txid      []byte
+		Vout      int
+		Signature []byte
+		PubKey    []byte
+		ScriptSig []byte
+	}
+	type TXOutput struct {
+		Value      int
+		PubKeyHash []byte
+		Script     []byte
+	}
+	type Transaction struct {
+		ID       []byte
+		Vin      []TXInput
+		Vout     []TXOutput
+		LockTime int64
+	}
+
+	txCopy := Transaction{ID: tx.ID, LockTime: tx.LockTime}
+	for _, vin := range tx.Vin {
+		txCopy.Vin = append(txCopy.Vin, TXInput{vin.Txid, vin.Vout, vin.Signature, vin.PubKey, vin.ScriptSig})
+	}
+	for _, vout := range tx.Vout {
+		txCopy.Vout = append(txCopy.Vout, TXOutput{vout.Value, vout.PubKeyHash, vout.Script})
+	}
+
+	return txCopy
+}
+
+// deserializeGobBlock decodes a block stored by the versions before the canonical encoding
+func deserializeGobBlock(data []byte) (*Block, error) {
+	var block Block
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionRoundTrip(t *testing.T) {
+	owner := NewWallet()
+	prevOut := *NewTXOutput(10, string(owner.GetAddress()))
+	tx := spendingTx(prevOut)
+	tx.LockTime = 42
+
+	data := tx.Serialize()
+	assert.Equal(t, data, tx.Serialize(), "Encoding is deterministic")
+
+	decoded, err := DecodeTransaction(data)
+	assert.NoError(t, err)
+	assert.Equal(t, *tx, decoded)
+
+	_, err = DecodeTransaction(data[:len(data)-1])
+	assert.Error(t, err)
+	_, err = DecodeTransaction(append(data, 0))
+	assert.Error(t, err, "Trailing bytes are not allowed")
+}
+
+func TestTransactionIDDoesNotDependOnSignatures(t *testing.T) {
+	owner := NewWallet()
+	prevOut := *NewTXOutput(10, string(owner.GetAddress()))
+	tx := spendingTx(prevOut)
+	id := tx.ID
+
+	prevTx := Transaction{ID: tx.Vin[0].Txid, Vout: []TXOutput{prevOut}}
+	tx.Sign(owner.PrivateKey, map[string]Transaction{hex.EncodeToString(prevTx.ID): prevTx})
+	assert.NotEmpty(t, tx.Vin[0].ScriptSig)
+	assert.Equal(t, id, tx.Hash())
+	assert.NoError(t, checkTransactionEncoding(tx))
+
+	// Подпись входит в дерево Меркла, хотя ID не меняет
+	unsigned := spendingTx(prevOut)
+	unsigned.Vin = append([]TXInput{}, tx.Vin...)
+	unsigned.Vin[0].ScriptSig = nil
+	assert.NotEqual(t, unsigned.merkleData(), tx.merkleData())
+
+	tx.Vout[0].Value++
+	assert.Error(t, checkTransactionEncoding(tx))
+}
+
+func TestLegacyBlockKeepsHash(t *testing.T) {
+	coinbase := NewCoinbaseTX(string(NewWallet().GetAddress()), "legacy", 0, 0)
+	coinbase.Version = txVersionLegacy
+	coinbase.ID = coinbase.Hash()
+
+	unhashed := Transaction{Vin: coinbase.Vin, Vout: coinbase.Vout}
+	legacyHash := sha256.Sum256(gobEncode(unhashed.legacyLayout()))
+	assert.Equal(t, legacyHash[:], coinbase.ID, "Version 0 is hashed over the old gob encoding")
+
+	block := &Block{
+		Timestamp:    time.Now().Unix(),
+		Transactions: []*Transaction{coinbase},
+		Bits:         BigToCompact(initialTarget),
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.Nonce, block.Hash = NewProofOfWork(block).Run()
+
+	// Так блоки хранились до канонического кодирования
+	stored, err := deserializeGobBlock(gobEncode(block))
+	assert.NoError(t, err)
+
+	migrated := DeserializeBlock(stored.Serialize())
+	assert.Equal(t, block.Hash, migrated.Hash)
+	assert.True(t, NewProofOfWork(migrated).Validate())
+	assert.NoError(t, CheckBlock(migrated))
+
+	migrated.Transactions[0].Version = txVersion
+	assert.Error(t, CheckBlock(migrated), "Transactions have the version of their block")
+}
@@ -1,61 +1,32 @@
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 const protocol = "tcp"
-const nodeVersion = 1
-const commandLength = 12
+const nodeVersion = 4
+const minPeerVersion = 4
+const commandLength = 16
 
-var nodeAddress string
-var miningAddress string
-var knownNodes = []string{"localhost:3000"}
-var blocksInTransit = [][]byte{}
-var mempool = make(map[string]Transaction)
+var seedNodes = networks["mainnet"].Seeds
 
-type addr struct {
-	AddrList []string
-}
-
-type block struct {
-	AddrFrom string
-	Block    []byte
-}
+// Сколько транзакций и адресов можно запросить в одном getmerkleproof
+const maxMerkleProofItems = 1000
 
-type getblocks struct {
-	AddrFrom string
-}
-
-type getdata struct {
-	AddrFrom string
-	Type     string
-	ID       []byte
-}
+// Как часто из мемпула удаляются устаревшие и ставшие невалидными транзакции
+const mempoolPruneInterval = time.Minute
 
-type inv struct {
-	AddrFrom string
-	Type     string
-	Items    [][]byte
-}
-
-type tx struct {
-	AddFrom     string
-	Transaction []byte
-}
-
-type verzion struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
-}
+// Сколько при остановке нода ждать завершения запросов к JSON-RPC и обозревателю
+const httpShutdownTimeout = 5 * time.Second
 
 func commandToBytes(command string) []byte {
 	var bytes [commandLength]byte
@@ -79,398 +50,479 @@ func bytesToCommand(bytes []byte) string {
 	return fmt.Sprintf("%s", command)
 }
 
-func extractCommand(request []byte) []byte {
-	return request[:commandLength]
+func newVersionMessage(addrFrom string, bestHeight int, nonce uint64) []byte {
+	return newMessage("version", &verzion{nodeVersion, bestHeight, addrFrom, nonce})
 }
 
-func requestBlocks() {
-	for _, node := range knownNodes {
-		sendGetBlocks(node)
+// sendVersion отправляет пиру версию нода, без высоты цепочки рукопожатие не начать и соединение закрывается
+func (n *Node) sendVersion(p *Peer) {
+	bestHeight, err := n.bc.GetBestHeight()
+	if err != nil {
+		n.log.Error("Failed to read the best height", "peer", p, "err", err)
+		p.Disconnect()
+		return
 	}
-}
 
-func sendAddr(address string) {
-	nodes := addr{knownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddress)
-	payload := gobEncode(nodes)
-	request := append(commandToBytes("addr"), payload...)
-
-	sendData(address, request)
+	p.Send(newVersionMessage(n.address, bestHeight, n.peers.nonce))
 }
 
-func sendBlock(addr string, b *Block) {
-	data := block{nodeAddress, b.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("block"), payload...)
-
-	sendData(addr, request)
+// sendTx отправляет транзакцию без запущенного нода, как это делают команды CLI
+func sendTx(addr string, tnx *Transaction) {
+	err := sendOneShot(addr, newMessage("tx", &tx{"", tnx.Serialize()}))
+	if err != nil {
+		(*Logger)(nil).Warn("Node is not available", "peer", addr, "err", err)
+	}
 }
 
-func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
+// decodePayload декодирует данные сообщения, битые данные считаются нарушением
+func (n *Node) decodePayload(p *Peer, msg *message, payload wireMessage) bool {
+	err := msg.decode(payload)
 	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
-
-		for _, node := range knownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
+		n.peers.Misbehaving(p, banThreshold/2, fmt.Sprintf("broken %s message: %s", msg.Command, err))
+		return false
+	}
 
-		knownNodes = updatedNodes
+	return true
+}
 
+func (n *Node) handleAddr(p *Peer, msg *message) {
+	var payload addr
+	if !n.decodePayload(p, msg, &payload) {
 		return
 	}
-	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
+	if len(payload.AddrList) > maxAddrPerMessage {
+		n.peers.Misbehaving(p, banThreshold/5, fmt.Sprintf("%d addresses in a message", len(payload.AddrList)))
+		return
 	}
-}
-
-func sendInv(address, kind string, items [][]byte) {
-	inventory := inv{nodeAddress, kind, items}
-	payload := gobEncode(inventory)
-	request := append(commandToBytes("inv"), payload...)
 
-	sendData(address, request)
+	added := n.peers.AddAddresses(payload.AddrList)
+	n.log.Debug("Received addresses", "peer", p, "count", len(payload.AddrList), "new", added)
 }
 
-func sendGetBlocks(address string) {
-	payload := gobEncode(getblocks{nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...)
+func (n *Node) handleBlock(p *Peer, msg *message) {
+	var payload block
+	if !n.decodePayload(p, msg, &payload) {
+		return
+	}
 
-	sendData(address, request)
-}
+	n.metrics.BlocksReceived.Inc()
+	block, err := DecodeBlock(payload.Block)
+	if err != nil {
+		n.metrics.BlocksRejected.Inc()
+		n.peers.Misbehaving(p, banThreshold/2, fmt.Sprintf("broken block: %s", err))
+		return
+	}
 
-func sendGetData(address, kind string, id []byte) {
-	payload := gobEncode(getdata{nodeAddress, kind, id})
-	request := append(commandToBytes("getdata"), payload...)
+	n.log.Debug("Received a block", "peer", p, "block", block.Hash)
 
-	sendData(address, request)
-}
+	// Блоки, запрошенные при синхронизации, подключаются по порядку менеджером синхронизации
+	if n.sync.HandleBlock(p, block) {
+		return
+	}
 
-func sendTx(addr string, tnx *Transaction) {
-	data := tx{nodeAddress, tnx.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("tx"), payload...)
+	// Родитель неизвестен - значит отстали, запрашиваем недостающие заголовки
+	_, err = n.bc.GetBlock(block.PrevBlockHash)
+	parentIsKnown := err == nil
 
-	sendData(addr, request)
-}
+	// chainstate обновляется внутри AddBlock, в том числе при переключении на другую ветку
+	err = n.bc.AddBlock(block)
+	if err != nil {
+		n.metrics.BlocksRejected.Inc()
+		n.log.Warn("Block is rejected", "peer", p, "block", block.Hash, "err", err)
 
-func sendVersion(addr string, bc *Blockchain) {
-	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(verzion{nodeVersion, bestHeight, nodeAddress})
+		var ruleErr RuleError
+		if errors.As(err, &ruleErr) {
+			n.peers.Misbehaving(p, blockBanScore(ruleErr), "invalid block")
+		}
 
-	request := append(commandToBytes("version"), payload...)
+		return
+	}
 
-	sendData(addr, request)
-}
+	// Намайненные транзакции и конфликтующие с ними больше не нужны
+	n.mempool.RemoveBlock(block)
 
-func handleAddr(request []byte) {
-	var buff bytes.Buffer
-	var payload addr
+	n.log.Info("Block is added", "peer", p, "block", block.Hash, "height", block.Height)
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if !parentIsKnown {
+		n.sync.Start(p)
+		return
 	}
-
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(knownNodes))
-	requestBlocks()
+	n.relayBlock(block.Hash, p.Addr())
 }
 
-func handleBlock(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
-	var payload block
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+func (n *Node) handleInv(p *Peer, msg *message) {
+	var payload inv
+	if !n.decodePayload(p, msg, &payload) {
+		return
 	}
 
-	blockData := payload.Block
-	block := DeserializeBlock(blockData)
-
-	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
+	n.log.Debug("Received inventory", "peer", p, "type", payload.Type, "count", len(payload.Items))
 
-	fmt.Printf("Added block %x\n", block.Hash)
+	if payload.Type == "block" {
+		// Новые блоки качаются так же, как при первой синхронизации: сначала заголовки, потом сами блоки
+		for _, hash := range payload.Items {
+			if _, err := n.bc.GetBlock(hash); err != nil {
+				n.sync.Start(p)
+				break
+			}
+		}
+	}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+	if payload.Type == "tx" && len(payload.Items) > 0 {
+		txID := payload.Items[0]
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
+		if !n.mempool.Has(txID) {
+			p.Send(newMessage("getdata", &getdata{n.address, "tx", txID}))
+		}
 	}
 }
 
-func handleInv(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
-	var payload inv
+// handleGetHeaders отправляет заголовки основной цепочки после последнего общего блока из локатора
+func (n *Node) handleGetHeaders(p *Peer, msg *message) {
+	var payload getheaders
+	if !n.decodePayload(p, msg, &payload) {
+		return
+	}
+
+	if len(payload.Locator) > maxLocatorSize {
+		n.peers.Misbehaving(p, banThreshold/5, fmt.Sprintf("%d hashes in a locator", len(payload.Locator)))
+		return
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	found, err := n.bc.HeadersAfter(payload.Locator, maxHeadersPerMessage)
 	if err != nil {
-		log.Panic(err)
+		n.log.Error("Failed to find headers", "peer", p, "err", err)
+		return
 	}
 
-	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
+	items := [][]byte{}
+	for _, header := range found {
+		items = append(items, header.SerializeHeader())
+	}
 
-	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+	p.Send(newMessage("headers", &headers{items}))
+}
 
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+func (n *Node) handleHeaders(p *Peer, msg *message) {
+	var payload headers
+	if !n.decodePayload(p, msg, &payload) {
+		return
+	}
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
-		}
-		blocksInTransit = newInTransit
+	n.log.Debug("Received headers", "peer", p, "count", len(payload.Headers))
+	n.sync.HandleHeaders(p, payload.Headers)
+}
+
+// handleGetMerkleProof отправляет легкому клиенту транзакции с доказательствами их включения в блоки.
+// Транзакции ищутся по индексам, без них отвечать нечем
+func (n *Node) handleGetMerkleProof(p *Peer, msg *message) {
+	var payload getmerkleproof
+	if !n.decodePayload(p, msg, &payload) {
+		return
 	}
 
-	if payload.Type == "tx" {
-		txID := payload.Items[0]
+	if len(payload.TxIDs)+len(payload.PubKeyHashes) > maxMerkleProofItems {
+		n.peers.Misbehaving(p, banThreshold/5, fmt.Sprintf("%d items in getmerkleproof", len(payload.TxIDs)+len(payload.PubKeyHashes)))
+		return
+	}
+	indexed, err := n.bc.HasTxIndex()
+	if err != nil || !indexed {
+		n.log.Warn("Can't prove transactions without the transaction index", "peer", p)
+		return
+	}
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
-			sendGetData(payload.AddrFrom, "tx", txID)
+	txIDs := payload.TxIDs
+	for _, pubKeyHash := range payload.PubKeyHashes {
+		found, err := n.bc.FindAddressTransactions(pubKeyHash)
+		if err != nil {
+			n.log.Error("Failed to find transactions", "peer", p, "err", err)
+			return
 		}
+		txIDs = append(txIDs, found...)
 	}
-}
 
-func handleGetBlocks(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
-	var payload getblocks
+	sent := make(map[string]bool)
+	for _, txID := range txIDs {
+		blockHash, found, err := n.bc.FindTransactionBlock(txID)
+		if err != nil || !found || sent[hex.EncodeToString(txID)] {
+			continue
+		}
+		sent[hex.EncodeToString(txID)] = true
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
+		block, err := n.bc.GetBlock(blockHash)
+		if err != nil {
+			continue
+		}
+		proof, err := block.MerkleProof(txID)
+		if err != nil {
+			continue
+		}
 
-	blocks := bc.GetBlockHashes()
-	sendInv(payload.AddrFrom, "block", blocks)
+		tx := block.Transactions[proof.Index]
+		p.Send(newMessage("merkleproof", &merkleproof{blockHash, tx.Serialize(), proof.Index, proof.Hashes}))
+	}
 }
 
-func handleGetData(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func (n *Node) handleGetData(p *Peer, msg *message) {
 	var payload getdata
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if !n.decodePayload(p, msg, &payload) {
+		return
 	}
 
 	if payload.Type == "block" {
-		block, err := bc.GetBlock([]byte(payload.ID))
+		stored, err := n.bc.GetBlock([]byte(payload.ID))
 		if err != nil {
 			return
 		}
+		// У обрезанного блока остался только заголовок, отдать его некому не нужно
+		if stored.isPruned() {
+			return
+		}
 
-		sendBlock(payload.AddrFrom, &block)
+		p.Send(newMessage("block", &block{n.address, stored.Serialize()}))
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		pending, ok := n.mempool.Get(payload.ID)
+		if !ok {
+			return
+		}
 
-		sendTx(payload.AddrFrom, &tx)
-		// delete(mempool, txID)
+		p.Send(newMessage("tx", &tx{n.address, pending.Serialize()}))
 	}
 }
 
-func handleTx(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func (n *Node) handleTx(p *Peer, msg *message) {
 	var payload tx
+	if !n.decodePayload(p, msg, &payload) {
+		return
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	n.metrics.TxsReceived.Inc()
+	tx, err := DecodeTransaction(payload.Transaction)
 	if err != nil {
-		log.Panic(err)
+		n.metrics.TxsRejected.Inc()
+		n.peers.Misbehaving(p, banThreshold/2, fmt.Sprintf("broken transaction: %s", err))
+		return
 	}
 
-	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	err = n.processTransaction(tx, p.Addr())
+	if err != nil {
+		n.metrics.TxsRejected.Inc()
+		n.log.Warn("Transaction is rejected", "peer", p, "tx", tx.ID, "err", err)
 
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddFrom {
-				sendInv(node, "tx", [][]byte{tx.ID})
-			}
+		// Входа может не быть из-за того, что мы еще не получили блок, за это не наказываем
+		var ruleErr RuleError
+		if errors.As(err, &ruleErr) && !errors.Is(err, ErrMissingInput) {
+			n.peers.Misbehaving(p, banThreshold/10, "invalid transaction")
 		}
-	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
-		MineTransactions:
-			var txs []*Transaction
-
-			for id := range mempool {
-				tx := mempool[id]
-				if bc.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
-			}
+	}
+}
 
-			if len(txs) == 0 {
-				fmt.Println("All transactions are invalid! Waiting for new ones...")
-				return
-			}
+// processTransaction кладет новую транзакцию в мемпул и рассылает ее подключенным нодам,
+// а майнер начинает майнить, если накопилось достаточно для сети транзакций. addrFrom - нод, приславший транзакцию
+func (n *Node) processTransaction(tx Transaction, addrFrom string) error {
+	// Мемпул сам проверяет транзакцию по UTXO и по другим ожидающим транзакциям
+	err := n.mempool.Add(tx)
+	if err != nil {
+		return err
+	}
 
-			cbTx := NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
+	// Приславшему нод транзакция уже известна
+	n.broadcast(newMessage("inv", &inv{n.address, "tx", [][]byte{tx.ID}}), addrFrom)
 
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.Reindex()
+	if n.mempool.Count() >= activeNetwork.MinerBatch && n.miner != nil {
+		n.miner.Wake()
+	}
 
-			fmt.Println("New block is mined!")
+	return nil
+}
 
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
-			}
+func (n *Node) handleVersion(p *Peer, msg *message) {
+	var payload verzion
+	if !n.decodePayload(p, msg, &payload) {
+		return
+	}
 
-			for _, node := range knownNodes {
-				if node != nodeAddress {
-					sendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
+	if p.version != nil {
+		n.peers.Misbehaving(p, banThreshold/10, "duplicate version")
+		return
+	}
+	if !n.peers.acceptVersion(p, payload) {
+		p.Disconnect()
+		return
+	}
 
-			if len(mempool) > 0 {
-				goto MineTransactions
-			}
-		}
+	// Входящее соединение отвечает своей версией, после чего обе стороны подтверждают полученную
+	if p.inbound {
+		n.sendVersion(p)
+	}
+	p.Send(newMessage("verack", &verack{}))
+
+	if p.handshakeDone() {
+		n.handleHandshakeDone(p)
 	}
 }
 
-func handleVersion(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
-	var payload verzion
+func (n *Node) handleVerack(p *Peer) {
+	if p.verackDone {
+		n.peers.Misbehaving(p, banThreshold/10, "duplicate verack")
+		return
+	}
+	p.verackDone = true
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if p.handshakeDone() {
+		n.handleHandshakeDone(p)
 	}
+}
+
+// handleHandshakeDone делится адресами с новым нодом и начинает синхронизацию, если он впереди
+func (n *Node) handleHandshakeDone(p *Peer) {
+	n.peers.handshakeDone(p)
 
-	myBestHeight := bc.GetBestHeight()
-	foreignerBestHeight := payload.BestHeight
+	p.Send(newMessage("addr", &addr{n.peers.GoodAddresses()}))
 
-	if myBestHeight < foreignerBestHeight {
-		sendGetBlocks(payload.AddrFrom)
-	} else if myBestHeight > foreignerBestHeight {
-		sendVersion(payload.AddrFrom, bc)
+	bestHeight, err := n.bc.GetBestHeight()
+	if err != nil {
+		n.log.Error("Failed to read the best height", "err", err)
+		return
 	}
+	if bestHeight < p.version.BestHeight {
+		n.sync.Start(p)
+	}
+}
 
-	// sendAddr(payload.AddrFrom)
-	if !nodeIsKnown(payload.AddrFrom) {
-		knownNodes = append(knownNodes, payload.AddrFrom)
+func (n *Node) handlePing(p *Peer, msg *message) {
+	var payload ping
+	if !n.decodePayload(p, msg, &payload) {
+		return
 	}
+
+	p.Send(newMessage("pong", &payload))
 }
 
-func handleConnection(conn net.Conn, bc *Blockchain) {
-	// Читаем все данные из соединения до символа конца файла EOF
-	// Успешное чтение возвращает err == nil, а не err == EOF, так как чтение до конца файла это валидная ситуация
-	// В случае ошибки чтения или в случае отвала - тогда уже возвращается ошибка
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
+func (n *Node) handlePong(p *Peer, msg *message) {
+	var payload ping
+	if !n.decodePayload(p, msg, &payload) {
+		return
 	}
 
-	// Конвертируем начало входных данных в команду
-	command := bytesToCommand(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
+	p.handlePong(payload.Nonce)
+}
+
+// handleMessage обрабатывает запрос нода, запросы одного нода обрабатываются по очереди
+func (n *Node) handleMessage(p *Peer, msg *message) {
+	n.log.Debug("Received a message", "peer", p, "command", msg.Command)
 
-	// Непосредственно обработка команды
-	switch command {
+	switch msg.Command {
 	case "addr":
-		handleAddr(request)
+		n.handleAddr(p, msg)
 	case "block":
-		handleBlock(request, bc)
+		n.handleBlock(p, msg)
 	case "inv":
-		handleInv(request, bc)
-	case "getblocks":
-		handleGetBlocks(request, bc)
+		n.handleInv(p, msg)
+	case "getheaders":
+		n.handleGetHeaders(p, msg)
+	case "headers":
+		n.handleHeaders(p, msg)
 	case "getdata":
-		handleGetData(request, bc)
+		n.handleGetData(p, msg)
+	case "getmerkleproof":
+		n.handleGetMerkleProof(p, msg)
 	case "tx":
-		handleTx(request, bc)
+		n.handleTx(p, msg)
 	case "version":
-		handleVersion(request, bc)
+		n.handleVersion(p, msg)
+	case "verack":
+		n.handleVerack(p)
+	case "ping":
+		n.handlePing(p, msg)
+	case "pong":
+		n.handlePong(p, msg)
 	default:
-		fmt.Println("Unknown command!")
+		n.log.Warn("Unknown command", "peer", p, "command", msg.Command)
 	}
-
-	conn.Close()
 }
 
-// StartServer starts a node
-func StartServer(nodeID, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	miningAddress = minerAddress
-	// Запускаем ожидание подключений на указанном адресе
-	ln, err := net.Listen(protocol, nodeAddress)
+// StartServer starts a node with the settings of the config, JSON-RPC and the explorer are served when their addresses are set.
+// The node runs until SIGINT or SIGTERM, then HTTP requests are finished, peers are disconnected
+// and the database is closed. A second signal kills the process right away
+func StartServer(config Config) error {
+	node, err := NewNode(NodeConfig{ID: config.NodeID, Address: config.Listen, Seeds: config.Seeds, MinerAddress: config.Miner})
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	defer ln.Close()
 
-	// Создаем новый блокчейн
-	bc := NewBlockchain(nodeID)
+	// Подписываемся до запуска, чтобы и во время него сигнал останавливал нод штатно
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var servers []*http.Server
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
 
-	// Если адрес нода не первый известный нод
-	if nodeAddress != knownNodes[0] {
-		// Отправляем версию
-		sendVersion(knownNodes[0], bc)
+		// Сначала HTTP, чтобы запросы не пришли в уже закрытую базу
+		for _, server := range servers {
+			server.Shutdown(ctx)
+		}
+		node.Stop()
+		node.Wait()
 	}
 
-	// Принимаем новые соединения и начинаем с ними работу
-	for {
-		conn, err := ln.Accept()
+	err = node.Start()
+	if err != nil {
+		stop()
+		return err
+	}
+
+	if config.RPC != "" {
+		server, err := StartRPCServer(config.RPC, config.RPCUser, config.RPCPassword, node)
 		if err != nil {
-			log.Panic(err)
+			stop()
+			return err
 		}
-		go handleConnection(conn, bc)
+		servers = append(servers, server)
 	}
-}
 
-func gobEncode(data interface{}) []byte {
-	var buff bytes.Buffer
+	if config.Explorer != "" {
+		server, err := StartExplorer(config.Explorer, node.bc, node.mempool, node.peers)
+		if err != nil {
+			stop()
+			return err
+		}
+		servers = append(servers, server)
+	}
 
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	if err != nil {
-		log.Panic(err)
+	if config.Metrics != "" {
+		server, err := StartMetricsServer(config.Metrics, node)
+		if err != nil {
+			stop()
+			return err
+		}
+		servers = append(servers, server)
+	}
+
+	select {
+	case sig := <-signals:
+		node.log.Info("Shutting down", "signal", sig)
+	case <-node.Done():
 	}
+	signal.Stop(signals)
 
-	return buff.Bytes()
+	stop()
+	node.log.Info("Node is stopped")
+
+	return nil
 }
 
-func nodeIsKnown(addr string) bool {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
-		}
+// Блок из будущего может быть следствием расхождения часов, остальное - заведомо плохие данные
+func blockBanScore(err RuleError) int {
+	if errors.Is(err, ErrTimeTooNew) {
+		return banThreshold / 10
 	}
 
-	return false
+	return banThreshold
 }
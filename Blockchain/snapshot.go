@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// Формат снимка: magic и версия (uint32), заголовки основной цепочки от генезиса к вершине (Block.SerializeHeader),
+// записи chainstate (ID транзакции и ее непотраченные выходы), в конце двойной SHA-256 всего предыдущего
+const snapshotMagic = 0x50414e53 // "SNAP"
+const snapshotVersion = 1
+
+// Ошибки снимков
+var (
+	ErrBadSnapshot = errors.New("invalid snapshot")
+	ErrDBExists    = errors.New("blockchain database already exists")
+)
+
+// ExportSnapshot writes the main chain headers and the UTXO set to the file.
+// It returns the height of the tip and the number of transactions with unspent outputs
+func (bc *Blockchain) ExportSnapshot(file string) (int, int, error) {
+	var headers, keys, values [][]byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		for hash := b.Get([]byte("l")); len(hash) > 0; {
+			block := DeserializeBlock(b.Get(hash))
+			headers = append(headers, block.SerializeHeader())
+			hash = block.PrevBlockHash
+		}
+		// Собирали от вершины, а сохраняем от генезиса
+		for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+			headers[i], headers[j] = headers[j], headers[i]
+		}
+
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			values = append(values, append([]byte{}, v...))
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	w := &wireWriter{}
+	w.putUint32(snapshotMagic)
+	w.putUint32(snapshotVersion)
+	w.putBytesList(headers)
+	w.putCount(len(keys))
+	for i := range keys {
+		w.putBytes(keys[i])
+		w.putBytes(values[i])
+	}
+
+	data := w.buf.Bytes()
+	err = writeFileAtomic(file, append(data, doubleSHA256(data)...), 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(headers) - 1, len(keys), nil
+}
+
+// decodeSnapshot checks the checksum of the snapshot and returns its headers and chainstate entries
+func decodeSnapshot(data []byte) ([]*Block, [][]byte, [][]byte, error) {
+	if len(data) < 32 {
+		return nil, nil, nil, fmt.Errorf("%w: too short", ErrBadSnapshot)
+	}
+	content, checksum := data[:len(data)-32], data[len(data)-32:]
+	if !bytes.Equal(doubleSHA256(content), checksum) {
+		return nil, nil, nil, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	var items, keys, values [][]byte
+	var magic, version uint32
+	err := decodeCanonical(content, func(r *wireReader) {
+		magic = r.uint32()
+		version = r.uint32()
+		items = r.bytesList()
+
+		n := r.count()
+		for i := 0; i < n && r.err == nil; i++ {
+			keys = append(keys, r.bytes())
+			values = append(values, r.bytes())
+		}
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrBadSnapshot, err)
+	}
+	if magic != snapshotMagic || version != snapshotVersion {
+		return nil, nil, nil, fmt.Errorf("%w: unknown format %08x version %d", ErrBadSnapshot, magic, version)
+	}
+
+	// Выходы хранятся так же, как в chainstate, битые записи не должны попасть в базу
+	for _, value := range values {
+		var outputs TXOutputs
+		err := gob.NewDecoder(bytes.NewReader(value)).Decode(&outputs)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%w: %s", ErrBadSnapshot, err)
+		}
+	}
+
+	var headers []*Block
+	for _, item := range items {
+		header, err := DecodeHeader(item)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%w: %s", ErrBadSnapshot, err)
+		}
+		headers = append(headers, header)
+	}
+
+	return headers, keys, values, nil
+}
+
+// checkSnapshotHeaders проверяет, что заголовки образуют цепочку от генезиса по правилам консенсуса.
+// Chainstate с заголовками не связан, ему приходится доверять так же, как генезису
+func checkSnapshotHeaders(headers []*Block) error {
+	if len(headers) == 0 || headers[0].Height != 0 || len(headers[0].PrevBlockHash) != 0 {
+		return fmt.Errorf("%w: headers don't start with genesis", ErrBadSnapshot)
+	}
+
+	index := make(map[string]*Block)
+	lookup := func(hash []byte) *Block {
+		return index[hex.EncodeToString(hash)]
+	}
+
+	for i, header := range headers {
+		err := CheckBlockHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			parent := headers[i-1]
+			if !bytes.Equal(header.PrevBlockHash, parent.Hash) {
+				return fmt.Errorf("%w: header %x doesn't follow %x", ErrBadSnapshot, header.Hash, parent.Hash)
+			}
+			err = checkBlockContext(header, parent, lookup)
+			if err != nil {
+				return err
+			}
+		}
+
+		index[hex.EncodeToString(header.Hash)] = header
+	}
+
+	return nil
+}
+
+// ImportSnapshot creates the blockchain database of the node from the snapshot file.
+// All imported blocks have only headers, as if they were pruned. It returns the height of the tip
+func ImportSnapshot(nodeID, file string) (int, error) {
+	dbFile := dataPath(dbFile, nodeID)
+	if dbExists(dbFile) {
+		return 0, fmt.Errorf("%w: %s", ErrDBExists, dbFile)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	headers, keys, values, err := decodeSnapshot(data)
+	if err != nil {
+		return 0, err
+	}
+	err = checkSnapshotHeaders(headers)
+	if err != nil {
+		return 0, err
+	}
+	tip := headers[len(headers)-1]
+
+	db, err := bolt.Open(dbFile, 0600, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		for _, header := range headers {
+			err = b.Put(header.Hash, header.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+		err = b.Put([]byte("l"), tip.Hash)
+		if err != nil {
+			return err
+		}
+
+		_, err = chainWork(tx, tip.Hash)
+		if err != nil {
+			return err
+		}
+
+		utxo, err := tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+		for i := range keys {
+			err = utxo.Put(keys[i], values[i])
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket([]byte(undoBucket))
+		if err != nil {
+			return err
+		}
+		err = writeChainstateTip(tx, tip.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = writeDBVersion(tx)
+		if err != nil {
+			return err
+		}
+		err = writeNetworkParams(tx)
+		if err != nil {
+			return err
+		}
+
+		return writePruneHeight(tx, tip.Height+1)
+	})
+	db.Close()
+	if err != nil {
+		os.Remove(dbFile)
+		return 0, err
+	}
+
+	return tip.Height, nil
+}
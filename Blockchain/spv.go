@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Легкий клиент хранит только заголовки и подтвержденные транзакции своего кошелька
+const spvDBFile = "spv_%s.db"
+const headersBucket = "headers"
+const headerWorkBucket = "headerwork"
+const walletTxBucket = "wallettxs"
+
+// Ошибки легкого клиента
+var (
+	ErrUnknownParent = errors.New("header doesn't follow a stored header")
+	ErrInvalidProof  = errors.New("invalid Merkle proof")
+)
+
+// HeaderStore keeps the header chain of a light client. The chain with the most work is the main one,
+// the first stored header is trusted as genesis
+type HeaderStore struct {
+	db *bolt.DB
+}
+
+// ConfirmedTx is a wallet transaction proven to be in a block
+type ConfirmedTx struct {
+	BlockHash   []byte
+	Transaction Transaction
+}
+
+// OpenHeaderStore opens the header database of the node, creating it when needed
+func OpenHeaderStore(nodeID string) (*HeaderStore, error) {
+	db, err := bolt.Open(dataPath(spvDBFile, nodeID), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{headersBucket, headerWorkBucket, walletTxBucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &HeaderStore{db}, nil
+}
+
+// Close closes the database
+func (hs *HeaderStore) Close() error {
+	return hs.db.Close()
+}
+
+func headerLookup(tx *bolt.Tx) blockLookup {
+	b := tx.Bucket([]byte(headersBucket))
+
+	return func(hash []byte) *Block {
+		data := b.Get(hash)
+		if data == nil {
+			return nil
+		}
+		header, err := DecodeHeader(data)
+		if err != nil {
+			return nil
+		}
+		return header
+	}
+}
+
+// Tip returns the last header of the main chain, nil when there are no headers
+func (hs *HeaderStore) Tip() *Block {
+	var tip *Block
+
+	hs.db.View(func(tx *bolt.Tx) error {
+		tip = headerLookup(tx)(tx.Bucket([]byte(headersBucket)).Get([]byte("l")))
+		return nil
+	})
+
+	return tip
+}
+
+// Locator returns the block locator of the main chain, empty without headers
+func (hs *HeaderStore) Locator() [][]byte {
+	var locator [][]byte
+
+	hs.db.View(func(tx *bolt.Tx) error {
+		lookup := headerLookup(tx)
+		locator = blockLocator(lookup(tx.Bucket([]byte(headersBucket)).Get([]byte("l"))), lookup)
+		return nil
+	})
+
+	return locator
+}
+
+// AddHeaders validates and stores the headers in order, a chain with more work becomes the main one.
+// It returns the number of new headers
+func (hs *HeaderStore) AddHeaders(headers []*Block) (int, error) {
+	added := 0
+
+	err := hs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(headersBucket))
+		works := tx.Bucket([]byte(headerWorkBucket))
+		lookup := headerLookup(tx)
+
+		for _, header := range headers {
+			if b.Get(header.Hash) != nil {
+				continue
+			}
+
+			err := CheckBlockHeader(header)
+			if err != nil {
+				return err
+			}
+
+			work := NewProofOfWork(header).Work()
+			tipHash := b.Get([]byte("l"))
+			if tipHash == nil {
+				// Генезис принимается на доверии, как и у полного нода, получившего копию базы
+				if header.Height != 0 || len(header.PrevBlockHash) != 0 {
+					return fmt.Errorf("%w: %x is not genesis", ErrUnknownParent, header.Hash)
+				}
+			} else {
+				parent := lookup(header.PrevBlockHash)
+				if parent == nil {
+					return fmt.Errorf("%w: %x follows %x", ErrUnknownParent, header.Hash, header.PrevBlockHash)
+				}
+				err = checkBlockContext(header, parent, lookup)
+				if err != nil {
+					return err
+				}
+				work.Add(work, new(big.Int).SetBytes(works.Get(header.PrevBlockHash)))
+			}
+
+			err = b.Put(header.Hash, header.SerializeHeader())
+			if err != nil {
+				return err
+			}
+			err = works.Put(header.Hash, work.Bytes())
+			if err != nil {
+				return err
+			}
+			if tipHash == nil || work.Cmp(new(big.Int).SetBytes(works.Get(tipHash))) > 0 {
+				err = b.Put([]byte("l"), header.Hash)
+				if err != nil {
+					return err
+				}
+			}
+			added++
+		}
+
+		return nil
+	})
+
+	return added, err
+}
+
+// MainChainHeader returns the header if it is in the main chain
+func (hs *HeaderStore) MainChainHeader(hash []byte) *Block {
+	var found *Block
+
+	hs.db.View(func(tx *bolt.Tx) error {
+		lookup := headerLookup(tx)
+		header := lookup(hash)
+		if header == nil {
+			return nil
+		}
+
+		// Спускаемся от вершины до высоты заголовка
+		block := lookup(tx.Bucket([]byte(headersBucket)).Get([]byte("l")))
+		for block != nil && block.Height > header.Height {
+			block = lookup(block.PrevBlockHash)
+		}
+		if block != nil && bytes.Equal(block.Hash, hash) {
+			found = header
+		}
+		return nil
+	})
+
+	return found
+}
+
+// AddTransaction stores the wallet transaction proven to be in the block
+func (hs *HeaderStore) AddTransaction(blockHash []byte, transaction *Transaction) error {
+	w := &wireWriter{}
+	w.putBytes(blockHash)
+	w.putBytes(transaction.Serialize())
+
+	return hs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(walletTxBucket)).Put(transaction.ID, w.buf.Bytes())
+	})
+}
+
+// ConfirmedTransactions returns the wallet transactions whose blocks are in the main chain
+func (hs *HeaderStore) ConfirmedTransactions() []ConfirmedTx {
+	var stored []ConfirmedTx
+
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(walletTxBucket)).ForEach(func(k, v []byte) error {
+			var blockHash, data []byte
+			err := decodeCanonical(v, func(r *wireReader) {
+				blockHash = r.bytes()
+				data = r.bytes()
+			})
+			if err != nil {
+				return err
+			}
+
+			transaction, err := DecodeTransaction(data)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, ConfirmedTx{blockHash, transaction})
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// После переключения на другую ветку транзакция может оказаться вне основной цепочки
+	var confirmed []ConfirmedTx
+	for _, c := range stored {
+		if hs.MainChainHeader(c.BlockHash) != nil {
+			confirmed = append(confirmed, c)
+		}
+	}
+
+	return confirmed
+}
+
+// Balance sums outputs of the confirmed transactions locked with the keys and not spent by them
+func (hs *HeaderStore) Balance(pubKeyHashes [][]byte) int {
+	confirmed := hs.ConfirmedTransactions()
+
+	spent := make(map[string]bool)
+	for _, c := range confirmed {
+		if c.Transaction.IsCoinbase() {
+			continue
+		}
+		for _, vin := range c.Transaction.Vin {
+			spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+	}
+
+	balance := 0
+	for _, c := range confirmed {
+		for outIdx, out := range c.Transaction.Vout {
+			if spent[fmt.Sprintf("%x:%d", c.Transaction.ID, outIdx)] {
+				continue
+			}
+			for _, pubKeyHash := range pubKeyHashes {
+				if out.IsLockedWithKey(pubKeyHash) {
+					balance += out.Value
+					break
+				}
+			}
+		}
+	}
+
+	return balance
+}
+
+// LightClient syncs headers from full nodes and checks transactions of the wallet by Merkle proofs,
+// without downloading blocks
+type LightClient struct {
+	store        *HeaderStore
+	pubKeyHashes [][]byte
+}
+
+// StartLightClient runs a light client for the wallet of the node, it doesn't return
+func StartLightClient(nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	store, err := OpenHeaderStore(nodeID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	lc := &LightClient{store: store}
+	for _, address := range wallets.GetAddresses() {
+		pubKeyHash := Base58Decode([]byte(address))
+		lc.pubKeyHashes = append(lc.pubKeyHashes, pubKeyHash[1:len(pubKeyHash)-4])
+	}
+	fmt.Printf("Light client for %d addresses, balance: %d\n", len(lc.pubKeyHashes), store.Balance(lc.pubKeyHashes))
+
+	for {
+		for _, addr := range seedNodes {
+			err := lc.run(addr)
+			fmt.Printf("Disconnected from %s: %s\n", addr, err)
+		}
+		time.Sleep(connectInterval)
+	}
+}
+
+// run connects to the full node and syncs with it until the connection is closed
+func (lc *LightClient) run(addr string) error {
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Высота 0, чтобы полный нод не пытался синхронизироваться с нами
+	err = writeMessage(conn, newVersionMessage("", 0, randomNonce()))
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(peerTimeout))
+		msg, err := readMessage(conn)
+		if err == ErrBadChecksum || err == ErrBadEncoding {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = lc.handleMessage(conn, msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (lc *LightClient) handleMessage(conn net.Conn, msg *message) error {
+	switch msg.Command {
+	case "version":
+		return writeMessage(conn, newMessage("verack", &verack{}))
+	case "verack":
+		return writeMessage(conn, newMessage("getheaders", &getheaders{lc.store.Locator()}))
+	case "ping":
+		var payload ping
+		if msg.decode(&payload) != nil {
+			return nil
+		}
+		return writeMessage(conn, newMessage("pong", &payload))
+	case "inv":
+		var payload inv
+		if msg.decode(&payload) != nil || payload.Type != "block" {
+			return nil
+		}
+		return writeMessage(conn, newMessage("getheaders", &getheaders{lc.store.Locator()}))
+	case "headers":
+		var payload headers
+		err := msg.decode(&payload)
+		if err != nil {
+			return err
+		}
+		return lc.handleHeaders(conn, payload.Headers)
+	case "merkleproof":
+		var payload merkleproof
+		err := msg.decode(&payload)
+		if err != nil {
+			return err
+		}
+		return lc.handleMerkleProof(&payload)
+	}
+
+	return nil
+}
+
+// handleHeaders stores the headers and requests the next ones, or the wallet transactions when they are over
+func (lc *LightClient) handleHeaders(conn net.Conn, items [][]byte) error {
+	var received []*Block
+	for _, data := range items {
+		header, err := DecodeHeader(data)
+		if err != nil {
+			return err
+		}
+		received = append(received, header)
+	}
+
+	added, err := lc.store.AddHeaders(received)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == maxHeadersPerMessage {
+		return writeMessage(conn, newMessage("getheaders", &getheaders{[][]byte{received[len(received)-1].Hash}}))
+	}
+
+	if tip := lc.store.Tip(); tip != nil {
+		fmt.Printf("Added %d headers, best height is %d\n", added, tip.Height)
+	}
+
+	return writeMessage(conn, newMessage("getmerkleproof", &getmerkleproof{PubKeyHashes: lc.pubKeyHashes}))
+}
+
+// handleMerkleProof checks that the transaction is in a main chain block and stores it
+func (lc *LightClient) handleMerkleProof(payload *merkleproof) error {
+	tx, err := DecodeTransaction(payload.Transaction)
+	if err != nil {
+		return err
+	}
+
+	header := lc.store.MainChainHeader(payload.BlockHash)
+	if header == nil {
+		fmt.Printf("Block %x of transaction %x is not in our main chain\n", payload.BlockHash, tx.ID)
+		return nil
+	}
+	if !header.hasMerkleRoot() {
+		fmt.Printf("Block %x has no Merkle root, transaction %x can't be checked\n", header.Hash, tx.ID)
+		return nil
+	}
+
+	if tx.Version != txVersionLegacy && !bytes.Equal(tx.ID, tx.Hash()) {
+		return fmt.Errorf("%w: transaction %x has a wrong ID", ErrInvalidProof, tx.ID)
+	}
+	if !VerifyProof(header.MerkleRoot, tx.merkleData(), &MerkleProof{payload.Index, payload.Hashes}) {
+		return fmt.Errorf("%w: transaction %x in block %x", ErrInvalidProof, tx.ID, header.Hash)
+	}
+
+	err = lc.store.AddTransaction(header.Hash, &tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %s is confirmed at height %d, balance: %d\n",
+		hex.EncodeToString(tx.ID), header.Height, lc.store.Balance(lc.pubKeyHashes))
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLightClientChecksTransactionsByProofs(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner, other := NewWallet(), NewWallet()
+	address := string(owner.GetAddress())
+	bc, _ := syncedChains(t, address)
+
+	bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", 1, 0)})
+	payment := testPayment(t, owner, string(other.GetAddress()), 7, 1, bc)
+	bc.MineBlock([]*Transaction{NewCoinbaseTX(string(other.GetAddress()), "", 2, 1), payment})
+
+	store, err := OpenHeaderStore("spv")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// Без генезиса цепочку заголовков не с чего начать
+	headers := testHeaders(t, bc, nil, maxHeadersPerMessage)
+	_, err = store.AddHeaders(headers[1:])
+	assert.ErrorIs(t, err, ErrUnknownParent)
+
+	added, err := store.AddHeaders(headers)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added)
+	assert.Equal(t, testTip(t, bc), store.Tip().Hash)
+	assert.Equal(t, testLocator(t, bc), store.Locator())
+
+	for _, header := range headers {
+		block, err := bc.GetBlock(header.Hash)
+		assert.NoError(t, err)
+
+		for _, tx := range block.Transactions {
+			proof, err := block.MerkleProof(tx.ID)
+			assert.NoError(t, err)
+			assert.True(t, VerifyProof(store.MainChainHeader(block.Hash).MerkleRoot, tx.merkleData(), proof))
+
+			// Доказательство не подходит к другим блокам
+			assert.False(t, VerifyProof(headers[0].MerkleRoot, tx.merkleData(), proof) && block.Height != 0)
+
+			assert.NoError(t, store.AddTransaction(block.Hash, tx))
+		}
+	}
+
+	ownerHash := HashPubKey(owner.PublicKey)
+	assert.Equal(t, 20-7-1, store.Balance([][]byte{ownerHash}))
+
+	outputs, err := (UTXOSet{bc}).FindUTXO(ownerHash)
+	assert.NoError(t, err)
+	balance := 0
+	for _, out := range outputs {
+		balance += out.Value
+	}
+	assert.Equal(t, balance, store.Balance([][]byte{ownerHash}))
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Headers-first sync parameters
+const (
+	maxHeadersPerMessage    = 2000
+	maxLocatorSize          = 100
+	maxBlocksInFlight       = 16   // Blocks requested from one peer at a time
+	blockDownloadWindow     = 1024 // Blocks are requested at most so far ahead of the next one to connect
+	blockDownloadTimeout    = 20 * time.Second
+	headersTimeout          = 30 * time.Second
+	maxBlockDownloadRetries = 5
+	syncCheckInterval       = time.Second
+)
+
+// ErrUnconnectedHeaders is returned for headers which don't continue the known chain
+var ErrUnconnectedHeaders = errors.New("headers don't connect to the known chain")
+
+// SyncManager downloads blocks from peers whose chain has more work, headers first.
+// The header chain is requested from one peer and validated, then the blocks are requested
+// from all peers having them, several at a time, and connected in chain order as they arrive.
+// Requests that time out are sent to other sm.node.peers. It is safe for concurrent use
+type SyncManager struct {
+	node *Node
+	bc   *Blockchain
+	lock sync.Mutex
+
+	headersPeer *Peer // Peer the headers are requested from, nil when they aren't
+	headersSent time.Time
+	source      *Peer // Peer the header chain came from, it has all its blocks
+	waiting     *Peer // Peer that announced blocks during another sync, it is synced with next
+
+	headers  []*Block          // Validated headers after the stored blocks, in chain order
+	index    map[string]*Block // The same headers by hash
+	next     int               // Index in headers of the next block to connect
+	requests map[string]*blockRequest
+	received map[string]receivedBlock // Downloaded blocks waiting for their parents to be connected
+	retries  map[string]int
+}
+
+// blockRequest is a block requested from a peer
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+type receivedBlock struct {
+	block *Block
+	peer  *Peer
+}
+
+// NewSyncManager creates a sync manager adding downloaded blocks to the blockchain of the node.
+// The node calls checkTimeouts every syncCheckInterval
+func NewSyncManager(n *Node) *SyncManager {
+	sm := &SyncManager{node: n, bc: n.bc}
+	sm.reset()
+
+	return sm
+}
+
+// Start begins syncing with the peer which has blocks we don't. During another sync the peer waits for it to finish,
+// but takes part in downloading blocks
+func (sm *SyncManager) Start(p *Peer) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if sm.headersPeer != nil || len(sm.headers) > 0 {
+		if sm.headersPeer != p && sm.source != p {
+			sm.waiting = p
+		}
+		sm.requestBlocks()
+		return
+	}
+
+	sm.requestChain(p)
+}
+
+// IsSyncing checks whether headers or blocks are being downloaded
+func (sm *SyncManager) IsSyncing() bool {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	return sm.headersPeer != nil || len(sm.headers) > 0
+}
+
+// requestChain запрашивает у пира заголовки блоков после нашей основной цепочки
+func (sm *SyncManager) requestChain(p *Peer) {
+	locator, err := sm.bc.BlockLocator()
+	if err != nil {
+		sm.node.log.Error("Sync is stopped", "err", err)
+		return
+	}
+
+	sm.requestHeaders(p, locator)
+}
+
+func (sm *SyncManager) requestHeaders(p *Peer, locator [][]byte) {
+	sm.headersPeer = p
+	sm.headersSent = time.Now()
+
+	p.Send(newMessage("getheaders", &getheaders{locator}))
+}
+
+// HandleHeaders accepts headers requested from the peer. A full message means there are more of them,
+// otherwise the header chain is complete and its blocks are downloaded when it has more work than ours
+func (sm *SyncManager) HandleHeaders(p *Peer, items [][]byte) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	// Заголовки, которые мы не запрашивали, не нужны
+	if p != sm.headersPeer {
+		return
+	}
+
+	if len(items) > maxHeadersPerMessage {
+		sm.node.peers.Misbehaving(p, banThreshold/2, fmt.Sprintf("%d headers in a message", len(items)))
+		sm.reset()
+		return
+	}
+
+	var received []*Block
+	for _, data := range items {
+		header, err := DecodeHeader(data)
+		if err != nil {
+			sm.node.peers.Misbehaving(p, banThreshold/2, fmt.Sprintf("broken header: %s", err))
+			sm.reset()
+			return
+		}
+		received = append(received, header)
+	}
+
+	err := sm.acceptHeaders(received)
+	if err != nil {
+		sm.node.log.Warn("Headers are rejected", "peer", p, "err", err)
+
+		score := banThreshold / 2
+		var ruleErr RuleError
+		if errors.As(err, &ruleErr) {
+			score = blockBanScore(ruleErr)
+		}
+		sm.node.peers.Misbehaving(p, score, "invalid headers")
+		sm.reset()
+		return
+	}
+
+	if len(received) == maxHeadersPerMessage {
+		sm.requestHeaders(p, [][]byte{received[len(received)-1].Hash})
+		return
+	}
+
+	sm.headersPeer = nil
+	sm.source = p
+	sm.startDownload()
+}
+
+// acceptHeaders validates the headers and appends them to the header chain.
+// Headers of stored blocks are skipped, the rest have to continue the header chain
+func (sm *SyncManager) acceptHeaders(received []*Block) error {
+	return sm.bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		stored := dbBlockLookup(tx)
+		lookup := func(hash []byte) *Block {
+			if header := sm.index[hex.EncodeToString(hash)]; header != nil {
+				return header
+			}
+			return stored(hash)
+		}
+
+		for _, header := range received {
+			if b.Get(header.Hash) != nil || sm.index[hex.EncodeToString(header.Hash)] != nil {
+				continue
+			}
+
+			// Первый заголовок продолжает сохраненный блок, следующие - предыдущий заголовок
+			var parent *Block
+			if len(sm.headers) == 0 {
+				parent = stored(header.PrevBlockHash)
+			} else if last := sm.headers[len(sm.headers)-1]; bytes.Equal(last.Hash, header.PrevBlockHash) {
+				parent = last
+			}
+			if parent == nil {
+				return fmt.Errorf("%w: %x follows unknown %x", ErrUnconnectedHeaders, header.Hash, header.PrevBlockHash)
+			}
+
+			err := CheckBlockHeader(header)
+			if err != nil {
+				return err
+			}
+			err = checkBlockContext(header, parent, lookup)
+			if err != nil {
+				return err
+			}
+
+			sm.headers = append(sm.headers, header)
+			sm.index[hex.EncodeToString(header.Hash)] = header
+		}
+
+		return nil
+	})
+}
+
+// startDownload starts downloading blocks of the complete header chain if it has more work than the main chain
+func (sm *SyncManager) startDownload() {
+	if len(sm.headers) == 0 {
+		sm.node.log.Info("Peer has no new blocks", "peer", sm.source)
+		sm.reset()
+		return
+	}
+
+	work, err := sm.bc.ChainWork(sm.headers[0].PrevBlockHash)
+	if err != nil {
+		sm.node.log.Error("Sync is stopped", "err", err)
+		sm.reset()
+		return
+	}
+	for _, header := range sm.headers {
+		work.Add(work, NewProofOfWork(header).Work())
+	}
+
+	tip, err := sm.bc.TipHash()
+	if err != nil {
+		sm.node.log.Error("Sync is stopped", "err", err)
+		sm.reset()
+		return
+	}
+	tipWork, err := sm.bc.ChainWork(tip)
+	if err != nil {
+		sm.node.log.Error("Sync is stopped", "err", err)
+		sm.reset()
+		return
+	}
+	if work.Cmp(tipWork) <= 0 {
+		sm.node.log.Info("Chain of the peer doesn't have more work than ours", "peer", sm.source)
+		sm.reset()
+		return
+	}
+
+	last := sm.headers[len(sm.headers)-1]
+	sm.node.log.Info("Downloading blocks", "peer", sm.source, "count", len(sm.headers), "height", last.Height)
+	sm.requestBlocks()
+}
+
+// requestBlocks requests the following blocks from the peers which have free slots
+func (sm *SyncManager) requestBlocks() {
+	if len(sm.headers) == 0 || sm.headersPeer != nil {
+		return
+	}
+
+	inFlight := make(map[*Peer]int)
+	for _, request := range sm.requests {
+		inFlight[request.peer]++
+	}
+
+	var candidates []*Peer
+	for _, p := range sm.node.peers.Peers() {
+		if !p.Closed() {
+			candidates = append(candidates, p)
+		}
+	}
+
+	end := sm.next + blockDownloadWindow
+	if end > len(sm.headers) {
+		end = len(sm.headers)
+	}
+
+	for _, header := range sm.headers[sm.next:end] {
+		key := hex.EncodeToString(header.Hash)
+		if sm.requests[key] != nil {
+			continue
+		}
+		if _, ok := sm.received[key]; ok {
+			continue
+		}
+
+		p := sm.pickPeer(candidates, inFlight, header)
+		if p == nil {
+			return
+		}
+
+		sm.requests[key] = &blockRequest{p, time.Now()}
+		inFlight[p]++
+		p.Send(newMessage("getdata", &getdata{sm.node.address, "block", header.Hash}))
+	}
+}
+
+// pickPeer returns the least busy peer which should have the block, nil when all of them are busy
+func (sm *SyncManager) pickPeer(candidates []*Peer, inFlight map[*Peer]int, header *Block) *Peer {
+	var best *Peer
+
+	for _, p := range candidates {
+		if inFlight[p] >= maxBlocksInFlight {
+			continue
+		}
+		if p != sm.source && (p.version == nil || p.version.BestHeight < header.Height) {
+			continue
+		}
+		if best == nil || inFlight[p] < inFlight[best] {
+			best = p
+		}
+	}
+
+	return best
+}
+
+// HandleBlock accepts a block requested by the sync and returns false for the blocks it didn't request
+func (sm *SyncManager) HandleBlock(p *Peer, block *Block) bool {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	key := hex.EncodeToString(block.Hash)
+	if sm.requests[key] == nil {
+		return false
+	}
+	delete(sm.requests, key)
+
+	// Блок должен совпадать с проверенным заголовком, иначе запрашиваем его заново
+	if !bytes.Equal(block.SerializeHeader(), sm.index[key].SerializeHeader()) {
+		sm.node.peers.Misbehaving(p, banThreshold, "block doesn't match its header")
+		sm.requestBlocks()
+		return true
+	}
+
+	sm.received[key] = receivedBlock{block, p}
+	sm.connectBlocks()
+	sm.requestBlocks()
+
+	return true
+}
+
+// connectBlocks adds downloaded blocks to the blockchain while the next one is there,
+// the UTXO set is updated block by block
+func (sm *SyncManager) connectBlocks() {
+	for sm.next < len(sm.headers) {
+		key := hex.EncodeToString(sm.headers[sm.next].Hash)
+		received, ok := sm.received[key]
+		if !ok {
+			return
+		}
+		delete(sm.received, key)
+
+		err := sm.bc.AddBlock(received.block)
+		if err != nil {
+			sm.node.metrics.BlocksRejected.Inc()
+			sm.node.log.Warn("Block is rejected", "peer", received.peer, "block", received.block.Hash, "err", err)
+
+			var ruleErr RuleError
+			if errors.As(err, &ruleErr) {
+				sm.node.peers.Misbehaving(received.peer, blockBanScore(ruleErr), "invalid block")
+			}
+
+			// Заголовки привели к невалидному блоку, остальная их цепочка тоже не нужна
+			sm.reset()
+			return
+		}
+
+		// Намайненные транзакции и конфликтующие с ними больше не нужны
+		sm.node.mempool.RemoveBlock(received.block)
+		sm.next++
+	}
+
+	// Остальным нодам достаточно узнать о последнем блоке, заголовки до него они запросят сами
+	last := sm.headers[len(sm.headers)-1]
+	sm.node.log.Info("Sync is finished", "height", last.Height)
+	sm.node.relayBlock(last.Hash, sm.source.Addr())
+	sm.reset()
+}
+
+// checkTimeouts drops requests which took too long or whose peers disconnected and sends them to other peers
+func (sm *SyncManager) checkTimeouts() {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	now := time.Now()
+
+	if sm.headersPeer != nil {
+		if sm.headersPeer.Closed() || now.Sub(sm.headersSent) > headersTimeout {
+			sm.node.log.Warn("Headers timed out", "peer", sm.headersPeer)
+
+			stalled := sm.headersPeer
+			sm.reset()
+			if other := sm.peerAhead(stalled); sm.headersPeer == nil && other != nil {
+				sm.requestChain(other)
+			}
+		}
+		return
+	}
+
+	if len(sm.headers) == 0 {
+		return
+	}
+
+	for key, request := range sm.requests {
+		if !request.peer.Closed() && now.Sub(request.sent) < blockDownloadTimeout {
+			continue
+		}
+		delete(sm.requests, key)
+
+		sm.retries[key]++
+		if sm.retries[key] > maxBlockDownloadRetries {
+			sm.node.log.Error("Block can't be downloaded, sync is stopped", "block", key)
+			sm.reset()
+			return
+		}
+		sm.node.log.Warn("Block timed out", "peer", request.peer, "block", key)
+	}
+
+	sm.requestBlocks()
+}
+
+// peerAhead returns a connected peer other than except which announced a higher chain than ours
+func (sm *SyncManager) peerAhead(except *Peer) *Peer {
+	bestHeight, err := sm.bc.GetBestHeight()
+	if err != nil {
+		return nil
+	}
+
+	for _, p := range sm.node.peers.Peers() {
+		if p != except && !p.Closed() && p.version != nil && p.version.BestHeight > bestHeight {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// reset forgets the current sync and starts syncing with the waiting peer
+func (sm *SyncManager) reset() {
+	sm.headersPeer = nil
+	sm.source = nil
+	sm.headers = nil
+	sm.index = make(map[string]*Block)
+	sm.next = 0
+	sm.requests = make(map[string]*blockRequest)
+	sm.received = make(map[string]receivedBlock)
+	sm.retries = make(map[string]int)
+
+	if sm.waiting != nil {
+		p := sm.waiting
+		sm.waiting = nil
+		if !p.Closed() {
+			sm.requestChain(p)
+		}
+	}
+}
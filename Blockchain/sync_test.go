@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncedChains создает два блокчейна с общим генезисом во временном каталоге
+func syncedChains(t *testing.T, address string) (*Blockchain, *Blockchain) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	bc, err := CreateBlockchain(address, "a", false)
+	assert.NoError(t, err)
+	bc.db.Close()
+
+	data, err := ioutil.ReadFile("blockchain_a.db")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile("blockchain_b.db", data, 0600))
+
+	a, err := NewBlockchain("a")
+	assert.NoError(t, err)
+	b, err := NewBlockchain("b")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		a.db.Close()
+		b.db.Close()
+	})
+
+	return a, b
+}
+
+// Обертки над чтением цепочки для тестов: ошибка базы проваливает тест
+func testTip(t *testing.T, bc *Blockchain) []byte {
+	tip, err := bc.TipHash()
+	assert.NoError(t, err)
+
+	return tip
+}
+
+func testHeight(t *testing.T, bc *Blockchain) int {
+	height, err := bc.GetBestHeight()
+	assert.NoError(t, err)
+
+	return height
+}
+
+func testLocator(t *testing.T, bc *Blockchain) [][]byte {
+	locator, err := bc.BlockLocator()
+	assert.NoError(t, err)
+
+	return locator
+}
+
+func testHeaders(t *testing.T, bc *Blockchain, locator [][]byte, max int) []*Block {
+	headers, err := bc.HeadersAfter(locator, max)
+	assert.NoError(t, err)
+
+	return headers
+}
+
+func testCountUTXO(t *testing.T, bc *Blockchain) int {
+	count, err := UTXOSet{bc}.CountTransactions()
+	assert.NoError(t, err)
+
+	return count
+}
+
+// testMine майнит блок с транзакциями поверх вершины
+func testMine(t *testing.T, bc *Blockchain, txs ...*Transaction) *Block {
+	block, err := bc.MineBlock(txs)
+	assert.NoError(t, err)
+
+	return block
+}
+
+// testPayment создает подписанную транзакцию с выходов кошелька
+func testPayment(t *testing.T, wallet *Wallet, to string, amount, fee int, bc *Blockchain) *Transaction {
+	tx, err := NewUTXOTransaction(wallet, to, amount, fee, &UTXOSet{bc})
+	assert.NoError(t, err)
+
+	return tx
+}
+
+// testPeer создает готовый к работе пир нода, отправленные ему сообщения остаются в очереди
+func testPeer(n *Node, bestHeight int) *Peer {
+	conn, _ := net.Pipe()
+	p := newPeer(conn, "localhost:1", false)
+	p.version = &verzion{Version: nodeVersion, BestHeight: bestHeight}
+	p.verackDone = true
+
+	n.peers.peers[p] = true
+	n.peers.ready[p] = true
+
+	return p
+}
+
+func TestHeadersFirstSync(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	a, b := syncedChains(t, address)
+	for i := 0; i < 5; i++ {
+		a.MineBlock([]*Transaction{NewCoinbaseTX(address, "", i+1, 0)})
+	}
+
+	locator := testLocator(t, a)
+	assert.Len(t, locator, 6)
+	assert.Equal(t, testTip(t, a), locator[0])
+	assert.Equal(t, testTip(t, b), locator[5], "Genesis is always in the locator")
+
+	headers := testHeaders(t, a, testLocator(t, b), maxHeadersPerMessage)
+	assert.Len(t, headers, 5)
+	assert.Equal(t, 1, headers[0].Height)
+	assert.Nil(t, headers[0].Transactions)
+	assert.Len(t, testHeaders(t, a, testLocator(t, b), 2), 2)
+	assert.Empty(t, testHeaders(t, a, locator, maxHeadersPerMessage))
+
+	node := newNode(b, "localhost:2", nil)
+	p := testPeer(node, 5)
+	sm := node.sync
+
+	// Заголовок с измененным nonce не проходит proof-of-work, а без родителя не принимается
+	tampered := *headers[2]
+	tampered.Nonce++
+	assert.Error(t, sm.acceptHeaders([]*Block{headers[0], headers[1], &tampered}))
+	sm.reset()
+	assert.ErrorIs(t, sm.acceptHeaders(headers[1:]), ErrUnconnectedHeaders)
+	sm.reset()
+
+	var items [][]byte
+	for _, header := range headers {
+		items = append(items, header.SerializeHeader())
+	}
+	sm.requestHeaders(p, testLocator(t, b))
+	sm.HandleHeaders(p, items)
+	assert.Len(t, sm.requests, 5, "All blocks are requested at once")
+	assert.Len(t, p.send, 6)
+
+	unrequested := NewBlock([]*Transaction{NewCoinbaseTX(address, "", 1, 0)}, testTip(t, b), 1, BigToCompact(initialTarget))
+	assert.False(t, sm.HandleBlock(p, unrequested))
+
+	// Блоки приходят в обратном порядке и подключаются, когда приходит первый
+	for i := len(headers) - 1; i >= 0; i-- {
+		assert.Equal(t, 0, testHeight(t, b))
+
+		block, err := a.GetBlock(headers[i].Hash)
+		assert.NoError(t, err)
+		assert.True(t, sm.HandleBlock(p, &block))
+	}
+
+	assert.Equal(t, 5, testHeight(t, b))
+	assert.Equal(t, testTip(t, a), testTip(t, b))
+	assert.False(t, sm.IsSyncing())
+	assert.Equal(t, testCountUTXO(t, a), testCountUTXO(t, b))
+}
@@ -3,25 +3,54 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"math/big"
+	"strconv"
 	"strings"
 
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 )
 
+// Награда за первые блоки, она делится пополам каждые halvingInterval блоков
 const subsidy = 10
 
+// Параметры выпуска монет задаются сетью
+var (
+	halvingInterval  = 210000
+	coinbaseMaturity = 100 // Сколько блоков должно пройти, прежде чем награду за блок можно потратить
+)
+
+// blockSubsidy returns the reward for the block at the height, fees of its transactions are paid on top of it
+func blockSubsidy(height int) int {
+	halvings := height / halvingInterval
+	if halvings >= strconv.IntSize-1 {
+		return 0
+	}
+
+	return subsidy >> uint(halvings)
+}
+
+// maxSupply returns the number of coins ever issued, no transaction output can exceed it
+func maxSupply() int {
+	supply := 0
+	for height := 0; blockSubsidy(height) > 0; height += halvingInterval {
+		supply += blockSubsidy(height) * halvingInterval
+	}
+
+	return supply
+}
+
 // Transaction represents a Bitcoin transaction
 type Transaction struct {
-	ID   []byte     // Идентификатор транзакции
-	Vin  []TXInput  // Выходы предыдущей транзации (входы текущей)
-	Vout []TXOutput // Входы следующей транзакции (выходы текущей)
+	Version  uint32     // Версия определяет, как считаются хэш и подписи
+	ID       []byte     // Идентификатор транзакции
+	Vin      []TXInput  // Выходы предыдущей транзации (входы текущей)
+	Vout     []TXOutput // Входы следующей транзакции (выходы текущей)
+	LockTime int64      // Высота блока или время, до которых транзакцию нельзя включить в блок
 }
 
 // IsCoinbase checks whether the transaction is coinbase
@@ -29,32 +58,46 @@ func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// Serialize returns a serialized Transaction
+// Serialize returns the canonical encoding of the Transaction
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	w := &wireWriter{}
+	tx.encode(w, true, true)
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return encoded.Bytes()
+	return w.buf.Bytes()
 }
 
-// Hash returns the hash of the Transaction
+// Hash returns the hash of the Transaction, it is used as the ID.
+// Unlocking scripts are not hashed, so the ID is known before signing and doesn't change with signatures.
+// Version 0 transactions are hashed over gob with everything but the ID, as they were created
 func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
+	if tx.Version == txVersionLegacy {
+		txCopy := *tx
+		txCopy.ID = []byte{}
+		hash := sha256.Sum256(txCopy.legacyGob())
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
+		return hash[:]
+	}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	w := &wireWriter{}
+	tx.encode(w, false, false)
 
-	return hash[:]
+	return doubleSHA256(w.buf.Bytes())
 }
 
-// Sign signs each input of a Transaction
+// merkleData returns the data representing the transaction in the merkle tree of its block
+func (tx *Transaction) merkleData() []byte {
+	if tx.Version == txVersionLegacy {
+		return tx.legacyGob()
+	}
+
+	w := &wireWriter{}
+	tx.encode(w, false, true)
+
+	return w.buf.Bytes()
+}
+
+// Sign signs inputs of a Transaction spending outputs locked to the key.
+// Inputs of other owners are left for them, as with multisig and partially signed transactions
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
 	if tx.IsCoinbase() {
 		return
@@ -66,24 +109,201 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
+	pubKey := publicKeyBytes(privKey)
+	pubKeyHash := HashPubKey(pubKey)
 
-	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+	for inID, vin := range tx.Vin {
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		script := prevOut.LockingScript()
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+		hash, ok := extractPubKeyHash(script)
+		if !ok || bytes.Compare(hash, pubKeyHash) != 0 {
+			continue
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		signature, err := tx.SignInput(inID, script, privKey, sigHashAll)
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		tx.Vin[inID].ScriptSig = (&ScriptBuilder{}).AddData(signature).AddData(pubKey).Script()
+	}
+}
+
+// SignInput returns the signature of the input, subscript is the locking script of the spent output
+// or the redeem script for P2SH
+func (tx *Transaction) SignInput(inputIndex int, subscript []byte, privKey ecdsa.PrivateKey, hashType byte) ([]byte, error) {
+	hash, err := tx.sigHash(inputIndex, subscript, hashType)
+	if err != nil {
+		return nil, err
+	}
 
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		return nil, err
 	}
+
+	// r и s дополняются до 32 байт, чтобы подпись всегда имела одну длину
+	signature := make([]byte, 65)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:64])
+	signature[64] = hashType
+
+	return signature, nil
+}
+
+// Signature hash types, the last byte of a signature. The base type chooses the signed outputs:
+// all of them, none, or the one with the index of the input. With sigHashAnyoneCanPay
+// the signature covers only its own input, so others can add inputs later
+const (
+	sigHashAll          = byte(1)
+	sigHashNone         = byte(2)
+	sigHashSingle       = byte(3)
+	sigHashAnyoneCanPay = byte(0x80)
+)
+
+// ErrSigHashType is returned for unknown hash types and the ones the transaction can't be signed with
+var ErrSigHashType = errors.New("unsupported signature hash type")
+
+var sigHashNames = map[byte]string{
+	sigHashAll:    "ALL",
+	sigHashNone:   "NONE",
+	sigHashSingle: "SINGLE",
+}
+
+// ParseSigHashType parses a hash type name like ALL or SINGLE|ANYONECANPAY
+func ParseSigHashType(name string) (byte, error) {
+	var hashType byte
+
+	upper := strings.ToUpper(name)
+	base := strings.TrimSuffix(upper, "|ANYONECANPAY")
+	if base != upper {
+		hashType = sigHashAnyoneCanPay
+	}
+	for value, valueName := range sigHashNames {
+		if valueName == base {
+			return hashType | value, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrSigHashType, name)
+}
+
+// sigHash returns the hash signed for the input. It is double SHA-256 of:
+//
+//	version (4) | inputs | outputs | lock time (8) | hash type (4)
+//	input  := txid | vout (8) | subscript for the signed input, empty for others
+//	output := value (8) | pubkey hash | script
+//
+// The inputs are all inputs, or only the signed one with sigHashAnyoneCanPay.
+// The outputs are chosen by the base type, sigHashSingle fails when the input has no output with its index.
+// Version 0 transactions sign the gob encoding instead, they support only sigHashAll
+func (tx *Transaction) sigHash(inputIndex int, subscript []byte, hashType byte) ([]byte, error) {
+	base := hashType &^ sigHashAnyoneCanPay
+	if _, ok := sigHashNames[base]; !ok {
+		return nil, fmt.Errorf("%w: %#x", ErrSigHashType, hashType)
+	}
+	if inputIndex < 0 || inputIndex >= len(tx.Vin) {
+		return nil, fmt.Errorf("input %d of %d", inputIndex, len(tx.Vin))
+	}
+
+	if tx.Version == txVersionLegacy {
+		if hashType != sigHashAll {
+			return nil, fmt.Errorf("%w: version 0 transactions are signed with ALL only", ErrSigHashType)
+		}
+
+		txCopy := tx.TrimmedCopy()
+		txCopy.ID = nil
+		txCopy.Vin[inputIndex].ScriptSig = subscript
+
+		return doubleSHA256(append(gobEncode(txCopy.scriptLayout()), hashType)), nil
+	}
+
+	inputs := tx.Vin
+	signed := inputIndex
+	if hashType&sigHashAnyoneCanPay != 0 {
+		inputs = tx.Vin[inputIndex : inputIndex+1]
+		signed = 0
+	}
+
+	var outputs []TXOutput
+	switch base {
+	case sigHashAll:
+		outputs = tx.Vout
+	case sigHashSingle:
+		if inputIndex >= len(tx.Vout) {
+			return nil, fmt.Errorf("%w: SINGLE for input %d of a transaction with %d outputs", ErrSigHashType, inputIndex, len(tx.Vout))
+		}
+		outputs = tx.Vout[inputIndex : inputIndex+1]
+	}
+
+	w := &wireWriter{}
+	w.putUint32(tx.Version)
+
+	w.putCount(len(inputs))
+	for i, vin := range inputs {
+		w.putBytes(vin.Txid)
+		w.putInt64(int64(vin.Vout))
+		if i == signed {
+			w.putBytes(subscript)
+		} else {
+			w.putBytes(nil)
+		}
+	}
+
+	w.putCount(len(outputs))
+	for _, vout := range outputs {
+		w.putInt64(int64(vout.Value))
+		w.putBytes(vout.PubKeyHash)
+		w.putBytes(vout.Script)
+	}
+
+	w.putInt64(tx.LockTime)
+	w.putUint32(uint32(hashType))
+
+	return doubleSHA256(w.buf.Bytes()), nil
+}
+
+// legacySigHash is what legacy inputs signed. They signed fmt.Sprintf("%x\n", txCopy), which formats
+// String() of the transaction, and ECDSA takes only the first 32 bytes: the hex of "--- Transaction ".
+// Such signatures don't commit to the transaction, so they are accepted only in blocks to keep existing chains valid
+var legacySigHash = []byte(hex.EncodeToString([]byte("--- Transaction ")))
+
+// txSigChecker checks signatures of one input of the transaction
+type txSigChecker struct {
+	tx         *Transaction
+	inputIndex int
+	legacy     bool
+}
+
+func (c *txSigChecker) checkSig(signature, pubKey, subscript []byte) bool {
+	key, ok := parsePublicKey(pubKey)
+	if !ok || len(signature) == 0 {
+		return false
+	}
+
+	var hash []byte
+	if c.legacy {
+		hash = legacySigHash
+	} else {
+		if len(signature) != 65 {
+			return false
+		}
+		var err error
+		hash, err = c.tx.sigHash(c.inputIndex, subscript, signature[64])
+		if err != nil {
+			return false
+		}
+		signature = signature[:64]
+	}
+
+	r := new(big.Int).SetBytes(signature[:len(signature)/2])
+	s := new(big.Int).SetBytes(signature[len(signature)/2:])
+
+	return ecdsa.Verify(key, hash, r, s)
+}
+
+func (c *txSigChecker) lockTime() int64 {
+	return c.tx.LockTime
 }
 
 // String returns a human-readable representation of a transaction
@@ -91,12 +311,20 @@ func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	lines = append(lines, fmt.Sprintf("     Version:   %d", tx.Version))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     Lock time: %d", tx.LockTime))
+	}
 
 	for i, input := range tx.Vin {
 
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
+		if len(input.ScriptSig) > 0 {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %s", DisassembleScript(input.ScriptSig)))
+			continue
+		}
 		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
 		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
 	}
@@ -104,7 +332,7 @@ func (tx Transaction) String() string {
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisassembleScript(output.LockingScript())))
 	}
 
 	return strings.Join(lines, "\n")
@@ -116,14 +344,14 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{Txid: vin.Txid, Vout: vin.Vout})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{Value: vout.Value, PubKeyHash: vout.PubKeyHash, Script: vout.Script})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{Version: tx.Version, ID: tx.ID, Vin: inputs, Vout: outputs, LockTime: tx.LockTime}
 
 	return txCopy
 }
@@ -140,40 +368,117 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
+	prevOuts := make([]TXOutput, len(tx.Vin))
 	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false
+		}
+		prevOuts[inID] = prevTx.Vout[vin.Vout]
+	}
+
+	return tx.VerifyOutputs(prevOuts)
+}
+
+// VerifyOutputs verifies signatures of Transaction inputs against the outputs they spend,
+// prevOuts[i] is the output referenced by tx.Vin[i]
+func (tx *Transaction) VerifyOutputs(prevOuts []TXOutput) bool {
+	return tx.VerifyScripts(prevOuts) == nil
+}
 
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
+// VerifyScripts runs scripts of every input, the error tells why an input can't be spent
+func (tx *Transaction) VerifyScripts(prevOuts []TXOutput) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
+	if len(prevOuts) != len(tx.Vin) {
+		return fmt.Errorf("%w: %d inputs, %d spent outputs", ErrScriptFailed, len(tx.Vin), len(prevOuts))
+	}
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+	for inID := range tx.Vin {
+		err := tx.VerifyInput(inID, prevOuts[inID])
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
+		}
+	}
 
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
-			return false
+	return nil
+}
+
+// VerifyInput runs the unlocking script of the input followed by the locking script of the output.
+// For P2SH outputs the redeem script, the last pushed element, runs on the rest of the stack afterwards
+func (tx *Transaction) VerifyInput(inputIndex int, prevOut TXOutput) error {
+	vin := tx.Vin[inputIndex]
+	lockingScript := prevOut.LockingScript()
+	checker := &txSigChecker{tx: tx, inputIndex: inputIndex}
+
+	unlockingScript := vin.ScriptSig
+	if vin.isLegacyInput() {
+		// Вход старого формата: подпись и ключ кладутся на стек как в P2PKH
+		if len(prevOut.Script) > 0 {
+			return fmt.Errorf("%w: legacy input spends a script output", ErrScriptFailed)
 		}
-		txCopy.Vin[inID].PubKey = nil
+		checker.legacy = true
+		unlockingScript = (&ScriptBuilder{}).AddData(vin.Signature).AddData(vin.PubKey).Script()
+	}
+
+	// Только данные, иначе скрипт входа мог бы обойти проверки скрипта выхода
+	if !isPushOnly(unlockingScript) {
+		return fmt.Errorf("%w: unlocking script is not push only", ErrScriptFailed)
+	}
+
+	engine := &scriptEngine{checker: checker, subscript: lockingScript}
+	err := engine.execute(unlockingScript)
+	if err != nil {
+		return err
+	}
+	redeemStack := append([][]byte{}, engine.stack...)
+
+	err = engine.execute(lockingScript)
+	if err != nil {
+		return err
 	}
+	if !engine.success() {
+		return fmt.Errorf("%w: locking script evaluated to false", ErrScriptFailed)
+	}
+
+	if _, ok := extractScriptHash(lockingScript); !ok {
+		return nil
+	}
+
+	redeemScript := redeemStack[len(redeemStack)-1]
+	engine.stack = redeemStack[:len(redeemStack)-1]
+	engine.subscript = redeemScript
+
+	err = engine.execute(redeemScript)
+	if err != nil {
+		return err
+	}
+	if !engine.success() {
+		return fmt.Errorf("%w: redeem script evaluated to false", ErrScriptFailed)
+	}
+
+	return nil
+}
 
-	return true
+// IsFinal checks whether the transaction can be included into the block with the height and time.
+// Lock time below lockTimeThreshold is a block height, otherwise unix time
+func (tx *Transaction) IsFinal(height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	limit := int64(height)
+	if tx.LockTime >= lockTimeThreshold {
+		limit = blockTime
+	}
+
+	return tx.LockTime < limit
 }
 
-// NewCoinbaseTX создает базовую транзакцию для Genesis блока
-func NewCoinbaseTX(to, data string) *Transaction {
+// NewCoinbaseTX создает базовую транзакцию блока на высоте height, fees - сумма комиссий транзакций этого блока
+func NewCoinbaseTX(to, data string, height, fees int) *Transaction {
 	// Если данные пустые
 	if data == "" {
 		// Создаем данные и заполняем их случайными значениями
@@ -187,65 +492,84 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	}
 
 	// Создаем вход с рандомным публичным ключем
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	// Создаем новый выход с указанием кому передаем значение, subsidy==10
-	// subsidy — это сумма вознаграждения
-	txout := NewTXOutput(subsidy, to)
+	txin := TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte(data)}
+	// Создаем новый выход с указанием кому передаем значение
+	// Награда зависит от высоты блока, к ней майнер добавляет все комиссии блока
+	txout := NewTXOutput(blockSubsidy(height)+fees, to)
 	// Создаем непосредственно транзакцию
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	tx := Transaction{Version: txVersion, Vin: []TXInput{txin}, Vout: []TXOutput{*txout}}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
+// ErrNotEnoughFunds is returned when unspent outputs of the address don't cover the payment
+var ErrNotEnoughFunds = errors.New("not enough funds")
+
+// NewUTXOTransaction creates a new transaction paying amount to the address and fee to the miner.
+// Change smaller than the fee is not worth an output and goes to the miner as well
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	tx, err := NewUnsignedTransaction(string(wallet.GetAddress()), to, amount, fee, 0, UTXOSet)
+	if err != nil {
+		return nil, err
+	}
+
+	err = UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// NewUnsignedTransaction creates a transaction spending outputs of the address, including script ones,
+// with the change going back to it. Inputs are left without unlocking scripts
+func NewUnsignedTransaction(from, to string, amount, fee int, lockTime int64, UTXOSet *UTXOSet) (*Transaction, error) {
 	var inputs []TXInput
 	var outputs []TXOutput
 
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	candidates, err := UTXOSet.FindSpendableOutputs(PubKeyHashFromAddress(from))
+	if err != nil {
+		return nil, err
+	}
+	selected, acc := SelectCoins(candidates, amount+fee, fee)
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: %s has %d, needs %d", ErrNotEnoughFunds, from, acc, amount+fee)
 	}
 
 	// Build a list of inputs
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
-			inputs = append(inputs, input)
-		}
+	for _, out := range selected {
+		inputs = append(inputs, TXInput{Txid: out.Txid, Vout: out.Vout})
 	}
 
 	// Build a list of outputs
-	from := fmt.Sprintf("%s", wallet.GetAddress())
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
+	change := acc - amount - fee
+	if change > 0 && !isDust(change, fee) {
+		outputs = append(outputs, *NewTXOutput(change, from)) // a change
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{Version: txVersion, Vin: inputs, Vout: outputs, LockTime: lockTime}
 	tx.ID = tx.Hash()
-	UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
 
-	return &tx
+	return &tx, nil
 }
 
 // DeserializeTransaction deserializes a transaction
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	tx, err := DecodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return transaction
+	return tx
+}
+
+// DecodeTransaction decodes a transaction received from outside, where broken data is not a reason to panic
+func DecodeTransaction(data []byte) (Transaction, error) {
+	var tx Transaction
+	err := decodeCanonical(data, tx.decode)
+
+	return tx, err
 }
@@ -6,13 +6,32 @@ import "bytes"
 type TXInput struct {
 	Txid      []byte // Хранит идентификатор такой транзакции, прошлый выход
 	Vout      int    // Индекс выхода данной транзакции
-	Signature []byte // Это скрипт, который предоставляет данные, которые будут в дальнейшем использоваться в скрипте ScriptPubKey
+	Signature []byte // Подпись входов старого формата, новые входы хранят подпись в ScriptSig
 	PubKey    []byte
+	ScriptSig []byte // Скрипт, который вместе со скриптом выхода должен оставить на стеке true
 }
 
 // UsesKey checks whether the address initiated the transaction
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := HashPubKey(in.PubKey)
+	return bytes.Compare(in.SpenderHash(), pubKeyHash) == 0
+}
+
+// isLegacyInput checks whether the input is signed without scripts
+func (in *TXInput) isLegacyInput() bool {
+	return len(in.ScriptSig) == 0 && len(in.Signature) > 0
+}
+
+// SpenderHash returns the hash of the key or of the redeem script which unlocks the input:
+// the last element pushed by the unlocking script or the key of a legacy input
+func (in *TXInput) SpenderHash() []byte {
+	if len(in.ScriptSig) == 0 {
+		return HashPubKey(in.PubKey)
+	}
+
+	pushes := scriptPushes(in.ScriptSig)
+	if len(pushes) == 0 {
+		return nil
+	}
 
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
+	return HashPubKey(pushes[len(pushes)-1])
 }
@@ -9,7 +9,8 @@ import (
 // TXOutput represents a transaction output
 type TXOutput struct {
 	Value      int    // Сумма
-	PubKeyHash []byte // Хэш
+	PubKeyHash []byte // Хэш ключа или, для P2SH, хэш скрипта - по нему ищутся выходы адреса
+	Script     []byte // Скрипт блокировки, пустой означает P2PKH по PubKeyHash
 }
 
 // Lock signs the output
@@ -17,6 +18,47 @@ func (out *TXOutput) Lock(address []byte) {
 	pubKeyHash := Base58Decode(address)
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
 	out.PubKeyHash = pubKeyHash
+	out.Script = nil
+
+	if IsScriptAddress(string(address)) {
+		out.Script = P2SHScript(pubKeyHash)
+	}
+}
+
+// LockingScript returns the script which has to be satisfied to spend the output
+func (out *TXOutput) LockingScript() []byte {
+	if len(out.Script) == 0 {
+		return P2PKHScript(out.PubKeyHash)
+	}
+
+	return out.Script
+}
+
+// matchesScript checks that PubKeyHash is the hash from a standard locking script
+func (out *TXOutput) matchesScript() bool {
+	if len(out.Script) == 0 {
+		return true
+	}
+
+	hash, ok := extractPubKeyHash(out.Script)
+	if !ok {
+		hash, ok = extractScriptHash(out.Script)
+	}
+
+	return !ok || bytes.Equal(hash, out.PubKeyHash)
+}
+
+// Address returns the address the output is paid to, empty for non-standard scripts
+func (out *TXOutput) Address() string {
+	script := out.LockingScript()
+	if pubKeyHash, ok := extractPubKeyHash(script); ok {
+		return string(AddressFromPubKeyHash(pubKeyHash))
+	}
+	if scriptHash, ok := extractScriptHash(script); ok {
+		return string(AddressFromScriptHash(scriptHash))
+	}
+
+	return ""
 }
 
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
@@ -26,7 +68,7 @@ func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
 
 // NewTXOutput create a new TXOutput
 func NewTXOutput(value int, address string) *TXOutput {
-	txo := &TXOutput{value, nil}
+	txo := &TXOutput{Value: value}
 	txo.Lock([]byte(address))
 
 	return txo
@@ -34,7 +76,51 @@ func NewTXOutput(value int, address string) *TXOutput {
 
 // TXOutputs collects TXOutput
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs  []TXOutput
+	Indexes  []int // Original output indexes in the transaction, parallel to Outputs
+	Height   int   // Height of the block of the transaction
+	Coinbase bool  // Записи старых версий без этих полей считаются созревшими до reindexutxo
+}
+
+// isMature reports whether the outputs can be spent in a block at the height
+func (outs TXOutputs) isMature(height int) bool {
+	return !outs.Coinbase || height-outs.Height >= coinbaseMaturity
+}
+
+// Index returns the original transaction output index of Outputs[i]
+func (outs TXOutputs) Index(i int) int {
+	// Entries written before indexes were tracked keep their natural order
+	if outs.Indexes == nil {
+		return i
+	}
+
+	return outs.Indexes[i]
+}
+
+// restore puts a spent output back keeping outputs sorted by their original index
+func (outs *TXOutputs) restore(index int, out TXOutput) {
+	if outs.Indexes == nil {
+		outs.Indexes = []int{}
+		for i := range outs.Outputs {
+			outs.Indexes = append(outs.Indexes, i)
+		}
+	}
+
+	pos := len(outs.Indexes)
+	for i, idx := range outs.Indexes {
+		if idx > index {
+			pos = i
+			break
+		}
+	}
+
+	outs.Outputs = append(outs.Outputs, TXOutput{})
+	copy(outs.Outputs[pos+1:], outs.Outputs[pos:])
+	outs.Outputs[pos] = out
+
+	outs.Indexes = append(outs.Indexes, 0)
+	copy(outs.Indexes[pos+1:], outs.Indexes[pos:])
+	outs.Indexes[pos] = index
 }
 
 // Serialize serializes TXOutputs
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport открывает соединения между нодами: TCP для настоящих нодов и каналы в памяти
+// для нескольких нодов, запущенных в одном процессе
+type Transport interface {
+	Listen(address string) (net.Listener, error)
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+// TCPTransport соединяет ноды по сети
+type TCPTransport struct{}
+
+// Listen принимает TCP-соединения на адресе
+func (TCPTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen(protocol, address)
+}
+
+// Dial подключается к адресу по TCP
+func (TCPTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(protocol, address, timeout)
+}
+
+// ErrConnectionRefused возвращает MemoryTransport, когда адрес никто не слушает
+var ErrConnectionRefused = errors.New("connection refused")
+
+// MemoryTransport соединяет ноды одного процесса синхронными каналами в памяти, адреса - произвольные строки
+type MemoryTransport struct {
+	lock      sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+// NewMemoryTransport создает транспорт без слушателей
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{listeners: make(map[string]*memoryListener)}
+}
+
+// Listen принимает соединения, открытые к адресу через этот транспорт
+func (t *MemoryTransport) Listen(address string) (net.Listener, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.listeners[address] != nil {
+		return nil, fmt.Errorf("address %s is already in use", address)
+	}
+
+	l := &memoryListener{
+		transport: t,
+		address:   memoryAddr(address),
+		conns:     make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+	t.listeners[address] = l
+
+	return l, nil
+}
+
+// Dial подключается к слушателю адреса и ждет, пока тот примет соединение, не дольше timeout.
+// Принявшая сторона видит соединение пришедшим с пустого адреса, адрес нода задает Host
+func (t *MemoryTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return t.dial("", address, timeout)
+}
+
+// Host возвращает транспорт нода с адресом host. Его соединения приходят с этого адреса,
+// так что ноды одного процесса различаются как разные машины
+func (t *MemoryTransport) Host(host string) Transport {
+	return memoryHost{t, host}
+}
+
+func (t *MemoryTransport) dial(from, address string, timeout time.Duration) (net.Conn, error) {
+	t.lock.Lock()
+	l := t.listeners[address]
+	t.lock.Unlock()
+
+	if l == nil {
+		return nil, fmt.Errorf("%w: %s", ErrConnectionRefused, address)
+	}
+
+	pipeClient, pipeServer := net.Pipe()
+	client := memoryConn{pipeClient, memoryAddr(from), memoryAddr(address)}
+	server := memoryConn{pipeServer, memoryAddr(address), memoryAddr(from)}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+	case <-timer.C:
+	}
+
+	client.Close()
+	server.Close()
+
+	return nil, fmt.Errorf("%w: %s", ErrConnectionRefused, address)
+}
+
+type memoryListener struct {
+	transport *MemoryTransport
+	address   memoryAddr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close освобождает адрес, чтобы перезапущенный нод мог снова его слушать
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+
+		l.transport.lock.Lock()
+		delete(l.transport.listeners, string(l.address))
+		l.transport.lock.Unlock()
+	})
+
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.address
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string {
+	return "memory"
+}
+
+func (a memoryAddr) String() string {
+	return string(a)
+}
+
+type memoryHost struct {
+	transport *MemoryTransport
+	host      string
+}
+
+func (h memoryHost) Listen(address string) (net.Listener, error) {
+	return h.transport.Listen(address)
+}
+
+func (h memoryHost) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return h.transport.dial(h.host, address, timeout)
+}
+
+// memoryConn - канал в памяти с адресами концов
+type memoryConn struct {
+	net.Conn
+	local, remote memoryAddr
+}
+
+func (c memoryConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c memoryConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// Индексы необязательны: они ведутся и используются, только если их корзины есть в базе
+const txIndexBucket = "txindex"
+const addrIndexBucket = "addrindex"
+
+// TxIndexEntry указывает, в каком блоке основной цепочки и под каким номером лежит транзакция
+type TxIndexEntry struct {
+	BlockHash []byte
+	Position  int
+}
+
+// Serialize сериализует запись индекса
+func (e TxIndexEntry) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(e)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeTxIndexEntry десериализует запись индекса
+func DeserializeTxIndexEntry(data []byte) TxIndexEntry {
+	var entry TxIndexEntry
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&entry)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return entry
+}
+
+// addrIndexKey - ключ индекса адресов: хэш публичного ключа, за которым идет ID транзакции,
+// так все транзакции адреса лежат рядом и находятся поиском по префиксу
+func addrIndexKey(pubKeyHash, txID []byte) []byte {
+	key := make([]byte, 0, len(pubKeyHash)+len(txID))
+	key = append(key, pubKeyHash...)
+
+	return append(key, txID...)
+}
+
+// transactionPubKeyHashes возвращает хэши ключей всех адресов, которые затрагивает транзакция
+func transactionPubKeyHashes(tx *Transaction) [][]byte {
+	var hashes [][]byte
+
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.Vin {
+			hashes = append(hashes, vin.SpenderHash())
+		}
+	}
+	for _, out := range tx.Vout {
+		hashes = append(hashes, out.PubKeyHash)
+	}
+
+	return hashes
+}
+
+// indexBlock добавляет транзакции блока, подключаемого к основной цепочке, в индексы
+func indexBlock(tx *bolt.Tx, block *Block) error {
+	txIndex := tx.Bucket([]byte(txIndexBucket))
+	addrIndex := tx.Bucket([]byte(addrIndexBucket))
+	if txIndex == nil || addrIndex == nil {
+		return nil
+	}
+
+	for i, transaction := range block.Transactions {
+		entry := TxIndexEntry{block.Hash, i}
+		err := txIndex.Put(transaction.ID, entry.Serialize())
+		if err != nil {
+			return err
+		}
+
+		for _, pubKeyHash := range transactionPubKeyHashes(transaction) {
+			err = addrIndex.Put(addrIndexKey(pubKeyHash, transaction.ID), block.Hash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock удаляет транзакции отключаемого блока из индексов
+func unindexBlock(tx *bolt.Tx, block *Block) error {
+	txIndex := tx.Bucket([]byte(txIndexBucket))
+	addrIndex := tx.Bucket([]byte(addrIndexBucket))
+	if txIndex == nil || addrIndex == nil {
+		return nil
+	}
+
+	for _, transaction := range block.Transactions {
+		err := txIndex.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, pubKeyHash := range transactionPubKeyHashes(transaction) {
+			err = addrIndex.Delete(addrIndexKey(pubKeyHash, transaction.ID))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// HasTxIndex проверяет, ведутся ли индексы транзакций и адресов
+func (bc *Blockchain) HasTxIndex() (bool, error) {
+	exists := false
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket([]byte(txIndexBucket)) != nil && tx.Bucket([]byte(addrIndexBucket)) != nil
+		return nil
+	})
+
+	return exists, err
+}
+
+// ReindexTransactions создает индексы заново по блокам основной цепочки и возвращает количество транзакций
+func (bc *Blockchain) ReindexTransactions() (int, error) {
+	var blocks []*Block
+	count := 0
+
+	bci := bc.Iterator()
+	for {
+		block, hasNext := bci.Next()
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+		count += len(block.Transactions)
+
+		if hasNext == false {
+			break
+		}
+	}
+	if err := bci.Err(); err != nil {
+		return 0, err
+	}
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{txIndexBucket, addrIndexBucket} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+
+			_, err = tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := len(blocks) - 1; i >= 0; i-- {
+			err := indexBlock(tx, blocks[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// findIndexedTransaction ищет транзакцию через индекс, indexed == false, если индекс не ведется
+func (bc *Blockchain) findIndexedTransaction(ID []byte) (transaction Transaction, found bool, indexed bool, err error) {
+	err = bc.db.View(func(tx *bolt.Tx) error {
+		txIndex := tx.Bucket([]byte(txIndexBucket))
+		if txIndex == nil {
+			return nil
+		}
+		indexed = true
+
+		entryData := txIndex.Get(ID)
+		if entryData == nil {
+			return nil
+		}
+		entry := DeserializeTxIndexEntry(entryData)
+
+		blockData := tx.Bucket([]byte(blocksBucket)).Get(entry.BlockHash)
+		if blockData == nil {
+			return nil
+		}
+		block := DeserializeBlock(blockData)
+
+		if entry.Position < len(block.Transactions) {
+			transaction = *block.Transactions[entry.Position]
+			found = true
+		}
+
+		return nil
+	})
+
+	return transaction, found, indexed, err
+}
+
+// FindTransactionBlock возвращает хэш блока основной цепочки, содержащего транзакцию, если ведется индекс
+func (bc *Blockchain) FindTransactionBlock(ID []byte) ([]byte, bool, error) {
+	var blockHash []byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		txIndex := tx.Bucket([]byte(txIndexBucket))
+		if txIndex == nil {
+			return nil
+		}
+
+		if entryData := txIndex.Get(ID); entryData != nil {
+			blockHash = DeserializeTxIndexEntry(entryData).BlockHash
+		}
+
+		return nil
+	})
+
+	return blockHash, blockHash != nil, err
+}
+
+// FindAddressTransactions возвращает ID транзакций основной цепочки, затрагивающих хэш публичного ключа.
+// Без индекса адресов возвращается nil
+func (bc *Blockchain) FindAddressTransactions(pubKeyHash []byte) ([][]byte, error) {
+	var txIDs [][]byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		addrIndex := tx.Bucket([]byte(addrIndexBucket))
+		if addrIndex == nil {
+			return nil
+		}
+
+		c := addrIndex.Cursor()
+		for k, _ := c.Seek(pubKeyHash); k != nil && bytes.HasPrefix(k, pubKeyHash); k, _ = c.Next() {
+			txID := make([]byte, len(k)-len(pubKeyHash))
+			copy(txID, k[len(pubKeyHash):])
+			txIDs = append(txIDs, txID)
+		}
+
+		return nil
+	})
+
+	return txIDs, err
+}
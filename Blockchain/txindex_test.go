@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxIndexFollowsReorganization(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address := string(owner.GetAddress())
+	other, third := NewWallet(), NewWallet()
+	a, b := syncedChains(t, address)
+
+	// Индексы включаются перестроением, в генезисе одна транзакция
+	for _, bc := range []*Blockchain{a, b} {
+		count, err := bc.ReindexTransactions()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+	}
+
+	payment := testPayment(t, owner, string(other.GetAddress()), 5, 1, a)
+	blockA := testMine(t, a, NewCoinbaseTX(address, "", 1, 1), payment)
+
+	blockHash, found, err := a.FindTransactionBlock(payment.ID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, blockA.Hash, blockHash)
+	txIDs, err := a.FindAddressTransactions(HashPubKey(other.PublicKey))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{payment.ID}, txIDs)
+
+	// Более длинная ветка тратит те же монеты по-другому
+	conflict := testPayment(t, owner, string(third.GetAddress()), 3, 1, b)
+	blockB := testMine(t, b, NewCoinbaseTX(address, "", 1, 1), conflict)
+	testMine(t, b, NewCoinbaseTX(address, "", 2, 0))
+	for height := 1; height <= 2; height++ {
+		hash, err := b.GetBlockHashByHeight(height)
+		assert.NoError(t, err)
+		block, err := b.GetBlock(hash)
+		assert.NoError(t, err)
+		assert.NoError(t, a.AddBlock(&block))
+	}
+	assert.Equal(t, testTip(t, b), testTip(t, a))
+
+	// Транзакции отключенного блока пропали из обоих индексов, транзакции новой ветки появились
+	_, found, err = a.FindTransactionBlock(payment.ID)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = a.FindTransactionBlock(blockA.Transactions[0].ID)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	txIDs, err = a.FindAddressTransactions(HashPubKey(other.PublicKey))
+	assert.NoError(t, err)
+	assert.Empty(t, txIDs)
+
+	blockHash, found, err = a.FindTransactionBlock(conflict.ID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, blockB.Hash, blockHash)
+	txIDs, err = a.FindAddressTransactions(HashPubKey(third.PublicKey))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{conflict.ID}, txIDs)
+
+	// Индексы после реорганизации совпадают с построенными заново
+	indexes := testBuckets(t, a, txIndexBucket, addrIndexBucket)
+	count, err := a.ReindexTransactions()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, indexes, testBuckets(t, a, txIndexBucket, addrIndexBucket))
+}
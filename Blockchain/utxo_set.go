@@ -1,52 +1,182 @@
 package main
 
 import (
-	"encoding/hex"
+	"bytes"
+	"encoding/gob"
+	"fmt"
 	"log"
 
 	"github.com/boltdb/bolt"
 )
 
 const utxoBucket = "chainstate"
+const undoBucket = "undo"
+
+// Хэш блока, до которого построен chainstate, хранится в корзине meta
+const chainstateTipKey = "chainstate"
 
 // UTXOSet represents UTXO set
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-// FindSpendableOutputs finds and returns unspent outputs to reference in inputs
-func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	accumulated := 0
+// SpentOutput is an output consumed by a block, kept to be able to disconnect the block
+type SpentOutput struct {
+	Txid     []byte
+	Vout     int
+	Output   TXOutput
+	Height   int // Высота и признак coinbase транзакции выхода, чтобы восстановить ее запись
+	Coinbase bool
+}
+
+// BlockUndo holds outputs spent by every transaction of a block
+type BlockUndo struct {
+	Spent [][]SpentOutput
+}
+
+// Serialize serializes BlockUndo
+func (bu BlockUndo) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(bu)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeBlockUndo deserializes BlockUndo
+func DeserializeBlockUndo(data []byte) BlockUndo {
+	var undo BlockUndo
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&undo)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return undo
+}
+
+// FindSpendableOutputs returns unspent outputs locked with the public key hash which can be spent in the next block,
+// immature coinbase outputs are skipped
+func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte) ([]SpendableOutput, error) {
+	var spendable []SpendableOutput
 	db := u.Blockchain.db
 
-	err := db.View(func(tx *bolt.Tx) error {
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
+			if !outs.isMature(bestHeight + 1) {
+				continue
+			}
 
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+			for i, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubkeyHash) {
+					txID := make([]byte, len(k))
+					copy(txID, k)
+					spendable = append(spendable, SpendableOutput{txID, outs.Index(i), out.Value})
 				}
 			}
 		}
 
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
+
+	return spendable, err
+}
+
+// FindOutput returns an unspent output by transaction ID and output index
+func (u UTXOSet) FindOutput(txID []byte, vout int) (TXOutput, bool, error) {
+	var output TXOutput
+	found := false
+	db := u.Blockchain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+
+		outsBytes := b.Get(txID)
+		if outsBytes == nil {
+			return nil
+		}
+		outs := DeserializeOutputs(outsBytes)
+
+		for i, out := range outs.Outputs {
+			if outs.Index(i) == vout {
+				output = out
+				found = true
+			}
+		}
+
+		return nil
+	})
+
+	return output, found, err
+}
+
+// checkMaturity проверяет, что транзакция в блоке на высоте height не тратит несозревшие награды за блок.
+// Входы, которых нет в chainstate, не проверяются
+func (u UTXOSet) checkMaturity(transaction *Transaction, height int) error {
+	if transaction.IsCoinbase() {
+		return nil
+	}
+
+	return u.Blockchain.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+
+		for _, vin := range transaction.Vin {
+			outsBytes := b.Get(vin.Txid)
+			if outsBytes == nil {
+				continue
+			}
+
+			outs := DeserializeOutputs(outsBytes)
+			if !outs.isMature(height) {
+				return ruleError(ErrImmatureCoinbase, "input %x:%d of transaction %x at height %d", vin.Txid, vin.Vout, transaction.ID, height)
+			}
+		}
+
+		return nil
+	})
+}
+
+// TransactionFee returns the fee of a transaction: the sum of its inputs minus the sum of its outputs.
+// All inputs have to be in the UTXO set
+func (u UTXOSet) TransactionFee(transaction *Transaction) (int, error) {
+	if transaction.IsCoinbase() {
+		return 0, nil
+	}
+
+	fee := 0
+	for _, vin := range transaction.Vin {
+		out, found, err := u.FindOutput(vin.Txid, vin.Vout)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			return 0, ruleError(ErrMissingInput, "input %x:%d of transaction %x", vin.Txid, vin.Vout, transaction.ID)
+		}
+		fee += out.Value
+	}
+	for _, out := range transaction.Vout {
+		fee -= out.Value
 	}
 
-	return accumulated, unspentOutputs
+	return fee, nil
 }
 
 // FindUTXO finds UTXO for a public key hash
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TXOutput, error) {
 	var UTXOs []TXOutput
 	db := u.Blockchain.db
 
@@ -66,15 +196,12 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
-	}
 
-	return UTXOs
+	return UTXOs, err
 }
 
 // CountTransactions returns the number of transactions in the UTXO set
-func (u UTXOSet) CountTransactions() int {
+func (u UTXOSet) CountTransactions() (int, error) {
 	db := u.Blockchain.db
 	counter := 0
 
@@ -88,106 +215,218 @@ func (u UTXOSet) CountTransactions() int {
 
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
-	}
 
-	return counter
+	return counter, err
 }
 
-// Reindex rebuilds the UTXO set
-func (u UTXOSet) Reindex() {
-	db := u.Blockchain.db
-	bucketName := []byte(utxoBucket)
+// Reindex rebuilds the UTXO set and the undo data by connecting every block of the main chain.
+// Transactions of pruned blocks are gone, so such a chain can't be reindexed
+func (u UTXOSet) Reindex() error {
+	return u.Blockchain.db.Update(u.reindex)
+}
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
+func (u UTXOSet) reindex(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	// Collect the main chain from the tip down to genesis
+	var blocks []*Block
+	for hash := b.Get([]byte("l")); len(hash) > 0; {
+		data := b.Get(hash)
+		if data == nil {
+			return fmt.Errorf("block %x is not found", hash)
+		}
+		block := DeserializeBlock(data)
+		if block.isPruned() {
+			return fmt.Errorf("%w: block %x can't be connected", ErrPruned, block.Hash)
+		}
+		blocks = append(blocks, block)
+
+		hash = block.PrevBlockHash
+	}
+
+	for _, name := range []string{utxoBucket, undoBucket} {
+		err := tx.DeleteBucket([]byte(name))
 		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(name))
+		if err != nil {
+			return err
 		}
+	}
 
-		_, err = tx.CreateBucket(bucketName)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		err := u.connectBlock(tx, blocks[i])
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
+	}
 
+	return nil
+}
+
+// readChainstateTip возвращает хэш блока, до которого построен chainstate, nil - в базах старых версий
+func readChainstateTip(tx *bolt.Tx) []byte {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
 		return nil
-	})
+	}
+
+	return b.Get([]byte(chainstateTipKey))
+}
+
+// writeChainstateTip запоминает блок, до которого построен chainstate, в той же транзакции, что и сами изменения
+func writeChainstateTip(tx *bolt.Tx, hash []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	UTXO := u.Blockchain.FindUTXO()
+	return b.Put([]byte(chainstateTipKey), hash)
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+// connectBlock applies transactions of the block to the UTXO set and stores undo data for it.
+// Inputs, signatures and the coinbase value including fees are checked here, against the outputs being spent.
+// The block is considered to be the new tip of the main chain
+func (u UTXOSet) connectBlock(tx *bolt.Tx, block *Block) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(utxoBucket))
+	if err != nil {
+		return err
+	}
+	undo, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
+	blockUndo := BlockUndo{}
+	fees := 0
+
+	for _, transaction := range block.Transactions {
+		var spent []SpentOutput
+
+		if transaction.IsCoinbase() == false {
+			for _, vin := range transaction.Vin {
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return ruleError(ErrMissingInput, "input %x:%d of transaction %x", vin.Txid, vin.Vout, transaction.ID)
+				}
+				outs := DeserializeOutputs(outsBytes)
+				if !outs.isMature(block.Height) {
+					return ruleError(ErrImmatureCoinbase, "input %x:%d of transaction %x", vin.Txid, vin.Vout, transaction.ID)
+				}
+
+				found := false
+				updatedOuts := TXOutputs{Indexes: []int{}, Height: outs.Height, Coinbase: outs.Coinbase}
+				for i, out := range outs.Outputs {
+					if outs.Index(i) == vin.Vout {
+						found = true
+						spent = append(spent, SpentOutput{vin.Txid, vin.Vout, out, outs.Height, outs.Coinbase})
+						continue
+					}
+
+					updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+					updatedOuts.Indexes = append(updatedOuts.Indexes, outs.Index(i))
+				}
+				if !found {
+					return ruleError(ErrMissingInput, "input %x:%d of transaction %x", vin.Txid, vin.Vout, transaction.ID)
+				}
+
+				if len(updatedOuts.Outputs) == 0 {
+					err = b.Delete(vin.Txid)
+				} else {
+					err = b.Put(vin.Txid, updatedOuts.Serialize())
+				}
+				if err != nil {
+					return err
+				}
 			}
 
-			err = b.Put(key, outs.Serialize())
+			// Подписи и суммы проверяем по тем выходам, которые транзакция тратит
+			prevOuts := make([]TXOutput, len(spent))
+			for i, s := range spent {
+				prevOuts[i] = s.Output
+			}
+			fee, err := checkTransactionInputs(transaction, prevOuts)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
+			fees += fee
 		}
 
-		return nil
-	})
-}
+		newOutputs := TXOutputs{Indexes: []int{}, Height: block.Height, Coinbase: transaction.IsCoinbase()}
+		for i, out := range transaction.Vout {
+			newOutputs.Outputs = append(newOutputs.Outputs, out)
+			newOutputs.Indexes = append(newOutputs.Indexes, i)
+		}
 
-// Update updates the UTXO set with transactions from the Block
-// The Block is considered to be the tip of a blockchain
-func (u UTXOSet) Update(block *Block) {
-	db := u.Blockchain.db
+		err = b.Put(transaction.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+		blockUndo.Spent = append(blockUndo.Spent, spent)
+	}
 
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+	err = checkCoinbaseValue(block, fees)
+	if err != nil {
+		return err
+	}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
+	err = undo.Put(block.Hash, blockUndo.Serialize())
+	if err != nil {
+		return err
+	}
 
-				}
-			}
+	return writeChainstateTip(tx, block.Hash)
+}
+
+// disconnectBlock reverts changes made by connectBlock.
+// The block is considered to be the current tip of the main chain
+func (u UTXOSet) disconnectBlock(tx *bolt.Tx, block *Block) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(utxoBucket))
+	if err != nil {
+		return err
+	}
+	undo, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	undoData := undo.Get(block.Hash)
+	if undoData == nil {
+		return fmt.Errorf("no undo data for block %x, run reindexutxo", block.Hash)
+	}
+	blockUndo := DeserializeBlockUndo(undoData)
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+	// Transactions are reverted in reverse order, so outputs created and spent
+	// within the same block are restored before their transaction is removed
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		transaction := block.Transactions[i]
+
+		err = b.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range blockUndo.Spent[i] {
+			outs := TXOutputs{Indexes: []int{}, Height: s.Height, Coinbase: s.Coinbase}
+			if outsBytes := b.Get(s.Txid); outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
 			}
+			outs.restore(s.Vout, s.Output)
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
+			err = b.Put(s.Txid, outs.Serialize())
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
+	}
 
-		return nil
-	})
+	err = undo.Delete(block.Hash)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	return writeChainstateTip(tx, block.PrevBlockHash)
 }
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Максимальное отклонение времени блока в будущее относительно локальных часов
+const maxFutureBlockTime = 2 * time.Hour
+
+// Количество предыдущих блоков для вычисления медианного времени
+const medianTimeBlocks = 11
+
+// Ошибки проверки блоков и транзакций
+var (
+	ErrNoTransactions   = errors.New("block has no transactions")
+	ErrBadCoinbase      = errors.New("block must contain exactly one coinbase transaction")
+	ErrBadCoinbaseValue = errors.New("coinbase pays more than allowed")
+	ErrBadProofOfWork   = errors.New("proof of work is invalid")
+	ErrBadDifficulty    = errors.New("block difficulty is invalid")
+	ErrBadMerkleRoot    = errors.New("merkle root does not match transactions")
+	ErrTimeTooNew       = errors.New("block timestamp is too far in the future")
+	ErrTimeTooOld       = errors.New("block timestamp is before the median time of previous blocks")
+	ErrBadHeight        = errors.New("block height does not follow its parent")
+	ErrDuplicateTx      = errors.New("block contains duplicate transactions")
+	ErrDoubleSpend      = errors.New("output is spent twice")
+	ErrMissingInput     = errors.New("input is not in the UTXO set")
+	ErrBadSignature     = errors.New("transaction signature is invalid")
+	ErrBadTxValue       = errors.New("transaction values are invalid")
+	ErrNonFinalTx       = errors.New("transaction is locked until a later block")
+	ErrBadOutputScript  = errors.New("output hash doesn't match its script")
+	ErrBadVersion       = errors.New("block or transaction version is not supported")
+	ErrBadTxID          = errors.New("transaction ID doesn't match its hash")
+	ErrImmatureCoinbase = errors.New("coinbase output is spent before it matures")
+	ErrMaxSupply        = errors.New("transaction or block value exceeds the max supply")
+)
+
+// RuleError описывает нарушение правил консенсуса, Err - одна из ошибок Err*
+type RuleError struct {
+	Err    error
+	Detail string
+}
+
+func (e RuleError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%s: %s", e.Err, e.Detail)
+}
+
+// Unwrap позволяет сравнивать ошибку через errors.Is
+func (e RuleError) Unwrap() error {
+	return e.Err
+}
+
+func ruleError(err error, format string, args ...interface{}) RuleError {
+	return RuleError{err, fmt.Sprintf(format, args...)}
+}
+
+// CheckBlockHeader выполняет проверки заголовка, не зависящие от состояния цепочки: версию, цель,
+// доказательство работы и время. Заголовки проверяются так до загрузки блоков при синхронизации
+func CheckBlockHeader(header *Block) error {
+	if header.Version != blockVersionLegacy && header.Version != blockVersion {
+		return ruleError(ErrBadVersion, "block %x version %d", header.Hash, header.Version)
+	}
+
+	// Цель должна быть положительной и не легче минимальной сложности
+	target := blockTarget(header)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return ruleError(ErrBadDifficulty, "block %x bits %08x", header.Hash, header.Bits)
+	}
+
+	// Хэш должен совпадать с посчитанным и быть меньше цели. Старые блоки без MerkleRoot
+	// считают хэш по транзакциям, их работа проверяется вместе с блоком
+	if header.hasMerkleRoot() && !NewProofOfWork(header).Validate() {
+		return ruleError(ErrBadProofOfWork, "block %x", header.Hash)
+	}
+
+	maxTime := time.Now().Add(maxFutureBlockTime).Unix()
+	if header.Timestamp > maxTime {
+		return ruleError(ErrTimeTooNew, "block %x time %d, max %d", header.Hash, header.Timestamp, maxTime)
+	}
+
+	return nil
+}
+
+// CheckBlock выполняет проверки блока, не зависящие от состояния цепочки:
+// заголовок, структуру, корень дерева Меркла и транзакции
+func CheckBlock(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block %x", block.Hash)
+	}
+
+	err := CheckBlockHeader(block)
+	if err != nil {
+		return err
+	}
+
+	if !block.hasMerkleRoot() && !NewProofOfWork(block).Validate() {
+		return ruleError(ErrBadProofOfWork, "block %x", block.Hash)
+	}
+
+	// Корень, записанный в блоке, должен соответствовать его транзакциям
+	if block.hasMerkleRoot() && bytes.Compare(block.MerkleRoot, block.HashTransactions()) != 0 {
+		return ruleError(ErrBadMerkleRoot, "block %x", block.Hash)
+	}
+
+	coinbases := 0
+	txIDs := make(map[string]bool)
+	spent := make(map[string]bool)
+	supply := maxSupply()
+
+	for _, tx := range block.Transactions {
+		if len(tx.ID) == 0 {
+			return ruleError(ErrBadTxValue, "transaction without ID")
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if txIDs[txID] {
+			return ruleError(ErrDuplicateTx, "transaction %s", txID)
+		}
+		txIDs[txID] = true
+
+		// Транзакции кодируются и хэшируются так же, как их блок
+		if tx.Version != block.Version {
+			return ruleError(ErrBadVersion, "transaction %s version %d in block version %d", txID, tx.Version, block.Version)
+		}
+		err = checkTransactionEncoding(tx)
+		if err != nil {
+			return err
+		}
+
+		// Ни один выход и ни одна транзакция не может превысить все когда-либо выпущенные монеты,
+		// заодно суммы выходов не переполняются
+		total := 0
+		for _, out := range tx.Vout {
+			if out.Value < 0 {
+				return ruleError(ErrBadTxValue, "transaction %s has negative output", txID)
+			}
+			if out.Value > supply || total+out.Value > supply {
+				return ruleError(ErrMaxSupply, "transaction %s pays more than %d", txID, supply)
+			}
+			total += out.Value
+		}
+
+		if !tx.IsFinal(block.Height, block.Timestamp) {
+			return ruleError(ErrNonFinalTx, "transaction %s lock time %d", txID, tx.LockTime)
+		}
+
+		if tx.IsCoinbase() {
+			coinbases++
+			continue
+		}
+
+		// Один и тот же выход не может тратиться внутри блока дважды
+		for _, vin := range tx.Vin {
+			outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+			if spent[outpoint] {
+				return ruleError(ErrDoubleSpend, "output %s in block %x", outpoint, block.Hash)
+			}
+			spent[outpoint] = true
+		}
+	}
+
+	if coinbases != 1 {
+		return ruleError(ErrBadCoinbase, "block %x has %d", block.Hash, coinbases)
+	}
+
+	// Вместе выходы блока тоже не могут превысить выпуск. Выходы, потраченные в этом же блоке,
+	// не считаются: их монеты уже учтены в тратящих транзакциях
+	total := 0
+	for _, tx := range block.Transactions {
+		for i, out := range tx.Vout {
+			if spent[fmt.Sprintf("%x:%d", tx.ID, i)] {
+				continue
+			}
+			total += out.Value
+			if total > supply {
+				return ruleError(ErrMaxSupply, "block %x pays more than %d", block.Hash, supply)
+			}
+		}
+	}
+
+	return nil
+}
+
+// blockLookup возвращает блок или заголовок по хэшу, nil - если он неизвестен.
+// Через него проверки по предыдущим блокам работают и с базой, и с цепочкой заголовков при синхронизации
+type blockLookup func(hash []byte) *Block
+
+// dbBlockLookup ищет блоки в базе
+func dbBlockLookup(tx *bolt.Tx) blockLookup {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	return func(hash []byte) *Block {
+		blockData := b.Get(hash)
+		if blockData == nil {
+			return nil
+		}
+
+		return DeserializeBlock(blockData)
+	}
+}
+
+// checkBlockContext проверяет заголовок блока относительно его родителя
+func checkBlockContext(block, parent *Block, lookup blockLookup) error {
+	if block.Version < parent.Version {
+		return ruleError(ErrBadVersion, "block %x version %d, parent version %d", block.Hash, block.Version, parent.Version)
+	}
+
+	if block.Height != parent.Height+1 {
+		return ruleError(ErrBadHeight, "height %d, parent height %d", block.Height, parent.Height)
+	}
+
+	requiredBits := nextWorkRequired(parent, lookup)
+	if block.Bits != requiredBits {
+		return ruleError(ErrBadDifficulty, "block %x bits %08x, required %08x", block.Hash, block.Bits, requiredBits)
+	}
+
+	// Блоки майнятся быстрее секунды, поэтому допускаем совпадение с медианой
+	medianTime := medianTimePast(parent, lookup)
+	if block.Timestamp < medianTime {
+		return ruleError(ErrTimeTooOld, "block %x time %d, median %d", block.Hash, block.Timestamp, medianTime)
+	}
+
+	return nil
+}
+
+// medianTimePast возвращает медиану времени последних medianTimeBlocks блоков, заканчивая block
+func medianTimePast(block *Block, lookup blockLookup) int64 {
+	var timestamps []int64
+	for block != nil && len(timestamps) < medianTimeBlocks {
+		timestamps = append(timestamps, block.Timestamp)
+		block = lookup(block.PrevBlockHash)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
+}
+
+// checkTransactionEncoding проверяет, что данные транзакции версии 1 соответствуют ее ID:
+// ID - хэш транзакции, а подписи входов находятся только в ScriptSig, который в хэш не входит
+func checkTransactionEncoding(tx *Transaction) error {
+	if tx.Version == txVersionLegacy {
+		return nil
+	}
+
+	if bytes.Compare(tx.ID, tx.Hash()) != 0 {
+		return ruleError(ErrBadTxID, "transaction %x", tx.ID)
+	}
+
+	if tx.IsCoinbase() {
+		return nil
+	}
+	for i, vin := range tx.Vin {
+		if len(vin.Signature) > 0 || len(vin.PubKey) > 0 {
+			return ruleError(ErrBadSignature, "transaction %x input %d has a legacy signature", tx.ID, i)
+		}
+	}
+
+	return nil
+}
+
+// checkTransactionInputs проверяет транзакцию по выходам, которые она тратит.
+// prevOuts[i] - выход, на который ссылается tx.Vin[i]. Возвращает комиссию транзакции
+func checkTransactionInputs(tx *Transaction, prevOuts []TXOutput) (int, error) {
+	err := tx.VerifyScripts(prevOuts)
+	if err != nil {
+		return 0, ruleError(ErrBadSignature, "transaction %x: %s", tx.ID, err)
+	}
+
+	// По PubKeyHash выходы находятся для адреса, он должен совпадать с хэшем из стандартного скрипта
+	for i, out := range tx.Vout {
+		if !out.matchesScript() {
+			return 0, ruleError(ErrBadOutputScript, "transaction %x output %d", tx.ID, i)
+		}
+	}
+
+	inputs := 0
+	for _, out := range prevOuts {
+		inputs += out.Value
+	}
+	outputs := 0
+	for _, out := range tx.Vout {
+		outputs += out.Value
+	}
+
+	// Комиссия - это разница между входами и выходами, она не может быть отрицательной
+	if outputs > inputs {
+		return 0, ruleError(ErrBadTxValue, "transaction %x spends %d, has %d", tx.ID, outputs, inputs)
+	}
+
+	return inputs - outputs, nil
+}
+
+// checkCoinbaseValue проверяет, что награда за блок не превышает вознаграждение на его высоте плюс комиссии его транзакций
+func checkCoinbaseValue(block *Block, fees int) error {
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			continue
+		}
+
+		value := 0
+		for _, out := range tx.Vout {
+			value += out.Value
+		}
+
+		allowed := blockSubsidy(block.Height) + fees
+		if value > allowed {
+			return ruleError(ErrBadCoinbaseValue, "block %x pays %d, allowed %d", block.Hash, value, allowed)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSpend создает неподписанную транзакцию, тратящую выход vout транзакции txID
+func testSpend(txID []byte, vout, value int, address string) *Transaction {
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: txID, Vout: vout}},
+		Vout:    []TXOutput{*NewTXOutput(value, address)},
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+// remine заново находит доказательство работы после изменения заголовка
+func remine(block *Block) *Block {
+	block.MerkleRoot = block.HashTransactions()
+	block.Nonce, block.Hash = NewProofOfWork(block).Run()
+
+	return block
+}
+
+func TestCheckBlockRules(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bits := BigToCompact(initialTarget)
+	coinbase := NewCoinbaseTX(address, "", 1, 0)
+	spend := testSpend([]byte("previous"), 0, 5, address)
+	otherSpend := testSpend([]byte("previous"), 0, 4, address)
+
+	// Каждая из транзакций меньше выпуска, а вместе они его превышают
+	half := maxSupply()/2 + 1
+	large, otherLarge := testSpend([]byte("first"), 0, half, address), testSpend([]byte("second"), 0, half, address)
+	chained := testSpend(large.ID, 0, half, address)
+
+	tests := []struct {
+		name  string
+		block func() *Block
+		err   error
+	}{
+		{"bad proof of work", func() *Block {
+			block := NewBlock([]*Transaction{coinbase}, []byte("parent"), 1, bits)
+			block.Nonce++
+			return block
+		}, ErrBadProofOfWork},
+		{"bad merkle root", func() *Block {
+			block := NewBlock([]*Transaction{coinbase}, []byte("parent"), 1, bits)
+			block.Transactions = []*Transaction{NewCoinbaseTX(address, "other", 1, 0)}
+			return block
+		}, ErrBadMerkleRoot},
+		{"duplicate transaction", func() *Block {
+			return NewBlock([]*Transaction{coinbase, spend, spend}, []byte("parent"), 1, bits)
+		}, ErrDuplicateTx},
+		{"double spend within the block", func() *Block {
+			return NewBlock([]*Transaction{coinbase, spend, otherSpend}, []byte("parent"), 1, bits)
+		}, ErrDoubleSpend},
+		{"two coinbases", func() *Block {
+			return NewBlock([]*Transaction{coinbase, NewCoinbaseTX(address, "second", 1, 0)}, []byte("parent"), 1, bits)
+		}, ErrBadCoinbase},
+		{"timestamp too new", func() *Block {
+			block := NewBlock([]*Transaction{coinbase}, []byte("parent"), 1, bits)
+			block.Timestamp = time.Now().Add(maxFutureBlockTime + time.Hour).Unix()
+			return remine(block)
+		}, ErrTimeTooNew},
+		{"outputs of the block exceed the max supply", func() *Block {
+			return NewBlock([]*Transaction{coinbase, large, otherLarge}, []byte("parent"), 1, bits)
+		}, ErrMaxSupply},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckBlock(test.block())
+			assert.ErrorIs(t, err, test.err)
+			assert.ErrorAs(t, err, &RuleError{})
+		})
+	}
+
+	assert.NoError(t, CheckBlock(NewBlock([]*Transaction{coinbase, spend}, []byte("parent"), 1, bits)))
+	// Выход, потраченный внутри блока, второй раз не считается
+	assert.NoError(t, CheckBlock(NewBlock([]*Transaction{coinbase, large, chained}, []byte("parent"), 1, bits)))
+}
+
+func TestCheckBlockContextRules(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	parent := NewBlock([]*Transaction{NewCoinbaseTX(address, "", 1, 0)}, []byte("genesis"), 1, BigToCompact(initialTarget))
+	noBlocks := func(hash []byte) *Block { return nil }
+
+	child := func(change func(block *Block)) *Block {
+		block := &Block{
+			Version:   blockVersion,
+			Timestamp: parent.Timestamp,
+			Height:    parent.Height + 1,
+			Bits:      nextWorkRequired(parent, noBlocks),
+		}
+		change(block)
+		return block
+	}
+
+	tests := []struct {
+		name   string
+		change func(block *Block)
+		err    error
+	}{
+		{"timestamp too old", func(block *Block) { block.Timestamp = parent.Timestamp - 1 }, ErrTimeTooOld},
+		{"height does not follow", func(block *Block) { block.Height = parent.Height + 2 }, ErrBadHeight},
+		{"wrong difficulty", func(block *Block) { block.Bits = BigToCompact(powLimit) }, ErrBadDifficulty},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkBlockContext(child(test.change), parent, noBlocks)
+			assert.ErrorIs(t, err, test.err)
+			assert.ErrorAs(t, err, &RuleError{})
+		})
+	}
+
+	assert.NoError(t, checkBlockContext(child(func(block *Block) {}), parent, noBlocks))
+}
+
+func TestConnectBlockRules(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	owner := NewWallet()
+	address, other := string(owner.GetAddress()), string(NewWallet().GetAddress())
+	bc, _ := syncedChains(t, address)
+	bits := BigToCompact(initialTarget)
+
+	// Награда больше положенной с учетом комиссий
+	payment := testPayment(t, owner, other, 5, 1, bc)
+	block := NewBlock([]*Transaction{NewCoinbaseTX(other, "", 1, 2), payment}, testTip(t, bc), 1, bits)
+	err := bc.AddBlock(block)
+	assert.ErrorIs(t, err, ErrBadCoinbaseValue)
+	assert.ErrorAs(t, err, &RuleError{})
+
+	// Выход, которого нет в chainstate
+	missing := testSpend([]byte("unknown"), 0, 1, other)
+	block = NewBlock([]*Transaction{NewCoinbaseTX(other, "", 1, 0), missing}, testTip(t, bc), 1, bits)
+	assert.ErrorIs(t, bc.AddBlock(block), ErrMissingInput)
+
+	// Подпись, не подходящая к тратимому выходу
+	forged := *payment
+	forged.Vout = []TXOutput{*NewTXOutput(6, other)}
+	forged.ID = forged.Hash()
+	block = NewBlock([]*Transaction{NewCoinbaseTX(other, "", 1, 0), &forged}, testTip(t, bc), 1, bits)
+	assert.ErrorIs(t, bc.AddBlock(block), ErrBadSignature)
+
+	// Ни один из отвергнутых блоков не сдвинул вершину
+	assert.Equal(t, 0, testHeight(t, bc))
+	testMine(t, bc, NewCoinbaseTX(other, "", 1, 1), payment)
+}
@@ -11,7 +11,9 @@ import (
 	"golang.org/x/crypto/ripemd160"
 )
 
-const version = byte(0x00)
+// Версии адресов задаются сетью
+var version = networks["mainnet"].AddressVersion
+var scriptVersion = networks["mainnet"].ScriptVersion // Версия адресов, которые указывают на хэш скрипта (P2SH)
 const addressChecksumLen = 4
 
 // Wallet хранит приватный и публичный ключ нашего кошелька
@@ -35,8 +37,22 @@ func (w Wallet) GetAddress() []byte {
 	// Получаем хэш от публичного ключа
 	pubKeyHash := HashPubKey(w.PublicKey)
 
+	return AddressFromPubKeyHash(pubKeyHash)
+}
+
+// AddressFromPubKeyHash возвращает адрес для хэша публичного ключа
+func AddressFromPubKeyHash(pubKeyHash []byte) []byte {
+	return encodeAddress(version, pubKeyHash)
+}
+
+// AddressFromScriptHash возвращает адрес для хэша скрипта, выходы на него тратятся этим скриптом
+func AddressFromScriptHash(scriptHash []byte) []byte {
+	return encodeAddress(scriptVersion, scriptHash)
+}
+
+func encodeAddress(addressVersion byte, hash []byte) []byte {
 	// Слепляем версию и получившийся хэш, чтобы начиналось все с нуля
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+	versionedPayload := append([]byte{addressVersion}, hash...)
 	// Вычисляем контрольную сумму от результата
 	checksum := checksum(versionedPayload)
 
@@ -61,13 +77,31 @@ func HashPubKey(pubKey []byte) []byte {
 	return publicRIPEMD160
 }
 
+// PubKeyHashFromAddress извлекает хэш публичного ключа из адреса, адрес должен быть валидным
+func PubKeyHashFromAddress(address string) []byte {
+	pubKeyHash := Base58Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+}
+
+// IsScriptAddress проверяет, указывает ли валидный адрес на хэш скрипта
+func IsScriptAddress(address string) bool {
+	return Base58Decode([]byte(address))[0] == scriptVersion
+}
+
 // ValidateAddress check if address if valid
 func ValidateAddress(address string) bool {
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) <= addressChecksumLen {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
+	addressVersion := pubKeyHash[0]
+	if addressVersion != version && addressVersion != scriptVersion {
+		return false
+	}
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	targetChecksum := checksum(append([]byte{addressVersion}, pubKeyHash...))
 
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 
@@ -89,7 +123,6 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
 
-	return *private, pubKey
+	return *private, publicKeyBytes(*private)
 }
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// В базе истории кошелька хранятся транзакции, затронувшие его адреса, и выходы на эти адреса.
+// Это публичные данные, поэтому они лежат отдельно от ключей и не шифруются
+const walletDBFile = "wallet_%s.db"
+const walletTxsBucket = "wallettxs"
+const walletOutputsBucket = "walletoutputs"
+const walletMetaBucket = "walletmeta"
+
+// Последний учтенный блок основной цепочки
+const (
+	walletTipKey    = "tip"
+	walletHeightKey = "height"
+)
+
+// ErrWalletTxConflict is returned when a pending transaction spends outputs already spent by another one
+var ErrWalletTxConflict = errors.New("transaction conflicts with a known wallet transaction")
+
+// Высота транзакций, которые еще не попали в блок
+const pendingHeight = -1
+
+// WalletTx is a transaction of the wallet history. Outputs to wallet addresses of a transaction
+// spending wallet outputs are change, so sending between own addresses costs only the fee
+type WalletTx struct {
+	ID        []byte
+	Height    int   // pendingHeight, пока транзакция не в блоке
+	Time      int64 // Время блока или отправки
+	Coinbase  bool
+	Received  int      // Пришло на адреса кошелька
+	Sent      int      // Ушло на чужие адреса
+	Change    int      // Вернулось на адреса кошелька при отправке
+	Fee       int      // Комиссия, если все входы принадлежат кошельку
+	Addresses []string // Адреса кошелька, которых коснулась транзакция
+	Seq       uint64   // Порядок появления в истории
+}
+
+// Category describes the transaction like bitcoind does: generate, receive or send
+func (wtx WalletTx) Category() string {
+	if wtx.Coinbase {
+		return "generate"
+	}
+	if wtx.Received > 0 {
+		return "receive"
+	}
+
+	return "send"
+}
+
+// Amount returns how the balance of the wallet is changed by the transaction
+func (wtx WalletTx) Amount() int {
+	return wtx.Received - wtx.Sent - wtx.Fee
+}
+
+// Confirmations returns the number of blocks on top of the transaction including its own, 0 for pending ones
+func (wtx WalletTx) Confirmations(bestHeight int) int {
+	if wtx.Height == pendingHeight {
+		return 0
+	}
+
+	return bestHeight - wtx.Height + 1
+}
+
+// walletOutput - выход на адрес кошелька
+type walletOutput struct {
+	Value   int
+	Address string
+	SpentBy []byte // Транзакция кошелька, которая его тратит, в том числе ожидающая
+}
+
+// WalletHistory keeps transactions of the wallet addresses, it is updated from the blockchain by Sync
+type WalletHistory struct {
+	db        *bolt.DB
+	addresses map[string]bool
+}
+
+// OpenWalletHistory opens the history database of the node for addresses and scripts of the wallet
+func OpenWalletHistory(nodeID string, wallets *Wallets) (*WalletHistory, error) {
+	dbFile := dataPath(walletDBFile, nodeID)
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: dbOpenTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is locked by another process", dbFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h := &WalletHistory{db: db, addresses: make(map[string]bool)}
+	for _, address := range append(wallets.GetAddresses(), wallets.GetScriptAddresses()...) {
+		h.addresses[address] = true
+	}
+
+	return h, nil
+}
+
+// Close closes the history database
+func (h *WalletHistory) Close() error {
+	return h.db.Close()
+}
+
+// Sync brings the history to the tip of the blockchain and returns the number of scanned blocks.
+// Transactions of blocks which have left the main chain become pending again, their coinbases are forgotten
+func (h *WalletHistory) Sync(bc *Blockchain) (int, error) {
+	var syncedTip []byte
+	syncedHeight := -1
+	err := h.db.View(func(tx *bolt.Tx) error {
+		syncedTip, syncedHeight = readWalletTip(tx)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Идем от вершины вниз, пока не встретим учтенный блок. Если его ветка ушла из основной цепочки,
+	// спускаемся и по ней до общего предка
+	var hashes [][]byte
+	commonHeight := -1
+	oldHash, oldHeight := syncedTip, syncedHeight
+	bci := bc.Iterator()
+	for {
+		block, hasNext := bci.Next()
+		if block == nil {
+			break
+		}
+		for oldHash != nil && oldHeight > block.Height {
+			old, err := bc.GetBlock(oldHash)
+			if err != nil {
+				return 0, err
+			}
+			oldHash, oldHeight = old.PrevBlockHash, oldHeight-1
+		}
+		if oldHash != nil && bytes.Equal(oldHash, block.Hash) {
+			commonHeight = block.Height
+			break
+		}
+
+		hashes = append(hashes, block.Hash)
+		if !hasNext {
+			break
+		}
+	}
+	if err := bci.Err(); err != nil {
+		return 0, err
+	}
+
+	err = h.db.Update(func(tx *bolt.Tx) error {
+		err := h.rollback(tx, commonHeight)
+		if err != nil {
+			return err
+		}
+
+		for i := len(hashes) - 1; i >= 0; i-- {
+			block, err := bc.GetBlock(hashes[i])
+			if err != nil {
+				return err
+			}
+			if block.isPruned() {
+				return fmt.Errorf("block %x at height %d: %w, the wallet history can't be built", block.Hash, block.Height, ErrPruned)
+			}
+
+			for _, transaction := range block.Transactions {
+				err = h.addTransaction(tx, transaction, block.Height, block.Timestamp)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(hashes) == 0 {
+			return nil
+		}
+
+		return writeWalletTip(tx, hashes[0], commonHeight+len(hashes))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(hashes), nil
+}
+
+// Rescan forgets the history and builds it from the genesis, it is needed after keys with past payments are added
+func (h *WalletHistory) Rescan(bc *Blockchain) (int, error) {
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{walletTxsBucket, walletOutputsBucket, walletMetaBucket} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return h.Sync(bc)
+}
+
+// AddPending records a sent transaction before it gets into a block. The first of conflicting pending transactions
+// is kept, as the mempool does
+func (h *WalletHistory) AddPending(transaction *Transaction) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		for _, vin := range transaction.Vin {
+			out, ok := getWalletOutput(tx, vin.Txid, vin.Vout)
+			if ok && out.SpentBy != nil && !bytes.Equal(out.SpentBy, transaction.ID) {
+				return fmt.Errorf("%w: %x:%d is spent by %x", ErrWalletTxConflict, vin.Txid, vin.Vout, out.SpentBy)
+			}
+		}
+
+		return h.addTransaction(tx, transaction, pendingHeight, time.Now().Unix())
+	})
+}
+
+// Transactions returns the history of the address or of the whole wallet when it is empty.
+// Confirmed transactions go by height, pending ones are the last
+func (h *WalletHistory) Transactions(address string) ([]WalletTx, error) {
+	var history []WalletTx
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(walletTxsBucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			wtx := deserializeWalletTx(v)
+			if address == "" || containsString(wtx.Addresses, address) {
+				history = append(history, wtx)
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(history, func(i, j int) bool {
+		a, b := history[i], history[j]
+		if (a.Height == pendingHeight) != (b.Height == pendingHeight) {
+			return b.Height == pendingHeight
+		}
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		return a.Seq < b.Seq
+	})
+
+	return history, err
+}
+
+// addTransaction учитывает транзакцию, если она касается адресов кошелька. Подтвержденная трата выхода
+// вытесняет ожидающую транзакцию, которая тратила его же
+func (h *WalletHistory) addTransaction(tx *bolt.Tx, transaction *Transaction, height int, timestamp int64) error {
+	txs, err := tx.CreateBucketIfNotExists([]byte(walletTxsBucket))
+	if err != nil {
+		return err
+	}
+
+	// Ожидающая транзакция попала в блок, выходы и траты уже учтены
+	if data := txs.Get(transaction.ID); data != nil {
+		wtx := deserializeWalletTx(data)
+		wtx.Height, wtx.Time = height, timestamp
+		return txs.Put(wtx.ID, wtx.Serialize())
+	}
+
+	wtx := WalletTx{ID: transaction.ID, Height: height, Time: timestamp, Coinbase: transaction.IsCoinbase()}
+	debit, allInputsOwn := 0, true
+
+	if !wtx.Coinbase {
+		for _, vin := range transaction.Vin {
+			out, ok := getWalletOutput(tx, vin.Txid, vin.Vout)
+			if !ok {
+				allInputsOwn = false
+				continue
+			}
+			if out.SpentBy != nil && !bytes.Equal(out.SpentBy, transaction.ID) {
+				err = h.removeTransaction(tx, out.SpentBy)
+				if err != nil {
+					return err
+				}
+			}
+
+			out.SpentBy = transaction.ID
+			err = putWalletOutput(tx, vin.Txid, vin.Vout, out)
+			if err != nil {
+				return err
+			}
+			debit += out.Value
+			wtx.Addresses = appendUnique(wtx.Addresses, out.Address)
+		}
+	}
+
+	credit, totalOut := 0, 0
+	for _, out := range transaction.Vout {
+		totalOut += out.Value
+		if h.addresses[out.Address()] {
+			credit += out.Value
+			wtx.Addresses = appendUnique(wtx.Addresses, out.Address())
+		}
+	}
+	if debit == 0 && credit == 0 {
+		return nil
+	}
+
+	if debit > 0 {
+		wtx.Change = credit
+		wtx.Sent = totalOut - credit
+		if allInputsOwn {
+			wtx.Fee = debit - totalOut
+		}
+	} else {
+		wtx.Received = credit
+	}
+
+	for i, out := range transaction.Vout {
+		if h.addresses[out.Address()] {
+			err = putWalletOutput(tx, transaction.ID, i, walletOutput{Value: out.Value, Address: out.Address()})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	wtx.Seq, err = txs.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	return txs.Put(wtx.ID, wtx.Serialize())
+}
+
+// removeTransaction забывает транзакцию, которая уже не попадет в блок, вместе с транзакциями, тратящими ее выходы
+func (h *WalletHistory) removeTransaction(tx *bolt.Tx, txID []byte) error {
+	txs := tx.Bucket([]byte(walletTxsBucket))
+	outputs := tx.Bucket([]byte(walletOutputsBucket))
+	if txs == nil || outputs == nil || txs.Get(txID) == nil {
+		return nil
+	}
+
+	err := txs.Delete(txID)
+	if err != nil {
+		return err
+	}
+
+	// Выходы, которые она тратила, снова свободны, а ее собственные выходы исчезают
+	var spenders [][]byte
+	var freed, removed [][]byte
+	err = outputs.ForEach(func(k, v []byte) error {
+		out := deserializeWalletOutput(v)
+		if bytes.Equal(out.SpentBy, txID) {
+			freed = append(freed, append([]byte{}, k...))
+		}
+		if bytes.HasPrefix(k, txID) && len(k) == len(txID)+4 {
+			removed = append(removed, append([]byte{}, k...))
+			if out.SpentBy != nil {
+				spenders = append(spenders, out.SpentBy)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range freed {
+		out := deserializeWalletOutput(outputs.Get(k))
+		out.SpentBy = nil
+		err = outputs.Put(k, out.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+	for _, k := range removed {
+		err = outputs.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	for _, spender := range spenders {
+		err = h.removeTransaction(tx, spender)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollback возвращает в ожидающие транзакции блоков выше height, их coinbase уже никогда не будут действительны
+func (h *WalletHistory) rollback(tx *bolt.Tx, height int) error {
+	txs := tx.Bucket([]byte(walletTxsBucket))
+	if txs == nil {
+		return nil
+	}
+
+	var disconnected []WalletTx
+	err := txs.ForEach(func(k, v []byte) error {
+		wtx := deserializeWalletTx(v)
+		if wtx.Height > height {
+			disconnected = append(disconnected, wtx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, wtx := range disconnected {
+		if wtx.Coinbase {
+			err = h.removeTransaction(tx, wtx.ID)
+		} else {
+			wtx.Height = pendingHeight
+			err = txs.Put(wtx.ID, wtx.Serialize())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walletOutputKey(txID []byte, vout int) []byte {
+	key := make([]byte, len(txID)+4)
+	copy(key, txID)
+	binary.BigEndian.PutUint32(key[len(txID):], uint32(vout))
+
+	return key
+}
+
+func getWalletOutput(tx *bolt.Tx, txID []byte, vout int) (walletOutput, bool) {
+	b := tx.Bucket([]byte(walletOutputsBucket))
+	if b == nil {
+		return walletOutput{}, false
+	}
+
+	data := b.Get(walletOutputKey(txID, vout))
+	if data == nil {
+		return walletOutput{}, false
+	}
+
+	return deserializeWalletOutput(data), true
+}
+
+func putWalletOutput(tx *bolt.Tx, txID []byte, vout int, out walletOutput) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(walletOutputsBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put(walletOutputKey(txID, vout), out.Serialize())
+}
+
+// readWalletTip возвращает последний учтенный блок, nil и -1 - история еще не строилась
+func readWalletTip(tx *bolt.Tx) ([]byte, int) {
+	b := tx.Bucket([]byte(walletMetaBucket))
+	if b == nil {
+		return nil, -1
+	}
+
+	tip, height := b.Get([]byte(walletTipKey)), b.Get([]byte(walletHeightKey))
+	if tip == nil || len(height) != 4 {
+		return nil, -1
+	}
+
+	return append([]byte{}, tip...), int(binary.LittleEndian.Uint32(height))
+}
+
+func writeWalletTip(tx *bolt.Tx, hash []byte, height int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(walletMetaBucket))
+	if err != nil {
+		return err
+	}
+
+	var data [4]byte
+	binary.LittleEndian.PutUint32(data[:], uint32(height))
+	err = b.Put([]byte(walletHeightKey), data[:])
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(walletTipKey), hash)
+}
+
+// Serialize serializes the history record
+func (wtx WalletTx) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	err := gob.NewEncoder(&encoded).Encode(wtx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+func deserializeWalletTx(data []byte) WalletTx {
+	var wtx WalletTx
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wtx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return wtx
+}
+
+func (out walletOutput) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	err := gob.NewEncoder(&encoded).Encode(out)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+func deserializeWalletOutput(data []byte) walletOutput {
+	var out walletOutput
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&out)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+
+	return append(list, s)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testHistory синхронизирует историю с цепочкой и возвращает ее записи по ID
+func testHistory(t *testing.T, h *WalletHistory, bc *Blockchain) map[string]WalletTx {
+	_, err := h.Sync(bc)
+	assert.NoError(t, err)
+	txs, err := h.Transactions("")
+	assert.NoError(t, err)
+
+	byID := make(map[string]WalletTx)
+	for _, wtx := range txs {
+		byID[string(wtx.ID)] = wtx
+	}
+
+	return byID
+}
+
+func TestWalletHistory(t *testing.T) {
+	spendCoinbasesAtOnce(t)
+
+	wallets, _ := NewWallets("w")
+	owner, err := wallets.CreateWallet()
+	assert.NoError(t, err)
+	ownerWallet, err := wallets.GetWallet(owner)
+	assert.NoError(t, err)
+	a, b := syncedChains(t, owner)
+
+	h, err := OpenWalletHistory("w", wallets)
+	assert.NoError(t, err)
+	defer h.Close()
+
+	history := testHistory(t, h, a)
+	assert.Len(t, history, 1)
+	for _, wtx := range history {
+		assert.Equal(t, "generate", wtx.Category())
+		assert.Equal(t, subsidy, wtx.Amount())
+	}
+
+	// Отправленная транзакция видна сразу, а вторая трата тех же выходов отвергается
+	other := string(NewWallet().GetAddress())
+	payment := testPayment(t, &ownerWallet, other, 3, 1, a)
+	assert.NoError(t, h.AddPending(payment))
+	assert.ErrorIs(t, h.AddPending(testPayment(t, &ownerWallet, other, 4, 1, a)), ErrWalletTxConflict)
+
+	history = testHistory(t, h, a)
+	pending := history[string(payment.ID)]
+	assert.Equal(t, "send", pending.Category())
+	assert.Equal(t, -4, pending.Amount())
+	assert.Equal(t, subsidy-4, pending.Change)
+	assert.Equal(t, 0, pending.Confirmations(testHeight(t, a)))
+
+	testMine(t, a, NewCoinbaseTX(other, "", testHeight(t, a)+1, 1), payment)
+	history = testHistory(t, h, a)
+	assert.Len(t, history, 2)
+	assert.Equal(t, 1, history[string(payment.ID)].Confirmations(testHeight(t, a)))
+
+	// На более длинной ветке тот же выход генезиса потрачен иначе, платеж из истории пропадает
+	doubleSpend := testPayment(t, &ownerWallet, other, 5, 1, b)
+	forked := []*Block{
+		testMine(t, b, NewCoinbaseTX(owner, "", 1, 1), doubleSpend),
+		testMine(t, b, NewCoinbaseTX(other, "", 2, 0)),
+	}
+	for _, block := range forked {
+		assert.NoError(t, a.AddBlock(block))
+	}
+	assert.Equal(t, testTip(t, b), testTip(t, a))
+
+	history = testHistory(t, h, a)
+	assert.Len(t, history, 3)
+	assert.NotContains(t, history, string(payment.ID))
+	assert.Equal(t, -6, history[string(doubleSpend.ID)].Amount())
+	assert.Equal(t, 2, history[string(doubleSpend.ID)].Confirmations(testHeight(t, a)))
+
+	// Rescan строит ту же историю с нуля
+	scanned, err := h.Rescan(a)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, scanned)
+	rescanned, err := h.Transactions(owner)
+	assert.NoError(t, err)
+	assert.Len(t, rescanned, 3)
+	assert.Equal(t, doubleSpend.ID, rescanned[2].ID)
+}
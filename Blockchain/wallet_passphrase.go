@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// walletPassphraseEnv задает пароль кошелька без ввода в терминале, например в скриптах
+const walletPassphraseEnv = "WALLET_PASSPHRASE"
+
+// ErrPassphraseMismatch возвращается, когда повторно введенный пароль не совпал с первым
+var ErrPassphraseMismatch = errors.New("passphrases don't match")
+
+// UnlockWallets загружает кошелек для команд, которым нужны закрытые ключи или seed.
+// Пароль зашифрованного кошелька берется из WALLET_PASSPHRASE или вводится в терминале и нигде не сохраняется
+func UnlockWallets(nodeID string) (*Wallets, error) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil || !wallets.locked {
+		return wallets, err
+	}
+
+	passphrase, err := readPassphrase("Wallet passphrase: ")
+	if err != nil {
+		return wallets, err
+	}
+
+	return wallets, wallets.Unlock(passphrase)
+}
+
+// readPassphrase возвращает пароль из WALLET_PASSPHRASE, а без нее спрашивает его в терминале без эха
+func readPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(walletPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	return promptPassphrase(prompt)
+}
+
+// readNewPassphrase возвращает пароль для шифрования кошелька, в терминале его нужно ввести дважды
+func readNewPassphrase() (string, error) {
+	if passphrase := os.Getenv(walletPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := promptPassphrase("New wallet passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	repeated, err := promptPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != repeated {
+		return "", ErrPassphraseMismatch
+	}
+
+	return passphrase, nil
+}
+
+// promptPassphrase читает строку из терминала, выключив эхо, чтобы пароль не остался на экране
+func promptPassphrase(prompt string) (string, error) {
+	// Без терминала спросить пароль не у кого
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return "", ErrWalletLocked
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	err = stty("-echo")
+	if err != nil {
+		return "", fmt.Errorf("can't disable terminal echo, set %s instead: %w", walletPassphraseEnv, err)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	stty("echo")
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", ErrEmptyPassphrase
+	}
+
+	return passphrase, nil
+}
+
+// stty меняет режим терминала, подключенного к stdin
+func stty(mode string) error {
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+
+	return cmd.Run()
+}
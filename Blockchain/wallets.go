@@ -2,60 +2,333 @@ package main
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"os"
+	"sort"
+
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/scrypt"
 )
 
 const walletFile = "wallet_%s.dat"
+const walletFileVersion = 1
+
+// Шифрование кошелька: scrypt выводит ключ из пароля, AES-256-GCM шифрует секреты
+const (
+	walletKDFN    = 1 << 15
+	walletKDFR    = 8
+	walletKDFP    = 1
+	walletKeyLen  = 32
+	walletSaltLen = 16
+)
 
-// Wallets stores a collection of wallets
+// 128 бит энтропии дают мнемонику из 12 слов
+const mnemonicEntropyBits = 128
+
+// importedKeyIndex отмечает ключи, выведенные не из seed, например ключи из старых файлов кошелька
+const importedKeyIndex = -1
+
+// Ошибки кошелька
+var (
+	ErrWalletLocked       = errors.New("wallet is locked, set WALLET_PASSPHRASE or run the command in a terminal")
+	ErrWalletEncrypted    = errors.New("wallet is already encrypted")
+	ErrWalletNotEncrypted = errors.New("wallet is not encrypted")
+	ErrWrongPassphrase    = errors.New("wrong wallet passphrase")
+	ErrEmptyPassphrase    = errors.New("wallet passphrase is empty")
+	ErrUnknownAddress     = errors.New("address is not in the wallet")
+	ErrWalletExists       = errors.New("wallet file already exists")
+	ErrInvalidMnemonic    = errors.New("invalid mnemonic")
+	ErrNoSeed             = errors.New("wallet has no seed")
+)
+
+// Wallets хранит набор кошельков.
+// Публичные ключи доступны всегда, закрытые ключи и seed - только пока кошелек разблокирован
 type Wallets struct {
 	Wallets map[string]*Wallet
+
+	indexes   map[string]int    // Индекс вывода каждого адреса
+	scripts   map[string][]byte // Redeem-скрипты адресов P2SH, они публичные
+	mnemonic  string
+	nextIndex uint32
+
+	encrypted bool
+	locked    bool
+	salt      []byte
+	kdf       [3]int // Параметры scrypt N, r, p
+	key       []byte // Ключ шифрования, есть, пока зашифрованный кошелек разблокирован
+	secret    []byte // Секреты в том виде, в каком они лежат в файле, хранятся, пока кошелек заблокирован
+}
+
+// walletFileData - содержимое файла кошелька.
+// Secret хранит walletSecret в gob, при Encrypted он зашифрован AES-GCM
+type walletFileData struct {
+	Version   int
+	Addresses []walletFileAddress
+	Scripts   []walletFileScript
+	Encrypted bool
+	Salt      []byte
+	KDF       [3]int
+	Secret    []byte
 }
 
-// NewWallets creates Wallets and fills it from a file if it exists
+type walletFileAddress struct {
+	Address   string
+	PublicKey []byte
+	Index     int
+}
+
+type walletFileScript struct {
+	Address string
+	Script  []byte
+}
+
+type walletSecret struct {
+	Mnemonic  string
+	NextIndex uint32
+	Keys      map[string][]byte // Закрытые ключи импортированных адресов
+}
+
+// legacyWalletFile повторяет старый файл кошелька со значениями ecdsa.PrivateKey в gob
+type legacyWalletFile struct {
+	Wallets map[string]*struct {
+		PrivateKey struct {
+			PublicKey struct {
+				X, Y *big.Int
+			}
+			D *big.Int
+		}
+		PublicKey []byte
+	}
+}
+
+// NewWallets создает Wallets и заполняет их из файла, если он есть.
+// Зашифрованный кошелек остается заблокированным, закрытые ключи и seed загружает UnlockWallets
 func NewWallets(nodeID string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.indexes = make(map[string]int)
+	wallets.scripts = make(map[string][]byte)
 
 	err := wallets.LoadFromFile(nodeID)
 
 	return &wallets, err
 }
 
-// CreateWallet adds a Wallet to Wallets
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
-	address := fmt.Sprintf("%s", wallet.GetAddress())
+// RestoreWallets создает кошелек по мнемонике и выводит count первых адресов.
+// Импортированные ключи не восстановить, они выведены не из seed
+func RestoreWallets(nodeID, mnemonic string, count int) (*Wallets, error) {
+	if _, err := os.Stat(dataPath(walletFile, nodeID)); err == nil {
+		return nil, ErrWalletExists
+	}
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+
+	wallets := Wallets{}
+	wallets.Wallets = make(map[string]*Wallet)
+	wallets.indexes = make(map[string]int)
+	wallets.scripts = make(map[string][]byte)
+	wallets.mnemonic = mnemonic
+
+	for i := 0; i < count; i++ {
+		_, err := wallets.CreateWallet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &wallets, nil
+}
+
+// CreateWallet выводит из seed следующий адрес и добавляет его в Wallets.
+// Seed создается при первом вызове
+func (ws *Wallets) CreateWallet() (string, error) {
+	if ws.locked {
+		return "", ErrWalletLocked
+	}
+
+	if ws.mnemonic == "" {
+		entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+		if err != nil {
+			return "", err
+		}
+		ws.mnemonic, err = bip39.NewMnemonic(entropy)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	private := deriveWalletKey(bip39.NewSeed(ws.mnemonic, ""), ws.nextIndex)
+	wallet := &Wallet{private, publicKeyBytes(private)}
+	address := string(wallet.GetAddress())
 
 	ws.Wallets[address] = wallet
+	ws.indexes[address] = int(ws.nextIndex)
+	ws.nextIndex++
 
-	return address
+	return address, nil
 }
 
-// GetAddresses returns an array of addresses stored in the wallet file
+// GetAddresses возвращает адреса, хранящиеся в файле кошелька, импортированные идут первыми
 func (ws *Wallets) GetAddresses() []string {
 	var addresses []string
 
 	for address := range ws.Wallets {
 		addresses = append(addresses, address)
 	}
+	sort.Slice(addresses, func(i, j int) bool {
+		if ws.indexes[addresses[i]] != ws.indexes[addresses[j]] {
+			return ws.indexes[addresses[i]] < ws.indexes[addresses[j]]
+		}
+		return addresses[i] < addresses[j]
+	})
 
 	return addresses
 }
 
-// GetWallet returns a Wallet by its address
-func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+// GetWallet возвращает Wallet по его адресу, кошелек должен быть разблокирован
+func (ws Wallets) GetWallet(address string) (Wallet, error) {
+	wallet := ws.Wallets[address]
+	if wallet == nil {
+		return Wallet{}, ErrUnknownAddress
+	}
+	if ws.locked {
+		return Wallet{}, ErrWalletLocked
+	}
+
+	return *wallet, nil
+}
+
+// GetWalletByPubKeyHash возвращает разблокированный Wallet, хэш публичного ключа которого равен заданному
+func (ws Wallets) GetWalletByPubKeyHash(pubKeyHash []byte) (Wallet, error) {
+	return ws.GetWallet(string(AddressFromPubKeyHash(pubKeyHash)))
+}
+
+// AddScript запоминает redeem-скрипт и возвращает его адрес P2SH
+func (ws *Wallets) AddScript(script []byte) string {
+	address := string(AddressFromScriptHash(HashPubKey(script)))
+	ws.scripts[address] = script
+
+	return address
+}
+
+// GetScript возвращает redeem-скрипт адреса P2SH
+func (ws Wallets) GetScript(address string) ([]byte, error) {
+	script, ok := ws.scripts[address]
+	if !ok {
+		return nil, ErrUnknownAddress
+	}
+
+	return script, nil
+}
+
+// GetScriptAddresses возвращает адреса P2SH сохраненных redeem-скриптов
+func (ws Wallets) GetScriptAddresses() []string {
+	var addresses []string
+	for address := range ws.scripts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// Mnemonic возвращает мнемонику seed, кошелек должен быть разблокирован
+func (ws *Wallets) Mnemonic() (string, error) {
+	if ws.locked {
+		return "", ErrWalletLocked
+	}
+	if ws.mnemonic == "" {
+		return "", ErrNoSeed
+	}
+
+	return ws.mnemonic, nil
+}
+
+// HasSeed проверяет, есть ли уже seed у разблокированного кошелька
+func (ws *Wallets) HasSeed() bool {
+	return ws.mnemonic != ""
+}
+
+// IsEncrypted проверяет, зашифрован ли кошелек
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.encrypted
+}
+
+// Encrypt задает пароль кошелька, он применяется в SaveToFile
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.encrypted {
+		return ErrWalletEncrypted
+	}
+	if passphrase == "" {
+		return ErrEmptyPassphrase
+	}
+
+	salt := make([]byte, walletSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	kdf := [3]int{walletKDFN, walletKDFR, walletKDFP}
+
+	key, err := walletEncryptionKey(passphrase, salt, kdf)
+	if err != nil {
+		return err
+	}
+
+	ws.encrypted = true
+	ws.salt = salt
+	ws.kdf = kdf
+	ws.key = key
+
+	return nil
+}
+
+// Unlock расшифровывает закрытые ключи и seed зашифрованного кошелька
+func (ws *Wallets) Unlock(passphrase string) error {
+	if !ws.encrypted {
+		return ErrWalletNotEncrypted
+	}
+	if !ws.locked {
+		return nil
+	}
+
+	key, err := walletEncryptionKey(passphrase, ws.salt, ws.kdf)
+	if err != nil {
+		return err
+	}
+
+	return ws.unlockWithKey(key)
+}
+
+func (ws *Wallets) unlockWithKey(key []byte) error {
+	plain, err := openWalletSecret(key, ws.secret)
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+
+	err = ws.loadSecret(plain)
+	if err != nil {
+		return err
+	}
+	ws.key = key
+	ws.locked = false
+
+	return nil
 }
 
-// LoadFromFile loads wallets from the file
+// LoadFromFile загружает кошельки из файла, зашифрованный кошелек остается заблокированным
 func (ws *Wallets) LoadFromFile(nodeID string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := dataPath(walletFile, nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -65,34 +338,200 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		log.Panic(err)
 	}
 
-	var wallets Wallets
+	var data walletFileData
+	err = gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&data)
+	if err != nil || data.Version == 0 {
+		return ws.loadLegacyFile(fileContent)
+	}
+	if data.Version > walletFileVersion {
+		return fmt.Errorf("unsupported wallet file version %d", data.Version)
+	}
+
+	for _, address := range data.Addresses {
+		ws.Wallets[address.Address] = &Wallet{PublicKey: address.PublicKey}
+		ws.indexes[address.Address] = address.Index
+	}
+	for _, script := range data.Scripts {
+		ws.scripts[script.Address] = script.Script
+	}
+	ws.encrypted = data.Encrypted
+	ws.salt = data.Salt
+	ws.kdf = data.KDF
+	ws.secret = data.Secret
+
+	if ws.encrypted {
+		ws.locked = true
+		return nil
+	}
+
+	return ws.loadSecret(data.Secret)
+}
+
+// loadLegacyFile загружает старый незашифрованный файл кошелька, его ключи становятся импортированными
+func (ws *Wallets) loadLegacyFile(fileContent []byte) error {
+	var legacy legacyWalletFile
 	gob.Register(elliptic.P256())
-	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
+	err := gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&legacy)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	ws.Wallets = wallets.Wallets
+	for address, wallet := range legacy.Wallets {
+		private := privateKeyFromD(wallet.PrivateKey.D.Bytes())
+		ws.Wallets[address] = &Wallet{private, wallet.PublicKey}
+		ws.indexes[address] = importedKeyIndex
+	}
 
 	return nil
 }
 
-// SaveToFile saves wallets to a file
+// loadSecret заполняет закрытые ключи из расшифрованных секретов, выведенные ключи сверяются с публичными
+func (ws *Wallets) loadSecret(plain []byte) error {
+	var secret walletSecret
+	err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&secret)
+	if err != nil {
+		return err
+	}
+
+	var seed []byte
+	if secret.Mnemonic != "" {
+		seed = bip39.NewSeed(secret.Mnemonic, "")
+	}
+
+	for address, wallet := range ws.Wallets {
+		index := ws.indexes[address]
+		if index == importedKeyIndex {
+			d, ok := secret.Keys[address]
+			if !ok {
+				return fmt.Errorf("wallet file has no private key for %s", address)
+			}
+			wallet.PrivateKey = privateKeyFromD(d)
+			continue
+		}
+
+		if seed == nil {
+			return fmt.Errorf("wallet file has no seed for %s", address)
+		}
+		wallet.PrivateKey = deriveWalletKey(seed, uint32(index))
+		if !bytes.Equal(publicKeyBytes(wallet.PrivateKey), wallet.PublicKey) {
+			return fmt.Errorf("derived key doesn't match address %s", address)
+		}
+	}
+
+	ws.mnemonic = secret.Mnemonic
+	ws.nextIndex = secret.NextIndex
+
+	return nil
+}
+
+// SaveToFile сохраняет кошельки в файл. Секреты заблокированного кошелька сохраняются такими, какими были загружены
 func (ws Wallets) SaveToFile(nodeID string) {
-	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := dataPath(walletFile, nodeID)
 
-	gob.Register(elliptic.P256())
+	data := walletFileData{
+		Version:   walletFileVersion,
+		Encrypted: ws.encrypted,
+		Salt:      ws.salt,
+		KDF:       ws.kdf,
+		Secret:    ws.secret,
+	}
+	for address, wallet := range ws.Wallets {
+		data.Addresses = append(data.Addresses, walletFileAddress{address, wallet.PublicKey, ws.indexes[address]})
+	}
+	for _, address := range ws.GetScriptAddresses() {
+		data.Scripts = append(data.Scripts, walletFileScript{address, ws.scripts[address]})
+	}
+
+	if !ws.locked {
+		secret := walletSecret{ws.mnemonic, ws.nextIndex, make(map[string][]byte)}
+		for address, wallet := range ws.Wallets {
+			if ws.indexes[address] == importedKeyIndex {
+				secret.Keys[address] = wallet.PrivateKey.D.Bytes()
+			}
+		}
+
+		var plain bytes.Buffer
+		err := gob.NewEncoder(&plain).Encode(secret)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		data.Secret = plain.Bytes()
+		if ws.encrypted {
+			data.Secret, err = sealWalletSecret(ws.key, plain.Bytes())
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+	}
 
-	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	var content bytes.Buffer
+	err := gob.NewEncoder(&content).Encode(data)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	err = writeFileAtomic(walletFile, content.Bytes(), 0600)
 	if err != nil {
 		log.Panic(err)
 	}
 }
+
+// walletEncryptionKey выводит ключ шифрования из пароля
+func walletEncryptionKey(passphrase string, salt []byte, kdf [3]int) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, kdf[0], kdf[1], kdf[2], walletKeyLen)
+}
+
+// sealWalletSecret шифрует секреты, случайный nonce ставится перед шифротекстом
+func sealWalletSecret(key, plain []byte) ([]byte, error) {
+	aead, err := walletAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// openWalletSecret расшифровывает секреты, с неверным ключом или измененными данными возвращает ошибку
+func openWalletSecret(key, sealed []byte) ([]byte, error) {
+	aead, err := walletAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("wallet secret is too short")
+	}
+
+	nonce := sealed[:aead.NonceSize()]
+	return aead.Open(nil, nonce, sealed[aead.NonceSize():], nil)
+}
+
+func walletAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic пишет файл через временный, чтобы при сбое не остался недописанный файл
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmpFile := filename + ".tmp"
+
+	err := ioutil.WriteFile(tmpFile, data, perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, filename)
+}
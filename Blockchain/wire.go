@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// Рамка сообщения: magic (4) | команда (16) | кодировка (1) | длина данных (4) | контрольная сумма (4) | данные.
+// Числа в little-endian, контрольная сумма - первые 4 байта двойного SHA-256 от данных
+const messageHeaderSize = 4 + commandLength + 1 + 4 + 4
+
+// Ограничение размера сообщения, чтобы не выделять память под злонамеренную длину
+const maxMessageSize = 32 << 20
+
+// networkMagic открывает каждое сообщение, по нему отбрасываются мусор и сообщения других сетей
+var networkMagic = networks["mainnet"].Magic
+
+// Кодировки данных
+const (
+	encodingGob    = byte(0)
+	encodingBinary = byte(1)
+)
+
+// wireEncoding используется для отправляемых сообщений, полученные декодируются в любой поддерживаемой кодировке
+var wireEncoding = encodingBinary
+
+// Ошибки сетевого протокола
+var (
+	ErrBadMagic        = errors.New("message has wrong network magic")
+	ErrBadChecksum     = errors.New("message checksum doesn't match")
+	ErrBadEncoding     = errors.New("unknown message encoding")
+	ErrBadCommand      = errors.New("malformed message command")
+	ErrMessageTooLarge = errors.New("message is too large")
+	ErrMalformedData   = errors.New("malformed message payload")
+)
+
+// message - полученное сообщение
+type message struct {
+	Command  string
+	Encoding byte
+	Payload  []byte
+}
+
+// wireMessage - данные с детерминированной двоичной кодировкой
+type wireMessage interface {
+	encodeBinary(w *wireWriter)
+	decodeBinary(r *wireReader)
+}
+
+// ParseEncoding преобразует имя кодировки из командной строки
+func ParseEncoding(name string) (byte, error) {
+	switch name {
+	case "binary":
+		return encodingBinary, nil
+	case "gob":
+		return encodingGob, nil
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrBadEncoding, name)
+}
+
+func payloadChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
+
+// newMessage кодирует данные в wireEncoding и оборачивает их в рамку
+func newMessage(command string, payload wireMessage) []byte {
+	return encodeMessage(command, payload, wireEncoding)
+}
+
+func encodeMessage(command string, payload wireMessage, encoding byte) []byte {
+	var data []byte
+	if encoding == encodingBinary {
+		w := &wireWriter{}
+		payload.encodeBinary(w)
+		data = w.buf.Bytes()
+	} else {
+		data = gobEncode(payload)
+	}
+
+	header := make([]byte, messageHeaderSize, messageHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(header[0:], networkMagic)
+	copy(header[4:], commandToBytes(command))
+	header[4+commandLength] = encoding
+	binary.LittleEndian.PutUint32(header[5+commandLength:], uint32(len(data)))
+	copy(header[9+commandLength:], payloadChecksum(data))
+
+	return append(header, data...)
+}
+
+// writeMessage записывает сообщение в рамке
+func writeMessage(w io.Writer, frame []byte) error {
+	_, err := w.Write(frame)
+	return err
+}
+
+// readMessage читает одно сообщение. Чужой magic и слишком большая длина отвергаются до чтения данных
+func readMessage(r io.Reader) (*message, error) {
+	var header [messageHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint32(header[0:]) != networkMagic {
+		return nil, ErrBadMagic
+	}
+
+	command, ok := parseCommand(header[4 : 4+commandLength])
+	if !ok {
+		return nil, ErrBadCommand
+	}
+
+	encoding := header[4+commandLength]
+	if encoding != encodingGob && encoding != encodingBinary {
+		return nil, ErrBadEncoding
+	}
+
+	size := binary.LittleEndian.Uint32(header[5+commandLength:])
+	if size > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(payloadChecksum(payload), header[9+commandLength:]) {
+		return nil, ErrBadChecksum
+	}
+
+	return &message{command, encoding, payload}, nil
+}
+
+// parseCommand принимает печатные символы ASCII, дополненные нулями
+func parseCommand(data []byte) (string, bool) {
+	command := bytesToCommand(data)
+	if len(command) == 0 {
+		return "", false
+	}
+
+	for i, b := range data {
+		if i < len(command) {
+			if b < 0x21 || b > 0x7e {
+				return "", false
+			}
+		} else if b != 0 {
+			return "", false
+		}
+	}
+
+	return command, true
+}
+
+// decode декодирует данные в структуру сообщения
+func (m *message) decode(payload wireMessage) error {
+	if m.Encoding == encodingGob {
+		return gob.NewDecoder(bytes.NewReader(m.Payload)).Decode(payload)
+	}
+
+	r := &wireReader{data: m.Payload}
+	payload.decodeBinary(r)
+	if r.err == nil && r.pos != len(r.data) {
+		r.err = ErrMalformedData
+	}
+
+	return r.err
+}
+
+// wireWriter строит двоичную кодировку: числа фиксированного размера в little-endian,
+// перед строками байт и списками пишется их длина в uvarint
+type wireWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *wireWriter) putUint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *wireWriter) putUint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *wireWriter) putInt64(v int64) {
+	w.putUint64(uint64(v))
+}
+
+func (w *wireWriter) putCount(n int) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (w *wireWriter) putBytes(data []byte) {
+	w.putCount(len(data))
+	w.buf.Write(data)
+}
+
+func (w *wireWriter) putString(s string) {
+	w.putBytes([]byte(s))
+}
+
+func (w *wireWriter) putBytesList(list [][]byte) {
+	w.putCount(len(list))
+	for _, data := range list {
+		w.putBytes(data)
+	}
+}
+
+func (w *wireWriter) putStringList(list []string) {
+	w.putCount(len(list))
+	for _, s := range list {
+		w.putString(s)
+	}
+}
+
+// wireReader читает двоичную кодировку. Первая ошибка сохраняется, и последующие чтения возвращают нулевые значения
+type wireReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.pos {
+		r.err = ErrMalformedData
+		return nil
+	}
+
+	data := r.data[r.pos : r.pos+n]
+	r.pos += n
+
+	return data
+}
+
+func (r *wireReader) uint32() uint32 {
+	data := r.next(4)
+	if data == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(data)
+}
+
+func (r *wireReader) uint64() uint64 {
+	data := r.next(8)
+	if data == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(data)
+}
+
+func (r *wireReader) int64() int64 {
+	return int64(r.uint64())
+}
+
+// count читает длину, она не может превышать остаток данных, так как каждый элемент занимает хотя бы байт
+func (r *wireReader) count() int {
+	if r.err != nil {
+		return 0
+	}
+
+	n, size := binary.Uvarint(r.data[r.pos:])
+	if size <= 0 || n > uint64(len(r.data)-r.pos-size) {
+		r.err = ErrMalformedData
+		return 0
+	}
+	r.pos += size
+
+	return int(n)
+}
+
+func (r *wireReader) bytes() []byte {
+	data := r.next(r.count())
+	if data == nil {
+		return nil
+	}
+
+	result := make([]byte, len(data))
+	copy(result, data)
+
+	return result
+}
+
+func (r *wireReader) string() string {
+	return string(r.next(r.count()))
+}
+
+func (r *wireReader) bytesList() [][]byte {
+	n := r.count()
+
+	var list [][]byte
+	for i := 0; i < n && r.err == nil; i++ {
+		list = append(list, r.bytes())
+	}
+
+	return list
+}
+
+func (r *wireReader) stringList() []string {
+	n := r.count()
+
+	var list []string
+	for i := 0; i < n && r.err == nil; i++ {
+		list = append(list, r.string())
+	}
+
+	return list
+}
+
+func gobEncode(data interface{}) []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
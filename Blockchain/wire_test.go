@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	payload := &inv{"localhost:3000", "block", [][]byte{[]byte("hash1"), []byte("hash2")}}
+
+	for _, encoding := range []byte{encodingBinary, encodingGob} {
+		frame := encodeMessage("inv", payload, encoding)
+
+		msg, err := readMessage(bytes.NewReader(frame))
+		assert.NoError(t, err)
+		assert.Equal(t, "inv", msg.Command)
+
+		var decoded inv
+		assert.NoError(t, msg.decode(&decoded))
+		assert.Equal(t, *payload, decoded)
+	}
+}
+
+func TestBinaryEncodingIsDeterministic(t *testing.T) {
+	payload := &verzion{nodeVersion, 10, "localhost:3000", 42}
+
+	assert.Equal(t, encodeMessage("version", payload, encodingBinary), encodeMessage("version", payload, encodingBinary))
+}
+
+// Самая длинная команда помещается в поле рамки целиком
+func TestLongestCommandFits(t *testing.T) {
+	frame := encodeMessage("getmerkleproof", &getmerkleproof{PubKeyHashes: [][]byte{[]byte("hash")}}, encodingBinary)
+
+	msg, err := readMessage(bytes.NewReader(frame))
+	assert.NoError(t, err)
+	assert.Equal(t, "getmerkleproof", msg.Command)
+}
+
+func TestReadMessageRejectsJunk(t *testing.T) {
+	frame := encodeMessage("ping", &ping{1}, encodingBinary)
+
+	badMagic := append([]byte{}, frame...)
+	badMagic[0] ^= 0xff
+	_, err := readMessage(bytes.NewReader(badMagic))
+	assert.Equal(t, ErrBadMagic, err)
+
+	badChecksum := append([]byte{}, frame...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+	_, err = readMessage(bytes.NewReader(badChecksum))
+	assert.Equal(t, ErrBadChecksum, err)
+
+	tooLarge := append([]byte{}, frame[:messageHeaderSize]...)
+	tooLarge[5+commandLength] = 0xff
+	tooLarge[8+commandLength] = 0xff
+	_, err = readMessage(bytes.NewReader(tooLarge))
+	assert.Equal(t, ErrMessageTooLarge, err)
+}
+
+func TestDecodeMalformedPayload(t *testing.T) {
+	// Длина списка больше, чем осталось данных
+	msg := &message{"addr", encodingBinary, []byte{0xff, 0xff, 0x03, 0x01}}
+
+	var payload addr
+	assert.Equal(t, ErrMalformedData, msg.decode(&payload))
+
+	// Лишние данные в конце
+	msg = &message{"ping", encodingBinary, make([]byte, 9)}
+	assert.Equal(t, ErrMalformedData, msg.decode(&ping{}))
+}
@@ -0,0 +1,156 @@
+package gameserver
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+)
+
+// Соседи ячейки: сначала по сторонам, потом по диагоналям
+var pathNeighbours = [8]Point16{
+	{0, -1}, {1, 0}, {0, 1}, {-1, 0},
+	{1, -1}, {1, 1}, {-1, 1}, {-1, -1},
+}
+
+// Ячейка в очереди поиска пути
+type pathNode struct {
+	index int     // индекс ячейки в Cells
+	cost  float64 // пройденный путь + оценка оставшегося
+}
+
+type pathQueue []pathNode
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathNode)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// Можно ли ходить по ячейке платформы, координаты локальные
+func (platform *Platform) IsWalkable(cell Point16) bool {
+	if (cell.X < 0) || (cell.Y < 0) || (cell.X >= int16(platform.Width)) || (cell.Y >= int16(platform.Height)) {
+		return false
+	}
+	index := int(cell.Y)*int(platform.Width) + int(cell.X)
+	if index >= len(platform.Cells) {
+		return false
+	}
+	return platform.Cells[index]&CELL_TYPE_WALK != 0
+}
+
+// Локальная ячейка платформы, в которой находится точка мира
+func (platform *Platform) LocalCell(point PointFloat) Point16 {
+	return Point16{
+		int16(math.Floor(point.X+0.5)) - platform.PosX,
+		int16(math.Floor(point.Y+0.5)) - platform.PosY,
+	}
+}
+
+// Точка мира, соответствующая локальной ячейке платформы
+func (platform *Platform) WorldPoint(cell Point16) PointFloat {
+	return PointFloat{float64(platform.PosX + cell.X), float64(platform.PosY + cell.Y)}
+}
+
+// Случайная проходимая ячейка не дальше radius от center, false - если таких нет
+func (platform *Platform) RandomWalkableCell(center Point16, radius int16) (Point16, bool) {
+	cells := make([]Point16, 0)
+	for y := center.Y - radius; y <= center.Y+radius; y++ {
+		for x := center.X - radius; x <= center.X+radius; x++ {
+			cell := Point16{x, y}
+			if platform.IsWalkable(cell) {
+				cells = append(cells, cell)
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return Point16{}, false
+	}
+	return cells[rand.Int()%len(cells)], true
+}
+
+// Поиск пути A* по ячейкам платформы в локальных координатах.
+// Путь не включает начальную ячейку, по диагонали можно пройти, только если свободны обе соседние стороны.
+// Если пути нет - возвращается nil
+func (platform *Platform) FindPath(from, to Point16) []Point16 {
+	if !platform.IsWalkable(from) || !platform.IsWalkable(to) {
+		return nil
+	}
+	if from == to {
+		return []Point16{}
+	}
+
+	width := int(platform.Width)
+	toIndex := func(cell Point16) int {
+		return int(cell.Y)*width + int(cell.X)
+	}
+	toCell := func(index int) Point16 {
+		return Point16{int16(index % width), int16(index / width)}
+	}
+	estimate := func(cell Point16) float64 {
+		dx := math.Abs(float64(cell.X - to.X))
+		dy := math.Abs(float64(cell.Y - to.Y))
+		return math.Max(dx, dy) + (math.Sqrt2-1)*math.Min(dx, dy)
+	}
+
+	start := toIndex(from)
+	goal := toIndex(to)
+	passed := map[int]float64{start: 0}
+	cameFrom := make(map[int]int)
+	closed := make(map[int]bool)
+
+	queue := &pathQueue{{start, estimate(from)}}
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(pathNode)
+		if current.index == goal {
+			break
+		}
+		if closed[current.index] {
+			continue
+		}
+		closed[current.index] = true
+
+		cell := toCell(current.index)
+		for i, offset := range pathNeighbours {
+			next := cell.Add(offset)
+			if !platform.IsWalkable(next) {
+				continue
+			}
+			stepCost := 1.0
+			if i >= 4 {
+				// Углы стен не срезаем
+				if !platform.IsWalkable(Point16{cell.X + offset.X, cell.Y}) || !platform.IsWalkable(Point16{cell.X, cell.Y + offset.Y}) {
+					continue
+				}
+				stepCost = math.Sqrt2
+			}
+
+			nextIndex := toIndex(next)
+			nextPassed := passed[current.index] + stepCost
+			if oldPassed, ok := passed[nextIndex]; ok && oldPassed <= nextPassed {
+				continue
+			}
+			passed[nextIndex] = nextPassed
+			cameFrom[nextIndex] = current.index
+			heap.Push(queue, pathNode{nextIndex, nextPassed + estimate(next)})
+		}
+	}
+
+	if _, found := cameFrom[goal]; !found {
+		return nil
+	}
+
+	// Восстанавливаем путь от конца к началу
+	path := make([]Point16, 0)
+	for index := goal; index != start; index = cameFrom[index] {
+		path = append(path, toCell(index))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
@@ -0,0 +1,116 @@
+package gameserver
+
+import (
+	"math"
+	"testing"
+)
+
+// Платформа из строк: '#' - непроходимая ячейка, остальные - проходимые. Платформа сдвинута в мире,
+// чтобы локальные координаты отличались от мировых
+func testPlatform(rows ...string) *Platform {
+	platform := &Platform{
+		Info:   &PlatformInfo{},
+		PosX:   10,
+		PosY:   20,
+		Width:  uint16(len(rows[0])),
+		Height: uint16(len(rows)),
+		Cells:  make([]PlatformCellType, 0, len(rows)*len(rows[0])),
+	}
+	for _, row := range rows {
+		for _, c := range row {
+			if c == '#' {
+				platform.Cells = append(platform.Cells, CELL_TYPE_BLOCK)
+			} else {
+				platform.Cells = append(platform.Cells, CELL_TYPE_WALK)
+			}
+		}
+	}
+	return platform
+}
+
+// Проверяем, что путь идет по проходимым соседним ячейкам без срезания углов, и возвращаем его длину
+func pathCost(t *testing.T, platform *Platform, from Point16, path []Point16) float64 {
+	cost := 0.0
+	cell := from
+	for _, next := range path {
+		dx, dy := next.X-cell.X, next.Y-cell.Y
+		if (dx < -1) || (dx > 1) || (dy < -1) || (dy > 1) || ((dx == 0) && (dy == 0)) {
+			t.Fatalf("step %v -> %v is not to a neighbour", cell, next)
+		}
+		if !platform.IsWalkable(next) {
+			t.Fatalf("step %v -> %v goes into a block", cell, next)
+		}
+		if (dx != 0) && (dy != 0) {
+			if !platform.IsWalkable(Point16{next.X, cell.Y}) || !platform.IsWalkable(Point16{cell.X, next.Y}) {
+				t.Fatalf("step %v -> %v cuts a corner", cell, next)
+			}
+			cost += math.Sqrt2
+		} else {
+			cost += 1
+		}
+		cell = next
+	}
+	return cost
+}
+
+func TestFindPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     []string
+		from, to Point16
+		cost     float64 // -1 - пути нет
+	}{
+		{"straight line", []string{
+			".....",
+		}, Point16{0, 0}, Point16{4, 0}, 4},
+		{"around an obstacle", []string{
+			"......",
+			"..#...",
+			"..#...",
+			"..#...",
+			"......",
+		}, Point16{0, 2}, Point16{4, 2}, 4 + 2*math.Sqrt2},
+		{"no corner cutting", []string{
+			"..",
+			"#.",
+		}, Point16{0, 0}, Point16{1, 1}, 2},
+		{"goal behind a wall", []string{
+			"..#..",
+			"..#..",
+			"..#..",
+		}, Point16{0, 1}, Point16{4, 1}, -1},
+		{"goal is a block", []string{
+			"...",
+			".#.",
+		}, Point16{0, 0}, Point16{1, 1}, -1},
+		{"goal outside the platform", []string{
+			"...",
+		}, Point16{0, 0}, Point16{3, 0}, -1},
+		{"start equals goal", []string{
+			"...",
+		}, Point16{1, 0}, Point16{1, 0}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			platform := testPlatform(test.rows...)
+			path := platform.FindPath(test.from, test.to)
+
+			if test.cost < 0 {
+				if path != nil {
+					t.Fatalf("expected no path, got %v", path)
+				}
+				return
+			}
+			if path == nil {
+				t.Fatal("path not found")
+			}
+			if (len(path) > 0) && (path[len(path)-1] != test.to) {
+				t.Fatalf("path %v doesn't end at %v", path, test.to)
+			}
+			if cost := pathCost(t, platform, test.from, path); math.Abs(cost-test.cost) > 1e-9 {
+				t.Fatalf("path %v costs %f, the shortest costs %f", path, cost, test.cost)
+			}
+		})
+	}
+}
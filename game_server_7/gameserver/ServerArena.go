@@ -18,8 +18,10 @@ type ServerArena struct {
 	server  *Server
 	clients []*ServerClient
 	//arenaData            ArenaModel
+	arenaModel        ArenaModel
 	arenaData         []byte
 	arenaState        GameArenaState
+	monsters          []*ServerMonster
 	isFull            uint32
 	needSendAll       uint32
 	addClientByConnCh chan *net.TCPConn
@@ -62,8 +64,10 @@ func NewServerArena(server *Server) (*ServerArena, error) {
 		arenaId:           newArenaId,
 		server:            server,
 		clients:           make([]*ServerClient, 0),
+		arenaModel:        arenaModel,
 		arenaData:         arenaData,
 		arenaState:        state,
+		monsters:          make([]*ServerMonster, 0),
 		isFull:            0,
 		needSendAll:       0,
 		addClientByConnCh: make(chan *net.TCPConn),
@@ -115,6 +119,10 @@ func (arena *ServerArena) sendAllNewState() {
 			arena.arenaState.Clients = append(arena.arenaState.Clients, stateCopy)
 		}
 	}
+	arena.arenaState.Monsters = make([]ServerMonsterState, 0, len(arena.monsters))
+	for _, monster := range arena.monsters {
+		arena.arenaState.Monsters = append(arena.arenaState.Monsters, monster.State)
+	}
 
 	// State to data
 	data, err := arena.arenaState.ToBytes()
@@ -130,63 +138,114 @@ func (arena *ServerArena) sendAllNewState() {
 }
 
 func (arena *ServerArena) worldTick(delta float64) {
-	if len(arena.arenaState.Monsters) > 0 {
-		hits := []ClientCommandHitInfo{}
-		for _, client := range arena.clients {
-			hits = append(hits, client.GetCurrentHitsWithReset()...)
-		}
+	if len(arena.monsters) == 0 {
+		return
+	}
 
-		// TODO: Optimize
-		validMonsters := make([]ServerMonsterState, 0)
-		haveUpdates := false
-		for i, _ := range arena.arenaState.Monsters {
-			for _, hit := range hits {
-				if arena.arenaState.Monsters[i].ID == hit.ID {
-					arena.arenaState.Monsters[i].Health -= hit.Damage / 10
+	// Урон от клиентов и их положения - цели для монстров
+	haveUpdates := false
+	targets := make([]MonsterTarget, 0, len(arena.clients))
+	for _, client := range arena.clients {
+		for _, hit := range client.GetCurrentHitsWithReset() {
+			for _, monster := range arena.monsters {
+				if monster.State.ID == hit.ID {
+					damage := hit.Damage / 10
+					monster.State.Health -= damage
+					monster.AddThreat(client.id, float64(damage))
 
-					log.Printf("Hit monster %d: damage = %d, health = %d\n", hit.ID, hit.Damage, arena.arenaState.Monsters[i].Health)
+					log.Printf("Hit monster %d: damage = %d, health = %d\n", hit.ID, hit.Damage, monster.State.Health)
 
 					haveUpdates = true
 				}
 			}
+		}
 
-			if arena.arenaState.Monsters[i].Health > 0 {
-				//arena.arenaState.Monsters[i].Health = int16(math.Max(float64(arena.arenaState.Monsters[i].Health), 0.0))
-				validMonsters = append(validMonsters, arena.arenaState.Monsters[i])
-			}
+		if client.IsValidState() {
+			state := client.GetCurrentState(false)
+			targets = append(targets, MonsterTarget{client.id, NewPointFloat(state.X, state.Y)})
 		}
-		arena.arenaState.Monsters = validMonsters
+	}
 
-		if haveUpdates == true {
-			atomic.StoreUint32(&arena.needSendAll, 1)
+	// Поведение монстров
+	validMonsters := make([]*ServerMonster, 0, len(arena.monsters))
+	for _, monster := range arena.monsters {
+		if monster.State.Health <= 0 {
+			continue
 		}
+
+		changed, damage := monster.Update(delta, targets)
+		if damage > 0 {
+			for _, client := range arena.clients {
+				if client.id == monster.State.TargetID {
+					client.AddReceivedDamage(uint32(damage))
+					break
+				}
+			}
+		}
+		if changed || (damage > 0) {
+			haveUpdates = true
+		}
+
+		validMonsters = append(validMonsters, monster)
+	}
+	arena.monsters = validMonsters
+
+	if haveUpdates == true {
+		atomic.StoreUint32(&arena.needSendAll, 1)
 	}
 }
 
-func (arena *ServerArena) createMonster() {
-	if len(arena.arenaState.Monsters) == 0 {
-		newMonsterId := atomic.AddUint32(&LAST_MONSTER_ID, 1)
+// На каждой боевой платформе, где монстров меньше SpawnMin, добавляем их до случайного количества от SpawnMin до SpawnMax
+func (arena *ServerArena) spawnMonsters() {
+	units := GetApp().GetStaticInfo().Units
+	haveNew := false
+
+	for y := range arena.arenaModel.Platforms {
+		for x := range arena.arenaModel.Platforms[y] {
+			platform := arena.arenaModel.Platforms[y][x]
+			if (platform == nil) || (platform.Info.Type != PLATFORM_INFO_TYPE_BATTLE) || (len(platform.Info.MonstersNames) == 0) {
+				continue
+			}
+
+			alive := 0
+			for _, monster := range arena.monsters {
+				if monster.platform == platform {
+					alive++
+				}
+			}
+			if alive >= int(platform.Info.SpawnMin) {
+				continue
+			}
 
-        points := [5]Point16{
-            NewPoint16(10, 2),
-            NewPoint16(2, 2),
-            NewPoint16(2, 10),
-            NewPoint16(10, 10),
-            NewPoint16(4, 5),
-        }
+			count := int(platform.Info.SpawnMin)
+			if platform.Info.SpawnMax > platform.Info.SpawnMin {
+				count += rand.Int() % int(platform.Info.SpawnMax-platform.Info.SpawnMin+1)
+			}
 
-        point := points[rand.Int() % len(points)]
+			for i := alive; i < count; i++ {
+				name := platform.Info.MonstersNames[rand.Int()%len(platform.Info.MonstersNames)]
+				info, exists := units[name]
+				if exists == false {
+					log.Printf("No unit info for monster %s\n", name)
+					continue
+				}
 
-		monsterState := NewServerMonsterState(newMonsterId)
-		monsterState.Name = "angry_cat"
-		monsterState.Health = 1000
-		monsterState.X = float64(point.X)
-		monsterState.Y = float64(point.Y)
+				center := NewPoint16(int16(platform.Width/2), int16(platform.Height/2))
+				cell, ok := platform.RandomWalkableCell(center, int16(platform.Width+platform.Height))
+				if ok == false {
+					break
+				}
 
-		arena.arenaState.Monsters = append(arena.arenaState.Monsters, monsterState)
+				newMonsterId := atomic.AddUint32(&LAST_MONSTER_ID, 1)
+				arena.monsters = append(arena.monsters, NewServerMonster(newMonsterId, name, info, platform, cell))
+				haveNew = true
 
-		log.Printf("Generated monster %d", newMonsterId)
+				log.Printf("Generated monster %d (%s) on platform %d:%d\n", newMonsterId, name, x, y)
+			}
+		}
+	}
 
+	if haveNew {
 		atomic.StoreUint32(&arena.needSendAll, 1)
 	}
 }
@@ -231,7 +290,7 @@ func (arena *ServerArena) mainLoop() {
 
 		case <-newMonsterTimer.C:
 			newMonsterTimer.Reset(time.Second * 20)
-			arena.createMonster()
+			arena.spawnMonsters()
 
 		case <-arena.forceSendAll:
 			atomic.StoreUint32(&arena.needSendAll, 0)
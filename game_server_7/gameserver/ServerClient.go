@@ -114,6 +114,13 @@ func (client *ServerClient) GetCurrentHitsWithReset() []ClientCommandHitInfo {
 	return hits
 }
 
+// Урон от атаки монстра, клиент узнает о нем из следующего состояния арены
+func (client *ServerClient) AddReceivedDamage(damage uint32) {
+	client.mutex.Lock()
+	client.state.ReceivedDamage += damage
+	client.mutex.Unlock()
+}
+
 // Пишем сообщение клиенту
 func (client *ServerClient) QueueSendData(data []byte) {
 	// Если очередь превышена - считаем, что юзер отвалился
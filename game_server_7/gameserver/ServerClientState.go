@@ -27,6 +27,7 @@ type ServerClientState struct {
 	AnimName       string  `json:"animName"`
 	StartSkillName string  `json:"startSkillName"`
 	TotalDamage    uint32  `json:"totalDamage"`
+	ReceivedDamage uint32  `json:"receivedDamage"` // урон от атак монстров, считается сервером
 }
 
 func NewServerClientState(id uint32) ServerClientState {
@@ -0,0 +1,253 @@
+package gameserver
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Состояния поведения монстра
+const (
+	MONSTER_AI_IDLE   uint8 = 0 // стоит на месте
+	MONSTER_AI_PATROL uint8 = 1 // бродит вокруг точки появления или возвращается к ней
+	MONSTER_AI_CHASE  uint8 = 2 // идет к цели
+	MONSTER_AI_ATTACK uint8 = 3 // бьет цель
+)
+
+const (
+	MONSTER_AGGRO_RADIUS     = 6.0  // на таком расстоянии в ячейках монстр замечает игрока
+	MONSTER_LEASH_RADIUS     = 12.0 // игроков дальше от точки появления монстр не преследует
+	MONSTER_PATROL_RADIUS    = 4    // в каких пределах от точки появления монстр бродит
+	MONSTER_PATROL_SPEED     = 0.5  // доля скорости при патрулировании
+	MONSTER_IDLE_MIN_TIME    = 2.0  // сколько секунд монстр стоит между патрулированиями
+	MONSTER_IDLE_MAX_TIME    = 5.0
+	MONSTER_REPATH_PERIOD    = 0.5  // как часто в секундах пересчитывается путь к цели
+	MONSTER_PROXIMITY_THREAT = 10.0 // угроза за каждую ячейку, на которую игрок ближе радиуса агрессии
+	MONSTER_TARGET_SWITCH    = 1.1  // во сколько раз новая цель должна быть опаснее текущей
+)
+
+// Игрок, которого монстр может выбрать целью
+type MonsterTarget struct {
+	ID  uint32
+	Pos PointFloat
+}
+
+// Серверный монстр: состояние для клиентов и данные поведения
+type ServerMonster struct {
+	State          ServerMonsterState
+	info           *UnitInfo
+	platform       *Platform
+	home           Point16   // ячейка появления на платформе
+	path           []Point16 // оставшиеся ячейки пути
+	pathTarget     Point16   // ячейка, к которой построен путь
+	repathTime     float64
+	idleTime       float64
+	attackCooldown float64
+	threat         map[uint32]float64 // угроза по ID клиентов, растет от их урона
+}
+
+func NewServerMonster(id uint32, name string, info *UnitInfo, platform *Platform, cell Point16) *ServerMonster {
+	state := NewServerMonsterState(id)
+	state.Name = name
+	state.Health = info.Health
+	state.Status = MONSTER_STATE_STATUS_ALIVE
+	point := platform.WorldPoint(cell)
+	state.X = point.X
+	state.Y = point.Y
+
+	monster := &ServerMonster{
+		State:    state,
+		info:     info,
+		platform: platform,
+		home:     cell,
+		threat:   make(map[uint32]float64),
+	}
+	monster.startIdle()
+	return monster
+}
+
+func (monster *ServerMonster) Position() PointFloat {
+	return NewPointFloat(monster.State.X, monster.State.Y)
+}
+
+// Урон от клиента делает его более привлекательной целью
+func (monster *ServerMonster) AddThreat(clientID uint32, value float64) {
+	monster.threat[clientID] += value
+}
+
+// Шаг поведения монстра. Возвращает, изменилось ли состояние, и урон, нанесенный цели State.TargetID
+func (monster *ServerMonster) Update(delta float64, targets []MonsterTarget) (bool, int16) {
+	before := monster.State
+	damage := int16(0)
+
+	monster.attackCooldown = math.Max(monster.attackCooldown-delta, 0)
+
+	target, found := monster.selectTarget(targets)
+	if found {
+		monster.State.TargetID = target.ID
+		position := monster.Position()
+		if position.Distance(target.Pos) <= monster.info.AttackDistanceCells() {
+			monster.State.AIState = MONSTER_AI_ATTACK
+		} else if monster.State.AIState != MONSTER_AI_CHASE {
+			monster.State.AIState = MONSTER_AI_CHASE
+			monster.repathTime = 0
+		}
+	} else if monster.State.TargetID != 0 {
+		// Цель ушла или пропала - монстр забывает обиды и возвращается
+		monster.returnHome()
+	}
+
+	switch monster.State.AIState {
+	case MONSTER_AI_IDLE:
+		monster.idleTime -= delta
+		if monster.idleTime <= 0 {
+			monster.startPatrol()
+		}
+
+	case MONSTER_AI_PATROL:
+		if monster.move(delta, monster.info.MoveSpeedCells()*MONSTER_PATROL_SPEED) {
+			monster.startIdle()
+		}
+
+	case MONSTER_AI_CHASE:
+		targetCell := monster.platform.LocalCell(target.Pos)
+		monster.repathTime -= delta
+		if (monster.repathTime <= 0) || (targetCell != monster.pathTarget) {
+			monster.repathTime = MONSTER_REPATH_PERIOD
+			monster.pathTarget = targetCell
+			monster.path = monster.platform.FindPath(monster.currentCell(), targetCell)
+			if monster.path == nil {
+				// До цели не дойти, дальше выбираем из остальных
+				delete(monster.threat, target.ID)
+				monster.returnHome()
+				break
+			}
+		}
+		monster.move(delta, monster.info.MoveSpeedCells())
+
+	case MONSTER_AI_ATTACK:
+		monster.path = nil
+		monster.faceTo(target.Pos)
+		if (monster.attackCooldown <= 0) && (monster.info.AttackSpeed > 0) {
+			monster.attackCooldown = 1.0 / monster.info.AttackSpeed
+			damage = monster.info.Power
+		}
+	}
+
+	switch monster.State.AIState {
+	case MONSTER_AI_IDLE:
+		monster.State.AnimationName = "idle"
+	case MONSTER_AI_PATROL, MONSTER_AI_CHASE:
+		monster.State.AnimationName = "walk"
+	case MONSTER_AI_ATTACK:
+		monster.State.AnimationName = "attack"
+	}
+
+	return monster.State != before, damage
+}
+
+// Выбор цели по близости и угрозе. Цель должна стоять на платформе монстра недалеко от точки появления,
+// игроков без угрозы монстр замечает только в радиусе агрессии
+func (monster *ServerMonster) selectTarget(targets []MonsterTarget) (MonsterTarget, bool) {
+	position := monster.Position()
+	home := monster.platform.WorldPoint(monster.home)
+
+	best, current := MonsterTarget{}, MonsterTarget{}
+	bestScore, currentScore := 0.0, 0.0
+	found, haveCurrent := false, false
+	for _, target := range targets {
+		if !monster.platform.IsWalkable(monster.platform.LocalCell(target.Pos)) {
+			continue
+		}
+		if home.Distance(target.Pos) > MONSTER_LEASH_RADIUS {
+			continue
+		}
+
+		distance := position.Distance(target.Pos)
+		threat := monster.threat[target.ID]
+		if (threat <= 0) && (distance > MONSTER_AGGRO_RADIUS) {
+			continue
+		}
+
+		score := threat + math.Max(MONSTER_AGGRO_RADIUS-distance, 0)*MONSTER_PROXIMITY_THREAT
+		if target.ID == monster.State.TargetID {
+			current, currentScore, haveCurrent = target, score, true
+		}
+		if !found || (score > bestScore) {
+			best, bestScore, found = target, score, true
+		}
+	}
+
+	// Чтобы монстр не метался между игроками, текущую цель он меняет только на заметно более опасную
+	if haveCurrent && (bestScore < currentScore*MONSTER_TARGET_SWITCH) {
+		return current, true
+	}
+	return best, found
+}
+
+func (monster *ServerMonster) currentCell() Point16 {
+	return monster.platform.LocalCell(monster.Position())
+}
+
+func (monster *ServerMonster) startIdle() {
+	monster.State.AIState = MONSTER_AI_IDLE
+	monster.path = nil
+	monster.idleTime = MONSTER_IDLE_MIN_TIME + rand.Float64()*(MONSTER_IDLE_MAX_TIME-MONSTER_IDLE_MIN_TIME)
+}
+
+func (monster *ServerMonster) startPatrol() {
+	cell, ok := monster.platform.RandomWalkableCell(monster.home, MONSTER_PATROL_RADIUS)
+	if ok {
+		monster.path = monster.platform.FindPath(monster.currentCell(), cell)
+	}
+	if !ok || (len(monster.path) == 0) {
+		monster.startIdle()
+		return
+	}
+	monster.State.AIState = MONSTER_AI_PATROL
+}
+
+func (monster *ServerMonster) returnHome() {
+	monster.State.TargetID = 0
+	monster.threat = make(map[uint32]float64)
+	monster.path = monster.platform.FindPath(monster.currentCell(), monster.home)
+	if len(monster.path) == 0 {
+		monster.startIdle()
+		return
+	}
+	monster.State.AIState = MONSTER_AI_PATROL
+}
+
+// Движение по пути со скоростью в ячейках в секунду, возвращает true, когда путь пройден
+func (monster *ServerMonster) move(delta, speed float64) bool {
+	position := monster.Position()
+	step := speed * delta
+	for (step > 0) && (len(monster.path) > 0) {
+		next := monster.platform.WorldPoint(monster.path[0])
+		monster.faceTo(next)
+
+		offset := next.Sub(position)
+		distance := offset.Length()
+		if distance <= step {
+			position = next
+			step -= distance
+			monster.path = monster.path[1:]
+			continue
+		}
+		shift := offset.Mul(step / distance)
+		position = position.Add(shift)
+		step = 0
+	}
+
+	monster.State.X = position.X
+	monster.State.Y = position.Y
+	return len(monster.path) == 0
+}
+
+// Поворот монстра к точке в плоскости платформы
+func (monster *ServerMonster) faceTo(point PointFloat) {
+	position := monster.Position()
+	offset := point.Sub(position)
+	if offset.Length() > 0 {
+		monster.State.RotZ = math.Atan2(offset.Y, offset.X)
+	}
+}
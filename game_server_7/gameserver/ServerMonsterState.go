@@ -18,6 +18,8 @@ type ServerMonsterState struct {
 	Health        int16   `json:"health"`
 	VisualState   int16   `json:"visualState"`
 	AnimationName string  `json:"animName"`
+	AIState       uint8   `json:"aiState"`  // MONSTER_AI_*
+	TargetID      uint32  `json:"targetId"` // клиент, которого монстр преследует или атакует, 0 - нет цели
 }
 
 func NewServerMonsterState(id uint32) ServerMonsterState {
@@ -0,0 +1,178 @@
+package gameserver
+
+import (
+	"testing"
+)
+
+// Монстр проходит 2 ячейки в секунду и бьет на 1 ячейку
+func testUnitInfo() *UnitInfo {
+	return &UnitInfo{
+		MoveSpeed:      2 * UNIT_INFO_CELL_SIZE,
+		AttackSpeed:    1,
+		Power:          7,
+		Health:         100,
+		BoundingRadius: UNIT_INFO_CELL_SIZE / 3,
+		AttackRadius:   UNIT_INFO_CELL_SIZE * 2 / 3,
+	}
+}
+
+func testOpenPlatform() *Platform {
+	return testPlatform(
+		"....................",
+		"....................",
+		"....................",
+		"....................",
+		"....#...............",
+	)
+}
+
+// Клиент без соединения, стоящий в ячейке платформы
+func testClient(id uint32, platform *Platform, cell Point16) *ServerClient {
+	client := &ServerClient{
+		id:         id,
+		stateValid: true,
+		state:      NewServerClientState(id),
+		hits:       make([]ClientCommandHitInfo, 0),
+	}
+	testMoveClient(client, platform, cell)
+	return client
+}
+
+func testMoveClient(client *ServerClient, platform *Platform, cell Point16) {
+	point := platform.WorldPoint(cell)
+	client.state.X = point.X
+	client.state.Y = point.Y
+}
+
+func TestMonsterSelectTarget(t *testing.T) {
+	platform := testOpenPlatform()
+	target := func(id uint32, x, y int16) MonsterTarget {
+		return MonsterTarget{id, platform.WorldPoint(Point16{x, y})}
+	}
+
+	// Монстр стоит в точке появления (2, 2)
+	tests := []struct {
+		name    string
+		current uint32
+		threat  map[uint32]float64
+		targets []MonsterTarget
+		want    uint32 // 0 - цели нет
+	}{
+		{"nobody around", 0, nil, nil, 0},
+		{"nearest player", 0, nil,
+			[]MonsterTarget{target(1, 6, 2), target(2, 4, 2)}, 2},
+		{"player outside the aggro radius", 0, nil,
+			[]MonsterTarget{target(1, 10, 2)}, 0},
+		{"threat outside the aggro radius", 0, map[uint32]float64{1: 5},
+			[]MonsterTarget{target(1, 10, 2)}, 1},
+		{"threat beats proximity", 0, map[uint32]float64{1: 50},
+			[]MonsterTarget{target(1, 7, 2), target(2, 4, 2)}, 1},
+		{"proximity beats small threat", 0, map[uint32]float64{1: 5},
+			[]MonsterTarget{target(1, 7, 2), target(2, 4, 2)}, 2},
+		{"current target is kept against a slightly more dangerous one", 1, map[uint32]float64{2: 3},
+			[]MonsterTarget{target(1, 4, 2), target(2, 4, 3)}, 1},
+		{"current target is switched to a much more dangerous one", 1, map[uint32]float64{2: 10},
+			[]MonsterTarget{target(1, 4, 2), target(2, 4, 3)}, 2},
+		{"player beyond the leash radius", 0, map[uint32]float64{1: 100},
+			[]MonsterTarget{target(1, 15, 2)}, 0},
+		{"player on a block", 0, nil,
+			[]MonsterTarget{target(1, 4, 4)}, 0},
+		{"player outside the platform", 0, nil,
+			[]MonsterTarget{target(1, 2, -2)}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			monster := NewServerMonster(1, "test", testUnitInfo(), platform, Point16{2, 2})
+			monster.State.TargetID = test.current
+			for id, threat := range test.threat {
+				monster.AddThreat(id, threat)
+			}
+
+			selected, found := monster.selectTarget(test.targets)
+			if (test.want == 0) && found {
+				t.Fatalf("expected no target, got %d", selected.ID)
+			}
+			if (test.want != 0) && (!found || (selected.ID != test.want)) {
+				t.Fatalf("expected target %d, got %d (found %v)", test.want, selected.ID, found)
+			}
+		})
+	}
+}
+
+func TestMonsterChaseAttackIdle(t *testing.T) {
+	const tick = 0.1
+
+	platform := testOpenPlatform()
+	info := testUnitInfo()
+	home := Point16{2, 2}
+	monster := NewServerMonster(1, "test", info, platform, home)
+	client := testClient(7, platform, Point16{10, 2})
+	arena := &ServerArena{
+		clients:  []*ServerClient{client},
+		monsters: []*ServerMonster{monster},
+	}
+
+	// Шаги идут по порядку на одной арене, каждый ждет нужного состояния не дольше within секунд
+	steps := []struct {
+		name   string
+		action func()
+		want   uint8
+		within float64
+		target uint32
+	}{
+		{"player outside the aggro radius is ignored", func() {}, MONSTER_AI_IDLE, tick, 0},
+		{"hit makes the monster chase", func() {
+			client.hits = append(client.hits, ClientCommandHitInfo{monster.State.ID, 100})
+		}, MONSTER_AI_CHASE, tick, client.id},
+		{"monster attacks in reach", func() {}, MONSTER_AI_ATTACK, 5, client.id},
+		{"monster follows the moving player", func() {
+			testMoveClient(client, platform, Point16{8, 0})
+		}, MONSTER_AI_CHASE, tick, client.id},
+		{"monster catches up", func() {}, MONSTER_AI_ATTACK, 5, client.id},
+		{"monster returns home when the player leaves", func() {
+			client.stateValid = false
+		}, MONSTER_AI_IDLE, 10, 0},
+	}
+
+	for _, step := range steps {
+		step.action()
+		for elapsed := 0.0; ; elapsed += tick {
+			arena.worldTick(tick)
+			if monster.State.AIState == step.want {
+				break
+			}
+			if elapsed >= step.within {
+				t.Fatalf("%s: state %d, expected %d", step.name, monster.State.AIState, step.want)
+			}
+		}
+		if monster.State.TargetID != step.target {
+			t.Fatalf("%s: target %d, expected %d", step.name, monster.State.TargetID, step.target)
+		}
+	}
+
+	if monster.State.Health != info.Health-10 {
+		t.Errorf("health %d, expected %d after a hit of 100", monster.State.Health, info.Health-10)
+	}
+	if (client.state.ReceivedDamage == 0) || (client.state.ReceivedDamage%uint32(info.Power) != 0) {
+		t.Errorf("received damage %d is not a multiple of power %d", client.state.ReceivedDamage, info.Power)
+	}
+	if position := monster.Position(); position != platform.WorldPoint(home) {
+		t.Errorf("monster stopped at %v, home is %v", position, platform.WorldPoint(home))
+	}
+}
+
+// Размер ячейки подобран так, что герой бьет ровно на одну ячейку
+func TestPlayerReachIsOneCell(t *testing.T) {
+	units, err := NewUnitsFromFile("../data/units.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, ok := units["player"]
+	if !ok {
+		t.Fatal("no player in units.json")
+	}
+	if reach := player.AttackDistanceCells(); reach != 1 {
+		t.Errorf("player reaches %f cells", reach)
+	}
+}
@@ -8,6 +8,7 @@ import (
 type StaticInfo struct {
 	Platforms     map[string]*PlatformInfo
 	Levels        map[string]*LevelInfo
+	Units         map[string]*UnitInfo
 	TestArenaData []byte
 }
 
@@ -26,6 +27,13 @@ func NewStaticInfo() (*StaticInfo, error) {
 		return nil, err
 	}
 
+	// Load units
+	units, err := NewUnitsFromFile("data/units.json")
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
 	// Test arena
 	testArenaData, err := ioutil.ReadFile("data/arenaDump2x2.json")
 	if err != nil {
@@ -36,6 +44,7 @@ func NewStaticInfo() (*StaticInfo, error) {
 	staticInfo := &StaticInfo{
 		Platforms:     platforms,
 		Levels:        levels,
+		Units:         units,
 		TestArenaData: testArenaData,
 	}
 	return staticInfo, nil
@@ -0,0 +1,55 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+// Сколько единиц скорости и радиусов из units.json в одной ячейке платформы. Сам размер ячейки в данных нигде
+// не записан: значение взято из героя ("player" в units.json), у которого bounding_radius + attack_radius = 5 + 25,
+// то есть герой бьет ровно на соседнюю ячейку, а move_speed 210 дает 7 ячеек в секунду.
+// При изменении радиусов героя константу нужно пересмотреть, это проверяет TestPlayerReachIsOneCell
+const UNIT_INFO_CELL_SIZE = 30.0
+
+type UnitInfo struct {
+	SymbolName     string  `json:"symbol_name"`     // имя символа
+	MoveSpeed      float64 `json:"move_speed"`      // скорость движения, единиц в секунду
+	AttackSpeed    float64 `json:"attack_speed"`    // атак в секунду
+	Power          int16   `json:"power"`           // урон одной атаки
+	Defence        int16   `json:"defence"`         // защита
+	Health         int16   `json:"health"`          // здоровье
+	Reward         int16   `json:"reward"`          // награда за убийство
+	BoundingRadius float64 `json:"bounding_radius"` // радиус тела
+	AttackRadius   float64 `json:"attack_radius"`   // дальность атаки от края тела
+}
+
+func NewUnitsFromReader(reader io.Reader) (map[string]*UnitInfo, error) {
+	result := make(map[string]*UnitInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	return result, err
+}
+
+func NewUnitsFromFile(filePath string) (map[string]*UnitInfo, error) {
+	// Загрузка юнитов из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string]*UnitInfo), err
+	}
+	defer f.Close()
+
+	return NewUnitsFromReader(f)
+}
+
+// Скорость движения в ячейках в секунду
+func (info *UnitInfo) MoveSpeedCells() float64 {
+	return info.MoveSpeed / UNIT_INFO_CELL_SIZE
+}
+
+// Расстояние в ячейках, с которого юнит достает цель
+func (info *UnitInfo) AttackDistanceCells() float64 {
+	return (info.BoundingRadius + info.AttackRadius) / UNIT_INFO_CELL_SIZE
+}